@@ -0,0 +1,351 @@
+// Package config holds the server settings. Every setting is registered
+// with a name, a type that parses and formats its value, a default and
+// whether it may change while the server runs. A config file, the
+// environment, command-line flags and CONFIG SET all fill the same registry.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Values are the typed settings.
+type Values struct {
+	Port      int
+	Bind      string
+	Databases int
+
+	// Timeout closes a connection idle for that many seconds, or never
+	// when 0. TCPKeepAlive is the keepalive period of client connections
+	// in seconds, or 0 to leave keepalives off.
+	Timeout      int
+	TCPKeepAlive int
+
+	// MaxMemory and MaxMemoryPolicy are recorded for the eviction to come;
+	// nothing is evicted yet.
+	MaxMemory       int64
+	MaxMemoryPolicy string
+
+	// Dir and DBFilename name the snapshot file, which is written on
+	// shutdown when Save has save points. It is a text file of the commands
+	// that rebuild each database, not an RDB file, hence the default name
+	// dump.kvdb. The other persistence settings are recorded for the
+	// persistence to come.
+	Dir            string
+	DBFilename     string
+	Save           string
+	AppendOnly     bool
+	AppendFilename string
+	AppendFsync    string
+
+	// ACLFile holds the users, which are loaded from it at startup and
+	// written to it by ACL SAVE. ACLLogMaxLen bounds the entries ACL LOG
+	// keeps.
+	ACLFile      string
+	ACLLogMaxLen int
+
+	// TLSPort is the port of the TLS listener, which is off when 0. The
+	// certificate, key and CA files are read again when they or these
+	// settings change. TLSAuthClients asks clients for a certificate signed
+	// by the CA, and TLSAuthClientsUser logs a client in as the user named
+	// by the CN of its certificate when set to "cn".
+	TLSPort            int
+	TLSCertFile        string
+	TLSKeyFile         string
+	TLSCACertFile      string
+	TLSAuthClients     string
+	TLSAuthClientsUser string
+}
+
+type param struct {
+	name    string
+	mutable bool
+	value   value
+}
+
+// SnapshotPath returns the path of the snapshot file.
+func (v Values) SnapshotPath() string {
+	return filepath.Join(v.Dir, v.DBFilename)
+}
+
+// Config is the registry of settings. It is safe for concurrent use.
+type Config struct {
+	mu     sync.RWMutex
+	values Values
+	params map[string]*param
+	// file is the config file the settings were loaded from, if any.
+	file string
+}
+
+// New returns the settings with their defaults.
+func New() *Config {
+	c := &Config{params: make(map[string]*param)}
+	v := &c.values
+	c.register("port", false, intValue{p: &v.Port, min: 0, max: 65535}, "9736")
+	c.register("bind", false, stringValue{p: &v.Bind}, "")
+	c.register("databases", false, intValue{p: &v.Databases, min: 1, max: 1 << 20}, "16")
+	c.register("timeout", true, intValue{p: &v.Timeout, min: 0, max: math.MaxInt32}, "0")
+	c.register("tcp-keepalive", true, intValue{p: &v.TCPKeepAlive, min: 0, max: math.MaxInt32}, "300")
+	c.register("maxmemory", true, memoryValue{p: &v.MaxMemory}, "0")
+	c.register("maxmemory-policy", true, enumValue{p: &v.MaxMemoryPolicy, allowed: []string{
+		"noeviction", "allkeys-lru", "volatile-lru", "allkeys-lfu", "volatile-lfu",
+		"allkeys-random", "volatile-random", "volatile-ttl",
+	}}, "noeviction")
+	c.register("dir", false, stringValue{p: &v.Dir}, ".")
+	c.register("dbfilename", false, stringValue{p: &v.DBFilename, check: checkFilename}, "dump.kvdb")
+	c.register("save", true, stringValue{p: &v.Save, check: checkSave}, "3600 1 300 100 60 10000")
+	c.register("appendonly", true, boolValue{p: &v.AppendOnly}, "no")
+	c.register("appendfilename", false, stringValue{p: &v.AppendFilename, check: checkFilename}, "appendonly.aof")
+	c.register("appendfsync", true, enumValue{p: &v.AppendFsync, allowed: []string{"always", "everysec", "no"}}, "everysec")
+	c.register("aclfile", false, stringValue{p: &v.ACLFile}, "")
+	c.register("acllog-max-len", true, intValue{p: &v.ACLLogMaxLen, min: 0, max: math.MaxInt32}, "128")
+	c.register("tls-port", false, intValue{p: &v.TLSPort, min: 0, max: 65535}, "0")
+	c.register("tls-cert-file", true, stringValue{p: &v.TLSCertFile}, "")
+	c.register("tls-key-file", true, stringValue{p: &v.TLSKeyFile}, "")
+	c.register("tls-ca-cert-file", true, stringValue{p: &v.TLSCACertFile}, "")
+	c.register("tls-auth-clients", true, enumValue{p: &v.TLSAuthClients, allowed: []string{"yes", "no", "optional"}}, "yes")
+	c.register("tls-auth-clients-user", true, enumValue{p: &v.TLSAuthClientsUser, allowed: []string{"off", "cn"}}, "off")
+	return c
+}
+
+func (c *Config) register(name string, mutable bool, v value, def string) {
+	if err := v.set(def); err != nil {
+		panic(fmt.Sprintf("config: bad default for %s: %v", name, err))
+	}
+	c.params[name] = &param{name: name, mutable: mutable, value: v}
+}
+
+// Values returns a copy of the current settings.
+func (c *Config) Values() Values {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.values
+}
+
+// File returns the path of the config file, or "" when there is none.
+func (c *Config) File() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.file
+}
+
+// Names returns the name of every setting in order.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.params))
+	for name := range c.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns a setting formatted as in a config file.
+func (c *Config) Get(name string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	p, ok := c.params[strings.ToLower(name)]
+	if !ok {
+		return "", false
+	}
+	return p.value.String(), true
+}
+
+var (
+	ErrUnknownOption = errors.New("unknown option")
+	ErrImmutable     = errors.New("can't set immutable config")
+)
+
+// SetError reports a setting that could not be changed and why.
+type SetError struct {
+	Name string
+	Err  error
+}
+
+func (e *SetError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e *SetError) Unwrap() error {
+	return e.Err
+}
+
+// Set changes a setting before the server starts, so immutable settings
+// may be set too.
+func (c *Config) Set(name, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.set(name, value, false)
+}
+
+// Update changes settings of a running server, given as name and value
+// pairs. Either all of them change or, when one is unknown, immutable or
+// invalid, none do.
+func (c *Config) Update(pairs ...string) error {
+	if len(pairs)%2 != 0 {
+		return &SetError{Name: pairs[len(pairs)-1], Err: errors.New("missing value")}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old := c.values
+	seen := make(map[string]bool)
+	for i := 0; i < len(pairs); i += 2 {
+		name := strings.ToLower(pairs[i])
+		err := c.set(name, pairs[i+1], true)
+		if err == nil && seen[name] {
+			err = &SetError{Name: pairs[i], Err: errors.New("duplicate parameter")}
+		}
+		if err != nil {
+			c.values = old
+			return err
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+func (c *Config) set(name, value string, live bool) error {
+	p, ok := c.params[strings.ToLower(name)]
+	if !ok {
+		return &SetError{Name: name, Err: ErrUnknownOption}
+	}
+	if live && !p.mutable {
+		return &SetError{Name: name, Err: ErrImmutable}
+	}
+	if err := p.value.set(value); err != nil {
+		return &SetError{Name: name, Err: err}
+	}
+	return nil
+}
+
+// Load reads a config file of "name value" lines and remembers it for
+// Rewrite. Blank lines and lines starting with # are skipped, and a value
+// may be put in double quotes.
+func (c *Config) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		name, value, ok, err := parseLine(scanner.Text())
+		if err == nil && ok {
+			err = c.set(name, value, false)
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	c.file = path
+	return nil
+}
+
+// parseLine splits a config file line into a name and a value. It reports
+// false for blank lines and comments.
+func parseLine(line string) (string, string, bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", "", false, nil
+	}
+	name, value := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, value = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", "", false, fmt.Errorf("bad quoted value %s", value)
+		}
+		value = unquoted
+	}
+	return strings.ToLower(name), value, true, nil
+}
+
+// formatLine formats a setting as a config file line.
+func formatLine(name, value string) string {
+	if value == "" || strings.ContainsAny(value, " \t") || strconv.Quote(value) != `"`+value+`"` {
+		value = strconv.Quote(value)
+	}
+	return name + " " + value
+}
+
+// Rewrite writes the current settings back to the config file. Comments
+// and the order of the lines are kept: each setting replaces its first
+// line, later lines for it are dropped, and settings not in the file are
+// added at the end when they differ from their default.
+func (c *Config) Rewrite() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.file == "" {
+		return fmt.Errorf("the server is running without a config file")
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(c.file); err == nil {
+		mode = info.Mode().Perm()
+	}
+	data, err := os.ReadFile(c.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	var out []string
+	written := make(map[string]bool)
+	for _, line := range lines {
+		name, _, ok, _ := parseLine(line)
+		p, known := c.params[name]
+		if !ok || !known {
+			out = append(out, line)
+			continue
+		}
+		if !written[name] {
+			out = append(out, formatLine(name, p.value.String()))
+			written[name] = true
+		}
+	}
+	defaults := New()
+	for _, name := range c.Names() {
+		value := c.params[name].value.String()
+		if !written[name] && value != defaults.params[name].value.String() {
+			out = append(out, formatLine(name, value))
+		}
+	}
+
+	// Write a new file and rename it over the old one, so a failure never
+	// leaves a partly written config behind.
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), ".kvdb-conf-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(out, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file)
+}
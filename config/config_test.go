@@ -0,0 +1,162 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaults(t *testing.T) {
+	v := New().Values()
+	if v.Port != 9736 || v.Databases != 16 || v.MaxMemoryPolicy != "noeviction" || v.AppendOnly {
+		t.Errorf("New().Values() = %+v", v)
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr error
+	}{
+		{name: "port", value: "6380", want: "6380"},
+		{name: "PORT", value: "70000", want: "9736", wantErr: errors.New("argument must be between 0 and 65535 inclusive")},
+		{name: "databases", value: "x", want: "16", wantErr: errors.New("argument couldn't be parsed into an integer")},
+		{name: "maxmemory", value: "100mb", want: "104857600"},
+		{name: "maxmemory", value: "2K", want: "2000"},
+		{name: "maxmemory", value: "-1", want: "0", wantErr: errors.New("argument must be a memory value")},
+		{name: "maxmemory-policy", value: "ALLKEYS-LRU", want: "allkeys-lru"},
+		{name: "appendonly", value: "yes", want: "yes"},
+		{name: "appendonly", value: "true", want: "no", wantErr: errors.New("argument must be 'yes' or 'no'")},
+		{name: "save", value: "", want: ""},
+		{name: "save", value: "900", want: "3600 1 300 100 60 10000", wantErr: errors.New("invalid save parameters")},
+		{name: "dbfilename", value: "../dump.kvdb", want: "dump.kvdb", wantErr: errors.New("argument can't be a path, just a filename")},
+		{name: "nope", value: "1", wantErr: ErrUnknownOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.value, func(t *testing.T) {
+			c := New()
+			err := c.Set(tt.name, tt.value)
+			if (err == nil) != (tt.wantErr == nil) || err != nil && errors.Unwrap(err).Error() != tt.wantErr.Error() {
+				t.Fatalf("Set() error = %v, want %v", err, tt.wantErr)
+			}
+			if got, _ := c.Get(tt.name); got != tt.want {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	c := New()
+	if err := c.Update("timeout", "30", "maxmemory", "1gb"); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	if v := c.Values(); v.Timeout != 30 || v.MaxMemory != 1<<30 {
+		t.Errorf("Update() left timeout %d and maxmemory %d", v.Timeout, v.MaxMemory)
+	}
+
+	tests := []struct {
+		name    string
+		pairs   []string
+		wantErr error
+	}{
+		{name: "immutable", pairs: []string{"timeout", "5", "port", "1"}, wantErr: ErrImmutable},
+		{name: "snapshot directory", pairs: []string{"dir", "/etc"}, wantErr: ErrImmutable},
+		{name: "snapshot file", pairs: []string{"dbfilename", "crontab"}, wantErr: ErrImmutable},
+		{name: "unknown", pairs: []string{"timeout", "5", "nope", "1"}, wantErr: ErrUnknownOption},
+		{name: "invalid", pairs: []string{"timeout", "5", "maxmemory", "lots"}},
+		{name: "duplicate", pairs: []string{"timeout", "5", "TIMEOUT", "6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.Update(tt.pairs...)
+			if err == nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Update() error = %v, want %v", err, tt.wantErr)
+			}
+			if v := c.Values(); v.Timeout != 30 {
+				t.Errorf("a failed Update() changed timeout to %d", v.Timeout)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "settings", content: "# comment\n\nport 6380\n  Timeout\t10\nsave \"\"\ndir \"/var/lib/kv db\"\n"},
+		{name: "unknown setting", content: "port 6380\nnope 1\n", wantErr: true},
+		{name: "invalid value", content: "port x\n", wantErr: true},
+		{name: "bad quotes", content: "dir \"/tmp\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "kvdb.conf")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			c := New()
+			err := c.Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			v := c.Values()
+			if v.Port != 6380 || v.Timeout != 10 || v.Save != "" || v.Dir != "/var/lib/kv db" || c.File() != path {
+				t.Errorf("Load() = %+v from %q", v, c.File())
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvdb.conf")
+	content := "# kvdb settings\nport 6380\n\ntimeout 10\nmaxmemory 1mb\ntimeout 20\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := New()
+	if err := c.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update("timeout", "0", "maxmemory-policy", "volatile-ttl", "save", "60 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rewrite(); err != nil {
+		t.Fatalf("Rewrite() = %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# kvdb settings\nport 6380\n\ntimeout 0\nmaxmemory 1048576\n" +
+		"maxmemory-policy volatile-ttl\nsave \"60 1\"\n"
+	if string(got) != want {
+		t.Errorf("Rewrite() wrote %q, want %q", got, want)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Rewrite() changed the file mode to %v", info.Mode())
+	}
+
+	reloaded := New()
+	if err := reloaded.Load(path); err != nil {
+		t.Fatalf("Load() of the rewritten file = %v", err)
+	}
+	if reloaded.Values() != c.Values() {
+		t.Errorf("Load() of the rewritten file = %+v, want %+v", reloaded.Values(), c.Values())
+	}
+}
+
+func TestRewriteWithoutFile(t *testing.T) {
+	if err := New().Rewrite(); err == nil {
+		t.Errorf("Rewrite() without a config file succeeded")
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// value is the type of a setting. It parses the text of a config file line
+// or CONFIG SET into the field it points to, and formats the field back.
+type value interface {
+	set(s string) error
+	String() string
+}
+
+type intValue struct {
+	p        *int
+	min, max int
+}
+
+func (v intValue) set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("argument couldn't be parsed into an integer")
+	}
+	if n < v.min || n > v.max {
+		return fmt.Errorf("argument must be between %d and %d inclusive", v.min, v.max)
+	}
+	*v.p = n
+	return nil
+}
+
+func (v intValue) String() string {
+	return strconv.Itoa(*v.p)
+}
+
+type boolValue struct {
+	p *bool
+}
+
+func (v boolValue) set(s string) error {
+	switch strings.ToLower(s) {
+	case "yes":
+		*v.p = true
+	case "no":
+		*v.p = false
+	default:
+		return fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+func (v boolValue) String() string {
+	if *v.p {
+		return "yes"
+	}
+	return "no"
+}
+
+type stringValue struct {
+	p     *string
+	check func(s string) error
+}
+
+func (v stringValue) set(s string) error {
+	if v.check != nil {
+		if err := v.check(s); err != nil {
+			return err
+		}
+	}
+	*v.p = s
+	return nil
+}
+
+func (v stringValue) String() string {
+	return *v.p
+}
+
+type enumValue struct {
+	p       *string
+	allowed []string
+}
+
+func (v enumValue) set(s string) error {
+	s = strings.ToLower(s)
+	for _, a := range v.allowed {
+		if s == a {
+			*v.p = s
+			return nil
+		}
+	}
+	return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(v.allowed, ", "))
+}
+
+func (v enumValue) String() string {
+	return *v.p
+}
+
+// memoryUnits are the suffixes a memory value may have, as in Redis.
+var memoryUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// memoryValue is a byte count, written as a number with an optional unit
+// such as "100mb".
+type memoryValue struct {
+	p *int64
+}
+
+func (v memoryValue) set(s string) error {
+	digits, unit := strings.ToLower(s), int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(digits, u.suffix) {
+			digits, unit = strings.TrimSuffix(digits, u.suffix), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/unit {
+		return fmt.Errorf("argument must be a memory value")
+	}
+	*v.p = n * unit
+	return nil
+}
+
+func (v memoryValue) String() string {
+	return strconv.FormatInt(*v.p, 10)
+}
+
+func checkFilename(s string) error {
+	if s == "" || strings.ContainsAny(s, "/\\") {
+		return fmt.Errorf("argument can't be a path, just a filename")
+	}
+	return nil
+}
+
+// checkSave accepts pairs of seconds and changes, or "" for no snapshots.
+func checkSave(s string) error {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return fmt.Errorf("invalid save parameters")
+	}
+	for _, f := range fields {
+		if n, err := strconv.Atoi(f); err != nil || n < 0 {
+			return fmt.Errorf("invalid save parameters")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,591 @@
+package domain
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errACLSyntax          = errors.New("Syntax error")
+	errACLUnknownCommand  = errors.New("Unknown command or category name in ACL")
+	errACLNoSuchPassword  = errors.New("The password you are trying to remove from the user does not exist")
+	errACLBadPasswordHash = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errACLBadDatabase     = errors.New("Database indexes must be non-negative integers")
+)
+
+// aclUser is a user of the ACL: whether it may log in and with which
+// passwords, and which commands, keys, channels and databases it may use.
+type aclUser struct {
+	name    string
+	enabled bool
+	// nopass lets the user log in with any password. passwords holds the
+	// SHA-256 hashes of the others, in hex.
+	nopass    bool
+	passwords []string
+	// commands are the +/- rules in the order given; the last one that
+	// matches a command decides whether it may run.
+	commands []commandRule
+	keys     []string
+	channels []string
+	// dbs lists the databases the user may use, or is nil for all of them.
+	dbs []int
+}
+
+// commandRule allows or denies a command, a subcommand such as
+// "config|get", or a category such as "@read".
+type commandRule struct {
+	allow bool
+	name  string
+}
+
+// newACLUser returns a user that can do nothing until rules are added.
+func newACLUser(name string) *aclUser {
+	return &aclUser{name: name}
+}
+
+// newDefaultUser returns the user connections start as, which needs no
+// password and may do anything.
+func newDefaultUser() *aclUser {
+	u := newACLUser("default")
+	u.enabled = true
+	u.nopass = true
+	u.commands = []commandRule{{allow: true, name: "@all"}}
+	u.keys = []string{"*"}
+	u.channels = []string{"*"}
+	return u
+}
+
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = append([]string(nil), u.passwords...)
+	c.commands = append([]commandRule(nil), u.commands...)
+	c.keys = append([]string(nil), u.keys...)
+	c.channels = append([]string(nil), u.channels...)
+	if u.dbs != nil {
+		c.dbs = append([]int{}, u.dbs...)
+	}
+	return &c
+}
+
+// setRules applies the rules of ACL SETUSER in order. It stops at the first
+// invalid rule and returns it with the reason, leaving u partly changed.
+func (u *aclUser) setRules(rules []string) (string, error) {
+	for _, rule := range rules {
+		if err := u.setRule(rule); err != nil {
+			return rule, err
+		}
+	}
+	return "", nil
+}
+
+func (u *aclUser) setRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+		return nil
+	case "allkeys":
+		u.keys = []string{"*"}
+		return nil
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		u.channels = []string{"*"}
+		return nil
+	case "resetchannels":
+		u.channels = nil
+		return nil
+	case "allcommands":
+		u.commands = []commandRule{{allow: true, name: "@all"}}
+		return nil
+	case "nocommands":
+		u.commands = nil
+		return nil
+	case "alldbs":
+		u.dbs = nil
+		return nil
+	case "reset":
+		*u = *newACLUser(u.name)
+		return nil
+	}
+	if rule == "" {
+		return errACLSyntax
+	}
+
+	switch arg := rule[1:]; rule[0] {
+	case '>':
+		u.addPassword(hashPassword(arg))
+	case '<':
+		return u.removePassword(hashPassword(arg))
+	case '#':
+		if !isPasswordHash(arg) {
+			return errACLBadPasswordHash
+		}
+		u.addPassword(arg)
+	case '!':
+		if !isPasswordHash(arg) {
+			return errACLBadPasswordHash
+		}
+		return u.removePassword(arg)
+	case '~':
+		u.keys = appendPattern(u.keys, arg)
+	case '&':
+		u.channels = appendPattern(u.channels, arg)
+	case '+', '-':
+		return u.addCommandRule(rule[0] == '+', strings.ToLower(arg))
+	default:
+		if strings.HasPrefix(strings.ToLower(rule), "db=") {
+			return u.setDatabases(rule[len("db="):])
+		}
+		return errACLSyntax
+	}
+	return nil
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *aclUser) addPassword(hash string) {
+	u.nopass = false
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+}
+
+func (u *aclUser) removePassword(hash string) error {
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return nil
+		}
+	}
+	return errACLNoSuchPassword
+}
+
+// appendPattern adds a key or channel pattern unless it is there already.
+func appendPattern(patterns []string, pattern string) []string {
+	for _, p := range patterns {
+		if p == pattern {
+			return patterns
+		}
+	}
+	return append(patterns, pattern)
+}
+
+func (u *aclUser) addCommandRule(allow bool, name string) error {
+	if category := strings.TrimPrefix(name, "@"); category != name {
+		if !isACLCategory(category) {
+			return errACLUnknownCommand
+		}
+		if category == "all" {
+			// Every earlier rule is overridden.
+			u.commands = nil
+		}
+	} else {
+		command := name
+		if i := strings.IndexByte(name, '|'); i >= 0 {
+			if i == len(name)-1 {
+				return errACLUnknownCommand
+			}
+			command = name[:i]
+		}
+		if _, ok := lookupCommand(command); !ok {
+			return errACLUnknownCommand
+		}
+	}
+	// An earlier rule for the same name is overridden.
+	rules := u.commands[:0]
+	for _, r := range u.commands {
+		if r.name != name {
+			rules = append(rules, r)
+		}
+	}
+	u.commands = append(rules, commandRule{allow: allow, name: name})
+	return nil
+}
+
+func (u *aclUser) setDatabases(list string) error {
+	var dbs []int
+	for _, field := range strings.Split(list, ",") {
+		idx, err := strconv.Atoi(field)
+		if err != nil || idx < 0 {
+			return errACLBadDatabase
+		}
+		dbs = append(dbs, idx)
+	}
+	sort.Ints(dbs)
+	u.dbs = dbs
+	return nil
+}
+
+// checkPassword reports whether the user may log in with password.
+func (u *aclUser) checkPassword(password string) bool {
+	if !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	hash := hashPassword(password)
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(hash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// canRun reports whether the user may run cmd. A rule for a subcommand,
+// such as "config|get", applies to commands whose first argument names it.
+func (u *aclUser) canRun(spec *CommandSpec, cmd Command) bool {
+	name := strings.ToLower(spec.Name)
+	full := name
+	if len(cmd.Argv) > 0 {
+		full = name + "|" + strings.ToLower(cmd.arg(0))
+	}
+	categories := spec.aclCategories()
+	allowed := false
+	for _, r := range u.commands {
+		match := r.name == name || r.name == full
+		if category := strings.TrimPrefix(r.name, "@"); category != r.name {
+			for _, c := range categories {
+				match = match || c == category
+			}
+		}
+		if match {
+			allowed = r.allow
+		}
+	}
+	return allowed
+}
+
+func (u *aclUser) canKey(key string) bool {
+	return matchesAny(u.keys, key)
+}
+
+// canAllKeys reports whether the user may access every key, whatever its
+// name.
+func (u *aclUser) canAllKeys() bool {
+	for _, p := range u.keys {
+		if p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *aclUser) canChannel(channel string) bool {
+	return matchesAny(u.channels, channel)
+}
+
+func matchesAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if globMatch(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *aclUser) canUseDB(dbIndex int) bool {
+	if u.dbs == nil {
+		return true
+	}
+	for _, idx := range u.dbs {
+		if idx == dbIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// flags returns the flags ACL GETUSER reports.
+func (u *aclUser) flags() []interface{} {
+	flags := []interface{}{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *aclUser) describeCommands() string {
+	if len(u.commands) == 0 {
+		return "-@all"
+	}
+	rules := make([]string, len(u.commands))
+	for i, r := range u.commands {
+		sign := "-"
+		if r.allow {
+			sign = "+"
+		}
+		rules[i] = sign + r.name
+	}
+	return strings.Join(rules, " ")
+}
+
+func describePatterns(prefix string, patterns []string) string {
+	words := make([]string, len(patterns))
+	for i, p := range patterns {
+		words[i] = prefix + p
+	}
+	return strings.Join(words, " ")
+}
+
+func (u *aclUser) describeDatabases() string {
+	if u.dbs == nil {
+		return "alldbs"
+	}
+	indexes := make([]string, len(u.dbs))
+	for i, idx := range u.dbs {
+		indexes[i] = strconv.Itoa(idx)
+	}
+	return "db=" + strings.Join(indexes, ",")
+}
+
+// describe returns the rules that recreate the user, as ACL LIST shows
+// them and the ACL file stores them.
+func (u *aclUser) describe() string {
+	words := []string{"user", u.name}
+	for _, flag := range u.flags() {
+		words = append(words, flag.(string))
+	}
+	for _, p := range u.passwords {
+		words = append(words, "#"+p)
+	}
+	if len(u.keys) > 0 {
+		words = append(words, describePatterns("~", u.keys))
+	}
+	if len(u.channels) > 0 {
+		words = append(words, describePatterns("&", u.channels))
+	}
+	if u.dbs != nil {
+		words = append(words, u.describeDatabases())
+	}
+	words = append(words, u.describeCommands())
+	return strings.Join(words, " ")
+}
+
+// aclCategories returns the ACL categories of a command: "all", those of
+// its flags and the one of its group.
+func (spec *CommandSpec) aclCategories() []string {
+	categories := []string{"all"}
+	if spec.Flags&FlagWrite != 0 {
+		categories = append(categories, "write")
+	}
+	if spec.Flags&FlagReadOnly != 0 {
+		categories = append(categories, "read")
+	}
+	if spec.Flags&FlagAdmin != 0 {
+		categories = append(categories, "admin", "dangerous")
+	}
+	if spec.Flags&FlagBlocking != 0 {
+		categories = append(categories, "blocking")
+	}
+	switch spec.Group {
+	case "":
+	case "generic":
+		categories = append(categories, "keyspace")
+	case "transactions":
+		categories = append(categories, "transaction")
+	case "sorted-set":
+		categories = append(categories, "sortedset")
+	default:
+		categories = append(categories, spec.Group)
+	}
+	return categories
+}
+
+// aclCategoryNames returns every category some command belongs to.
+func aclCategoryNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, spec := range registry {
+		for _, c := range spec.aclCategories() {
+			if !seen[c] {
+				seen[c] = true
+				names = append(names, c)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isACLCategory(name string) bool {
+	for _, c := range aclCategoryNames() {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// aclLogEntry records commands or logins that were denied. Denials that
+// differ only in time are counted in a single entry.
+type aclLogEntry struct {
+	id       int64
+	count    int
+	reason   string
+	context  string
+	object   string
+	username string
+	created  time.Time
+	updated  time.Time
+	client   string
+}
+
+// aclLogGroupTime is how long after its last denial an entry still counts
+// identical ones.
+const aclLogGroupTime = 60 * time.Second
+
+// accessList holds the users and the log of denials. It is guarded by the
+// KeyValueDB mutex.
+type accessList struct {
+	users map[string]*aclUser
+	// log holds the newest entries first.
+	log         []*aclLogEntry
+	lastEntryID int64
+}
+
+func newAccessList() *accessList {
+	return &accessList{users: map[string]*aclUser{"default": newDefaultUser()}}
+}
+
+// userNames returns the names of the users in order.
+func (acl *accessList) userNames() []string {
+	names := make([]string, 0, len(acl.users))
+	for name := range acl.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// record adds a denial to the log, keeping at most maxLen entries.
+func (acl *accessList) record(entry aclLogEntry, maxLen int) {
+	for i, e := range acl.log {
+		if e.reason == entry.reason && e.context == entry.context && e.object == entry.object &&
+			e.username == entry.username && entry.created.Sub(e.updated) < aclLogGroupTime {
+			e.count++
+			e.updated = entry.created
+			e.client = entry.client
+			copy(acl.log[1:i+1], acl.log[:i])
+			acl.log[0] = e
+			return
+		}
+	}
+	acl.lastEntryID++
+	entry.id = acl.lastEntryID
+	entry.count = 1
+	entry.updated = entry.created
+	acl.log = append([]*aclLogEntry{&entry}, acl.log...)
+	if len(acl.log) > maxLen {
+		acl.log = acl.log[:maxLen]
+	}
+}
+
+// parseACLFile reads the users of an ACL file, one "user name rules..."
+// line each. Blank lines and lines starting with # are skipped. The default
+// user is added with its usual rules when the file does not define it.
+func parseACLFile(path string) (map[string]*aclUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]*aclUser)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: line should start with user keyword and a name", path, lineNo)
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate user '%s'", path, lineNo, name)
+		}
+		u := newACLUser(name)
+		if rule, err := u.setRules(fields[2:]); err != nil {
+			return nil, fmt.Errorf("%s:%d: error in user declaration '%s': %v", path, lineNo, rule, err)
+		}
+		users[name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := users["default"]; !ok {
+		users["default"] = newDefaultUser()
+	}
+	return users, nil
+}
+
+// writeACLFile replaces the ACL file with the users, once they are written
+// out in full.
+func writeACLFile(path string, acl *accessList) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".kvdb-acl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, name := range acl.userNames() {
+		fmt.Fprintln(writer, acl.users[name].describe())
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
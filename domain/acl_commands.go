@@ -0,0 +1,318 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	errNoAuth    = ErrorReply("(error) NOAUTH Authentication required.")
+	errWrongPass = ErrorReply("(error) WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+// checkAccess returns the error a session gets for a command it may not
+// run, after recording the denial in the ACL log. Commands given to
+// Execute run with every permission.
+func (kvdb *KeyValueDB) checkAccess(s *Session, dbIndex int, cmd Command, spec *CommandSpec) ErrorReply {
+	if s == kvdb.session || spec.Name == AUTH {
+		return ""
+	}
+	if !s.authenticated {
+		return errNoAuth
+	}
+	u, ok := kvdb.acl.users[s.user]
+	if !ok {
+		// The user was deleted and the connection is being closed.
+		return errNoAuth
+	}
+
+	if !u.canRun(spec, cmd) {
+		name := strings.ToLower(spec.Name)
+		kvdb.logDenied(s, "command", name, s.user)
+		return ErrorReply(fmt.Sprintf("(error) NOPERM User %s has no permissions to run the '%s' command", s.user, name))
+	}
+	for _, idx := range kvdb.accessedDBs(dbIndex, cmd, spec) {
+		if !u.canUseDB(idx) {
+			kvdb.logDenied(s, "database", strconv.Itoa(idx), s.user)
+			return ErrorReply("(error) NOPERM No permissions to access a database")
+		}
+	}
+	if readsEveryKey(spec) && !u.canAllKeys() {
+		kvdb.logDenied(s, "key", "*", s.user)
+		return ErrorReply("(error) NOPERM No permissions to access a key")
+	}
+	for _, key := range cmd.Keys() {
+		if !u.canKey(key) {
+			kvdb.logDenied(s, "key", key, s.user)
+			return ErrorReply("(error) NOPERM No permissions to access a key")
+		}
+	}
+	return ""
+}
+
+// readsEveryKey reports whether a command reads keys it is not given by
+// name, so that only users who may access every key can run it: TS.MRANGE
+// picks series by their labels and COMPACT dumps the whole database.
+func readsEveryKey(spec *CommandSpec) bool {
+	return spec.Name == TS_MRANGE || spec.Name == COMPACT
+}
+
+// accessedDBs returns the databases a command uses: the one SELECT
+// switches to, every one for the commands that reach across databases,
+// and otherwise the selected one when the command reads keys.
+func (kvdb *KeyValueDB) accessedDBs(dbIndex int, cmd Command, spec *CommandSpec) []int {
+	switch spec.Name {
+	case SELECT:
+		if idx, err := strconv.Atoi(cmd.arg(0)); err == nil {
+			return []int{idx}
+		}
+		return nil
+	case SWAPDB, FLUSHALL, MOVE, COPY:
+		all := make([]int, kvdb.storage.DBCount())
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+	if len(cmd.Keys()) > 0 || readsEveryKey(spec) {
+		return []int{dbIndex}
+	}
+	return nil
+}
+
+func (kvdb *KeyValueDB) logDenied(s *Session, reason, object, username string) {
+	context := "toplevel"
+	if kvdb.isExecuting {
+		context = "multi"
+	}
+	now := timeNow()
+	kvdb.acl.record(aclLogEntry{
+		reason:   reason,
+		context:  context,
+		object:   object,
+		username: username,
+		created:  now,
+		client:   strings.TrimSuffix(kvdb.clientLine(s, now), "\n"),
+	}, kvdb.config.Values().ACLLogMaxLen)
+}
+
+// authenticates reports whether a new connection is logged in as the
+// default user without AUTH.
+func (kvdb *KeyValueDB) authenticates() bool {
+	u, ok := kvdb.acl.users["default"]
+	return ok && u.enabled && u.nopass
+}
+
+func auth(ctx *CommandContext, cmd Command) interface{} {
+	kvdb, s := ctx.kvdb, ctx.session
+	args := cmd.params()
+	name, password := "default", args[0]
+	if len(args) == 2 {
+		name, password = args[0], args[1]
+	} else if kvdb.authenticates() {
+		return ErrorReply("(error) ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	u, ok := kvdb.acl.users[name]
+	if !ok || !u.checkPassword(password) {
+		kvdb.logDenied(s, "auth", "AUTH", name)
+		return errWrongPass
+	}
+	s.user = name
+	s.authenticated = true
+	return statusOK
+}
+
+func aclCommand(ctx *CommandContext, cmd Command) interface{} {
+	kvdb, s := ctx.kvdb, ctx.session
+	args := cmd.params()
+	switch sub := strings.ToUpper(args[0]); {
+	case sub == "SETUSER" && len(args) > 1:
+		return kvdb.aclSetUser(args[1], args[2:])
+	case sub == "GETUSER" && len(args) == 2:
+		return kvdb.aclGetUser(args[1])
+	case sub == "DELUSER" && len(args) > 1:
+		return kvdb.aclDelUser(args[1:])
+	case sub == "LIST" && len(args) == 1:
+		lines := []interface{}{}
+		for _, name := range kvdb.acl.userNames() {
+			lines = append(lines, kvdb.acl.users[name].describe())
+		}
+		return lines
+	case sub == "USERS" && len(args) == 1:
+		names := []interface{}{}
+		for _, name := range kvdb.acl.userNames() {
+			names = append(names, name)
+		}
+		return names
+	case sub == "WHOAMI" && len(args) == 1:
+		return s.user
+	case sub == "CAT" && len(args) <= 2:
+		return aclCat(args[1:])
+	case sub == "LOG" && len(args) <= 2:
+		return kvdb.aclLog(args[1:])
+	case sub == "SAVE" && len(args) == 1:
+		path := kvdb.config.Values().ACLFile
+		if path == "" {
+			return ErrorReply("(error) ERR The server is running without an ACL file")
+		}
+		if err := writeACLFile(path, kvdb.acl); err != nil {
+			return ErrorReply(fmt.Sprintf("(error) ERR Saving ACL file: %v", err))
+		}
+		return statusOK
+	case sub == "LOAD" && len(args) == 1:
+		if kvdb.config.Values().ACLFile == "" {
+			return ErrorReply("(error) ERR The server is running without an ACL file")
+		}
+		if err := kvdb.loadACL(); err != nil {
+			return ErrorReply(fmt.Sprintf("(error) ERR Loading ACL file: %v", err))
+		}
+		return statusOK
+	case sub == "SETUSER" || sub == "GETUSER" || sub == "DELUSER" || sub == "LIST" || sub == "USERS" ||
+		sub == "WHOAMI" || sub == "CAT" || sub == "LOG" || sub == "SAVE" || sub == "LOAD":
+		return ErrorReply(fmt.Sprintf("(error) ERR wrong number of arguments for 'acl|%s' command", strings.ToLower(sub)))
+	}
+	return ErrorReply(fmt.Sprintf("(error) ERR unknown subcommand '%s'. Try ACL HELP.", args[0]))
+}
+
+// aclSetUser creates or changes a user. The rules apply all or not at all.
+func (kvdb *KeyValueDB) aclSetUser(name string, rules []string) interface{} {
+	if strings.ContainsAny(name, " \t\r\n\x00") {
+		return ErrorReply("(error) ERR Usernames can't contain spaces or null characters")
+	}
+	u := newACLUser(name)
+	if old, ok := kvdb.acl.users[name]; ok {
+		u = old.clone()
+	}
+	if rule, err := u.setRules(rules); err != nil {
+		return ErrorReply(fmt.Sprintf("(error) ERR Error in ACL SETUSER modifier '%s': %v", rule, err))
+	}
+	kvdb.acl.users[name] = u
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) aclGetUser(name string) interface{} {
+	u, ok := kvdb.acl.users[name]
+	if !ok {
+		return nil
+	}
+	passwords := []interface{}{}
+	for _, p := range u.passwords {
+		passwords = append(passwords, p)
+	}
+	return []interface{}{
+		"flags", u.flags(),
+		"passwords", passwords,
+		"commands", u.describeCommands(),
+		"keys", describePatterns("~", u.keys),
+		"channels", describePatterns("&", u.channels),
+		"databases", u.describeDatabases(),
+	}
+}
+
+// aclDelUser deletes users and disconnects the clients logged in as them.
+func (kvdb *KeyValueDB) aclDelUser(names []string) interface{} {
+	deleted := 0
+	for _, name := range names {
+		if name == "default" {
+			return ErrorReply("(error) ERR The 'default' user cannot be removed")
+		}
+	}
+	for _, name := range names {
+		if _, ok := kvdb.acl.users[name]; ok {
+			delete(kvdb.acl.users, name)
+			deleted++
+		}
+	}
+	kvdb.dropOrphanedSessions()
+	return deleted
+}
+
+// dropOrphanedSessions disconnects the clients whose user no longer exists.
+func (kvdb *KeyValueDB) dropOrphanedSessions() {
+	for _, s := range kvdb.sessions {
+		if _, ok := kvdb.acl.users[s.user]; ok || !s.authenticated {
+			continue
+		}
+		s.authenticated = false
+		if s.conn.Close != nil {
+			s.conn.Close()
+		}
+	}
+}
+
+// aclCat lists the categories, or the commands of one of them.
+func aclCat(args []string) interface{} {
+	reply := []interface{}{}
+	if len(args) == 0 {
+		for _, c := range aclCategoryNames() {
+			reply = append(reply, c)
+		}
+		return reply
+	}
+	category := strings.ToLower(args[0])
+	if !isACLCategory(category) {
+		return ErrorReply(fmt.Sprintf("(error) ERR Unknown category '%s'", args[0]))
+	}
+	for _, spec := range sortedCommands() {
+		for _, c := range spec.aclCategories() {
+			if c == category {
+				reply = append(reply, strings.ToLower(spec.Name))
+				break
+			}
+		}
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) aclLog(args []string) interface{} {
+	count := len(kvdb.acl.log)
+	if len(args) == 1 {
+		if strings.EqualFold(args[0], "RESET") {
+			kvdb.acl.log = nil
+			return statusOK
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return errNotInteger
+		}
+		if n < count {
+			count = n
+		}
+	}
+	now := timeNow()
+	entries := []interface{}{}
+	for _, e := range kvdb.acl.log[:count] {
+		entries = append(entries, []interface{}{
+			"count", e.count,
+			"reason", e.reason,
+			"context", e.context,
+			"object", e.object,
+			"username", e.username,
+			"age-seconds", strconv.FormatFloat(now.Sub(e.created).Seconds(), 'f', 3, 64),
+			"client-info", e.client,
+			"entry-id", e.id,
+			"timestamp-created", e.created.UnixMilli(),
+			"timestamp-last-updated", e.updated.UnixMilli(),
+		})
+	}
+	return entries
+}
+
+// LoadACL replaces the users with those of the file named by the aclfile
+// setting.
+func (kvdb *KeyValueDB) LoadACL() error {
+	kvdb.mu.Lock()
+	defer kvdb.mu.Unlock()
+	return kvdb.loadACL()
+}
+
+func (kvdb *KeyValueDB) loadACL() error {
+	users, err := parseACLFile(kvdb.config.Values().ACLFile)
+	if err != nil {
+		return err
+	}
+	kvdb.acl.users = users
+	kvdb.dropOrphanedSessions()
+	return nil
+}
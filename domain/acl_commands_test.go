@@ -0,0 +1,216 @@
+package domain
+
+import (
+	"keyvaluedb/config"
+	"keyvaluedb/storage"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestACLCommands(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	admin := kvdb.NewSession(Conn{RemoteAddr: "10.0.0.1:5000"})
+	if got := kvdb.Run(admin, NewCommand(ACL, "SETUSER", "default", ">adminpw")); got != statusOK {
+		t.Fatalf("ACL SETUSER default returned %#v", got)
+	}
+	var closed []string
+	client := kvdb.NewSession(Conn{RemoteAddr: "10.0.0.2:5000", Close: func() { closed = append(closed, "client") }})
+
+	type step struct {
+		session  *Session
+		cmd      Command
+		expected interface{}
+	}
+	steps := []step{
+		{client, NewCommand(GET, "cache:1"), ErrorReply("(error) NOAUTH Authentication required.")},
+		{client, NewCommand(AUTH, "wrong"), ErrorReply("(error) WRONGPASS invalid username-password pair or user is disabled.")},
+		{admin, NewCommand(ACL, "SETUSER", "alice", "on", ">alicepw", "~cache:*", "+@read", "+set", "+select", "db=0"), statusOK},
+		{admin, NewCommand(ACL, "SETUSER", "alice", "+nosuch"), ErrorReply("(error) ERR Error in ACL SETUSER modifier '+nosuch': Unknown command or category name in ACL")},
+		{admin, NewCommand(ACL, "SETUSER", "a b"), ErrorReply("(error) ERR Usernames can't contain spaces or null characters")},
+		{client, NewCommand(AUTH, "alice", "alicepw"), statusOK},
+		{client, NewCommand(ACL, "WHOAMI"), ErrorReply("(error) NOPERM User alice has no permissions to run the 'acl' command")},
+		{client, NewCommand(SET, "cache:1", "v"), statusOK},
+		{client, NewCommand(GET, "cache:1"), "v"},
+		{client, NewCommand(GET, "session:1"), ErrorReply("(error) NOPERM No permissions to access a key")},
+		{client, NewCommand(DEL, "cache:1"), ErrorReply("(error) NOPERM User alice has no permissions to run the 'del' command")},
+		{client, NewCommand(SELECT, "1"), ErrorReply("(error) NOPERM No permissions to access a database")},
+		{admin, NewCommand(ACL, "WHOAMI"), "default"},
+		{admin, NewCommand(ACL, "USERS"), []interface{}{"alice", "default"}},
+		{admin, NewCommand(ACL, "GETUSER", "alice"), []interface{}{
+			"flags", []interface{}{"on"},
+			"passwords", []interface{}{hashPassword("alicepw")},
+			"commands", "+@read +set +select",
+			"keys", "~cache:*",
+			"channels", "",
+			"databases", "db=0",
+		}},
+		{admin, NewCommand(ACL, "GETUSER", "bob"), nil},
+		{admin, NewCommand(ACL, "LIST"), []interface{}{
+			"user alice on #" + hashPassword("alicepw") + " ~cache:* db=0 +@read +set +select",
+			"user default on #" + hashPassword("adminpw") + " ~* &* +@all",
+		}},
+		{admin, NewCommand(ACL, "LOG", "2"), []interface{}{
+			[]interface{}{
+				"count", 1, "reason", "database", "context", "toplevel", "object", "1", "username", "alice",
+				"age-seconds", "0.000", "client-info", "id=2 addr=10.0.0.2:5000 laddr= name= age=0 idle=0 flags=N db=0 sub=0 psub=0 multi=-1 qbuf=0 obl=0 cmd=select user=alice",
+				"entry-id", int64(5), "timestamp-created", clock.UnixMilli(), "timestamp-last-updated", clock.UnixMilli(),
+			},
+			[]interface{}{
+				"count", 1, "reason", "command", "context", "toplevel", "object", "del", "username", "alice",
+				"age-seconds", "0.000", "client-info", "id=2 addr=10.0.0.2:5000 laddr= name= age=0 idle=0 flags=N db=0 sub=0 psub=0 multi=-1 qbuf=0 obl=0 cmd=del user=alice",
+				"entry-id", int64(4), "timestamp-created", clock.UnixMilli(), "timestamp-last-updated", clock.UnixMilli(),
+			},
+		}},
+		{admin, NewCommand(ACL, "DELUSER", "default"), ErrorReply("(error) ERR The 'default' user cannot be removed")},
+		{admin, NewCommand(ACL, "DELUSER", "alice", "bob"), 1},
+		{client, NewCommand(GET, "cache:1"), ErrorReply("(error) NOAUTH Authentication required.")},
+		{admin, NewCommand(ACL, "LOG", "RESET"), statusOK},
+		{admin, NewCommand(ACL, "LOG"), []interface{}{}},
+		{admin, NewCommand(ACL, "SAVE"), ErrorReply("(error) ERR The server is running without an ACL file")},
+		{admin, NewCommand(ACL, "FROB"), ErrorReply("(error) ERR unknown subcommand 'FROB'. Try ACL HELP.")},
+	}
+	for _, step := range steps {
+		if got := kvdb.Run(step.session, step.cmd); !reflect.DeepEqual(got, step.expected) {
+			t.Errorf("command %v returned %#v, expected %#v", step.cmd, got, step.expected)
+		}
+	}
+	if !reflect.DeepEqual(closed, []string{"client"}) {
+		t.Errorf("ACL DELUSER closed %v, expected the client of alice", closed)
+	}
+}
+
+func TestACLLogCountsRepeats(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	s := kvdb.NewSession(Conn{})
+	for _, delay := range []time.Duration{0, time.Second, 2 * time.Minute} {
+		clock = clock.Add(delay)
+		kvdb.Run(s, NewCommand(AUTH, "bob", "pw"))
+	}
+	entries := kvdb.Run(s, NewCommand(ACL, "LOG")).([]interface{})
+	if len(entries) != 2 {
+		t.Fatalf("ACL LOG returned %d entries, expected 2", len(entries))
+	}
+	for i, count := range []int{1, 2} {
+		if got := entries[i].([]interface{})[1]; got != count {
+			t.Errorf("entry %d counts %v denials, expected %d", i, got, count)
+		}
+	}
+}
+
+// COMMAND is in the read and connection categories, so that clients of
+// users limited to them can look commands up.
+func TestACLCommandDescribesCommandsToReaders(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(ACL, "SETUSER", "reader", "on", "nopass", "+@read"))
+	kvdb.Execute(0, NewCommand(ACL, "SETUSER", "client", "on", "nopass", "+@connection"))
+	kvdb.Execute(0, NewCommand(ACL, "SETUSER", "writer", "on", "nopass", "+@write"))
+
+	for _, test := range []struct {
+		user    string
+		cmd     Command
+		allowed bool
+	}{
+		{"reader", NewCommand(COMMAND), true},
+		{"reader", NewCommand(COMMAND, "DOCS", "GET"), true},
+		{"reader", NewCommand(COMMAND, "COUNT"), true},
+		{"client", NewCommand(COMMAND, "INFO", "GET"), true},
+		{"writer", NewCommand(COMMAND), false},
+	} {
+		s := kvdb.NewSession(Conn{})
+		kvdb.Run(s, NewCommand(AUTH, test.user, "pw"))
+		_, denied := kvdb.Run(s, test.cmd).(ErrorReply)
+		if denied == test.allowed {
+			t.Errorf("%s: command %v was allowed: %v, expected %v", test.user, test.cmd, !denied, test.allowed)
+		}
+	}
+}
+
+func TestACLCommandsReadingEveryKey(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(SET, "secret:pw", "hunter2"))
+	kvdb.Execute(0, NewCommand(TS_ADD, "secret:ts", "1", "1", "LABELS", "kind", "secret"))
+	kvdb.Execute(0, NewCommand(ACL, "SETUSER", "alice", "on", "nopass", "~cache:*", "+@read", "+@admin"))
+	kvdb.Execute(0, NewCommand(ACL, "SETUSER", "bob", "on", "nopass", "allkeys", "+@read", "+@admin"))
+
+	for _, test := range []struct {
+		user     string
+		cmd      Command
+		expected interface{}
+	}{
+		{"alice", NewCommand(TS_MRANGE, "-", "+", "FILTER", "kind=secret"), ErrorReply("(error) NOPERM No permissions to access a key")},
+		{"alice", NewCommand(COMPACT), ErrorReply("(error) NOPERM No permissions to access a key")},
+		{"bob", NewCommand(TS_MRANGE, "-", "+", "FILTER", "kind=missing"), []interface{}{}},
+	} {
+		s := kvdb.NewSession(Conn{})
+		kvdb.Run(s, NewCommand(AUTH, test.user, "pw"))
+		if got := kvdb.Run(s, test.cmd); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: command %v returned %#v, expected %#v", test.user, test.cmd, got, test.expected)
+		}
+	}
+	s := kvdb.NewSession(Conn{})
+	kvdb.Run(s, NewCommand(AUTH, "bob", "pw"))
+	if got, ok := kvdb.Run(s, NewCommand(COMPACT)).([]interface{}); !ok || len(got) == 0 {
+		t.Errorf("COMPACT for a user with every key returned %#v", got)
+	}
+}
+
+func TestACLFileCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	if err := os.WriteFile(path, []byte("user default on nopass ~* &* +@all\nuser ops on >opspw +@all ~*\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.New()
+	if err := cfg.Set("aclfile", path); err != nil {
+		t.Fatal(err)
+	}
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.SetConfig(cfg)
+	if err := kvdb.LoadACL(); err != nil {
+		t.Fatal(err)
+	}
+	s := kvdb.NewSession(Conn{})
+	for _, step := range []struct {
+		cmd      Command
+		expected interface{}
+	}{
+		{NewCommand(AUTH, "ops", "opspw"), statusOK},
+		{NewCommand(ACL, "SETUSER", "default", "off"), statusOK},
+		{NewCommand(ACL, "SAVE"), statusOK},
+		{NewCommand(ACL, "SETUSER", "ops", "off"), statusOK},
+		{NewCommand(ACL, "LOAD"), statusOK},
+		{NewCommand(ACL, "GETUSER", "ops"), []interface{}{
+			"flags", []interface{}{"on"},
+			"passwords", []interface{}{hashPassword("opspw")},
+			"commands", "+@all",
+			"keys", "~*",
+			"channels", "",
+			"databases", "alldbs",
+		}},
+	} {
+		if got := kvdb.Run(s, step.cmd); !reflect.DeepEqual(got, step.expected) {
+			t.Errorf("command %v returned %#v, expected %#v", step.cmd, got, step.expected)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "user default off nopass ~* &* +@all\n") {
+		t.Errorf("ACL SAVE wrote:\n%s", data)
+	}
+	if got := kvdb.Run(kvdb.NewSession(Conn{}), NewCommand(GET, "k")); got != errNoAuth {
+		t.Errorf("a new connection with the default user off got %#v", got)
+	}
+}
@@ -0,0 +1,201 @@
+package domain
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestACLUserRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []string
+		expected string
+		err      string
+	}{
+		{
+			name:     "New user",
+			expected: "user alice off -@all",
+		},
+		{
+			name:     "Password and patterns",
+			rules:    []string{"on", ">secret", "~cache:*", "~cache:*", "&news.*", "+@read", "-@admin"},
+			expected: "user alice on #" + hashPassword("secret") + " ~cache:* &news.* +@read -@admin",
+		},
+		{
+			name:     "A later rule for a command replaces an earlier one",
+			rules:    []string{"+get", "+set", "-get"},
+			expected: "user alice off +set -get",
+		},
+		{
+			name:     "@all replaces every earlier rule",
+			rules:    []string{"+get", "-@all", "+config|get"},
+			expected: "user alice off -@all +config|get",
+		},
+		{
+			name:     "Databases",
+			rules:    []string{"db=2,0"},
+			expected: "user alice off db=0,2 -@all",
+		},
+		{
+			name:     "nopass drops the passwords",
+			rules:    []string{">a", ">b", "<a", "nopass", "allkeys", "allcommands"},
+			expected: "user alice off nopass ~* +@all",
+		},
+		{
+			name:     "reset",
+			rules:    []string{"on", ">a", "~*", "+@all", "db=1", "reset"},
+			expected: "user alice off -@all",
+		},
+		{
+			name:  "Unknown command",
+			rules: []string{"+nosuch"},
+			err:   "+nosuch: Unknown command or category name in ACL",
+		},
+		{
+			name:  "Unknown category",
+			rules: []string{"-@nosuch"},
+			err:   "-@nosuch: Unknown command or category name in ACL",
+		},
+		{
+			name:  "Removing a missing password",
+			rules: []string{"<nope"},
+			err:   "<nope: The password you are trying to remove from the user does not exist",
+		},
+		{
+			name:  "Bad password hash",
+			rules: []string{"#abc"},
+			err:   "#abc: The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters",
+		},
+		{
+			name:  "Bad database",
+			rules: []string{"db=1,x"},
+			err:   "db=1,x: Database indexes must be non-negative integers",
+		},
+		{
+			name:  "Unknown rule",
+			rules: []string{"sometimes"},
+			err:   "sometimes: Syntax error",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u := newACLUser("alice")
+			rule, err := u.setRules(test.rules)
+			if test.err != "" {
+				if err == nil || rule+": "+err.Error() != test.err {
+					t.Errorf("setRules(%q) failed with %q: %v, expected %q", test.rules, rule, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setRules(%q) failed with %q: %v", test.rules, rule, err)
+			}
+			if got := u.describe(); got != test.expected {
+				t.Errorf("setRules(%q) described as %q, expected %q", test.rules, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestACLUserPermissions(t *testing.T) {
+	u := newACLUser("alice")
+	if _, err := u.setRules([]string{"on", ">pw", "+@read", "+config", "-config|set", "~cache:*", "db=1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		cmd      Command
+		expected bool
+	}{
+		{NewCommand(GET, "k"), true},
+		{NewCommand(SET, "k", "v"), false},
+		{NewCommand(CONFIG, "GET", "port"), true},
+		{NewCommand(CONFIG, "set", "timeout", "1"), false},
+		{NewCommand(FLUSHALL), false},
+	} {
+		spec, _ := lookupCommand(test.cmd.Name)
+		if got := u.canRun(spec, test.cmd); got != test.expected {
+			t.Errorf("canRun(%v) = %v, expected %v", test.cmd, got, test.expected)
+		}
+	}
+	if !u.canKey("cache:1") || u.canKey("session:1") {
+		t.Errorf("key patterns %q are not applied", u.keys)
+	}
+	if !u.canUseDB(1) || u.canUseDB(0) {
+		t.Errorf("databases %v are not applied", u.dbs)
+	}
+	if !u.checkPassword("pw") || u.checkPassword("nope") {
+		t.Error("password is not checked")
+	}
+	u.enabled = false
+	if u.checkPassword("pw") {
+		t.Error("a disabled user can log in")
+	}
+}
+
+func TestACLFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	acl := newAccessList()
+	alice := newACLUser("alice")
+	if _, err := alice.setRules([]string{"on", ">pw", "~cache:*", "+@read", "db=0,3"}); err != nil {
+		t.Fatal(err)
+	}
+	acl.users["alice"] = alice
+	if err := writeACLFile(path, acl); err != nil {
+		t.Fatal(err)
+	}
+	users, err := parseACLFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(users, acl.users) {
+		t.Errorf("ACL file read back as %v, expected %v", users, acl.users)
+	}
+
+	for _, test := range []struct {
+		name     string
+		contents string
+		err      string
+	}{
+		{
+			name:     "Comments and no default user",
+			contents: "# users\n\nuser bob on nopass +@all\n",
+		},
+		{
+			name:     "Missing keyword",
+			contents: "bob on\n",
+			err:      ":1: line should start with user keyword and a name",
+		},
+		{
+			name:     "Bad rule",
+			contents: "user bob on\nuser carol +nosuch\n",
+			err:      ":2: error in user declaration '+nosuch': Unknown command or category name in ACL",
+		},
+		{
+			name:     "Duplicate user",
+			contents: "user bob on\nuser bob off\n",
+			err:      ":2: duplicate user 'bob'",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(test.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+			users, err := parseACLFile(path)
+			if test.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), test.err) {
+					t.Errorf("parseACLFile failed with %v, expected %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := users["default"]; !ok {
+				t.Error("the default user was not added")
+			}
+		})
+	}
+}
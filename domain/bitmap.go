@@ -0,0 +1,217 @@
+package domain
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Bits are addressed the way Redis does it: offset 0 is the most significant
+// bit of the first byte.
+
+// maxBitOffset is the largest bit offset a bitmap may grow to (512MB).
+const maxBitOffset = 1<<32 - 1
+
+func getBit(b []byte, offset uint64) int {
+	idx := offset >> 3
+	if idx >= uint64(len(b)) {
+		return 0
+	}
+	return int(b[idx]>>(7-offset&7)) & 1
+}
+
+// setBit sets the bit at offset and returns the previous value. The slice
+// must already be long enough.
+func setBit(b []byte, offset uint64, on bool) int {
+	idx := offset >> 3
+	mask := byte(1 << (7 - offset&7))
+	old := 0
+	if b[idx]&mask != 0 {
+		old = 1
+	}
+	if on {
+		b[idx] |= mask
+	} else {
+		b[idx] &^= mask
+	}
+	return old
+}
+
+// growBytes returns b zero-padded to at least n bytes.
+func growBytes(b []byte, n uint64) []byte {
+	if uint64(len(b)) >= n {
+		return b
+	}
+	if uint64(cap(b)) >= n {
+		old := len(b)
+		b = b[:n]
+		for i := old; i < len(b); i++ {
+			b[i] = 0
+		}
+		return b
+	}
+	grown := make([]byte, n)
+	copy(grown, b)
+	return grown
+}
+
+// normalizeRange turns start and end, which may count back from the end
+// when negative, into an inclusive range within [0, length). It reports
+// false when the range is empty.
+func normalizeRange(start, end, length int64) (int64, int64, bool) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	if length == 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// countBits returns the number of set bits in the inclusive bit range
+// [start, end].
+func countBits(b []byte, start, end uint64) int {
+	firstByte, lastByte := start>>3, end>>3
+	firstMask := byte(0xff >> (start & 7))
+	lastMask := byte(0xff << (7 - end&7))
+	if firstByte == lastByte {
+		return bits.OnesCount8(b[firstByte] & firstMask & lastMask)
+	}
+	count := bits.OnesCount8(b[firstByte]&firstMask) + bits.OnesCount8(b[lastByte]&lastMask)
+	for _, c := range b[firstByte+1 : lastByte] {
+		count += bits.OnesCount8(c)
+	}
+	return count
+}
+
+// findBit returns the offset of the first bit equal to bit in the inclusive
+// bit range [start, end], or -1 when there is none.
+func findBit(b []byte, bit int, start, end uint64) int64 {
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for offset := start; offset <= end; {
+		if offset&7 == 0 && offset+7 <= end && b[offset>>3] == skip {
+			offset += 8
+			continue
+		}
+		if getBit(b, offset) == bit {
+			return int64(offset)
+		}
+		offset++
+	}
+	return -1
+}
+
+// bitfieldType is the integer type of a BITFIELD operation, such as i5 or
+// u16.
+type bitfieldType struct {
+	signed bool
+	bits   uint64
+}
+
+func getUnsignedBitfield(b []byte, offset, width uint64) uint64 {
+	var value uint64
+	for i := uint64(0); i < width; i++ {
+		value = value<<1 | uint64(getBit(b, offset+i))
+	}
+	return value
+}
+
+func getSignedBitfield(b []byte, offset, width uint64) int64 {
+	value := getUnsignedBitfield(b, offset, width)
+	// Sign-extend when the most significant bit of the field is set.
+	if width < 64 && value&(1<<(width-1)) != 0 {
+		value |= math.MaxUint64 << width
+	}
+	return int64(value)
+}
+
+func setUnsignedBitfield(b []byte, offset, width, value uint64) {
+	for i := uint64(0); i < width; i++ {
+		on := value&(1<<(width-1-i)) != 0
+		setBit(b, offset+i, on)
+	}
+}
+
+// Overflow behaviours of BITFIELD SET and INCRBY.
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// unsignedOverflow adds incr to value as a width-bit unsigned integer. It
+// returns the result after applying the overflow policy and whether the
+// operation overflowed.
+func unsignedOverflow(value uint64, incr int64, width uint64, policy int) (uint64, bool) {
+	max := uint64(math.MaxUint64)
+	if width < 64 {
+		max = 1<<width - 1
+	}
+	maxIncr := int64(max - value)
+	minIncr := -int64(value)
+
+	switch {
+	case value > max || (incr > 0 && incr > maxIncr):
+		if policy == overflowSat {
+			return max, true
+		}
+	case incr < 0 && incr < minIncr:
+		if policy == overflowSat {
+			return 0, true
+		}
+	default:
+		return value + uint64(incr), false
+	}
+	return (value + uint64(incr)) & max, true
+}
+
+// signedOverflow is the signed counterpart of unsignedOverflow.
+func signedOverflow(value, incr int64, width uint64, policy int) (int64, bool) {
+	max := int64(math.MaxInt64)
+	if width < 64 {
+		max = 1<<(width-1) - 1
+	}
+	min := -max - 1
+	maxIncr := max - value
+	minIncr := min - value
+
+	switch {
+	case value > max || (width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr):
+		if policy == overflowSat {
+			return max, true
+		}
+	case value < min || (width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr):
+		if policy == overflowSat {
+			return min, true
+		}
+	default:
+		return value + incr, false
+	}
+
+	// Wrap around by truncating to width bits and sign-extending.
+	result := uint64(value) + uint64(incr)
+	if width < 64 {
+		msb := uint64(1) << (width - 1)
+		mask := uint64(math.MaxUint64) << width
+		if result&msb != 0 {
+			result |= mask
+		} else {
+			result &^= mask
+		}
+	}
+	return int64(result), true
+}
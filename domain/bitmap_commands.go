@@ -0,0 +1,434 @@
+package domain
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	errBitOffset = ErrorReply("(error) ERR bit offset is not an integer or out of range")
+	errBitValue  = ErrorReply("(error) ERR bit is not an integer or out of range")
+)
+
+// lookupBytes returns the contents of the string stored at key. It reports
+// false when the key holds another type.
+func (kvdb *KeyValueDB) lookupBytes(dbIndex int, key string) ([]byte, bool) {
+	switch v := kvdb.storage.Get(dbIndex, []byte(key)).(type) {
+	case nil:
+		return nil, true
+	case []byte:
+		return v, true
+	case int64:
+		return strconv.AppendInt(nil, v, 10), true
+	}
+	return nil, false
+}
+
+// mutableBytes returns the string at key as a byte slice that can be changed
+// in place, grown to at least n bytes. The grown slice is stored back so
+// that later commands see the changes.
+func (kvdb *KeyValueDB) mutableBytes(dbIndex int, key string, n uint64) ([]byte, bool) {
+	b, ok := kvdb.lookupBytes(dbIndex, key)
+	if !ok {
+		return nil, false
+	}
+	b = growBytes(b, n)
+	kvdb.storage.Set(dbIndex, []byte(key), b)
+	return b, true
+}
+
+func parseBitOffset(arg string) (uint64, bool) {
+	offset, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || offset > maxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+func (kvdb *KeyValueDB) setbit(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	offset, ok := parseBitOffset(args[1])
+	if !ok {
+		return errBitOffset
+	}
+	var on bool
+	switch args[2] {
+	case "0":
+	case "1":
+		on = true
+	default:
+		return errBitValue
+	}
+
+	b, ok := kvdb.mutableBytes(dbIndex, cmd.arg(0), offset>>3+1)
+	if !ok {
+		return errWrongType
+	}
+	return setBit(b, offset, on)
+}
+
+func (kvdb *KeyValueDB) getbit(dbIndex int, cmd Command) interface{} {
+	offset, ok := parseBitOffset(cmd.arg(1))
+	if !ok {
+		return errBitOffset
+	}
+	b, ok := kvdb.lookupBytes(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	return getBit(b, offset)
+}
+
+// parseBitRange parses the optional "start end [BYTE|BIT]" arguments of
+// BITCOUNT and BITPOS. It returns the range in bits, whether an end was
+// given and whether the range is empty.
+func parseBitRange(args []string, length int) (start, end uint64, hasEnd, empty bool, errMsg ErrorReply) {
+	byteStart, byteEnd := int64(0), int64(-1)
+	bitMode := false
+	if len(args) > 0 {
+		n, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return 0, 0, false, false, errNotInteger
+		}
+		byteStart = n
+	}
+	if len(args) > 1 {
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return 0, 0, false, false, errNotInteger
+		}
+		byteEnd = n
+		hasEnd = true
+	}
+	if len(args) > 2 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			bitMode = true
+		default:
+			return 0, 0, false, false, errSyntax
+		}
+	}
+	if len(args) > 3 {
+		return 0, 0, false, false, errSyntax
+	}
+
+	total := int64(length)
+	if bitMode {
+		total *= 8
+	}
+	s, e, ok := normalizeRange(byteStart, byteEnd, total)
+	if !ok {
+		return 0, 0, hasEnd, true, ""
+	}
+	if bitMode {
+		return uint64(s), uint64(e), hasEnd, false, ""
+	}
+	return uint64(s) * 8, uint64(e)*8 + 7, hasEnd, false, ""
+}
+
+func (kvdb *KeyValueDB) bitcount(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	if len(args) == 2 {
+		return errSyntax
+	}
+	b, ok := kvdb.lookupBytes(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	start, end, _, empty, errMsg := parseBitRange(args[1:], len(b))
+	if errMsg != "" {
+		return errMsg
+	}
+	if empty {
+		return 0
+	}
+	return countBits(b, start, end)
+}
+
+func (kvdb *KeyValueDB) bitpos(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	var bit int
+	switch args[1] {
+	case "0":
+	case "1":
+		bit = 1
+	default:
+		return ErrorReply("(error) ERR The bit argument must be 1 or 0.")
+	}
+
+	b, ok := kvdb.lookupBytes(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	start, end, hasEnd, empty, errMsg := parseBitRange(args[2:], len(b))
+	if errMsg != "" {
+		return errMsg
+	}
+	if len(b) == 0 {
+		if bit == 0 {
+			return 0
+		}
+		return -1
+	}
+	if empty {
+		return -1
+	}
+
+	pos := findBit(b, bit, start, end)
+	// Looking for a clear bit in a range of set bits without an explicit end
+	// finds the first bit past the string, as if it were padded with zeros.
+	if pos == -1 && bit == 0 && !hasEnd {
+		return int(end + 1)
+	}
+	return int(pos)
+}
+
+func (kvdb *KeyValueDB) bitop(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	op := strings.ToUpper(args[0])
+	dest, srcKeys := args[1], args[2:]
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(srcKeys) != 1 {
+			return ErrorReply("(error) ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return errSyntax
+	}
+
+	sources := make([][]byte, 0, len(srcKeys))
+	maxLen := 0
+	for _, key := range srcKeys {
+		b, ok := kvdb.lookupBytes(dbIndex, key)
+		if !ok {
+			return errWrongType
+		}
+		sources = append(sources, b)
+		if len(b) > maxLen {
+			maxLen = len(b)
+		}
+	}
+
+	result := make([]byte, maxLen)
+	for i := range result {
+		var out byte
+		for j, src := range sources {
+			// Missing bytes of shorter strings count as zeros.
+			var c byte
+			if i < len(src) {
+				c = src[i]
+			}
+			switch {
+			case op == "NOT":
+				out = ^c
+			case j == 0:
+				out = c
+			case op == "AND":
+				out &= c
+			case op == "OR":
+				out |= c
+			case op == "XOR":
+				out ^= c
+			}
+		}
+		result[i] = out
+	}
+
+	if maxLen == 0 {
+		kvdb.storage.Del(dbIndex, []byte(dest))
+		kvdb.signalKey(dbIndex, dest)
+	} else {
+		kvdb.replaceValue(dbIndex, dest, result)
+	}
+	return maxLen
+}
+
+// bitfieldOp is a single GET, SET or INCRBY operation of BITFIELD.
+type bitfieldOp struct {
+	name     string
+	typ      bitfieldType
+	offset   uint64
+	value    int64
+	overflow int
+}
+
+func parseBitfieldType(arg string) (bitfieldType, bool) {
+	if len(arg) < 2 {
+		return bitfieldType{}, false
+	}
+	var typ bitfieldType
+	switch arg[0] {
+	case 'i', 'I':
+		typ.signed = true
+	case 'u', 'U':
+	default:
+		return bitfieldType{}, false
+	}
+	width, err := strconv.ParseUint(arg[1:], 10, 8)
+	if err != nil || width < 1 || width > 64 || (!typ.signed && width == 64) {
+		return bitfieldType{}, false
+	}
+	typ.bits = width
+	return typ, true
+}
+
+// parseBitfieldOffset parses an offset in bits, or in multiples of the type
+// width when prefixed with "#".
+func parseBitfieldOffset(arg string, typ bitfieldType) (uint64, bool) {
+	multiply := strings.HasPrefix(arg, "#")
+	if multiply {
+		arg = arg[1:]
+	}
+	offset, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if multiply {
+		offset *= typ.bits
+	}
+	if offset > maxBitOffset || offset+typ.bits-1 > maxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+func parseBitfieldOps(args []string, readOnly bool) ([]bitfieldOp, ErrorReply) {
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	for i := 0; i < len(args); {
+		name := strings.ToUpper(args[i])
+		needed := 3
+		switch name {
+		case "GET":
+		case "SET", "INCRBY":
+			if readOnly {
+				return nil, ErrorReply("(error) ERR BITFIELD_RO only supports the GET subcommand")
+			}
+			needed = 4
+		case "OVERFLOW":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return nil, ErrorReply("(error) ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		default:
+			return nil, errSyntax
+		}
+		if i+needed > len(args) {
+			return nil, errSyntax
+		}
+
+		typ, ok := parseBitfieldType(args[i+1])
+		if !ok {
+			return nil, ErrorReply("(error) ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+		offset, ok := parseBitfieldOffset(args[i+2], typ)
+		if !ok {
+			return nil, errBitOffset
+		}
+		op := bitfieldOp{name: name, typ: typ, offset: offset, overflow: overflow}
+		if needed == 4 {
+			v, err := strconv.ParseInt(args[i+3], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+			op.value = v
+		}
+		ops = append(ops, op)
+		i += needed
+	}
+	return ops, ""
+}
+
+func (kvdb *KeyValueDB) bitfield(dbIndex int, cmd Command, readOnly bool) interface{} {
+	args := cmd.params()
+	ops, errMsg := parseBitfieldOps(args[1:], readOnly)
+	if errMsg != "" {
+		return errMsg
+	}
+
+	// Only writes create the key or grow the string.
+	var grow uint64
+	for _, op := range ops {
+		if op.name != "GET" {
+			if end := (op.offset+op.typ.bits-1)>>3 + 1; end > grow {
+				grow = end
+			}
+		}
+	}
+	var b []byte
+	var ok bool
+	if grow > 0 {
+		b, ok = kvdb.mutableBytes(dbIndex, cmd.arg(0), grow)
+	} else {
+		b, ok = kvdb.lookupBytes(dbIndex, cmd.arg(0))
+	}
+	if !ok {
+		return errWrongType
+	}
+
+	reply := make([]interface{}, 0, len(ops))
+	for _, op := range ops {
+		reply = append(reply, applyBitfieldOp(b, op))
+	}
+	if grow > 0 {
+		kvdb.signalKey(dbIndex, cmd.arg(0))
+	}
+	return reply
+}
+
+// applyBitfieldOp runs op against b and returns its reply: the value read,
+// the old value for SET, the new value for INCRBY, or nil when the FAIL
+// overflow policy prevented the write.
+func applyBitfieldOp(b []byte, op bitfieldOp) interface{} {
+	width := op.typ.bits
+	if op.typ.signed {
+		old := getSignedBitfield(b, op.offset, width)
+		if op.name == "GET" {
+			return old
+		}
+		value, incr := op.value, int64(0)
+		if op.name == "INCRBY" {
+			value, incr = old, op.value
+		}
+		result, overflowed := signedOverflow(value, incr, width, op.overflow)
+		if overflowed && op.overflow == overflowFail {
+			return nil
+		}
+		setUnsignedBitfield(b, op.offset, width, uint64(result))
+		if op.name == "SET" {
+			return old
+		}
+		return result
+	}
+
+	old := getUnsignedBitfield(b, op.offset, width)
+	if op.name == "GET" {
+		return int64(old)
+	}
+	value, incr := uint64(op.value), int64(0)
+	if op.name == "INCRBY" {
+		value, incr = old, op.value
+	}
+	result, overflowed := unsignedOverflow(value, incr, width, op.overflow)
+	if overflowed && op.overflow == overflowFail {
+		return nil
+	}
+	setUnsignedBitfield(b, op.offset, width, result)
+	if op.name == "SET" {
+		return int64(old)
+	}
+	return int64(result)
+}
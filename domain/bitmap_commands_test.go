@@ -0,0 +1,138 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"testing"
+)
+
+func TestBitmapCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "SETBIT grows the string and GETBIT reads it back",
+			commands: []Command{
+				NewCommand(SETBIT, "b", "7", "1"),
+				NewCommand(SETBIT, "b", "7", "0"),
+				NewCommand(SETBIT, "b", "100", "1"),
+				NewCommand(GETBIT, "b", "100"),
+				NewCommand(GETBIT, "b", "1000"),
+				NewCommand(BITCOUNT, "b"),
+			},
+			expected: []interface{}{0, 1, 0, 1, 0, 1},
+		},
+		{
+			name: "SETBIT on an existing string",
+			commands: []Command{
+				NewCommand(SET, "s", "@"),
+				NewCommand(SETBIT, "s", "7", "1"),
+				NewCommand(GET, "s"),
+				NewCommand(INCR, "s"),
+			},
+			expected: []interface{}{statusOK, 0, "A", errNotInteger},
+		},
+		{
+			name: "SETBIT with invalid arguments",
+			commands: []Command{
+				NewCommand(SETBIT, "b", "-1", "1"),
+				NewCommand(SETBIT, "b", "4294967296", "1"),
+				NewCommand(SETBIT, "b", "1", "2"),
+				NewCommand(XADD, "s", "1-1", "a", "b"),
+				NewCommand(SETBIT, "s", "1", "1"),
+			},
+			expected: []interface{}{errBitOffset, errBitOffset, errBitValue, "1-1", errWrongType},
+		},
+		{
+			name: "BITCOUNT with byte and bit ranges",
+			commands: []Command{
+				NewCommand(SET, "s", "foobar"),
+				NewCommand(BITCOUNT, "s"),
+				NewCommand(BITCOUNT, "s", "0", "0"),
+				NewCommand(BITCOUNT, "s", "1", "1"),
+				NewCommand(BITCOUNT, "s", "-2", "-1"),
+				NewCommand(BITCOUNT, "s", "5", "30", "BIT"),
+				NewCommand(BITCOUNT, "s", "1"),
+				NewCommand(BITCOUNT, "missing"),
+			},
+			expected: []interface{}{statusOK, 26, 4, 6, 7, 17, errSyntax, 0},
+		},
+		{
+			name: "BITPOS",
+			commands: []Command{
+				NewCommand(SET, "s", "\xff\xf0\x00"),
+				NewCommand(BITPOS, "s", "0"),
+				NewCommand(BITPOS, "s", "1", "2"),
+				NewCommand(BITPOS, "s", "1", "7", "15", "BIT"),
+				NewCommand(SET, "ones", "\xff"),
+				NewCommand(BITPOS, "ones", "0"),
+				NewCommand(BITPOS, "ones", "0", "0", "-1"),
+				NewCommand(BITPOS, "missing", "0"),
+				NewCommand(BITPOS, "missing", "1"),
+				NewCommand(BITPOS, "s", "2"),
+			},
+			expected: []interface{}{statusOK, 12, -1, 7, statusOK, 8, -1, 0, -1, ErrorReply("(error) ERR The bit argument must be 1 or 0.")},
+		},
+		{
+			name: "BITOP",
+			commands: []Command{
+				NewCommand(SET, "a", "foobar"),
+				NewCommand(SET, "b", "abcdef"),
+				NewCommand(BITOP, "AND", "dest", "a", "b"),
+				NewCommand(GET, "dest"),
+				NewCommand(BITOP, "OR", "dest", "a", "missing"),
+				NewCommand(GET, "dest"),
+				NewCommand(BITOP, "XOR", "dest", "a", "a"),
+				NewCommand(BITCOUNT, "dest"),
+				NewCommand(BITOP, "NOT", "dest", "a", "b"),
+				NewCommand(BITOP, "NOT", "dest", "missing"),
+				NewCommand(GET, "dest"),
+			},
+			expected: []interface{}{
+				statusOK, statusOK, 6, "`bc`ab", 6, "foobar", 6, 0,
+				ErrorReply("(error) ERR BITOP NOT must be called with a single source key."),
+				0, nil,
+			},
+		},
+		{
+			name: "BITFIELD SET, GET and INCRBY",
+			commands: []Command{
+				NewCommand(BITFIELD, "b", "SET", "u8", "0", "255", "GET", "u4", "0", "GET", "i4", "#1"),
+				NewCommand(BITFIELD, "b", "INCRBY", "u8", "0", "10", "OVERFLOW", "SAT", "INCRBY", "u8", "0", "300"),
+				NewCommand(BITFIELD, "b", "OVERFLOW", "FAIL", "INCRBY", "i8", "#1", "200", "INCRBY", "i8", "#1", "-5"),
+				NewCommand(BITFIELD_RO, "b", "GET", "u8", "8"),
+				NewCommand(BITFIELD_RO, "b", "SET", "u8", "8", "1"),
+				NewCommand(BITFIELD, "b", "GET", "u64", "0"),
+				NewCommand(BITFIELD, "b", "OVERFLOW", "NOPE"),
+				NewCommand(BITFIELD, "missing", "GET", "u8", "0"),
+				NewCommand(GET, "missing"),
+			},
+			expected: []interface{}{
+				[]interface{}{int64(0), int64(15), int64(-1)},
+				[]interface{}{int64(9), int64(255)},
+				[]interface{}{nil, int64(-5)},
+				[]interface{}{int64(251)},
+				ErrorReply("(error) ERR BITFIELD_RO only supports the GET subcommand"),
+				ErrorReply("(error) ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."),
+				ErrorReply("(error) ERR Invalid OVERFLOW type specified"),
+				[]interface{}{int64(0)},
+				nil,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCountBits(t *testing.T) {
+	b := []byte("foobar")
+	tests := []struct {
+		name       string
+		start, end uint64
+		want       int
+	}{
+		{name: "Whole string", start: 0, end: 47, want: 26},
+		{name: "Single byte", start: 8, end: 15, want: 6},
+		{name: "Within one byte", start: 5, end: 30, want: 17},
+		{name: "Single bit", start: 1, end: 1, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countBits(b, tt.start, tt.end); got != tt.want {
+				t.Errorf("countBits(%d, %d) = %d, want %d", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindBit(t *testing.T) {
+	b := []byte{0xff, 0xf0, 0x00}
+	tests := []struct {
+		name       string
+		bit        int
+		start, end uint64
+		want       int64
+	}{
+		{name: "First clear bit", bit: 0, start: 0, end: 23, want: 12},
+		{name: "First set bit", bit: 1, start: 0, end: 23, want: 0},
+		{name: "Set bit after start", bit: 1, start: 13, end: 23, want: -1},
+		{name: "Clear bit in all ones", bit: 0, start: 0, end: 7, want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findBit(b, tt.bit, tt.start, tt.end); got != tt.want {
+				t.Errorf("findBit(%d, %d, %d) = %d, want %d", tt.bit, tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBitfieldRoundTrip(t *testing.T) {
+	b := make([]byte, 16)
+	setUnsignedBitfield(b, 3, 13, 0x1abc)
+	if got := getUnsignedBitfield(b, 3, 13); got != 0x1abc {
+		t.Errorf("getUnsignedBitfield() = %#x, want 0x1abc", got)
+	}
+	setUnsignedBitfield(b, 64, 64, uint64(math.MaxUint64))
+	if got := getSignedBitfield(b, 64, 64); got != -1 {
+		t.Errorf("getSignedBitfield(i64) = %d, want -1", got)
+	}
+	setUnsignedBitfield(b, 20, 5, 0x10)
+	if got := getSignedBitfield(b, 20, 5); got != -16 {
+		t.Errorf("getSignedBitfield(i5) = %d, want -16", got)
+	}
+}
+
+func TestBitfieldOverflow(t *testing.T) {
+	tests := []struct {
+		name           string
+		signed         bool
+		value, incr    int64
+		width          uint64
+		policy         int
+		want           int64
+		wantOverflowed bool
+	}{
+		{name: "u8 wrap", value: 250, incr: 10, width: 8, policy: overflowWrap, want: 4, wantOverflowed: true},
+		{name: "u8 saturate high", value: 250, incr: 10, width: 8, policy: overflowSat, want: 255, wantOverflowed: true},
+		{name: "u8 saturate low", value: 5, incr: -10, width: 8, policy: overflowSat, want: 0, wantOverflowed: true},
+		{name: "u8 no overflow", value: 5, incr: 10, width: 8, policy: overflowFail, want: 15},
+		{name: "i8 wrap", signed: true, value: 120, incr: 10, width: 8, policy: overflowWrap, want: -126, wantOverflowed: true},
+		{name: "i8 saturate low", signed: true, value: -120, incr: -10, width: 8, policy: overflowSat, want: -128, wantOverflowed: true},
+		{name: "i64 saturate", signed: true, value: math.MaxInt64, incr: 1, width: 64, policy: overflowSat, want: math.MaxInt64, wantOverflowed: true},
+		{name: "i4 set out of range", signed: true, value: 9, incr: 0, width: 4, policy: overflowWrap, want: -7, wantOverflowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got int64
+			var overflowed bool
+			if tt.signed {
+				got, overflowed = signedOverflow(tt.value, tt.incr, tt.width, tt.policy)
+			} else {
+				var u uint64
+				u, overflowed = unsignedOverflow(uint64(tt.value), tt.incr, tt.width, tt.policy)
+				got = int64(u)
+			}
+			if got != tt.want || overflowed != tt.wantOverflowed {
+				t.Errorf("got (%d, %v), want (%d, %v)", got, overflowed, tt.want, tt.wantOverflowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"time"
+)
+
+// timeNow is the clock used by commands that record or compare times.
+var timeNow = time.Now
+
+type waitKey struct {
+	dbIndex int
+	key     string
+}
+
+// keyWaiters keeps track of the clients blocked until one of their keys
+// receives new data. It is guarded by the KeyValueDB mutex.
+type keyWaiters struct {
+	waiters map[waitKey]map[chan struct{}]struct{}
+}
+
+func newKeyWaiters() *keyWaiters {
+	return &keyWaiters{waiters: make(map[waitKey]map[chan struct{}]struct{})}
+}
+
+func (w *keyWaiters) add(wk waitKey, ch chan struct{}) {
+	chans, ok := w.waiters[wk]
+	if !ok {
+		chans = make(map[chan struct{}]struct{})
+		w.waiters[wk] = chans
+	}
+	chans[ch] = struct{}{}
+}
+
+func (w *keyWaiters) remove(wk waitKey, ch chan struct{}) {
+	chans := w.waiters[wk]
+	delete(chans, ch)
+	if len(chans) == 0 {
+		delete(w.waiters, wk)
+	}
+}
+
+// signalKey wakes every client blocked on the key. Woken clients run their
+// command again, so signalling a key that did not change is harmless.
+func (kvdb *KeyValueDB) signalKey(dbIndex int, key string) {
+	for ch := range kvdb.waiters.waiters[waitKey{dbIndex, key}] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// signalDB wakes every client blocked on a key of the database, after its
+// contents were replaced as a whole.
+func (kvdb *KeyValueDB) signalDB(dbIndex int) {
+	for wk := range kvdb.waiters.waiters {
+		if wk.dbIndex == dbIndex {
+			kvdb.signalKey(wk.dbIndex, wk.key)
+		}
+	}
+}
+
+// blockOn releases the database lock until one of the keys is signalled,
+// the deadline passes or the session is cancelled, then takes the lock
+// again. A zero deadline waits forever. It reports false unless a key was
+// signalled.
+func (kvdb *KeyValueDB) blockOn(s *Session, dbIndex int, keys []string, deadline time.Time) bool {
+	ch := make(chan struct{}, 1)
+	for _, key := range keys {
+		kvdb.waiters.add(waitKey{dbIndex, key}, ch)
+	}
+	kvdb.stats.blockedClients++
+	defer func() {
+		kvdb.stats.blockedClients--
+		for _, key := range keys {
+			kvdb.waiters.remove(waitKey{dbIndex, key}, ch)
+		}
+	}()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		wait := deadline.Sub(timeNow())
+		if wait <= 0 {
+			return false
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	kvdb.mu.Unlock()
+	defer kvdb.mu.Lock()
+
+	select {
+	case <-ch:
+		return true
+	case <-timeout:
+		return false
+	case <-s.done:
+		return false
+	}
+}
+
+// blockDeadline converts a BLOCK timeout in milliseconds into a deadline.
+// Zero means block forever.
+func blockDeadline(timeoutMs int64) time.Time {
+	if timeoutMs == 0 {
+		return time.Time{}
+	}
+	return timeNow().Add(time.Duration(timeoutMs) * time.Millisecond)
+}
@@ -0,0 +1,256 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	bloomDefaultErrorRate = 0.01
+	bloomDefaultCapacity  = 100
+	bloomDefaultExpansion = 2
+	// Each new sub-filter halves the error rate of the previous one, so the
+	// compound error rate stays below twice the requested one.
+	bloomTighteningRatio = 0.5
+)
+
+var errBloomFull = errors.New("ERR non scaling filter is full")
+
+// bloomFilter is a scalable Bloom filter: a list of sub-filters where only
+// the last one takes new items. Once it holds its capacity a larger one with
+// a tighter error rate is added, unless the filter is non scaling.
+type bloomFilter struct {
+	errorRate  float64
+	capacity   uint64
+	expansion  uint64
+	nonScaling bool
+	layers     []*bloomLayer
+}
+
+type bloomLayer struct {
+	capacity  uint64
+	errorRate float64
+	hashes    uint64
+	nbits     uint64
+	count     uint64
+	bits      []byte
+}
+
+func newBloomFilter(errorRate float64, capacity, expansion uint64, nonScaling bool) *bloomFilter {
+	bf := &bloomFilter{
+		errorRate:  errorRate,
+		capacity:   capacity,
+		expansion:  expansion,
+		nonScaling: nonScaling,
+	}
+	bf.layers = []*bloomLayer{newBloomLayer(capacity, errorRate)}
+	return bf
+}
+
+func (bf *bloomFilter) clone() *bloomFilter {
+	c := *bf
+	c.layers = make([]*bloomLayer, len(bf.layers))
+	for i, l := range bf.layers {
+		cl := *l
+		cl.bits = append([]byte(nil), l.bits...)
+		c.layers[i] = &cl
+	}
+	return &c
+}
+
+// bloomBits returns the size of the bit array of a sub-filter, rounded up
+// to whole bytes so it can be dumped as is. It reports false when the error
+// rate is not valid or the array would be larger than maxFilterBytes.
+func bloomBits(capacity uint64, errorRate float64) (uint64, bool) {
+	if !validErrorRate(errorRate) {
+		return 0, false
+	}
+	nbits := math.Ceil(float64(capacity) * bloomBitsPerItem(errorRate))
+	if !(nbits <= maxFilterBytes*8) {
+		return 0, false
+	}
+	return (uint64(nbits) + 7) / 8 * 8, true
+}
+
+// validErrorRate reports whether errorRate is in (0, 1). It is written so
+// that NaN is rejected as well.
+func validErrorRate(errorRate float64) bool {
+	return errorRate > 0 && errorRate < 1
+}
+
+func bloomBitsPerItem(errorRate float64) float64 {
+	return -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+}
+
+// newBloomLayer creates a sub-filter, whose size must have been checked with
+// bloomBits.
+func newBloomLayer(capacity uint64, errorRate float64) *bloomLayer {
+	nbits, _ := bloomBits(capacity, errorRate)
+	return &bloomLayer{
+		capacity:  capacity,
+		errorRate: errorRate,
+		hashes:    uint64(math.Ceil(math.Ln2 * bloomBitsPerItem(errorRate))),
+		nbits:     nbits,
+		bits:      make([]byte, nbits/8),
+	}
+}
+
+// bloomHash returns the two hashes that every bit position of an item is
+// derived from.
+func bloomHash(item []byte) (uint64, uint64) {
+	h1 := murmurHash64A(item, 0xc6a4a7935bd1e995)
+	return h1, murmurHash64A(item, h1)
+}
+
+func (l *bloomLayer) test(h1, h2 uint64) bool {
+	for i := uint64(0); i < l.hashes; i++ {
+		pos := (h1 + i*h2) % l.nbits
+		if l.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) set(h1, h2 uint64) {
+	for i := uint64(0); i < l.hashes; i++ {
+		pos := (h1 + i*h2) % l.nbits
+		l.bits[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+func (bf *bloomFilter) exists(item []byte) bool {
+	h1, h2 := bloomHash(item)
+	for _, l := range bf.layers {
+		if l.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds item and reports whether it was not present before.
+func (bf *bloomFilter) add(item []byte) (bool, error) {
+	h1, h2 := bloomHash(item)
+	for _, l := range bf.layers {
+		if l.test(h1, h2) {
+			return false, nil
+		}
+	}
+	last := bf.layers[len(bf.layers)-1]
+	if last.count >= last.capacity {
+		if bf.nonScaling {
+			return false, errBloomFull
+		}
+		if last.capacity > math.MaxUint64/bf.expansion {
+			return false, errFilterTooLarge
+		}
+		capacity, errorRate := last.capacity*bf.expansion, last.errorRate*bloomTighteningRatio
+		if _, ok := bloomBits(capacity, errorRate); !ok {
+			return false, errFilterTooLarge
+		}
+		last = newBloomLayer(capacity, errorRate)
+		bf.layers = append(bf.layers, last)
+	}
+	last.set(h1, h2)
+	last.count++
+	return true, nil
+}
+
+// count returns the number of items added.
+func (bf *bloomFilter) count() uint64 {
+	var n uint64
+	for _, l := range bf.layers {
+		n += l.count
+	}
+	return n
+}
+
+// bloomHeader is the fixed part of a dumped Bloom filter. Each layer is
+// described by a bloomLayerHeader and its bits follow in later chunks.
+type bloomHeader struct {
+	ErrorRate  float64
+	Capacity   uint64
+	Expansion  uint64
+	NonScaling bool
+	Layers     uint64
+}
+
+type bloomLayerHeader struct {
+	Capacity  uint64
+	ErrorRate float64
+	Hashes    uint64
+	Nbits     uint64
+	Count     uint64
+}
+
+func (bf *bloomFilter) dumpHeader() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, bloomHeader{
+		ErrorRate:  bf.errorRate,
+		Capacity:   bf.capacity,
+		Expansion:  bf.expansion,
+		NonScaling: bf.nonScaling,
+		Layers:     uint64(len(bf.layers)),
+	})
+	for _, l := range bf.layers {
+		binary.Write(&buf, binary.LittleEndian, bloomLayerHeader{
+			Capacity:  l.capacity,
+			ErrorRate: l.errorRate,
+			Hashes:    l.hashes,
+			Nbits:     l.nbits,
+			Count:     l.count,
+		})
+	}
+	return buf.Bytes()
+}
+
+func (bf *bloomFilter) dumpChunks() [][]byte {
+	chunks := make([][]byte, len(bf.layers))
+	for i, l := range bf.layers {
+		chunks[i] = l.bits
+	}
+	return chunks
+}
+
+// loadBloomHeader creates an empty filter with the layout of a dumped one.
+// The bits are restored from the chunks that follow.
+func loadBloomHeader(b []byte) (*bloomFilter, error) {
+	r := bytes.NewReader(b)
+	var h bloomHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, errDumpInvalid
+	}
+	if h.Layers == 0 || h.Layers > uint64(r.Len())/uint64(binary.Size(bloomLayerHeader{})) || h.Expansion == 0 || !validErrorRate(h.ErrorRate) {
+		return nil, errDumpInvalid
+	}
+	bf := &bloomFilter{
+		errorRate:  h.ErrorRate,
+		capacity:   h.Capacity,
+		expansion:  h.Expansion,
+		nonScaling: h.NonScaling,
+	}
+	for i := uint64(0); i < h.Layers; i++ {
+		var lh bloomLayerHeader
+		if err := binary.Read(r, binary.LittleEndian, &lh); err != nil {
+			return nil, errDumpInvalid
+		}
+		if lh.Nbits == 0 || lh.Nbits%8 != 0 || lh.Hashes == 0 || lh.Nbits > maxFilterBytes*8 || !validErrorRate(lh.ErrorRate) {
+			return nil, errDumpInvalid
+		}
+		bf.layers = append(bf.layers, &bloomLayer{
+			capacity:  lh.Capacity,
+			errorRate: lh.ErrorRate,
+			hashes:    lh.Hashes,
+			nbits:     lh.Nbits,
+			count:     lh.Count,
+			bits:      make([]byte, lh.Nbits/8),
+		})
+	}
+	if r.Len() != 0 {
+		return nil, errDumpInvalid
+	}
+	return bf, nil
+}
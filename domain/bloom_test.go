@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	bf := newBloomFilter(0.01, 1000, 2, true)
+	for i := 0; i < 1000; i++ {
+		// A false positive makes add report the item as already present.
+		if _, err := bf.add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("add(%d) error = %v", i, err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !bf.exists([]byte(strconv.Itoa(i))) {
+			t.Fatalf("exists(%d) = false after add", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 1000; i < 101000; i++ {
+		if bf.exists([]byte(strconv.Itoa(i))) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 100000; rate > 0.02 {
+		t.Errorf("false positive rate = %.4f, want about 0.01", rate)
+	}
+}
+
+func TestBloomFilterScaling(t *testing.T) {
+	bf := newBloomFilter(0.01, 10, 2, false)
+	for i := 0; i < 100; i++ {
+		bf.add([]byte(strconv.Itoa(i)))
+	}
+	// 10 + 20 + 40 is not enough for 100 items, so a fourth layer is needed.
+	if len(bf.layers) != 4 {
+		t.Errorf("got %d layers, want 4", len(bf.layers))
+	}
+	if bf.layers[3].capacity != 80 || bf.layers[3].errorRate != 0.00125 {
+		t.Errorf("fourth layer has capacity %d and error rate %v", bf.layers[3].capacity, bf.layers[3].errorRate)
+	}
+	for i := 0; i < 100; i++ {
+		if !bf.exists([]byte(strconv.Itoa(i))) {
+			t.Fatalf("exists(%d) = false after add", i)
+		}
+	}
+}
+
+func TestBloomFilterNonScalingFull(t *testing.T) {
+	bf := newBloomFilter(0.01, 2, 2, true)
+	bf.add([]byte("a"))
+	bf.add([]byte("b"))
+	if _, err := bf.add([]byte("c")); err != errBloomFull {
+		t.Errorf("add() on a full filter error = %v, want %v", err, errBloomFull)
+	}
+}
+
+func TestBloomFilterExpansionTooLarge(t *testing.T) {
+	for _, expansion := range []uint64{1 << 40, math.MaxUint64} {
+		bf := newBloomFilter(0.01, 2, expansion, false)
+		bf.add([]byte("a"))
+		bf.add([]byte("b"))
+		if _, err := bf.add([]byte("c")); err != errFilterTooLarge {
+			t.Errorf("add() expanding by %d error = %v, want %v", expansion, err, errFilterTooLarge)
+		}
+		if len(bf.layers) != 1 {
+			t.Errorf("expanding by %d added a sub-filter", expansion)
+		}
+	}
+}
+
+func TestBloomBitsInvalidErrorRate(t *testing.T) {
+	for _, errorRate := range []float64{0, 1, -0.5, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, ok := bloomBits(100, errorRate); ok {
+			t.Errorf("bloomBits(100, %v) reported a valid size", errorRate)
+		}
+	}
+}
+
+func TestBloomFilterDumpRoundTrip(t *testing.T) {
+	bf := newBloomFilter(0.001, 50, 3, false)
+	for i := 0; i < 200; i++ {
+		bf.add([]byte(strconv.Itoa(i)))
+	}
+
+	iter, header := scanDump(bf, 0)
+	loaded, err := loadBloomHeader(header)
+	if err != nil {
+		t.Fatalf("loadBloomHeader() error = %v", err)
+	}
+	for {
+		var data []byte
+		if iter, data = scanDump(bf, iter); iter == 0 {
+			break
+		}
+		if err := loadChunk(loaded, iter, data); err != nil {
+			t.Fatalf("loadChunk(%d) error = %v", iter, err)
+		}
+	}
+
+	if loaded.count() != bf.count() || len(loaded.layers) != len(bf.layers) {
+		t.Fatalf("loaded %d items in %d layers, want %d in %d", loaded.count(), len(loaded.layers), bf.count(), len(bf.layers))
+	}
+	for i := 0; i < 200; i++ {
+		if !loaded.exists([]byte(strconv.Itoa(i))) {
+			t.Fatalf("exists(%d) = false after loading", i)
+		}
+	}
+}
+
+func TestLoadBloomHeaderInvalid(t *testing.T) {
+	header := newBloomFilter(0.01, 100, 2, false).dumpHeader()
+	nan := newBloomFilter(0.01, 100, 2, false)
+	nan.errorRate = math.NaN()
+	layerNaN := newBloomFilter(0.01, 100, 2, false)
+	layerNaN.layers[0].errorRate = math.NaN()
+	for _, b := range [][]byte{nil, header[:10], append(header, 0), nan.dumpHeader(), layerNaN.dumpHeader()} {
+		if _, err := loadBloomHeader(b); err != errDumpInvalid {
+			t.Errorf("loadBloomHeader(%d bytes) error = %v, want %v", len(b), err, errDumpInvalid)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package domain
+
+// onDB adapts a KeyValueDB method to a Handler.
+func onDB(method func(*KeyValueDB, int, Command) interface{}) Handler {
+	return func(ctx *CommandContext, cmd Command) interface{} {
+		return method(ctx.kvdb, ctx.DBIndex, cmd)
+	}
+}
+
+// onSession adapts a KeyValueDB method that needs the session running the
+// command, such as one that blocks, to a Handler.
+func onSession(method func(*KeyValueDB, *Session, int, Command) interface{}) Handler {
+	return func(ctx *CommandContext, cmd Command) interface{} {
+		return method(ctx.kvdb, ctx.session, ctx.DBIndex, cmd)
+	}
+}
+
+// onDBFlag adapts a method shared by two commands, such as RENAME and
+// RENAMENX, that a flag tells apart.
+func onDBFlag(method func(*KeyValueDB, int, Command, bool) interface{}, flag bool) Handler {
+	return func(ctx *CommandContext, cmd Command) interface{} {
+		return method(ctx.kvdb, ctx.DBIndex, cmd, flag)
+	}
+}
+
+func georadiusHandler(flavour int) Handler {
+	return func(ctx *CommandContext, cmd Command) interface{} {
+		return ctx.kvdb.georadius(ctx.DBIndex, cmd, flavour)
+	}
+}
+
+var builtinCommands = []CommandSpec{
+	{Name: SET, MinArgs: 2, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Sets the string value of a key, optionally only if it exists or not and with an expiry.", Handler: onDB((*KeyValueDB).set)},
+	{Name: GET, MinArgs: 1, MaxArgs: 1, Flags: FlagReadOnly, Keys: firstKey, Group: "string", Summary: "Returns the string value of a key.", Handler: onDB((*KeyValueDB).get)},
+	{Name: DEL, MinArgs: 1, MaxArgs: -1, Flags: FlagWrite, Keys: allKeys, Group: "generic", Summary: "Deletes one or more keys.", Handler: onDB((*KeyValueDB).del)},
+	{Name: INCR, MinArgs: 1, MaxArgs: 1, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Increments the integer value of a key by one.", Handler: onDB((*KeyValueDB).incr)},
+	{Name: INCRBY, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Increments the integer value of a key by a number.", Handler: onDB((*KeyValueDB).incrby)},
+	{Name: DECR, MinArgs: 1, MaxArgs: 1, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Decrements the integer value of a key by one.", Handler: onDB((*KeyValueDB).decr)},
+	{Name: DECRBY, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Decrements the integer value of a key by a number.", Handler: onDB((*KeyValueDB).decrby)},
+	{Name: INCRBYFLOAT, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Increments the floating point value of a key by a number.", Handler: onDB((*KeyValueDB).incrbyfloat)},
+	{Name: MULTI, MinArgs: 0, MaxArgs: 0, Flags: FlagNoScript, Keys: noKeys, Group: "transactions", Summary: "Starts a transaction.", Handler: multi},
+	{Name: EXEC, MinArgs: 0, MaxArgs: 0, Flags: FlagNoScript, Keys: noKeys, Group: "transactions", Summary: "Executes all commands in a transaction.", Handler: exec},
+	{Name: DISCARD, MinArgs: 0, MaxArgs: 0, Flags: FlagNoScript, Keys: noKeys, Group: "transactions", Summary: "Discards a transaction.", Handler: discard},
+	{Name: WATCH, MinArgs: 1, MaxArgs: -1, Flags: FlagNoScript, Keys: allKeys, Group: "transactions", Summary: "Monitors changes to keys to determine the execution of a transaction.", Handler: watch},
+	{Name: UNWATCH, MinArgs: 0, MaxArgs: 0, Flags: FlagNoScript, Keys: noKeys, Group: "transactions", Summary: "Forgets about watched keys of a transaction.", Handler: unwatch},
+	{Name: COMPACT, MinArgs: 0, MaxArgs: 0, Flags: FlagAdmin | FlagNoScript, Keys: noKeys, Group: "server", Summary: "Returns the commands that recreate the current database.", Handler: onDB((*KeyValueDB).compact)},
+	{Name: SELECT, MinArgs: 1, MaxArgs: 1, Keys: noKeys, Group: "connection", Summary: "Changes the selected database.", Handler: selectDB},
+	{Name: COMMAND, MinArgs: 0, MaxArgs: -1, Flags: FlagReadOnly, Keys: noKeys, Group: "connection", Summary: "Describes the commands the server supports.", Handler: command},
+	{Name: INFO, MinArgs: 0, MaxArgs: -1, Keys: noKeys, Group: "server", Summary: "Returns information and statistics about the server.", Handler: onDB((*KeyValueDB).info)},
+	{Name: CONFIG, MinArgs: 1, MaxArgs: -1, Flags: FlagAdmin | FlagNoScript, Keys: noKeys, Group: "server", Summary: "Reads, changes and saves the server settings.", Handler: configCommand},
+	{Name: SHUTDOWN, MinArgs: 0, MaxArgs: 1, Flags: FlagAdmin | FlagNoScript, Keys: noKeys, Group: "server", Summary: "Saves the data unless told not to and stops the server.", Handler: shutdown},
+	{Name: CLIENT, MinArgs: 1, MaxArgs: -1, Flags: FlagAdmin | FlagNoScript, Keys: noKeys, Group: "connection", Summary: "Lists, names, kills and pauses client connections.", Handler: clientCommand},
+	{Name: AUTH, MinArgs: 1, MaxArgs: 2, Flags: FlagNoScript, Keys: noKeys, Group: "connection", Summary: "Logs the connection in as a user.", Handler: auth},
+	{Name: ACL, MinArgs: 1, MaxArgs: -1, Flags: FlagAdmin | FlagNoScript, Keys: noKeys, Group: "server", Summary: "Manages the users and their permissions.", Handler: aclCommand},
+
+	{Name: APPEND, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Appends a string to the value of a key.", Handler: onDB((*KeyValueDB).appendString)},
+	{Name: STRLEN, MinArgs: 1, MaxArgs: 1, Flags: FlagReadOnly, Keys: firstKey, Group: "string", Summary: "Returns the length of a string value.", Handler: onDB((*KeyValueDB).strlen)},
+	{Name: GETRANGE, MinArgs: 3, MaxArgs: 3, Flags: FlagReadOnly, Keys: firstKey, Group: "string", Summary: "Returns a substring of the string stored at a key.", Handler: onDB((*KeyValueDB).getrange)},
+	{Name: SETRANGE, MinArgs: 3, MaxArgs: 3, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Overwrites part of a string value from an offset.", Handler: onDB((*KeyValueDB).setrange)},
+	{Name: GETSET, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Sets the string value of a key and returns its old value.", Handler: onDB((*KeyValueDB).getset)},
+	{Name: GETDEL, MinArgs: 1, MaxArgs: 1, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Returns the string value of a key and deletes the key.", Handler: onDB((*KeyValueDB).getdel)},
+	{Name: GETEX, MinArgs: 1, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Returns the string value of a key and sets or removes its expiry.", Handler: onDB((*KeyValueDB).getex)},
+	{Name: SETNX, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "string", Summary: "Sets the string value of a key only if the key does not exist.", Handler: onDB((*KeyValueDB).setnx)},
+	{Name: MSET, MinArgs: 2, MaxArgs: -1, Flags: FlagWrite, Keys: keyPairs, Group: "string", Summary: "Sets the string values of several keys.", Handler: onDBFlag((*KeyValueDB).mset, false)},
+	{Name: MSETNX, MinArgs: 2, MaxArgs: -1, Flags: FlagWrite, Keys: keyPairs, Group: "string", Summary: "Sets the string values of several keys only if none of them exist.", Handler: onDBFlag((*KeyValueDB).mset, true)},
+	{Name: MGET, MinArgs: 1, MaxArgs: -1, Flags: FlagReadOnly, Keys: allKeys, Group: "string", Summary: "Returns the string values of several keys.", Handler: onDB((*KeyValueDB).mget)},
+
+	{Name: KEYS, MinArgs: 1, MaxArgs: 1, Flags: FlagReadOnly, Keys: noKeys, Group: "generic", Summary: "Returns the key names that match a pattern.", Handler: onDB((*KeyValueDB).keys)},
+	{Name: SCAN, MinArgs: 1, MaxArgs: -1, Flags: FlagReadOnly, Keys: noKeys, Group: "generic", Summary: "Iterates over the key names in the database.", Handler: onDB((*KeyValueDB).scan)},
+	{Name: ZSCAN, MinArgs: 2, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "sorted-set", Summary: "Iterates over the members and scores of a sorted set.", Handler: onDB((*KeyValueDB).zscan)},
+	{Name: EXISTS, MinArgs: 1, MaxArgs: -1, Flags: FlagReadOnly, Keys: allKeys, Group: "generic", Summary: "Counts how many of the given keys exist.", Handler: onDB((*KeyValueDB).exists)},
+	{Name: TYPE, MinArgs: 1, MaxArgs: 1, Flags: FlagReadOnly, Keys: firstKey, Group: "generic", Summary: "Returns the type of value stored at a key.", Handler: onDB((*KeyValueDB).keyType)},
+	{Name: RENAME, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: twoKeys, Group: "generic", Summary: "Renames a key, overwriting the destination.", Handler: onDBFlag((*KeyValueDB).rename, false)},
+	{Name: RENAMENX, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: twoKeys, Group: "generic", Summary: "Renames a key only if the new name does not exist.", Handler: onDBFlag((*KeyValueDB).rename, true)},
+	{Name: COPY, MinArgs: 2, MaxArgs: 5, Flags: FlagWrite, Keys: twoKeys, Group: "generic", Summary: "Copies the value of a key to a new key.", Handler: onDB((*KeyValueDB).copyKey)},
+	{Name: MOVE, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "generic", Summary: "Moves a key to another database.", Handler: onDB((*KeyValueDB).move)},
+	{Name: RANDOMKEY, MinArgs: 0, MaxArgs: 0, Flags: FlagReadOnly, Keys: noKeys, Group: "generic", Summary: "Returns a random key name from the database.", Handler: onDB((*KeyValueDB).randomKey)},
+	{Name: UNLINK, MinArgs: 1, MaxArgs: -1, Flags: FlagWrite, Keys: allKeys, Group: "generic", Summary: "Deletes one or more keys.", Handler: onDB((*KeyValueDB).del)},
+	{Name: TOUCH, MinArgs: 1, MaxArgs: -1, Flags: FlagReadOnly, Keys: allKeys, Group: "generic", Summary: "Counts how many of the given keys exist.", Handler: onDB((*KeyValueDB).exists)},
+
+	{Name: DBSIZE, MinArgs: 0, MaxArgs: 0, Flags: FlagReadOnly, Keys: noKeys, Group: "server", Summary: "Returns the number of keys in the database.", Handler: onDB((*KeyValueDB).dbsize)},
+	{Name: FLUSHDB, MinArgs: 0, MaxArgs: 1, Flags: FlagWrite, Keys: noKeys, Group: "server", Summary: "Removes every key from the current database.", Handler: onDB((*KeyValueDB).flushdb)},
+	{Name: FLUSHALL, MinArgs: 0, MaxArgs: 1, Flags: FlagWrite, Keys: noKeys, Group: "server", Summary: "Removes every key from all databases.", Handler: onDB((*KeyValueDB).flushall)},
+	{Name: SWAPDB, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: noKeys, Group: "server", Summary: "Swaps the contents of two databases.", Handler: onDB((*KeyValueDB).swapdb)},
+
+	{Name: XADD, MinArgs: 4, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "stream", Summary: "Appends an entry to a stream, creating the stream if needed.", Handler: onDB((*KeyValueDB).xadd)},
+	{Name: XLEN, MinArgs: 1, MaxArgs: 1, Flags: FlagReadOnly, Keys: firstKey, Group: "stream", Summary: "Returns the number of entries in a stream.", Handler: onDB((*KeyValueDB).xlen)},
+	{Name: XDEL, MinArgs: 2, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "stream", Summary: "Deletes entries from a stream.", Handler: onDB((*KeyValueDB).xdel)},
+	{Name: XTRIM, MinArgs: 3, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "stream", Summary: "Deletes the oldest entries of a stream.", Handler: onDB((*KeyValueDB).xtrim)},
+	{Name: XSETID, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "stream", Summary: "Sets the last entry ID of a stream.", Handler: onDB((*KeyValueDB).xsetid)},
+	{Name: XRANGE, MinArgs: 3, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "stream", Summary: "Returns the stream entries within a range of IDs.", Handler: onDBFlag((*KeyValueDB).xrange, false)},
+	{Name: XREVRANGE, MinArgs: 3, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "stream", Summary: "Returns the stream entries within a range of IDs in reverse order.", Handler: onDBFlag((*KeyValueDB).xrange, true)},
+	{Name: XREAD, MinArgs: 3, MaxArgs: -1, Flags: FlagReadOnly | FlagBlocking, Keys: noKeys, FindKeys: streamReadKeys(false), Group: "stream", Summary: "Returns new entries from streams, optionally waiting for them.", Handler: onSession((*KeyValueDB).xread)},
+	{Name: XGROUP, MinArgs: 1, MaxArgs: -1, Flags: FlagWrite, Keys: KeySpec{2, 2, 1}, Group: "stream", Summary: "Creates, changes and deletes consumer groups and their consumers.", Handler: onDB((*KeyValueDB).xgroup)},
+	{Name: XREADGROUP, MinArgs: 6, MaxArgs: -1, Flags: FlagWrite | FlagBlocking, Keys: noKeys, FindKeys: streamReadKeys(true), Group: "stream", Summary: "Returns new or pending entries from streams for a consumer of a group.", Handler: onSession((*KeyValueDB).xreadgroup)},
+	{Name: XACK, MinArgs: 3, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "stream", Summary: "Acknowledges entries delivered to a consumer group.", Handler: onDB((*KeyValueDB).xack)},
+	{Name: XPENDING, MinArgs: 2, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "stream", Summary: "Returns the entries delivered to a consumer group but not acknowledged.", Handler: onDB((*KeyValueDB).xpending)},
+	{Name: XCLAIM, MinArgs: 5, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "stream", Summary: "Changes the owner of pending entries of a consumer group.", Handler: onDB((*KeyValueDB).xclaim)},
+
+	{Name: SETBIT, MinArgs: 3, MaxArgs: 3, Flags: FlagWrite, Keys: firstKey, Group: "bitmap", Summary: "Sets or clears the bit at an offset of a string value.", Handler: onDB((*KeyValueDB).setbit)},
+	{Name: GETBIT, MinArgs: 2, MaxArgs: 2, Flags: FlagReadOnly, Keys: firstKey, Group: "bitmap", Summary: "Returns the bit at an offset of a string value.", Handler: onDB((*KeyValueDB).getbit)},
+	{Name: BITCOUNT, MinArgs: 1, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "bitmap", Summary: "Counts the set bits of a string value.", Handler: onDB((*KeyValueDB).bitcount)},
+	{Name: BITPOS, MinArgs: 2, MaxArgs: 5, Flags: FlagReadOnly, Keys: firstKey, Group: "bitmap", Summary: "Finds the first set or clear bit of a string value.", Handler: onDB((*KeyValueDB).bitpos)},
+	{Name: BITOP, MinArgs: 3, MaxArgs: -1, Flags: FlagWrite, Keys: KeySpec{2, -1, 1}, Group: "bitmap", Summary: "Combines string values bit by bit and stores the result.", Handler: onDB((*KeyValueDB).bitop)},
+	{Name: BITFIELD, MinArgs: 1, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "bitmap", Summary: "Reads and writes integers at bit offsets of a string value.", Handler: onDBFlag((*KeyValueDB).bitfield, false)},
+	{Name: BITFIELD_RO, MinArgs: 1, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "bitmap", Summary: "Reads integers at bit offsets of a string value.", Handler: onDBFlag((*KeyValueDB).bitfield, true)},
+
+	{Name: PFADD, MinArgs: 1, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "hyperloglog", Summary: "Adds elements to a HyperLogLog.", Handler: onDB((*KeyValueDB).pfadd)},
+	// PFCOUNT writes back the cardinality it caches in the value.
+	{Name: PFCOUNT, MinArgs: 1, MaxArgs: -1, Flags: FlagWrite, Keys: allKeys, Group: "hyperloglog", Summary: "Estimates the number of distinct elements added to HyperLogLogs.", Handler: onDB((*KeyValueDB).pfcount)},
+	{Name: PFMERGE, MinArgs: 1, MaxArgs: -1, Flags: FlagWrite, Keys: allKeys, Group: "hyperloglog", Summary: "Merges HyperLogLogs into one.", Handler: onDB((*KeyValueDB).pfmerge)},
+
+	{Name: GEOADD, MinArgs: 4, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "geo", Summary: "Adds members with coordinates to a geospatial index.", Handler: onDB((*KeyValueDB).geoadd)},
+	{Name: GEODIST, MinArgs: 3, MaxArgs: 4, Flags: FlagReadOnly, Keys: firstKey, Group: "geo", Summary: "Returns the distance between two members of a geospatial index.", Handler: onDB((*KeyValueDB).geodist)},
+	{Name: GEOPOS, MinArgs: 1, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "geo", Summary: "Returns the coordinates of members of a geospatial index.", Handler: onDB((*KeyValueDB).geopos)},
+	{Name: GEOHASH, MinArgs: 1, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "geo", Summary: "Returns members of a geospatial index as geohash strings.", Handler: onDB((*KeyValueDB).geohash)},
+	{Name: GEOSEARCH, MinArgs: 6, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "geo", Summary: "Returns the members of a geospatial index within an area.", Handler: georadiusHandler(geoSearch)},
+	{Name: GEOSEARCHSTORE, MinArgs: 7, MaxArgs: -1, Flags: FlagWrite, Keys: twoKeys, Group: "geo", Summary: "Stores the members of a geospatial index within an area in another key.", Handler: georadiusHandler(geoSearchStore)},
+	{Name: GEORADIUS, MinArgs: 5, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, FindKeys: geoRadiusKeys(geoRadius), Group: "geo", Summary: "Returns the members of a geospatial index within a distance of a point.", Handler: georadiusHandler(geoRadius)},
+	{Name: GEORADIUSBYMEMBER, MinArgs: 4, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, FindKeys: geoRadiusKeys(geoRadiusByMember), Group: "geo", Summary: "Returns the members of a geospatial index within a distance of a member.", Handler: georadiusHandler(geoRadiusByMember)},
+
+	{Name: JSON_SET, MinArgs: 3, MaxArgs: 4, Flags: FlagWrite, Keys: firstKey, Group: "json", Summary: "Sets a JSON document or values inside one.", Handler: onDB((*KeyValueDB).jsonSet)},
+	{Name: JSON_GET, MinArgs: 1, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "json", Summary: "Returns a JSON document or values inside one.", Handler: onDB((*KeyValueDB).jsonGet)},
+	{Name: JSON_DEL, MinArgs: 1, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "json", Summary: "Deletes values from a JSON document.", Handler: onDB((*KeyValueDB).jsonDel)},
+	{Name: JSON_ARRAPPEND, MinArgs: 3, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "json", Summary: "Appends values to arrays inside a JSON document.", Handler: onDB((*KeyValueDB).jsonArrAppend)},
+	{Name: JSON_NUMINCRBY, MinArgs: 3, MaxArgs: 3, Flags: FlagWrite, Keys: firstKey, Group: "json", Summary: "Adds a number to numbers inside a JSON document.", Handler: onDB((*KeyValueDB).jsonNumIncrBy)},
+
+	{Name: BF_RESERVE, MinArgs: 3, MaxArgs: 6, Flags: FlagWrite, Keys: firstKey, Group: "bf", Summary: "Creates a Bloom filter.", Handler: onDB((*KeyValueDB).bfReserve)},
+	{Name: BF_ADD, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "bf", Summary: "Adds an item to a Bloom filter.", Handler: onDBFlag((*KeyValueDB).bfAdd, false)},
+	{Name: BF_MADD, MinArgs: 2, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "bf", Summary: "Adds items to a Bloom filter.", Handler: onDBFlag((*KeyValueDB).bfAdd, true)},
+	{Name: BF_EXISTS, MinArgs: 2, MaxArgs: 2, Flags: FlagReadOnly, Keys: firstKey, Group: "bf", Summary: "Checks whether an item may have been added to a Bloom filter.", Handler: onDBFlag((*KeyValueDB).bfExists, false)},
+	{Name: BF_MEXISTS, MinArgs: 2, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "bf", Summary: "Checks whether items may have been added to a Bloom filter.", Handler: onDBFlag((*KeyValueDB).bfExists, true)},
+	{Name: BF_SCANDUMP, MinArgs: 2, MaxArgs: 2, Flags: FlagReadOnly, Keys: firstKey, Group: "bf", Summary: "Returns a chunk of a Bloom filter for copying it.", Handler: onDB((*KeyValueDB).bfScanDump)},
+	{Name: BF_LOADCHUNK, MinArgs: 3, MaxArgs: 3, Flags: FlagWrite, Keys: firstKey, Group: "bf", Summary: "Restores a chunk of a Bloom filter.", Handler: onDB((*KeyValueDB).bfLoadChunk)},
+
+	{Name: CF_RESERVE, MinArgs: 2, MaxArgs: 8, Flags: FlagWrite, Keys: firstKey, Group: "cf", Summary: "Creates a cuckoo filter.", Handler: onDB((*KeyValueDB).cfReserve)},
+	{Name: CF_ADD, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "cf", Summary: "Adds an item to a cuckoo filter.", Handler: onDBFlag((*KeyValueDB).cfAdd, false)},
+	{Name: CF_ADDNX, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "cf", Summary: "Adds an item to a cuckoo filter unless it may be present.", Handler: onDBFlag((*KeyValueDB).cfAdd, true)},
+	{Name: CF_INSERT, MinArgs: 3, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "cf", Summary: "Adds items to a cuckoo filter.", Handler: onDBFlag((*KeyValueDB).cfInsert, false)},
+	{Name: CF_INSERTNX, MinArgs: 3, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "cf", Summary: "Adds items to a cuckoo filter unless they may be present.", Handler: onDBFlag((*KeyValueDB).cfInsert, true)},
+	{Name: CF_EXISTS, MinArgs: 2, MaxArgs: 2, Flags: FlagReadOnly, Keys: firstKey, Group: "cf", Summary: "Checks whether an item may be in a cuckoo filter.", Handler: onDBFlag((*KeyValueDB).cfExists, false)},
+	{Name: CF_MEXISTS, MinArgs: 2, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "cf", Summary: "Checks whether items may be in a cuckoo filter.", Handler: onDBFlag((*KeyValueDB).cfExists, true)},
+	{Name: CF_DEL, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: firstKey, Group: "cf", Summary: "Deletes an item from a cuckoo filter.", Handler: onDB((*KeyValueDB).cfDel)},
+	{Name: CF_COUNT, MinArgs: 2, MaxArgs: 2, Flags: FlagReadOnly, Keys: firstKey, Group: "cf", Summary: "Estimates how many times an item was added to a cuckoo filter.", Handler: onDB((*KeyValueDB).cfCount)},
+	{Name: CF_SCANDUMP, MinArgs: 2, MaxArgs: 2, Flags: FlagReadOnly, Keys: firstKey, Group: "cf", Summary: "Returns a chunk of a cuckoo filter for copying it.", Handler: onDB((*KeyValueDB).cfScanDump)},
+	{Name: CF_LOADCHUNK, MinArgs: 3, MaxArgs: 3, Flags: FlagWrite, Keys: firstKey, Group: "cf", Summary: "Restores a chunk of a cuckoo filter.", Handler: onDB((*KeyValueDB).cfLoadChunk)},
+
+	{Name: TS_CREATE, MinArgs: 1, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "timeseries", Summary: "Creates a time series.", Handler: onDB((*KeyValueDB).tsCreate)},
+	{Name: TS_ADD, MinArgs: 3, MaxArgs: -1, Flags: FlagWrite, Keys: firstKey, Group: "timeseries", Summary: "Adds a sample to a time series.", Handler: onDB((*KeyValueDB).tsAdd)},
+	{Name: TS_GET, MinArgs: 1, MaxArgs: 1, Flags: FlagReadOnly, Keys: firstKey, Group: "timeseries", Summary: "Returns the newest sample of a time series.", Handler: onDB((*KeyValueDB).tsGet)},
+	{Name: TS_RANGE, MinArgs: 3, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "timeseries", Summary: "Returns the samples of a time series within a time range.", Handler: onDBFlag((*KeyValueDB).tsRange, false)},
+	{Name: TS_REVRANGE, MinArgs: 3, MaxArgs: -1, Flags: FlagReadOnly, Keys: firstKey, Group: "timeseries", Summary: "Returns the samples of a time series within a time range in reverse order.", Handler: onDBFlag((*KeyValueDB).tsRange, true)},
+	{Name: TS_MRANGE, MinArgs: 4, MaxArgs: -1, Flags: FlagReadOnly, Keys: noKeys, Group: "timeseries", Summary: "Returns the samples within a time range of every time series matching filters.", Handler: onDB((*KeyValueDB).tsMRange)},
+	{Name: TS_CREATERULE, MinArgs: 5, MaxArgs: 5, Flags: FlagWrite, Keys: twoKeys, Group: "timeseries", Summary: "Creates a rule that downsamples one time series into another.", Handler: onDB((*KeyValueDB).tsCreateRule)},
+	{Name: TS_DELETERULE, MinArgs: 2, MaxArgs: 2, Flags: FlagWrite, Keys: twoKeys, Group: "timeseries", Summary: "Deletes a downsampling rule.", Handler: onDB((*KeyValueDB).tsDeleteRule)},
+}
+
+func init() {
+	for _, spec := range builtinCommands {
+		if err := RegisterCommand(spec); err != nil {
+			panic(err)
+		}
+	}
+}
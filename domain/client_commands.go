@@ -0,0 +1,319 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// clientPause describes a CLIENT PAUSE. Until it ends, commands of clients
+// wait: every command but CLIENT when all is set, and else only writes.
+type clientPause struct {
+	all   bool
+	until time.Time
+	// lifted is closed when the pause is replaced or lifted early.
+	lifted chan struct{}
+}
+
+func clientCommand(ctx *CommandContext, cmd Command) interface{} {
+	args := cmd.params()
+	kvdb, s := ctx.kvdb, ctx.session
+	switch sub := strings.ToUpper(args[0]); {
+	case sub == "ID" && len(args) == 1:
+		return s.id
+	case sub == "INFO" && len(args) == 1:
+		return kvdb.clientLine(s, timeNow())
+	case sub == "LIST":
+		return kvdb.clientList(args[1:])
+	case sub == "KILL" && len(args) == 2:
+		// The old form names a single client by address.
+		if kvdb.killClients(s, func(c *Session) bool { return c.conn.RemoteAddr == args[1] }, false) == 0 {
+			return ErrorReply("(error) ERR No such client")
+		}
+		return statusOK
+	case sub == "KILL" && len(args) > 2:
+		return kvdb.clientKill(s, args[1:])
+	case sub == "SETNAME" && len(args) == 2:
+		for _, c := range []byte(args[1]) {
+			if c < '!' || c > '~' {
+				return ErrorReply("(error) ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+		}
+		s.name = args[1]
+		return statusOK
+	case sub == "GETNAME" && len(args) == 1:
+		if s.name == "" {
+			return nil
+		}
+		return s.name
+	case sub == "PAUSE" && (len(args) == 2 || len(args) == 3):
+		return kvdb.startPause(args[1:])
+	case sub == "UNPAUSE" && len(args) == 1:
+		kvdb.unpause()
+		return statusOK
+	case sub == "NO-EVICT" && len(args) == 2:
+		switch strings.ToUpper(args[1]) {
+		case "ON":
+			s.noEvict = true
+		case "OFF":
+			s.noEvict = false
+		default:
+			return errSyntax
+		}
+		return statusOK
+	case sub == "ID" || sub == "INFO" || sub == "KILL" || sub == "SETNAME" || sub == "GETNAME" ||
+		sub == "PAUSE" || sub == "UNPAUSE" || sub == "NO-EVICT":
+		return ErrorReply(fmt.Sprintf("(error) ERR wrong number of arguments for 'client|%s' command", strings.ToLower(sub)))
+	}
+	return ErrorReply(fmt.Sprintf("(error) ERR unknown subcommand '%s'. Try CLIENT HELP.", args[0]))
+}
+
+// clientList describes the clients, in the order they connected, that pass
+// the TYPE and ID filters.
+func (kvdb *KeyValueDB) clientList(args []string) interface{} {
+	var clientType string
+	var ids map[int64]bool
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "TYPE":
+			if i+1 == len(args) {
+				return errSyntax
+			}
+			i++
+			clientType = strings.ToLower(args[i])
+			switch clientType {
+			case "normal", "pubsub", "master", "replica":
+			default:
+				return ErrorReply(fmt.Sprintf("(error) ERR Unknown client type '%s'", args[i]))
+			}
+		case "ID":
+			if i+1 == len(args) {
+				return errSyntax
+			}
+			ids = make(map[int64]bool)
+			for i+1 < len(args) {
+				id, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || id <= 0 {
+					return ErrorReply("(error) ERR Invalid client ID")
+				}
+				ids[id] = true
+				i++
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	now := timeNow()
+	var b strings.Builder
+	for _, s := range kvdb.sortedSessions() {
+		if ids != nil && !ids[s.id] {
+			continue
+		}
+		subscribed := len(s.channels)+len(s.patterns) > 0
+		switch clientType {
+		case "normal":
+			if subscribed {
+				continue
+			}
+		case "pubsub":
+			if !subscribed {
+				continue
+			}
+		case "master", "replica":
+			continue
+		}
+		b.WriteString(kvdb.clientLine(s, now))
+	}
+	return b.String()
+}
+
+func (kvdb *KeyValueDB) sortedSessions() []*Session {
+	sessions := make([]*Session, 0, len(kvdb.sessions))
+	for _, s := range kvdb.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	return sessions
+}
+
+// clientLine describes a client in the format of CLIENT LIST.
+func (kvdb *KeyValueDB) clientLine(s *Session, now time.Time) string {
+	flags := ""
+	if s.inMulti {
+		flags += "x"
+	}
+	if s.noEvict {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	multi := -1
+	if s.inMulti {
+		multi = len(s.queue)
+	}
+	cmd := s.lastCmd
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d multi=%d qbuf=%d obl=%d cmd=%s user=%s\n",
+		s.id, s.conn.RemoteAddr, s.conn.LocalAddr, s.name,
+		int64(now.Sub(s.created).Seconds()), int64(now.Sub(s.lastActive).Seconds()),
+		flags, s.dbIndex, len(s.channels), len(s.patterns), multi,
+		atomic.LoadInt64(&s.queryBuf), atomic.LoadInt64(&s.outputBuf), cmd, s.user)
+}
+
+// clientKill disconnects the clients matching every filter and returns how
+// many there were. Unless SKIPME is no, the client asking is spared.
+func (kvdb *KeyValueDB) clientKill(self *Session, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return errSyntax
+	}
+	var filters []func(*Session) bool
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return ErrorReply("(error) ERR client-id should be greater than 0")
+			}
+			filters = append(filters, func(s *Session) bool { return s.id == id })
+		case "ADDR":
+			filters = append(filters, func(s *Session) bool { return s.conn.RemoteAddr == value })
+		case "LADDR":
+			filters = append(filters, func(s *Session) bool { return s.conn.LocalAddr == value })
+		case "USER":
+			filters = append(filters, func(s *Session) bool { return s.user == value })
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+	}
+	return kvdb.killClients(self, func(s *Session) bool {
+		for _, match := range filters {
+			if !match(s) {
+				return false
+			}
+		}
+		return true
+	}, skipMe)
+}
+
+func (kvdb *KeyValueDB) killClients(self *Session, match func(*Session) bool, skipMe bool) int {
+	killed := 0
+	for _, s := range kvdb.sessions {
+		if (skipMe && s == self) || !match(s) {
+			continue
+		}
+		if s.conn.Close != nil {
+			s.conn.Close()
+		}
+		s.Cancel()
+		killed++
+	}
+	return killed
+}
+
+// startPause parses "timeout [WRITE|ALL]" and starts the pause, replacing
+// any pause in effect.
+func (kvdb *KeyValueDB) startPause(args []string) interface{} {
+	ms, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || ms < 0 {
+		return ErrorReply("(error) ERR timeout is not an integer or out of range")
+	}
+	all := true
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case "ALL":
+		case "WRITE":
+			all = false
+		default:
+			return errSyntax
+		}
+	}
+	kvdb.unpause()
+	kvdb.pause = &clientPause{
+		all:    all,
+		until:  timeNow().Add(time.Duration(ms) * time.Millisecond),
+		lifted: make(chan struct{}),
+	}
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) unpause() {
+	if kvdb.pause != nil {
+		close(kvdb.pause.lifted)
+		kvdb.pause = nil
+	}
+}
+
+// awaitUnpause releases the database lock until the CLIENT PAUSE in effect,
+// if any, lets the session run cmd. It reports false when the session is
+// cancelled first.
+func (kvdb *KeyValueDB) awaitUnpause(s *Session, cmd Command) bool {
+	for kvdb.pauses(s, cmd) {
+		p := kvdb.pause
+		timer := time.NewTimer(p.until.Sub(timeNow()))
+		kvdb.mu.Unlock()
+		cancelled := false
+		select {
+		case <-p.lifted:
+		case <-timer.C:
+		case <-s.done:
+			cancelled = true
+		}
+		timer.Stop()
+		kvdb.mu.Lock()
+		if cancelled {
+			return false
+		}
+	}
+	return true
+}
+
+// pauses reports whether the pause in effect holds cmd back. CLIENT always
+// runs, so that a paused server can be inspected and unpaused. A command
+// queued by MULTI is held back when EXEC runs it.
+func (kvdb *KeyValueDB) pauses(s *Session, cmd Command) bool {
+	p := kvdb.pause
+	if p == nil {
+		return false
+	}
+	if !timeNow().Before(p.until) {
+		kvdb.pause = nil
+		return false
+	}
+	spec, ok := lookupCommand(cmd.Name)
+	if !ok || spec.Name == CLIENT {
+		return false
+	}
+	if p.all {
+		return true
+	}
+	if s.inMulti && !cmd.runsInMulti() {
+		return false
+	}
+	if spec.Name == EXEC {
+		for _, queued := range s.queue {
+			if queuedSpec, ok := lookupCommand(queued.Name); ok && queuedSpec.Flags&FlagWrite != 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return spec.Flags&FlagWrite != 0
+}
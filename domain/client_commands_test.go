@@ -0,0 +1,255 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClientCommand(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	var closed []string
+	newClient := func(addr string) *Session {
+		return kvdb.NewSession(Conn{
+			RemoteAddr: addr,
+			LocalAddr:  "127.0.0.1:9736",
+			Close:      func() { closed = append(closed, addr) },
+		})
+	}
+	first, second := newClient("10.0.0.1:5000"), newClient("10.0.0.2:5000")
+	clock = clock.Add(10 * time.Second)
+	kvdb.Run(second, NewCommand(SELECT, "1"))
+	kvdb.Run(second, NewCommand(MULTI))
+	kvdb.Run(second, NewCommand(SET, "a", "1"))
+	clock = clock.Add(5 * time.Second)
+
+	tests := []struct {
+		name     string
+		cmd      Command
+		expected interface{}
+		closed   []string
+	}{
+		{
+			name:     "ID",
+			cmd:      NewCommand(CLIENT, "ID"),
+			expected: int64(1),
+		},
+		{
+			name:     "GETNAME without a name",
+			cmd:      NewCommand(CLIENT, "GETNAME"),
+			expected: nil,
+		},
+		{
+			name:     "SETNAME",
+			cmd:      NewCommand(CLIENT, "SETNAME", "worker"),
+			expected: statusOK,
+		},
+		{
+			name:     "SETNAME with a space",
+			cmd:      NewCommand(CLIENT, "SETNAME", "a worker"),
+			expected: ErrorReply("(error) ERR Client names cannot contain spaces, newlines or special characters."),
+		},
+		{
+			name:     "GETNAME",
+			cmd:      NewCommand(CLIENT, "getname"),
+			expected: "worker",
+		},
+		{
+			name:     "NO-EVICT",
+			cmd:      NewCommand(CLIENT, "NO-EVICT", "on"),
+			expected: statusOK,
+		},
+		{
+			name: "LIST",
+			cmd:  NewCommand(CLIENT, "LIST"),
+			expected: "id=1 addr=10.0.0.1:5000 laddr=127.0.0.1:9736 name=worker age=15 idle=0 flags=e db=0 sub=0 psub=0 multi=-1 qbuf=0 obl=0 cmd=client user=default\n" +
+				"id=2 addr=10.0.0.2:5000 laddr=127.0.0.1:9736 name= age=15 idle=5 flags=x db=1 sub=0 psub=0 multi=1 qbuf=0 obl=0 cmd=set user=default\n",
+		},
+		{
+			name:     "LIST by ID",
+			cmd:      NewCommand(CLIENT, "LIST", "ID", "2", "3"),
+			expected: "id=2 addr=10.0.0.2:5000 laddr=127.0.0.1:9736 name= age=15 idle=5 flags=x db=1 sub=0 psub=0 multi=1 qbuf=0 obl=0 cmd=set user=default\n",
+		},
+		{
+			name:     "LIST of replicas",
+			cmd:      NewCommand(CLIENT, "LIST", "TYPE", "replica"),
+			expected: "",
+		},
+		{
+			name:     "LIST of an unknown type",
+			cmd:      NewCommand(CLIENT, "LIST", "TYPE", "robot"),
+			expected: ErrorReply("(error) ERR Unknown client type 'robot'"),
+		},
+		{
+			name:     "INFO",
+			cmd:      NewCommand(CLIENT, "INFO"),
+			expected: "id=1 addr=10.0.0.1:5000 laddr=127.0.0.1:9736 name=worker age=15 idle=0 flags=e db=0 sub=0 psub=0 multi=-1 qbuf=0 obl=0 cmd=client user=default\n",
+		},
+		{
+			name:     "KILL by address of no client",
+			cmd:      NewCommand(CLIENT, "KILL", "10.0.0.3:5000"),
+			expected: ErrorReply("(error) ERR No such client"),
+		},
+		{
+			name:     "KILL skips the client asking",
+			cmd:      NewCommand(CLIENT, "KILL", "USER", "default"),
+			expected: 1,
+			closed:   []string{"10.0.0.2:5000"},
+		},
+		{
+			name:     "KILL with SKIPME no",
+			cmd:      NewCommand(CLIENT, "KILL", "ID", "1", "SKIPME", "no"),
+			expected: 1,
+			closed:   []string{"10.0.0.1:5000"},
+		},
+		{
+			name:     "KILL by address",
+			cmd:      NewCommand(CLIENT, "KILL", "10.0.0.2:5000"),
+			expected: statusOK,
+			closed:   []string{"10.0.0.2:5000"},
+		},
+		{
+			name:     "KILL with an invalid ID",
+			cmd:      NewCommand(CLIENT, "KILL", "ID", "x"),
+			expected: ErrorReply("(error) ERR client-id should be greater than 0"),
+		},
+		{
+			name:     "KILL with an unknown filter",
+			cmd:      NewCommand(CLIENT, "KILL", "AGE", "1"),
+			expected: errSyntax,
+		},
+		{
+			name:     "PAUSE with an invalid timeout",
+			cmd:      NewCommand(CLIENT, "PAUSE", "soon"),
+			expected: ErrorReply("(error) ERR timeout is not an integer or out of range"),
+		},
+		{
+			name:     "PAUSE with an unknown mode",
+			cmd:      NewCommand(CLIENT, "PAUSE", "10", "READ"),
+			expected: errSyntax,
+		},
+		{
+			name:     "Wrong number of arguments",
+			cmd:      NewCommand(CLIENT, "SETNAME"),
+			expected: ErrorReply("(error) ERR wrong number of arguments for 'client|setname' command"),
+		},
+		{
+			name:     "Unknown subcommand",
+			cmd:      NewCommand(CLIENT, "REPLY", "ON"),
+			expected: ErrorReply("(error) ERR unknown subcommand 'REPLY'. Try CLIENT HELP."),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			closed = nil
+			if got := kvdb.Run(first, test.cmd); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("command %v returned %#v, expected %#v", test.cmd, got, test.expected)
+			}
+			if !reflect.DeepEqual(closed, test.closed) {
+				t.Errorf("command %v closed %v, expected %v", test.cmd, closed, test.closed)
+			}
+		})
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		cmd    Command
+		paused bool
+	}{
+		{name: "WRITE holds writes", mode: "WRITE", cmd: NewCommand(SET, "a", "1"), paused: true},
+		{name: "WRITE lets reads run", mode: "WRITE", cmd: NewCommand(GET, "a"), paused: false},
+		{name: "ALL holds reads", mode: "ALL", cmd: NewCommand(GET, "a"), paused: true},
+		{name: "ALL lets CLIENT run", mode: "ALL", cmd: NewCommand(CLIENT, "LIST"), paused: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+			admin, client := kvdb.NewSession(Conn{}), kvdb.NewSession(Conn{})
+			if got := kvdb.Run(admin, NewCommand(CLIENT, "PAUSE", "60000", test.mode)); got != statusOK {
+				t.Fatalf("CLIENT PAUSE returned %#v", got)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				kvdb.Run(client, test.cmd)
+				close(done)
+			}()
+			select {
+			case <-done:
+				if test.paused {
+					t.Fatalf("%v ran during the pause", test.cmd)
+				}
+				return
+			case <-time.After(50 * time.Millisecond):
+				if !test.paused {
+					t.Fatalf("%v was held by the pause", test.cmd)
+				}
+			}
+
+			kvdb.Run(admin, NewCommand(CLIENT, "UNPAUSE"))
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%v still waits after CLIENT UNPAUSE", test.cmd)
+			}
+		})
+	}
+}
+
+func TestClientPauseEnds(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	admin, client := kvdb.NewSession(Conn{}), kvdb.NewSession(Conn{})
+	kvdb.Run(admin, NewCommand(CLIENT, "PAUSE", "50", "WRITE"))
+	// A transaction is queued during the pause but waits to run.
+	kvdb.Run(client, NewCommand(MULTI))
+	if got := kvdb.Run(client, NewCommand(SET, "a", "1")); got != statusQueued {
+		t.Fatalf("SET in MULTI returned %#v", got)
+	}
+	start := time.Now()
+	if got := kvdb.Run(client, NewCommand(EXEC)); !reflect.DeepEqual(got, []interface{}{statusOK}) {
+		t.Errorf("EXEC returned %#v", got)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("EXEC ran after %v, before the pause ended", waited)
+	}
+}
+
+func TestClientKillWakesBlockedClients(t *testing.T) {
+	for _, cmd := range []Command{
+		NewCommand(XREAD, "BLOCK", "0", "STREAMS", "s", "$"),
+		NewCommand(GET, "a"),
+	} {
+		kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+		admin, client := kvdb.NewSession(Conn{}), kvdb.NewSession(Conn{})
+		if cmd.Name == GET {
+			kvdb.Run(admin, NewCommand(CLIENT, "PAUSE", "60000"))
+		}
+		done := make(chan interface{})
+		go func() { done <- kvdb.Run(client, cmd) }()
+		time.Sleep(20 * time.Millisecond)
+
+		if got := kvdb.Run(admin, NewCommand(CLIENT, "KILL", "ID", strconv.FormatInt(client.ID(), 10))); got != 1 {
+			t.Fatalf("CLIENT KILL returned %#v", got)
+		}
+		select {
+		case got := <-done:
+			if got != nil {
+				t.Errorf("%v returned %#v after CLIENT KILL", cmd, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v still waits after CLIENT KILL", cmd)
+		}
+		if got := kvdb.Run(client, cmd); got != nil {
+			t.Errorf("%v of a killed client returned %#v", cmd, got)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package domain
 
 import (
 	"fmt"
+	"strings"
 )
 
 const (
@@ -15,79 +16,226 @@ const (
 	DISCARD string = "DISCARD"
 	COMPACT string = "COMPACT"
 	SELECT  string = "SELECT"
+	COMMAND string = "COMMAND"
+
+	INFO     string = "INFO"
+	CONFIG   string = "CONFIG"
+	SHUTDOWN string = "SHUTDOWN"
+	CLIENT   string = "CLIENT"
+	AUTH     string = "AUTH"
+	ACL      string = "ACL"
+
+	WATCH   string = "WATCH"
+	UNWATCH string = "UNWATCH"
+
+	APPEND   string = "APPEND"
+	STRLEN   string = "STRLEN"
+	GETRANGE string = "GETRANGE"
+	SETRANGE string = "SETRANGE"
+	GETSET   string = "GETSET"
+	GETDEL   string = "GETDEL"
+	GETEX    string = "GETEX"
+	SETNX    string = "SETNX"
+	MSET     string = "MSET"
+	MSETNX   string = "MSETNX"
+	MGET     string = "MGET"
+
+	DECR        string = "DECR"
+	DECRBY      string = "DECRBY"
+	INCRBYFLOAT string = "INCRBYFLOAT"
+
+	KEYS      string = "KEYS"
+	SCAN      string = "SCAN"
+	ZSCAN     string = "ZSCAN"
+	EXISTS    string = "EXISTS"
+	TYPE      string = "TYPE"
+	RENAME    string = "RENAME"
+	RENAMENX  string = "RENAMENX"
+	COPY      string = "COPY"
+	MOVE      string = "MOVE"
+	RANDOMKEY string = "RANDOMKEY"
+	UNLINK    string = "UNLINK"
+	TOUCH     string = "TOUCH"
+
+	DBSIZE   string = "DBSIZE"
+	FLUSHDB  string = "FLUSHDB"
+	FLUSHALL string = "FLUSHALL"
+	SWAPDB   string = "SWAPDB"
+
+	XADD       string = "XADD"
+	XLEN       string = "XLEN"
+	XDEL       string = "XDEL"
+	XTRIM      string = "XTRIM"
+	XSETID     string = "XSETID"
+	XRANGE     string = "XRANGE"
+	XREVRANGE  string = "XREVRANGE"
+	XREAD      string = "XREAD"
+	XGROUP     string = "XGROUP"
+	XREADGROUP string = "XREADGROUP"
+	XACK       string = "XACK"
+	XPENDING   string = "XPENDING"
+	XCLAIM     string = "XCLAIM"
+
+	SETBIT      string = "SETBIT"
+	GETBIT      string = "GETBIT"
+	BITCOUNT    string = "BITCOUNT"
+	BITPOS      string = "BITPOS"
+	BITOP       string = "BITOP"
+	BITFIELD    string = "BITFIELD"
+	BITFIELD_RO string = "BITFIELD_RO"
+
+	PFADD   string = "PFADD"
+	PFCOUNT string = "PFCOUNT"
+	PFMERGE string = "PFMERGE"
+
+	GEOADD            string = "GEOADD"
+	GEODIST           string = "GEODIST"
+	GEOPOS            string = "GEOPOS"
+	GEOHASH           string = "GEOHASH"
+	GEOSEARCH         string = "GEOSEARCH"
+	GEOSEARCHSTORE    string = "GEOSEARCHSTORE"
+	GEORADIUS         string = "GEORADIUS"
+	GEORADIUSBYMEMBER string = "GEORADIUSBYMEMBER"
+
+	JSON_SET       string = "JSON.SET"
+	JSON_GET       string = "JSON.GET"
+	JSON_DEL       string = "JSON.DEL"
+	JSON_ARRAPPEND string = "JSON.ARRAPPEND"
+	JSON_NUMINCRBY string = "JSON.NUMINCRBY"
+
+	BF_RESERVE   string = "BF.RESERVE"
+	BF_ADD       string = "BF.ADD"
+	BF_MADD      string = "BF.MADD"
+	BF_EXISTS    string = "BF.EXISTS"
+	BF_MEXISTS   string = "BF.MEXISTS"
+	BF_SCANDUMP  string = "BF.SCANDUMP"
+	BF_LOADCHUNK string = "BF.LOADCHUNK"
+
+	CF_RESERVE   string = "CF.RESERVE"
+	CF_ADD       string = "CF.ADD"
+	CF_ADDNX     string = "CF.ADDNX"
+	CF_INSERT    string = "CF.INSERT"
+	CF_INSERTNX  string = "CF.INSERTNX"
+	CF_EXISTS    string = "CF.EXISTS"
+	CF_MEXISTS   string = "CF.MEXISTS"
+	CF_DEL       string = "CF.DEL"
+	CF_COUNT     string = "CF.COUNT"
+	CF_SCANDUMP  string = "CF.SCANDUMP"
+	CF_LOADCHUNK string = "CF.LOADCHUNK"
+
+	TS_CREATE     string = "TS.CREATE"
+	TS_ADD        string = "TS.ADD"
+	TS_GET        string = "TS.GET"
+	TS_RANGE      string = "TS.RANGE"
+	TS_REVRANGE   string = "TS.REVRANGE"
+	TS_MRANGE     string = "TS.MRANGE"
+	TS_CREATERULE string = "TS.CREATERULE"
+	TS_DELETERULE string = "TS.DELETERULE"
 )
 
+// Command is one command line: a name and the arguments after it.
 type Command struct {
-	Name  string
-	Key   string
-	Value interface{}
+	Name string
+	// Argv holds the arguments that follow the name, byte for byte as the
+	// client sent them.
+	Argv [][]byte
 }
 
+// NewCommand builds a command from arguments of any type. Strings and byte
+// slices are taken as they are, nil arguments are left out, and anything
+// else is formatted with %v.
 func NewCommand(name string, args ...interface{}) Command {
-	var key string
-	var value interface{}
-	if len(args) > 1 {
-		value = args[1]
+	var argv [][]byte
+	for _, arg := range args {
+		switch a := arg.(type) {
+		case nil:
+			continue
+		case []byte:
+			argv = append(argv, a)
+		case string:
+			argv = append(argv, []byte(a))
+		default:
+			argv = append(argv, []byte(fmt.Sprintf("%v", a)))
+		}
 	}
-	if len(args) > 0 {
-		key = fmt.Sprintf("%v", args[0])
+	return Command{Name: name, Argv: argv}
+}
+
+// params returns every argument of the command, starting with the key.
+func (c Command) params() []string {
+	params := make([]string, len(c.Argv))
+	for i, arg := range c.Argv {
+		params[i] = string(arg)
 	}
+	return params
+}
 
-	return Command{
-		Name:  name,
-		Key:   key,
-		Value: value,
+// arg returns argument i, or "" when the command has fewer arguments.
+func (c Command) arg(i int) string {
+	if i >= len(c.Argv) {
+		return ""
 	}
+	return string(c.Argv[i])
 }
 
-func (c Command) isTerminatorCmd() bool {
+// argBytes returns a copy of argument i that can be stored and changed in
+// place without affecting the command.
+func (c Command) argBytes(i int) []byte {
+	return append([]byte{}, c.Argv[i]...)
+}
+
+// Keys returns the arguments that name keys, in the order they appear.
+func (c Command) Keys() []string {
+	spec, ok := lookupCommand(c.Name)
+	if !ok {
+		return nil
+	}
+	if spec.FindKeys != nil {
+		return spec.FindKeys(c.params())
+	}
+	return spec.Keys.find(c.params())
+}
+
+// String returns the command line as it would be typed.
+func (c Command) String() string {
+	words := []string{c.Name}
+	for _, arg := range c.params() {
+		words = append(words, compactArg(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// runsInMulti reports whether the command runs at once inside MULTI
+// instead of being queued for EXEC.
+func (c Command) runsInMulti() bool {
 	switch c.Name {
-	case EXEC, DISCARD:
+	case EXEC, DISCARD, WATCH:
 		return true
 	}
 	return false
 }
 
 func (c Command) Validate() (bool, error) {
-	switch c.Name {
-	case SET, INCRBY:
-		cmd := "set"
-		if c.Name == INCRBY {
-			cmd = "incrby"
-		}
-		if c.Value == nil {
-			return false, fmt.Errorf("(error) ERR wrong number of arguments for '%s' command", cmd)
-		}
-		return true, nil
-	case GET, DEL, INCR:
-		cmd := "get"
-		switch c.Name {
-		case DEL:
-			cmd = "del"
-		case INCR:
-			cmd = "incrby"
-		}
-		if c.Key == "" {
-			return false, fmt.Errorf("(error) ERR wrong number of arguments for '%s' command", cmd)
+	spec, ok := lookupCommand(c.Name)
+	if !ok {
+		params := ""
+		for i := 0; i < len(c.Argv) && i < 2; i++ {
+			params += fmt.Sprintf("`%s`,", c.Argv[i])
+			if i == 0 && len(c.Argv) > 1 {
+				params += " "
+			}
 		}
-		return true, nil
-	case SELECT:
-		cmd := "select"
-		if c.Key == "" {
-			return false, fmt.Errorf("(error) ERR wrong number of arguments for '%s' command", cmd)
-		}
-		return true, nil
-	case MULTI, EXEC, DISCARD, COMPACT:
-		return true, nil
+		return false, fmt.Errorf("(error) ERR unknown command `%s`, with args beginning with: %s", c.Name, params)
 	}
+	return c.validateArity(spec.MinArgs, spec.MaxArgs)
+}
 
-	params := ""
-	if c.Key != "" {
-		params = fmt.Sprintf("`%s`,", c.Key)
-		if c.Value != nil {
-			params = fmt.Sprintf("`%s`, `%v`,", c.Key, c.Value)
-		}
+// validateArity checks that the command has between minArgs and maxArgs
+// arguments. A negative maxArgs means there is no upper bound.
+func (c Command) validateArity(minArgs, maxArgs int) (bool, error) {
+	n := len(c.Argv)
+	if n < minArgs || (maxArgs >= 0 && n > maxArgs) {
+		return false, fmt.Errorf("(error) ERR wrong number of arguments for '%s' command", strings.ToLower(c.Name))
 	}
-
-	return false, fmt.Errorf("(error) ERR unknown command `%s`, with args beginning with: %s", c.Name, params)
+	return true, nil
 }
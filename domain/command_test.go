@@ -24,7 +24,7 @@ func TestNewCommand(t *testing.T) {
 			args: []interface{}{"foo"},
 			want: Command{
 				Name: GET,
-				Key:  "foo",
+				Argv: [][]byte{[]byte("foo")},
 			},
 		},
 		{
@@ -32,9 +32,17 @@ func TestNewCommand(t *testing.T) {
 			c:    SET,
 			args: []interface{}{"foo", "bar"},
 			want: Command{
-				Name:  SET,
-				Key:   "foo",
-				Value: "bar",
+				Name: SET,
+				Argv: [][]byte{[]byte("foo"), []byte("bar")},
+			},
+		},
+		{
+			name: "Command with more arguments",
+			c:    SET,
+			args: []interface{}{"foo", []byte("a\x00b"), "EX", 10},
+			want: Command{
+				Name: SET,
+				Argv: [][]byte{[]byte("foo"), []byte("a\x00b"), []byte("EX"), []byte("10")},
 			},
 		},
 	}
@@ -46,3 +54,72 @@ func TestNewCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  Command
+		want string
+	}{
+		{name: "Empty key", cmd: NewCommand(GET, "")},
+		{name: "Options after the value", cmd: NewCommand(SET, "k", "v", "EX", "10")},
+		{
+			name: "Extra argument",
+			cmd:  NewCommand(GET, "a", "b"),
+			want: "(error) ERR wrong number of arguments for 'get' command",
+		},
+		{
+			name: "Missing argument",
+			cmd:  NewCommand(INCR),
+			want: "(error) ERR wrong number of arguments for 'incr' command",
+		},
+		{
+			name: "Unknown command",
+			cmd:  NewCommand("NOPE", "a", "b", "c"),
+			want: "(error) ERR unknown command `NOPE`, with args beginning with: `a`, `b`,",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if _, err := tt.cmd.Validate(); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		cmd  Command
+		want []string
+	}{
+		{cmd: NewCommand(GET, "a"), want: []string{"a"}},
+		{cmd: NewCommand(SET, "a", "v", "EX", "10"), want: []string{"a"}},
+		{cmd: NewCommand(DEL, "a", "b", "c"), want: []string{"a", "b", "c"}},
+		{cmd: NewCommand(MSET, "a", "1", "b", "2"), want: []string{"a", "b"}},
+		{cmd: NewCommand(BITOP, "AND", "dest", "a", "b"), want: []string{"dest", "a", "b"}},
+		{cmd: NewCommand(XGROUP, "CREATE", "s", "g", "$"), want: []string{"s"}},
+		{cmd: NewCommand(XREAD, "COUNT", "2", "STREAMS", "a", "b", "0", "0"), want: []string{"a", "b"}},
+		{cmd: NewCommand(XREADGROUP, "GROUP", "g", "c", "STREAMS", "s", ">"), want: []string{"s"}},
+		{cmd: NewCommand(GEORADIUS, "z", "1", "2", "3", "km", "STORE", "dest"), want: []string{"z", "dest"}},
+		{cmd: NewCommand(GEORADIUSBYMEMBER, "z", "m", "3", "km"), want: []string{"z"}},
+		{cmd: NewCommand(SWAPDB, "0", "1")},
+		{cmd: NewCommand("NOPE", "a")},
+	}
+	for _, tt := range tests {
+		if got := tt.cmd.Keys(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v Keys() = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	cmd := NewCommand(SET, "greeting", "hello world")
+	if got, want := cmd.String(), `SET greeting "hello world"`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"keyvaluedb/config"
+	"strings"
+)
+
+func configCommand(ctx *CommandContext, cmd Command) interface{} {
+	args := cmd.params()
+	cfg := ctx.kvdb.config
+	switch sub := strings.ToUpper(args[0]); {
+	case sub == "GET" && len(args) > 1:
+		return configGet(cfg, args[1:])
+	case sub == "SET" && len(args) > 1 && len(args)%2 == 1:
+		return configSet(cfg, args[1:])
+	case sub == "REWRITE" && len(args) == 1:
+		if cfg.File() == "" {
+			return ErrorReply("(error) ERR The server is running without a config file")
+		}
+		if err := cfg.Rewrite(); err != nil {
+			return ErrorReply(fmt.Sprintf("(error) ERR Rewriting config file: %v", err))
+		}
+		return statusOK
+	case sub == "GET" || sub == "SET" || sub == "REWRITE":
+		return ErrorReply(fmt.Sprintf("(error) ERR wrong number of arguments for 'config|%s' command", strings.ToLower(sub)))
+	}
+	return ErrorReply(fmt.Sprintf("(error) ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0]))
+}
+
+// configGet returns the name and value of every setting matching one of the
+// patterns.
+func configGet(cfg *config.Config, patterns []string) interface{} {
+	reply := []interface{}{}
+	for _, name := range cfg.Names() {
+		for _, pattern := range patterns {
+			if globMatch(strings.ToLower(pattern), name) {
+				value, _ := cfg.Get(name)
+				reply = append(reply, name, value)
+				break
+			}
+		}
+	}
+	return reply
+}
+
+func configSet(cfg *config.Config, pairs []string) interface{} {
+	err := cfg.Update(pairs...)
+	if err == nil {
+		return statusOK
+	}
+	var setErr *config.SetError
+	if !errors.As(err, &setErr) {
+		return ErrorReply(fmt.Sprintf("(error) ERR %v", err))
+	}
+	if errors.Is(err, config.ErrUnknownOption) {
+		return ErrorReply(fmt.Sprintf("(error) ERR Unknown option or number of arguments for CONFIG SET - '%s'", setErr.Name))
+	}
+	return ErrorReply(fmt.Sprintf("(error) ERR CONFIG SET failed (possibly related to argument '%s') - %v", setErr.Name, setErr.Err))
+}
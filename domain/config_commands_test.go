@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"keyvaluedb/config"
+	"keyvaluedb/storage"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvdb.conf")
+	if err := os.WriteFile(path, []byte("# settings\ntimeout 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.New()
+	if err := cfg.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.SetConfig(cfg)
+
+	tests := []struct {
+		name     string
+		cmd      Command
+		expected interface{}
+	}{
+		{
+			name:     "GET",
+			cmd:      NewCommand(CONFIG, "GET", "timeout"),
+			expected: []interface{}{"timeout", "10"},
+		},
+		{
+			name:     "GET with patterns",
+			cmd:      NewCommand(CONFIG, "get", "MAXMEMORY*", "port", "nope"),
+			expected: []interface{}{"maxmemory", "0", "maxmemory-policy", "noeviction", "port", "9736"},
+		},
+		{
+			name:     "SET",
+			cmd:      NewCommand(CONFIG, "SET", "timeout", "0", "maxmemory", "10mb"),
+			expected: statusOK,
+		},
+		{
+			name:     "GET after SET",
+			cmd:      NewCommand(CONFIG, "GET", "timeout", "maxmemory"),
+			expected: []interface{}{"maxmemory", "10485760", "timeout", "0"},
+		},
+		{
+			name:     "SET of an immutable setting",
+			cmd:      NewCommand(CONFIG, "SET", "port", "6380"),
+			expected: ErrorReply("(error) ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config"),
+		},
+		{
+			name:     "SET of an invalid value",
+			cmd:      NewCommand(CONFIG, "SET", "appendonly", "maybe"),
+			expected: ErrorReply("(error) ERR CONFIG SET failed (possibly related to argument 'appendonly') - argument must be 'yes' or 'no'"),
+		},
+		{
+			name:     "SET of an unknown setting",
+			cmd:      NewCommand(CONFIG, "SET", "nope", "1"),
+			expected: ErrorReply("(error) ERR Unknown option or number of arguments for CONFIG SET - 'nope'"),
+		},
+		{
+			name:     "SET without a value",
+			cmd:      NewCommand(CONFIG, "SET", "timeout"),
+			expected: ErrorReply("(error) ERR wrong number of arguments for 'config|set' command"),
+		},
+		{
+			name:     "REWRITE",
+			cmd:      NewCommand(CONFIG, "REWRITE"),
+			expected: statusOK,
+		},
+		{
+			name:     "Unknown subcommand",
+			cmd:      NewCommand(CONFIG, "RESET"),
+			expected: ErrorReply("(error) ERR unknown subcommand 'RESET'. Try CONFIG HELP."),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, got := kvdb.Execute(0, test.cmd); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("command %v returned %#v, expected %#v", test.cmd, got, test.expected)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# settings\ntimeout 0\nmaxmemory 10485760\n"; string(data) != want {
+		t.Errorf("CONFIG REWRITE wrote %q, expected %q", data, want)
+	}
+	_, info := kvdb.Execute(0, NewCommand(INFO, "server"))
+	if !strings.Contains(info.(string), "config_file:"+path+"\r\n") {
+		t.Errorf("INFO server does not report the config file:\n%s", info)
+	}
+}
+
+func TestConfigRewriteWithoutFile(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	expected := ErrorReply("(error) ERR The server is running without a config file")
+	if _, got := kvdb.Execute(0, NewCommand(CONFIG, "REWRITE")); got != expected {
+		t.Errorf("CONFIG REWRITE returned %#v, expected %#v", got, expected)
+	}
+}
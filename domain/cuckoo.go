@@ -0,0 +1,300 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	cuckooDefaultCapacity      = 1024
+	cuckooDefaultBucketSize    = 2
+	cuckooDefaultMaxIterations = 20
+	cuckooDefaultExpansion     = 1
+)
+
+var errCuckooFull = errors.New("ERR Filter is full")
+
+// cuckooFilter stores an 8-bit fingerprint of each item in one of two
+// buckets, so items can be deleted again. When both buckets of an item are
+// full, fingerprints are kicked to their other bucket; when that fails too a
+// new sub-filter is added, unless expansion is zero.
+type cuckooFilter struct {
+	capacity      uint64
+	bucketSize    uint64
+	maxIterations uint64
+	expansion     uint64
+	layers        []*cuckooLayer
+	inserted      uint64
+	deleted       uint64
+}
+
+// cuckooLayer holds numBuckets buckets of bucketSize slots. numBuckets is a
+// power of two so that the alternate bucket can be derived by xor.
+type cuckooLayer struct {
+	numBuckets uint64
+	slots      []byte
+}
+
+func newCuckooFilter(capacity, bucketSize, maxIterations, expansion uint64) *cuckooFilter {
+	cf := &cuckooFilter{
+		capacity:      capacity,
+		bucketSize:    bucketSize,
+		maxIterations: maxIterations,
+		expansion:     expansion,
+	}
+	numBuckets, _ := cuckooBuckets(capacity, bucketSize)
+	cf.addLayer(numBuckets)
+	return cf
+}
+
+// cuckooBuckets returns the number of buckets of bucketSize slots a filter
+// needs to hold capacity items, rounded up to a power of two. It reports
+// false when the slots would take more than maxFilterBytes; newCuckooFilter
+// must only be called with a capacity that fits.
+func cuckooBuckets(capacity, bucketSize uint64) (uint64, bool) {
+	buckets := capacity / bucketSize
+	if capacity%bucketSize != 0 {
+		buckets++
+	}
+	if buckets > maxFilterBytes/bucketSize {
+		return 0, false
+	}
+	numBuckets := nextPowerOfTwo(buckets)
+	return numBuckets, numBuckets <= maxFilterBytes/bucketSize
+}
+
+func (cf *cuckooFilter) clone() *cuckooFilter {
+	c := *cf
+	c.layers = make([]*cuckooLayer, len(cf.layers))
+	for i, l := range cf.layers {
+		c.layers[i] = &cuckooLayer{numBuckets: l.numBuckets, slots: append([]byte(nil), l.slots...)}
+	}
+	return &c
+}
+
+func nextPowerOfTwo(n uint64) uint64 {
+	p := uint64(1)
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+func (cf *cuckooFilter) addLayer(numBuckets uint64) *cuckooLayer {
+	l := &cuckooLayer{numBuckets: numBuckets, slots: make([]byte, numBuckets*cf.bucketSize)}
+	cf.layers = append(cf.layers, l)
+	return l
+}
+
+// cuckooHash returns the item hash that bucket indexes are taken from, and
+// its non-zero fingerprint. A zero slot is empty.
+func cuckooHash(item []byte) (uint64, byte) {
+	h := murmurHash64A(item, 0)
+	return h, byte((h>>56)%255 + 1)
+}
+
+func (l *cuckooLayer) buckets(h uint64, fp byte) (uint64, uint64) {
+	i1 := h & (l.numBuckets - 1)
+	return i1, l.altBucket(i1, fp)
+}
+
+func (l *cuckooLayer) altBucket(i uint64, fp byte) uint64 {
+	return (i ^ (uint64(fp) * 0x5bd1e995)) & (l.numBuckets - 1)
+}
+
+func (l *cuckooLayer) bucket(cf *cuckooFilter, i uint64) []byte {
+	return l.slots[i*cf.bucketSize : (i+1)*cf.bucketSize]
+}
+
+func (l *cuckooLayer) count(cf *cuckooFilter, h uint64, fp byte) uint64 {
+	i1, i2 := l.buckets(h, fp)
+	var n uint64
+	for _, i := range []uint64{i1, i2} {
+		for _, slot := range l.bucket(cf, i) {
+			if slot == fp {
+				n++
+			}
+		}
+		if i1 == i2 {
+			break
+		}
+	}
+	return n
+}
+
+// place stores fp in the first empty slot of bucket i.
+func (l *cuckooLayer) place(cf *cuckooFilter, i uint64, fp byte) bool {
+	bucket := l.bucket(cf, i)
+	for j, slot := range bucket {
+		if slot == 0 {
+			bucket[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// kickInsert makes room for fp by moving fingerprints to their alternate
+// buckets. If no room is found within maxIterations every move is undone.
+func (l *cuckooLayer) kickInsert(cf *cuckooFilter, i uint64, fp byte) bool {
+	type move struct {
+		bucket, slot uint64
+		old          byte
+	}
+	var moves []move
+	for n := uint64(0); n < cf.maxIterations; n++ {
+		slot := n % cf.bucketSize
+		bucket := l.bucket(cf, i)
+		moves = append(moves, move{i, slot, bucket[slot]})
+		fp, bucket[slot] = bucket[slot], fp
+		i = l.altBucket(i, fp)
+		if l.place(cf, i, fp) {
+			return true
+		}
+	}
+	for k := len(moves) - 1; k >= 0; k-- {
+		l.bucket(cf, moves[k].bucket)[moves[k].slot] = moves[k].old
+	}
+	return false
+}
+
+func (cf *cuckooFilter) count(item []byte) uint64 {
+	h, fp := cuckooHash(item)
+	var n uint64
+	for _, l := range cf.layers {
+		n += l.count(cf, h, fp)
+	}
+	return n
+}
+
+func (cf *cuckooFilter) exists(item []byte) bool {
+	h, fp := cuckooHash(item)
+	for _, l := range cf.layers {
+		if l.count(cf, h, fp) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// add inserts item, which may already be present.
+func (cf *cuckooFilter) add(item []byte) error {
+	h, fp := cuckooHash(item)
+	for _, l := range cf.layers {
+		i1, i2 := l.buckets(h, fp)
+		if l.place(cf, i1, fp) || l.place(cf, i2, fp) {
+			cf.inserted++
+			return nil
+		}
+	}
+	last := cf.layers[len(cf.layers)-1]
+	i1, _ := last.buckets(h, fp)
+	if !last.kickInsert(cf, i1, fp) {
+		if cf.expansion == 0 {
+			return errCuckooFull
+		}
+		numBuckets := last.numBuckets * nextPowerOfTwo(cf.expansion)
+		if numBuckets > maxFilterBytes/cf.bucketSize {
+			return errFilterTooLarge
+		}
+		last = cf.addLayer(numBuckets)
+		i1, _ = last.buckets(h, fp)
+		last.place(cf, i1, fp)
+	}
+	cf.inserted++
+	return nil
+}
+
+// remove deletes one copy of item and reports whether it was found. Newer
+// layers are searched first.
+func (cf *cuckooFilter) remove(item []byte) bool {
+	h, fp := cuckooHash(item)
+	for k := len(cf.layers) - 1; k >= 0; k-- {
+		l := cf.layers[k]
+		i1, i2 := l.buckets(h, fp)
+		for _, i := range []uint64{i1, i2} {
+			bucket := l.bucket(cf, i)
+			for j, slot := range bucket {
+				if slot == fp {
+					bucket[j] = 0
+					cf.inserted--
+					cf.deleted++
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// cuckooHeader is the fixed part of a dumped cuckoo filter, followed by the
+// bucket count of each layer. The slots follow in later chunks.
+type cuckooHeader struct {
+	Capacity      uint64
+	BucketSize    uint64
+	MaxIterations uint64
+	Expansion     uint64
+	Inserted      uint64
+	Deleted       uint64
+	Layers        uint64
+}
+
+func (cf *cuckooFilter) dumpHeader() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, cuckooHeader{
+		Capacity:      cf.capacity,
+		BucketSize:    cf.bucketSize,
+		MaxIterations: cf.maxIterations,
+		Expansion:     cf.expansion,
+		Inserted:      cf.inserted,
+		Deleted:       cf.deleted,
+		Layers:        uint64(len(cf.layers)),
+	})
+	for _, l := range cf.layers {
+		binary.Write(&buf, binary.LittleEndian, l.numBuckets)
+	}
+	return buf.Bytes()
+}
+
+func (cf *cuckooFilter) dumpChunks() [][]byte {
+	chunks := make([][]byte, len(cf.layers))
+	for i, l := range cf.layers {
+		chunks[i] = l.slots
+	}
+	return chunks
+}
+
+// loadCuckooHeader creates an empty filter with the layout of a dumped one.
+func loadCuckooHeader(b []byte) (*cuckooFilter, error) {
+	r := bytes.NewReader(b)
+	var h cuckooHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return nil, errDumpInvalid
+	}
+	if h.BucketSize == 0 || h.BucketSize > 255 || h.Layers == 0 || h.Layers != uint64(r.Len())/8 {
+		return nil, errDumpInvalid
+	}
+	cf := &cuckooFilter{
+		capacity:      h.Capacity,
+		bucketSize:    h.BucketSize,
+		maxIterations: h.MaxIterations,
+		expansion:     h.Expansion,
+		inserted:      h.Inserted,
+		deleted:       h.Deleted,
+	}
+	for i := uint64(0); i < h.Layers; i++ {
+		var numBuckets uint64
+		if err := binary.Read(r, binary.LittleEndian, &numBuckets); err != nil {
+			return nil, errDumpInvalid
+		}
+		if numBuckets == 0 || numBuckets&(numBuckets-1) != 0 || numBuckets > maxFilterBytes/h.BucketSize {
+			return nil, errDumpInvalid
+		}
+		cf.addLayer(numBuckets)
+	}
+	if r.Len() != 0 {
+		return nil, errDumpInvalid
+	}
+	return cf, nil
+}
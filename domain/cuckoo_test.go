@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestCuckooFilterAddRemove(t *testing.T) {
+	cf := newCuckooFilter(1000, 2, 20, 1)
+	for i := 0; i < 500; i++ {
+		if err := cf.add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("add(%d) error = %v", i, err)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		if !cf.exists([]byte(strconv.Itoa(i))) {
+			t.Fatalf("exists(%d) = false after add", i)
+		}
+	}
+	for i := 0; i < 500; i += 2 {
+		if !cf.remove([]byte(strconv.Itoa(i))) {
+			t.Fatalf("remove(%d) = false", i)
+		}
+	}
+	for i := 1; i < 500; i += 2 {
+		if !cf.exists([]byte(strconv.Itoa(i))) {
+			t.Fatalf("exists(%d) = false after removing other items", i)
+		}
+	}
+	if cf.inserted != 250 || cf.deleted != 250 {
+		t.Errorf("inserted %d, deleted %d, want 250 and 250", cf.inserted, cf.deleted)
+	}
+}
+
+func TestCuckooFilterCountsDuplicates(t *testing.T) {
+	cf := newCuckooFilter(100, 4, 20, 1)
+	cf.add([]byte("x"))
+	cf.add([]byte("x"))
+	if got := cf.count([]byte("x")); got != 2 {
+		t.Errorf("count() = %d, want 2", got)
+	}
+	cf.remove([]byte("x"))
+	if got := cf.count([]byte("x")); got != 1 {
+		t.Errorf("count() after remove = %d, want 1", got)
+	}
+}
+
+func TestCuckooFilterFull(t *testing.T) {
+	cf := newCuckooFilter(4, 1, 10, 0)
+	var err error
+	added := 0
+	for i := 0; i < 100 && err == nil; i++ {
+		before := append([]byte(nil), cf.layers[0].slots...)
+		if err = cf.add([]byte(strconv.Itoa(i))); err == nil {
+			added++
+			continue
+		}
+		if !bytes.Equal(before, cf.layers[0].slots) {
+			t.Errorf("a failed add changed the filter")
+		}
+	}
+	if err != errCuckooFull || added > 4 {
+		t.Errorf("added %d items before error %v, want at most 4 before %v", added, err, errCuckooFull)
+	}
+}
+
+func TestCuckooFilterExpansion(t *testing.T) {
+	cf := newCuckooFilter(4, 1, 10, 2)
+	for i := 0; i < 50; i++ {
+		if err := cf.add([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("add(%d) error = %v", i, err)
+		}
+	}
+	if len(cf.layers) < 2 {
+		t.Fatalf("got %d layers, want the filter to grow", len(cf.layers))
+	}
+	for i := 0; i < 50; i++ {
+		if !cf.exists([]byte(strconv.Itoa(i))) {
+			t.Fatalf("exists(%d) = false after add", i)
+		}
+	}
+}
+
+func TestLoadCuckooHeaderInvalid(t *testing.T) {
+	header := newCuckooFilter(100, 2, 20, 1).dumpHeader()
+	bad := append([]byte(nil), header...)
+	// Bucket counts must be powers of two.
+	bad[len(bad)-8] = 3
+	for _, b := range [][]byte{nil, header[:20], bad} {
+		if _, err := loadCuckooHeader(b); err != errDumpInvalid {
+			t.Errorf("loadCuckooHeader(%d bytes) error = %v, want %v", len(b), err, errDumpInvalid)
+		}
+	}
+}
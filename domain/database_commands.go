@@ -0,0 +1,57 @@
+package domain
+
+import "strings"
+
+// parseFlushMode accepts the optional ASYNC or SYNC argument of FLUSHDB and
+// FLUSHALL. Both flush the same way: the tables are replaced at once and
+// the garbage collector frees the old ones concurrently.
+func parseFlushMode(args []string) ErrorReply {
+	if len(args) == 0 {
+		return ""
+	}
+	switch strings.ToUpper(args[0]) {
+	case "ASYNC", "SYNC":
+		return ""
+	}
+	return errSyntax
+}
+
+func (kvdb *KeyValueDB) dbsize(dbIndex int, cmd Command) interface{} {
+	return kvdb.storage.Size(dbIndex)
+}
+
+func (kvdb *KeyValueDB) flushdb(dbIndex int, cmd Command) interface{} {
+	if errMsg := parseFlushMode(cmd.params()); errMsg != "" {
+		return errMsg
+	}
+	kvdb.storage.Flush(dbIndex)
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) flushall(dbIndex int, cmd Command) interface{} {
+	if errMsg := parseFlushMode(cmd.params()); errMsg != "" {
+		return errMsg
+	}
+	kvdb.storage.FlushAll()
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) swapdb(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	db1, err := kvdb.storage.Select(args[0])
+	if err != nil {
+		return ErrorReply(err.Error())
+	}
+	db2, err := kvdb.storage.Select(args[1])
+	if err != nil {
+		return ErrorReply(err.Error())
+	}
+	if db1 == db2 {
+		return statusOK
+	}
+	kvdb.storage.Swap(db1, db2)
+	// Clients blocked on either database may now find data.
+	kvdb.signalDB(db1)
+	kvdb.signalDB(db2)
+	return statusOK
+}
@@ -0,0 +1,141 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDatabaseCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "DBSIZE and FLUSHDB",
+			commands: []Command{
+				NewCommand(DBSIZE),
+				NewCommand(SET, "a", "1"),
+				NewCommand(SET, "b", "2"),
+				NewCommand(DBSIZE),
+				NewCommand(FLUSHDB, "LAZY"),
+				NewCommand(FLUSHDB, "ASYNC"),
+				NewCommand(DBSIZE),
+				NewCommand(SET, "a", "1"),
+				NewCommand(FLUSHDB),
+				NewCommand(GET, "a"),
+			},
+			expected: []interface{}{0, statusOK, statusOK, 2, errSyntax, statusOK, 0, statusOK, statusOK, nil},
+		},
+		{
+			name: "SWAPDB",
+			commands: []Command{
+				NewCommand(SET, "a", "0"),
+				NewCommand(SWAPDB, "0", "1"),
+				NewCommand(GET, "a"),
+				NewCommand(SWAPDB, "1", "0"),
+				NewCommand(GET, "a"),
+				NewCommand(SWAPDB, "0", "0"),
+				NewCommand(SWAPDB, "0", "9"),
+				NewCommand(SWAPDB, "x", "1"),
+			},
+			expected: []interface{}{
+				statusOK, statusOK, nil, statusOK, "0", statusOK,
+				ErrorReply("(error) ERR DB index is out of range"), errNotInteger,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	kvdb.Execute(0, NewCommand(SET, "a", "0"))
+	kvdb.Execute(1, NewCommand(SET, "b", "1"))
+	if _, got := kvdb.Execute(1, NewCommand(FLUSHALL, "ASYNC")); got != statusOK {
+		t.Fatalf("FLUSHALL ASYNC = %v", got)
+	}
+	for db := 0; db < 2; db++ {
+		if _, got := kvdb.Execute(db, NewCommand(DBSIZE)); got != 0 {
+			t.Errorf("DBSIZE of database %d after FLUSHALL = %v", db, got)
+		}
+	}
+}
+
+func TestSwapDBIsSeenBySelectedConnections(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	// Reference data is loaded into database 1 while clients read 0.
+	kvdb.Execute(0, NewCommand(SET, "version", "1"))
+	kvdb.Execute(1, NewCommand(SET, "version", "2"))
+
+	reader := kvdb.NewSession(Conn{})
+	if got := kvdb.Run(reader, NewCommand(GET, "version")); got != "1" {
+		t.Fatalf("GET before SWAPDB = %v", got)
+	}
+	kvdb.Execute(1, NewCommand(SWAPDB, "0", "1"))
+	if got := kvdb.Run(reader, NewCommand(GET, "version")); got != "2" {
+		t.Errorf("GET after SWAPDB = %v, expected 2", got)
+	}
+}
+
+func TestSwapDBWakesBlockedReaders(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	kvdb.Execute(1, NewCommand(XADD, "s", "1-1", "a", "1"))
+	reader := kvdb.NewSession(Conn{})
+
+	done := make(chan interface{})
+	go func() {
+		result := kvdb.Run(reader, NewCommand(XREAD, "BLOCK", "0", "STREAMS", "s", "0"))
+		done <- result
+	}()
+
+	// Keep swapping until the reader has blocked and found the stream.
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case result := <-done:
+			items, ok := result.([]interface{})
+			if !ok || len(items) != 1 {
+				t.Fatalf("XREAD BLOCK returned %#v", result)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+			kvdb.Execute(0, NewCommand(SWAPDB, "0", "1"))
+		case <-timeout:
+			t.Fatal("XREAD BLOCK never returned")
+		}
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	kvdb.Execute(0, NewCommand(SET, "a", "1", "PX", "1"))
+	kvdb.Execute(1, NewCommand(SET, "b", "1", "PX", "1"))
+	kvdb.Execute(1, NewCommand(SET, "c", "1", "EX", "100"))
+	kvdb.Execute(1, NewCommand(SET, "d", "1"))
+	time.Sleep(5 * time.Millisecond)
+
+	if n := kvdb.DeleteExpired(); n != 2 {
+		t.Errorf("DeleteExpired() = %d, want 2", n)
+	}
+	if n := kvdb.DeleteExpired(); n != 0 {
+		t.Errorf("DeleteExpired() again = %d, want 0", n)
+	}
+	if _, got := kvdb.Execute(1, NewCommand(DBSIZE)); got != 2 {
+		t.Errorf("DBSIZE = %v, want 2", got)
+	}
+}
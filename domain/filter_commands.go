@@ -0,0 +1,482 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// dumpChunkSize bounds the filter data returned by one SCANDUMP call.
+	dumpChunkSize = 1 << 16
+	// maxFilterBytes bounds the memory of one sub-filter, whether it is
+	// reserved, added by expansion or described by a loaded header.
+	maxFilterBytes = 512 * 1024 * 1024
+
+	errFilterExists   = ErrorReply("(error) ERR item exists")
+	errFilterNotFound = ErrorReply("(error) ERR not found")
+)
+
+var (
+	errDumpInvalid    = errors.New("ERR received bad data")
+	errFilterTooLarge = errors.New("ERR filter would be larger than 512MB")
+)
+
+// dumpableFilter is implemented by the filter types that SCANDUMP and
+// LOADCHUNK move in pieces: a header describing the layout, then the raw
+// data of each sub-filter.
+type dumpableFilter interface {
+	dumpHeader() []byte
+	dumpChunks() [][]byte
+}
+
+// scanDump returns the chunk of f that starts at iter and the iterator of
+// the next one. Iterator 0 starts with the header; the next iterators are
+// one past the byte offset into the concatenated sub-filters. It returns 0
+// once everything has been dumped.
+func scanDump(f dumpableFilter, iter int64) (int64, []byte) {
+	if iter == 0 {
+		return 1, f.dumpHeader()
+	}
+	offset := iter - 1
+	for _, chunk := range f.dumpChunks() {
+		if offset < int64(len(chunk)) {
+			end := offset + dumpChunkSize
+			if end > int64(len(chunk)) {
+				end = int64(len(chunk))
+			}
+			data := chunk[offset:end]
+			return iter + int64(len(data)), data
+		}
+		offset -= int64(len(chunk))
+	}
+	return 0, nil
+}
+
+// loadChunk copies a chunk returned by scanDump back into f. iter is the
+// iterator scanDump returned along with data.
+func loadChunk(f dumpableFilter, iter int64, data []byte) error {
+	offset := iter - 1 - int64(len(data))
+	if offset < 0 {
+		return errDumpInvalid
+	}
+	for _, chunk := range f.dumpChunks() {
+		if offset < int64(len(chunk)) {
+			if offset+int64(len(data)) > int64(len(chunk)) {
+				return errDumpInvalid
+			}
+			copy(chunk[offset:], data)
+			return nil
+		}
+		offset -= int64(len(chunk))
+	}
+	return errDumpInvalid
+}
+
+func scanDumpReply(f dumpableFilter, iterArg string) interface{} {
+	iter, err := strconv.ParseInt(iterArg, 10, 64)
+	if err != nil || iter < 0 {
+		return errNotInteger
+	}
+	next, data := scanDump(f, iter)
+	return []interface{}{int(next), base64.StdEncoding.EncodeToString(data)}
+}
+
+// compactFilter returns the LOADCHUNK commands that recreate the filter f
+// at key.
+func compactFilter(name, key string, f dumpableFilter) []string {
+	var lines []string
+	for iter, data := scanDump(f, 0); iter != 0; iter, data = scanDump(f, iter) {
+		lines = append(lines, fmt.Sprintf("%s %s %d %s", name, compactArg(key), iter, base64.StdEncoding.EncodeToString(data)))
+	}
+	return lines
+}
+
+func (kvdb *KeyValueDB) lookupBloom(dbIndex int, key string) (*bloomFilter, bool) {
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	if v == nil {
+		return nil, true
+	}
+	bf, ok := v.(*bloomFilter)
+	return bf, ok
+}
+
+func (kvdb *KeyValueDB) lookupCuckoo(dbIndex int, key string) (*cuckooFilter, bool) {
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	if v == nil {
+		return nil, true
+	}
+	cf, ok := v.(*cuckooFilter)
+	return cf, ok
+}
+
+func (kvdb *KeyValueDB) bfReserve(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	errorRate, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return ErrorReply("(error) ERR bad error rate")
+	}
+	if !validErrorRate(errorRate) {
+		return ErrorReply("(error) ERR (0 < error rate range < 1)")
+	}
+	capacity, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return ErrorReply("(error) ERR bad capacity")
+	}
+	if capacity == 0 {
+		return ErrorReply("(error) ERR (capacity should be larger than 0)")
+	}
+	expansion := uint64(bloomDefaultExpansion)
+	nonScaling := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NONSCALING":
+			nonScaling = true
+		case "EXPANSION":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			i++
+			expansion, err = strconv.ParseUint(args[i], 10, 64)
+			if err != nil || expansion == 0 {
+				return ErrorReply("(error) ERR (expansion should be greater or equal to 1)")
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	if _, ok := bloomBits(capacity, errorRate); !ok {
+		return ErrorReply("(error) " + errFilterTooLarge.Error())
+	}
+
+	if kvdb.storage.Get(dbIndex, cmd.Argv[0]) != nil {
+		return errFilterExists
+	}
+	kvdb.storage.Set(dbIndex, cmd.Argv[0], newBloomFilter(errorRate, capacity, expansion, nonScaling))
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return statusOK
+}
+
+// bfAdd implements BF.ADD and BF.MADD.
+func (kvdb *KeyValueDB) bfAdd(dbIndex int, cmd Command, multi bool) interface{} {
+	bf, ok := kvdb.lookupBloom(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if bf == nil {
+		bf = newBloomFilter(bloomDefaultErrorRate, bloomDefaultCapacity, bloomDefaultExpansion, false)
+		kvdb.storage.Set(dbIndex, cmd.Argv[0], bf)
+	}
+
+	items := cmd.params()[1:]
+	reply := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		added, err := bf.add([]byte(item))
+		switch {
+		case err != nil:
+			reply = append(reply, ErrorReply("(error) "+err.Error()))
+		case added:
+			reply = append(reply, 1)
+		default:
+			reply = append(reply, 0)
+		}
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	if !multi {
+		return reply[0]
+	}
+	return reply
+}
+
+// bfExists implements BF.EXISTS and BF.MEXISTS.
+func (kvdb *KeyValueDB) bfExists(dbIndex int, cmd Command, multi bool) interface{} {
+	bf, ok := kvdb.lookupBloom(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	items := cmd.params()[1:]
+	reply := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if bf != nil && bf.exists([]byte(item)) {
+			reply = append(reply, 1)
+		} else {
+			reply = append(reply, 0)
+		}
+	}
+	if !multi {
+		return reply[0]
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) bfScanDump(dbIndex int, cmd Command) interface{} {
+	bf, ok := kvdb.lookupBloom(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if bf == nil {
+		return errFilterNotFound
+	}
+	return scanDumpReply(bf, cmd.params()[1])
+}
+
+func (kvdb *KeyValueDB) bfLoadChunk(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	iter, data, errMsg := parseLoadChunk(args[1], args[2])
+	if errMsg != "" {
+		return errMsg
+	}
+	bf, ok := kvdb.lookupBloom(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if iter == 1 {
+		bf, err := loadBloomHeader(data)
+		if err != nil {
+			return ErrorReply("(error) " + err.Error())
+		}
+		kvdb.storage.Set(dbIndex, cmd.Argv[0], bf)
+		kvdb.signalKey(dbIndex, cmd.arg(0))
+		return statusOK
+	}
+	if bf == nil {
+		return errFilterNotFound
+	}
+	if err := loadChunk(bf, iter, data); err != nil {
+		return ErrorReply("(error) " + err.Error())
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return statusOK
+}
+
+func parseLoadChunk(iterArg, dataArg string) (int64, []byte, ErrorReply) {
+	iter, err := strconv.ParseInt(iterArg, 10, 64)
+	if err != nil || iter <= 0 {
+		return 0, nil, errNotInteger
+	}
+	data, err := base64.StdEncoding.DecodeString(dataArg)
+	if err != nil {
+		return 0, nil, ErrorReply("(error) " + errDumpInvalid.Error())
+	}
+	return iter, data, ""
+}
+
+func (kvdb *KeyValueDB) cfReserve(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	capacity, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil || capacity == 0 {
+		return ErrorReply("(error) ERR Bad capacity")
+	}
+	bucketSize := uint64(cuckooDefaultBucketSize)
+	maxIterations := uint64(cuckooDefaultMaxIterations)
+	expansion := uint64(cuckooDefaultExpansion)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errSyntax
+		}
+		n, err := strconv.ParseUint(args[i+1], 10, 64)
+		switch strings.ToUpper(args[i]) {
+		case "BUCKETSIZE":
+			if err != nil || n == 0 || n > 255 {
+				return ErrorReply("(error) ERR Bad bucket size")
+			}
+			bucketSize = n
+		case "MAXITERATIONS":
+			if err != nil || n == 0 || n > 65535 {
+				return ErrorReply("(error) ERR Bad max iterations")
+			}
+			maxIterations = n
+		case "EXPANSION":
+			if err != nil || n > 32768 {
+				return ErrorReply("(error) ERR Bad expansion")
+			}
+			expansion = n
+		default:
+			return errSyntax
+		}
+	}
+
+	if _, ok := cuckooBuckets(capacity, bucketSize); !ok {
+		return ErrorReply("(error) " + errFilterTooLarge.Error())
+	}
+
+	if kvdb.storage.Get(dbIndex, cmd.Argv[0]) != nil {
+		return errFilterExists
+	}
+	kvdb.storage.Set(dbIndex, cmd.Argv[0], newCuckooFilter(capacity, bucketSize, maxIterations, expansion))
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return statusOK
+}
+
+// cfAdd implements CF.ADD and CF.ADDNX.
+func (kvdb *KeyValueDB) cfAdd(dbIndex int, cmd Command, nx bool) interface{} {
+	cf, ok := kvdb.lookupCuckoo(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if cf == nil {
+		cf = newCuckooFilter(cuckooDefaultCapacity, cuckooDefaultBucketSize, cuckooDefaultMaxIterations, cuckooDefaultExpansion)
+		kvdb.storage.Set(dbIndex, cmd.Argv[0], cf)
+	}
+	item := []byte(cmd.params()[1])
+	if nx && cf.exists(item) {
+		return 0
+	}
+	if err := cf.add(item); err != nil {
+		return ErrorReply("(error) " + err.Error())
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return 1
+}
+
+// cfInsert implements CF.INSERT and CF.INSERTNX. Items that do not fit are
+// reported as -1.
+func (kvdb *KeyValueDB) cfInsert(dbIndex int, cmd Command, nx bool) interface{} {
+	args := cmd.params()
+	capacity := uint64(cuckooDefaultCapacity)
+	noCreate := false
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "ITEMS" {
+			break
+		}
+		switch {
+		case opt == "CAPACITY" && i+1 < len(args):
+			n, err := strconv.ParseUint(args[i+1], 10, 64)
+			if err != nil || n == 0 {
+				return ErrorReply("(error) ERR Bad capacity")
+			}
+			if _, ok := cuckooBuckets(n, cuckooDefaultBucketSize); !ok {
+				return ErrorReply("(error) " + errFilterTooLarge.Error())
+			}
+			capacity = n
+			i++
+		case opt == "NOCREATE":
+			noCreate = true
+		default:
+			return errSyntax
+		}
+	}
+	if i >= len(args)-1 {
+		return ErrorReply(fmt.Sprintf("(error) ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Name)))
+	}
+
+	cf, ok := kvdb.lookupCuckoo(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if cf == nil {
+		if noCreate {
+			return errFilterNotFound
+		}
+		cf = newCuckooFilter(capacity, cuckooDefaultBucketSize, cuckooDefaultMaxIterations, cuckooDefaultExpansion)
+		kvdb.storage.Set(dbIndex, cmd.Argv[0], cf)
+	}
+
+	items := args[i+1:]
+	reply := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if nx && cf.exists([]byte(item)) {
+			reply = append(reply, 0)
+			continue
+		}
+		if err := cf.add([]byte(item)); err != nil {
+			reply = append(reply, -1)
+			continue
+		}
+		reply = append(reply, 1)
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return reply
+}
+
+// cfExists implements CF.EXISTS and CF.MEXISTS.
+func (kvdb *KeyValueDB) cfExists(dbIndex int, cmd Command, multi bool) interface{} {
+	cf, ok := kvdb.lookupCuckoo(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	items := cmd.params()[1:]
+	reply := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if cf != nil && cf.exists([]byte(item)) {
+			reply = append(reply, 1)
+		} else {
+			reply = append(reply, 0)
+		}
+	}
+	if !multi {
+		return reply[0]
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) cfDel(dbIndex int, cmd Command) interface{} {
+	cf, ok := kvdb.lookupCuckoo(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if cf == nil {
+		return ErrorReply("(error) ERR Not found")
+	}
+	if !cf.remove([]byte(cmd.params()[1])) {
+		return 0
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return 1
+}
+
+func (kvdb *KeyValueDB) cfCount(dbIndex int, cmd Command) interface{} {
+	cf, ok := kvdb.lookupCuckoo(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if cf == nil {
+		return 0
+	}
+	return int(cf.count([]byte(cmd.params()[1])))
+}
+
+func (kvdb *KeyValueDB) cfScanDump(dbIndex int, cmd Command) interface{} {
+	cf, ok := kvdb.lookupCuckoo(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if cf == nil {
+		return errFilterNotFound
+	}
+	return scanDumpReply(cf, cmd.params()[1])
+}
+
+func (kvdb *KeyValueDB) cfLoadChunk(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	iter, data, errMsg := parseLoadChunk(args[1], args[2])
+	if errMsg != "" {
+		return errMsg
+	}
+	cf, ok := kvdb.lookupCuckoo(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if iter == 1 {
+		cf, err := loadCuckooHeader(data)
+		if err != nil {
+			return ErrorReply("(error) " + err.Error())
+		}
+		kvdb.storage.Set(dbIndex, cmd.Argv[0], cf)
+		kvdb.signalKey(dbIndex, cmd.arg(0))
+		return statusOK
+	}
+	if cf == nil {
+		return errFilterNotFound
+	}
+	if err := loadChunk(cf, iter, data); err != nil {
+		return ErrorReply("(error) " + err.Error())
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return statusOK
+}
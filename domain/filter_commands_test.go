@@ -0,0 +1,205 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFilterCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "BF.ADD and BF.EXISTS",
+			commands: []Command{
+				NewCommand(BF_ADD, "bf", "a"),
+				NewCommand(BF_ADD, "bf", "a"),
+				NewCommand(BF_MADD, "bf", "b", "a", "c"),
+				NewCommand(BF_EXISTS, "bf", "b"),
+				NewCommand(BF_MEXISTS, "bf", "a", "c", "zzz"),
+				NewCommand(BF_EXISTS, "missing", "a"),
+			},
+			expected: []interface{}{
+				1, 0,
+				[]interface{}{1, 0, 1},
+				1,
+				[]interface{}{1, 1, 0},
+				0,
+			},
+		},
+		{
+			name: "BF.RESERVE",
+			commands: []Command{
+				NewCommand(BF_RESERVE, "bf", "0.01", "2", "NONSCALING"),
+				NewCommand(BF_RESERVE, "bf", "0.01", "2"),
+				NewCommand(BF_MADD, "bf", "a", "b", "c"),
+				NewCommand(BF_RESERVE, "x", "1", "100"),
+				NewCommand(BF_RESERVE, "x", "nan", "3"),
+				NewCommand(BF_RESERVE, "x", "inf", "3"),
+				NewCommand(BF_RESERVE, "x", "-inf", "3"),
+				NewCommand(BF_RESERVE, "x", "0.1", "0"),
+				NewCommand(BF_RESERVE, "x", "0.1", "10", "EXPANSION", "0"),
+				NewCommand(BF_RESERVE, "x", "0.1", "10", "BOGUS"),
+				NewCommand(BF_RESERVE, "x", "0.999", "9223372036854775807"),
+				NewCommand(BF_RESERVE, "x", "0.01", "20000000000"),
+				NewCommand(EXISTS, "x"),
+			},
+			expected: []interface{}{
+				statusOK,
+				errFilterExists,
+				[]interface{}{1, 1, ErrorReply("(error) ERR non scaling filter is full")},
+				ErrorReply("(error) ERR (0 < error rate range < 1)"),
+				ErrorReply("(error) ERR (0 < error rate range < 1)"),
+				ErrorReply("(error) ERR (0 < error rate range < 1)"),
+				ErrorReply("(error) ERR (0 < error rate range < 1)"),
+				ErrorReply("(error) ERR (capacity should be larger than 0)"),
+				ErrorReply("(error) ERR (expansion should be greater or equal to 1)"),
+				errSyntax,
+				ErrorReply("(error) ERR filter would be larger than 512MB"),
+				ErrorReply("(error) ERR filter would be larger than 512MB"),
+				0,
+			},
+		},
+		{
+			name: "CF.ADD, CF.DEL and CF.COUNT",
+			commands: []Command{
+				NewCommand(CF_ADD, "cf", "a"),
+				NewCommand(CF_ADD, "cf", "a"),
+				NewCommand(CF_ADDNX, "cf", "a"),
+				NewCommand(CF_COUNT, "cf", "a"),
+				NewCommand(CF_DEL, "cf", "a"),
+				NewCommand(CF_EXISTS, "cf", "a"),
+				NewCommand(CF_DEL, "cf", "a"),
+				NewCommand(CF_DEL, "cf", "a"),
+				NewCommand(CF_EXISTS, "cf", "a"),
+				NewCommand(CF_DEL, "missing", "a"),
+			},
+			expected: []interface{}{1, 1, 0, 2, 1, 1, 1, 0, 0, ErrorReply("(error) ERR Not found")},
+		},
+		{
+			name: "CF.RESERVE, CF.INSERT and CF.MEXISTS",
+			commands: []Command{
+				NewCommand(CF_RESERVE, "cf", "2", "BUCKETSIZE", "1", "MAXITERATIONS", "5", "EXPANSION", "0"),
+				NewCommand(CF_RESERVE, "cf", "100"),
+				NewCommand(CF_INSERTNX, "cf", "ITEMS", "a", "a"),
+				NewCommand(CF_MEXISTS, "cf", "a", "b"),
+				NewCommand(CF_INSERT, "other", "NOCREATE", "ITEMS", "a"),
+				NewCommand(CF_INSERT, "other", "CAPACITY", "10", "ITEMS", "a", "b"),
+				NewCommand(CF_INSERT, "other", "ITEMS"),
+				NewCommand(CF_RESERVE, "x", "10", "BUCKETSIZE", "0"),
+				NewCommand(CF_RESERVE, "x", "9223372036854775809", "BUCKETSIZE", "1"),
+				NewCommand(CF_RESERVE, "x", "9223372036854775807"),
+				NewCommand(CF_INSERT, "x", "CAPACITY", "18446744073709551615", "ITEMS", "a"),
+				NewCommand(EXISTS, "x"),
+			},
+			expected: []interface{}{
+				statusOK,
+				errFilterExists,
+				[]interface{}{1, 0},
+				[]interface{}{1, 0},
+				errFilterNotFound,
+				[]interface{}{1, 1},
+				ErrorReply("(error) ERR wrong number of arguments for 'cf.insert' command"),
+				ErrorReply("(error) ERR Bad bucket size"),
+				ErrorReply("(error) ERR filter would be larger than 512MB"),
+				ErrorReply("(error) ERR filter would be larger than 512MB"),
+				ErrorReply("(error) ERR filter would be larger than 512MB"),
+				0,
+			},
+		},
+		{
+			name: "filter commands on other values",
+			commands: []Command{
+				NewCommand(SET, "s", "v"),
+				NewCommand(BF_ADD, "s", "a"),
+				NewCommand(CF_EXISTS, "s", "a"),
+				NewCommand(BF_ADD, "bf", "a"),
+				NewCommand(CF_ADD, "bf", "a"),
+				NewCommand(GET, "bf"),
+				NewCommand(BF_SCANDUMP, "missing", "0"),
+			},
+			expected: []interface{}{statusOK, errWrongType, errWrongType, 1, errWrongType, errWrongType, errFilterNotFound},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
+
+// replay executes COMPACT output lines on kvdb.
+func replay(t *testing.T, kvdb *KeyValueDB, lines interface{}) {
+	t.Helper()
+	for _, line := range lines.([]interface{}) {
+		words := strings.Split(line.(string), " ")
+		args := make([]interface{}, 0, len(words)-1)
+		for _, w := range words[1:] {
+			args = append(args, w)
+		}
+		if _, res := kvdb.Execute(0, NewCommand(words[0], args...)); res != statusOK {
+			t.Fatalf("replaying %q returned %v", line, res)
+		}
+	}
+}
+
+func TestFilterScanDumpRoundTrip(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(BF_RESERVE, "bf", "0.001", "100000"))
+	kvdb.Execute(0, NewCommand(CF_RESERVE, "cf", "1000"))
+	for i := 0; i < 300; i++ {
+		kvdb.Execute(0, NewCommand(BF_ADD, "bf", strconv.Itoa(i)))
+		kvdb.Execute(0, NewCommand(CF_ADD, "cf", strconv.Itoa(i)))
+	}
+
+	// Dump the Bloom filter with SCANDUMP and load it under a new key.
+	for iter := "0"; ; {
+		_, res := kvdb.Execute(0, NewCommand(BF_SCANDUMP, "bf", iter))
+		reply := res.([]interface{})
+		if reply[0] == 0 {
+			break
+		}
+		iter = strconv.Itoa(reply[0].(int))
+		if _, res := kvdb.Execute(0, NewCommand(BF_LOADCHUNK, "copy", iter, reply[1])); res != statusOK {
+			t.Fatalf("BF.LOADCHUNK returned %v", res)
+		}
+	}
+
+	// And the whole database with COMPACT.
+	_, lines := kvdb.Execute(0, NewCommand(COMPACT))
+	restored := NewKeyValueDB(storage.NewInMemory("1"))
+	replay(t, restored, lines)
+
+	for i := 0; i < 300; i++ {
+		item := strconv.Itoa(i)
+		for _, check := range []struct {
+			db  *KeyValueDB
+			cmd Command
+		}{
+			{kvdb, NewCommand(BF_EXISTS, "copy", item)},
+			{restored, NewCommand(BF_EXISTS, "bf", item)},
+			{restored, NewCommand(BF_EXISTS, "copy", item)},
+			{restored, NewCommand(CF_EXISTS, "cf", item)},
+		} {
+			if _, got := check.db.Execute(0, check.cmd); got != 1 {
+				t.Fatalf("command %v returned %v after restoring", check.cmd, got)
+			}
+		}
+	}
+	if _, got := restored.Execute(0, NewCommand(CF_DEL, "cf", "7")); got != 1 {
+		t.Errorf("CF.DEL after restoring returned %v", got)
+	}
+}
@@ -0,0 +1,534 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	errGeoUnit         = ErrorReply("(error) ERR unsupported unit provided. please use M, KM, FT, MI")
+	errNotFloat        = ErrorReply("(error) ERR value is not a valid float")
+	errGeoMemberDecode = ErrorReply("(error) ERR could not decode requested zset member")
+)
+
+// geoUnits maps each distance unit to its length in meters.
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.34,
+	"ft": 0.3048,
+}
+
+func parseGeoUnit(arg string) (float64, bool) {
+	unit, ok := geoUnits[strings.ToLower(arg)]
+	return unit, ok
+}
+
+// formatGeoCoord formats a coordinate with up to 17 decimals, dropping
+// trailing zeros.
+func formatGeoCoord(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func formatGeoDistance(meters, unit float64) string {
+	return fmt.Sprintf("%.4f", meters/unit)
+}
+
+// lookupSortedSet returns the sorted set stored at key, or nil when the key
+// does not exist. It reports false when the key holds another type.
+func (kvdb *KeyValueDB) lookupSortedSet(dbIndex int, key string) (*sortedSet, bool) {
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	if v == nil {
+		return nil, true
+	}
+	z, ok := v.(*sortedSet)
+	return z, ok
+}
+
+func (kvdb *KeyValueDB) geoadd(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	nx, xx, ch := false, false, false
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+			ch = true
+		default:
+			break options
+		}
+	}
+	if nx && xx {
+		return ErrorReply("(error) ERR XX and NX options at the same time are not compatible")
+	}
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return ErrorReply("(error) ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... ")
+	}
+
+	type geoMember struct {
+		member string
+		score  float64
+	}
+	members := make([]geoMember, 0, len(triples)/3)
+	for j := 0; j < len(triples); j += 3 {
+		longitude, err := strconv.ParseFloat(triples[j], 64)
+		if err != nil {
+			return errNotFloat
+		}
+		latitude, err := strconv.ParseFloat(triples[j+1], 64)
+		if err != nil {
+			return errNotFloat
+		}
+		if !validGeoPoint(longitude, latitude) {
+			return ErrorReply(fmt.Sprintf("(error) ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+		}
+		hash := geohashEncodeWGS84(longitude, latitude, geoStepMax)
+		members = append(members, geoMember{triples[j+2], float64(geohashAlign52Bits(hash))})
+	}
+
+	z, ok := kvdb.lookupSortedSet(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if z == nil {
+		if xx {
+			return 0
+		}
+		z = newSortedSet()
+		kvdb.storage.Set(dbIndex, cmd.Argv[0], z)
+	}
+
+	count := 0
+	for _, m := range members {
+		_, exists := z.score(m.member)
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		added, changed := z.add(m.member, m.score)
+		if added || (ch && changed) {
+			count++
+		}
+	}
+	if z.length() == 0 {
+		kvdb.storage.Del(dbIndex, cmd.Argv[0])
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return count
+}
+
+func (kvdb *KeyValueDB) geopos(dbIndex int, cmd Command) interface{} {
+	z, ok := kvdb.lookupSortedSet(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	members := cmd.params()[1:]
+	reply := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		var score float64
+		found := false
+		if z != nil {
+			score, found = z.score(member)
+		}
+		if !found {
+			reply = append(reply, nil)
+			continue
+		}
+		longitude, latitude := geohashToPoint(score)
+		reply = append(reply, []interface{}{formatGeoCoord(longitude), formatGeoCoord(latitude)})
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) geohash(dbIndex int, cmd Command) interface{} {
+	z, ok := kvdb.lookupSortedSet(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	members := cmd.params()[1:]
+	reply := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		var score float64
+		found := false
+		if z != nil {
+			score, found = z.score(member)
+		}
+		if !found {
+			reply = append(reply, nil)
+			continue
+		}
+		reply = append(reply, geohashString(geohashToPoint(score)))
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) geodist(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	unit := 1.0
+	if len(args) == 4 {
+		var ok bool
+		if unit, ok = parseGeoUnit(args[3]); !ok {
+			return errGeoUnit
+		}
+	}
+
+	z, ok := kvdb.lookupSortedSet(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if z == nil {
+		return nil
+	}
+	score1, ok1 := z.score(args[1])
+	score2, ok2 := z.score(args[2])
+	if !ok1 || !ok2 {
+		return nil
+	}
+	lon1, lat1 := geohashToPoint(score1)
+	lon2, lat2 := geohashToPoint(score2)
+	return formatGeoDistance(geoDistance(lon1, lat1, lon2, lat2), unit)
+}
+
+// Flavours of the geo search commands, which share one implementation.
+const (
+	geoRadius = iota
+	geoRadiusByMember
+	geoSearch
+	geoSearchStore
+)
+
+type geoSearchOptions struct {
+	shape      geoShape
+	fromMember string
+	hasCenter  bool
+	hasMember  bool
+	hasShape   bool
+	unit       float64
+	sortOrder  int
+	count      int
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+	storeKey   string
+}
+
+// parseGeoSearch parses the arguments that follow the source key.
+func parseGeoSearch(args []string, flavour int) (geoSearchOptions, ErrorReply) {
+	opts := geoSearchOptions{unit: 1}
+
+	parseUnit := func(arg string) ErrorReply {
+		unit, ok := parseGeoUnit(arg)
+		if !ok {
+			return errGeoUnit
+		}
+		opts.unit = unit
+		return ""
+	}
+	parseDistance := func(arg string) (float64, ErrorReply) {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return 0, ErrorReply("(error) ERR need numeric radius")
+		}
+		if v < 0 {
+			return 0, ErrorReply("(error) ERR radius cannot be negative")
+		}
+		return v, ""
+	}
+
+	// GEORADIUS and GEORADIUSBYMEMBER start with a fixed centre and radius.
+	switch flavour {
+	case geoRadius, geoRadiusByMember:
+		n := 3
+		if flavour == geoRadius {
+			n = 4
+		}
+		if len(args) < n {
+			return opts, errSyntax
+		}
+		if flavour == geoRadius {
+			longitude, err1 := strconv.ParseFloat(args[0], 64)
+			latitude, err2 := strconv.ParseFloat(args[1], 64)
+			if err1 != nil || err2 != nil {
+				return opts, errNotFloat
+			}
+			if !validGeoPoint(longitude, latitude) {
+				return opts, ErrorReply(fmt.Sprintf("(error) ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+			}
+			opts.shape.longitude, opts.shape.latitude = longitude, latitude
+			opts.hasCenter = true
+		} else {
+			opts.fromMember = args[0]
+			opts.hasMember = true
+		}
+		radius, errMsg := parseDistance(args[n-2])
+		if errMsg != "" {
+			return opts, errMsg
+		}
+		if errMsg := parseUnit(args[n-1]); errMsg != "" {
+			return opts, errMsg
+		}
+		opts.shape.radius = radius * opts.unit
+		opts.hasShape = true
+		args = args[n:]
+	}
+
+	isSearch := flavour == geoSearch || flavour == geoSearchStore
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		left := len(args) - i - 1
+		switch {
+		case isSearch && opt == "FROMMEMBER" && left >= 1:
+			if opts.hasCenter || opts.hasMember {
+				return opts, ErrorReply("(error) ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+			opts.fromMember = args[i+1]
+			opts.hasMember = true
+			i++
+		case isSearch && opt == "FROMLONLAT" && left >= 2:
+			if opts.hasCenter || opts.hasMember {
+				return opts, ErrorReply("(error) ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+			longitude, err1 := strconv.ParseFloat(args[i+1], 64)
+			latitude, err2 := strconv.ParseFloat(args[i+2], 64)
+			if err1 != nil || err2 != nil {
+				return opts, errNotFloat
+			}
+			if !validGeoPoint(longitude, latitude) {
+				return opts, ErrorReply(fmt.Sprintf("(error) ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+			}
+			opts.shape.longitude, opts.shape.latitude = longitude, latitude
+			opts.hasCenter = true
+			i += 2
+		case isSearch && opt == "BYRADIUS" && left >= 2:
+			if opts.hasShape {
+				return opts, ErrorReply("(error) ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+			radius, errMsg := parseDistance(args[i+1])
+			if errMsg != "" {
+				return opts, errMsg
+			}
+			if errMsg := parseUnit(args[i+2]); errMsg != "" {
+				return opts, errMsg
+			}
+			opts.shape.radius = radius * opts.unit
+			opts.hasShape = true
+			i += 2
+		case isSearch && opt == "BYBOX" && left >= 3:
+			if opts.hasShape {
+				return opts, ErrorReply("(error) ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+			width, err1 := strconv.ParseFloat(args[i+1], 64)
+			height, err2 := strconv.ParseFloat(args[i+2], 64)
+			if err1 != nil || err2 != nil {
+				return opts, ErrorReply("(error) ERR need numeric width and height")
+			}
+			if width < 0 || height < 0 {
+				return opts, ErrorReply("(error) ERR height or width cannot be negative")
+			}
+			if errMsg := parseUnit(args[i+3]); errMsg != "" {
+				return opts, errMsg
+			}
+			opts.shape.width, opts.shape.height = width*opts.unit, height*opts.unit
+			opts.shape.isBox = true
+			opts.hasShape = true
+			i += 3
+		case opt == "ASC":
+			opts.sortOrder = 1
+		case opt == "DESC":
+			opts.sortOrder = -1
+		case opt == "COUNT" && left >= 1:
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if n <= 0 {
+				return opts, ErrorReply("(error) ERR COUNT must be > 0")
+			}
+			opts.count = n
+			i++
+			if i+1 < len(args) && strings.ToUpper(args[i+1]) == "ANY" {
+				opts.any = true
+				i++
+			}
+		case opt == "WITHCOORD" && flavour != geoSearchStore:
+			opts.withCoord = true
+		case opt == "WITHDIST" && flavour != geoSearchStore:
+			opts.withDist = true
+		case opt == "WITHHASH" && flavour != geoSearchStore:
+			opts.withHash = true
+		case opt == "STORE" && !isSearch && left >= 1:
+			opts.storeKey = args[i+1]
+			i++
+		default:
+			return opts, errSyntax
+		}
+	}
+
+	if isSearch {
+		if !opts.hasCenter && !opts.hasMember {
+			return opts, ErrorReply("(error) ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+		}
+		if !opts.hasShape {
+			return opts, ErrorReply("(error) ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+		}
+	}
+	if opts.storeKey != "" && (opts.withCoord || opts.withDist || opts.withHash) {
+		return opts, ErrorReply("(error) ERR STORE option in GEORADIUS is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	}
+	if opts.any && opts.count == 0 {
+		return opts, ErrorReply("(error) ERR the ANY argument requires COUNT argument")
+	}
+	// Returning the closest matches needs them sorted.
+	if opts.count > 0 && !opts.any && opts.sortOrder == 0 {
+		opts.sortOrder = 1
+	}
+	return opts, ""
+}
+
+type geoMatch struct {
+	member    string
+	score     float64
+	longitude float64
+	latitude  float64
+	distance  float64
+}
+
+// searchGeo returns the members within the shape, stopping early once limit
+// matches are found when limit is positive.
+func searchGeo(z *sortedSet, shape geoShape, limit int) []geoMatch {
+	var matches []geoMatch
+	for _, area := range shape.searchAreas() {
+		min := float64(geohashAlign52Bits(area))
+		max := float64(geohashAlign52Bits(geoHash{bits: area.bits + 1, step: area.step}))
+		z.rangeByScore(min, max, func(member string, score float64) bool {
+			longitude, latitude := geohashToPoint(score)
+			if distance, ok := shape.contains(longitude, latitude); ok {
+				matches = append(matches, geoMatch{member, score, longitude, latitude, distance})
+			}
+			return limit <= 0 || len(matches) < limit
+		})
+		if limit > 0 && len(matches) >= limit {
+			break
+		}
+	}
+	return matches
+}
+
+// georadius implements GEORADIUS, GEORADIUSBYMEMBER, GEOSEARCH and
+// GEOSEARCHSTORE.
+// geoRadiusKeys returns a function that finds the source key of GEORADIUS
+// or GEORADIUSBYMEMBER and the key named by its STORE option.
+func geoRadiusKeys(flavour int) func(args []string) []string {
+	return func(args []string) []string {
+		keys := args[:1]
+		if opts, errMsg := parseGeoSearch(args[1:], flavour); errMsg == "" && opts.storeKey != "" {
+			keys = append(keys, opts.storeKey)
+		}
+		return keys
+	}
+}
+
+func (kvdb *KeyValueDB) georadius(dbIndex int, cmd Command, flavour int) interface{} {
+	args := cmd.params()
+	srcKey, rest := args[0], args[1:]
+	if flavour == geoSearchStore {
+		srcKey, rest = args[1], args[2:]
+	}
+	opts, errMsg := parseGeoSearch(rest, flavour)
+	if errMsg != "" {
+		return errMsg
+	}
+	if flavour == geoSearchStore {
+		opts.storeKey = args[0]
+	}
+
+	z, ok := kvdb.lookupSortedSet(dbIndex, srcKey)
+	if !ok {
+		return errWrongType
+	}
+	var matches []geoMatch
+	if z != nil {
+		if opts.hasMember {
+			score, found := z.score(opts.fromMember)
+			if !found {
+				return errGeoMemberDecode
+			}
+			opts.shape.longitude, opts.shape.latitude = geohashToPoint(score)
+		}
+		limit := 0
+		if opts.any {
+			limit = opts.count
+		}
+		matches = searchGeo(z, opts.shape, limit)
+	}
+
+	if opts.sortOrder != 0 {
+		sort.SliceStable(matches, func(i, j int) bool {
+			if opts.sortOrder > 0 {
+				return matches[i].distance < matches[j].distance
+			}
+			return matches[i].distance > matches[j].distance
+		})
+	}
+	if opts.count > 0 && len(matches) > opts.count {
+		matches = matches[:opts.count]
+	}
+
+	if opts.storeKey != "" {
+		if len(matches) == 0 {
+			kvdb.storage.Del(dbIndex, []byte(opts.storeKey))
+			kvdb.signalKey(dbIndex, opts.storeKey)
+			return 0
+		}
+		dest := newSortedSet()
+		// Only geohash scores are kept, so the destination is a geo index
+		// like its source.
+		for _, m := range matches {
+			dest.add(m.member, m.score)
+		}
+		kvdb.replaceValue(dbIndex, opts.storeKey, dest)
+		return len(matches)
+	}
+
+	reply := make([]interface{}, 0, len(matches))
+	for _, m := range matches {
+		if !opts.withCoord && !opts.withDist && !opts.withHash {
+			reply = append(reply, m.member)
+			continue
+		}
+		item := []interface{}{m.member}
+		if opts.withDist {
+			item = append(item, formatGeoDistance(m.distance, opts.unit))
+		}
+		if opts.withHash {
+			item = append(item, int64(m.score))
+		}
+		if opts.withCoord {
+			item = append(item, []interface{}{formatGeoCoord(m.longitude), formatGeoCoord(m.latitude)})
+		}
+		reply = append(reply, item)
+	}
+	return reply
+}
+
+// compactSortedSet returns the commands that recreate the geo index z at
+// key.
+func compactSortedSet(key string, z *sortedSet) []string {
+	var lines []string
+	z.each(func(member string, score float64) bool {
+		longitude, latitude := geohashToPoint(score)
+		lines = append(lines, fmt.Sprintf("GEOADD %s %s %s %s", compactArg(key),
+			formatGeoCoord(longitude), formatGeoCoord(latitude), compactArg(member)))
+		return true
+	})
+	return lines
+}
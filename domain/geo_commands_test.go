@@ -0,0 +1,167 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"testing"
+)
+
+func TestGeoCommands(t *testing.T) {
+	sicily := NewCommand(GEOADD, "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "GEOADD, GEODIST, GEOPOS and GEOHASH",
+			commands: []Command{
+				sicily,
+				NewCommand(GEODIST, "Sicily", "Palermo", "Catania"),
+				NewCommand(GEODIST, "Sicily", "Palermo", "Catania", "km"),
+				NewCommand(GEODIST, "Sicily", "Palermo", "Nowhere"),
+				NewCommand(GEOPOS, "Sicily", "Palermo", "Nowhere"),
+				NewCommand(GEOHASH, "Sicily", "Palermo", "Catania"),
+			},
+			expected: []interface{}{
+				2,
+				"166274.1516",
+				"166.2742",
+				nil,
+				[]interface{}{[]interface{}{"13.36138933897018433", "38.11555639549629859"}, nil},
+				[]interface{}{"sqc8b49rny0", "sqdtr74hyu0"},
+			},
+		},
+		{
+			name: "GEOADD with NX, XX and CH",
+			commands: []Command{
+				sicily,
+				NewCommand(GEOADD, "Sicily", "NX", "13", "38", "Palermo", "14", "38", "Messina"),
+				NewCommand(GEOADD, "Sicily", "XX", "CH", "13", "38", "Palermo", "12", "38", "Marsala"),
+				NewCommand(GEOADD, "Sicily", "NX", "XX", "13", "38", "Palermo"),
+				NewCommand(GEOADD, "Sicily", "200", "38", "Nowhere"),
+			},
+			expected: []interface{}{
+				2, 1, 1,
+				ErrorReply("(error) ERR XX and NX options at the same time are not compatible"),
+				ErrorReply("(error) ERR invalid longitude,latitude pair 200.000000,38.000000"),
+			},
+		},
+		{
+			name: "GEOSEARCH by radius and box",
+			commands: []Command{
+				sicily,
+				NewCommand(GEOSEARCH, "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"),
+				NewCommand(GEOSEARCH, "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km"),
+				NewCommand(GEOSEARCH, "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "DESC", "WITHDIST", "WITHCOORD"),
+				NewCommand(GEOSEARCH, "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "200", "km", "COUNT", "1", "WITHDIST"),
+			},
+			expected: []interface{}{
+				2,
+				[]interface{}{"Catania", "Palermo"},
+				[]interface{}{"Catania"},
+				[]interface{}{
+					[]interface{}{"Palermo", "190.4424", []interface{}{"13.36138933897018433", "38.11555639549629859"}},
+					[]interface{}{"Catania", "56.4413", []interface{}{"15.08726745843887329", "37.50266842333162032"}},
+				},
+				[]interface{}{[]interface{}{"Palermo", "0.0000"}},
+			},
+		},
+		{
+			name: "GEORADIUS and GEORADIUSBYMEMBER",
+			commands: []Command{
+				sicily,
+				NewCommand(GEORADIUS, "Sicily", "15", "37", "200", "km", "WITHDIST", "ASC"),
+				NewCommand(GEORADIUSBYMEMBER, "Sicily", "Catania", "100", "mi"),
+				NewCommand(GEORADIUS, "Sicily", "15", "37", "200", "parsecs"),
+			},
+			expected: []interface{}{
+				2,
+				[]interface{}{[]interface{}{"Catania", "56.4413"}, []interface{}{"Palermo", "190.4424"}},
+				[]interface{}{"Catania"},
+				errGeoUnit,
+			},
+		},
+		{
+			name: "GEOSEARCHSTORE and STORE",
+			commands: []Command{
+				sicily,
+				NewCommand(GEOSEARCHSTORE, "near", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km"),
+				NewCommand(GEOPOS, "near", "Catania", "Palermo"),
+				NewCommand(GEORADIUS, "Sicily", "15", "37", "200", "km", "STORE", "all"),
+				NewCommand(GEOSEARCHSTORE, "near", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"),
+				NewCommand(GET, "near"),
+			},
+			expected: []interface{}{
+				2, 1,
+				[]interface{}{[]interface{}{"15.08726745843887329", "37.50266842333162032"}, nil},
+				2, 0, nil,
+			},
+		},
+		{
+			name: "GEOSEARCH argument errors",
+			commands: []Command{
+				sicily,
+				NewCommand(GEOSEARCH, "Sicily", "BYRADIUS", "10", "km", "ASC", "WITHDIST"),
+				NewCommand(GEOSEARCH, "Sicily", "FROMMEMBER", "Nowhere", "BYRADIUS", "10", "km"),
+				NewCommand(GEOSEARCH, "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "10", "km", "ANY"),
+				NewCommand(GEORADIUS, "Sicily", "15", "37", "200", "km", "WITHDIST", "STORE", "dest"),
+			},
+			expected: []interface{}{
+				2,
+				ErrorReply("(error) ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"),
+				errGeoMemberDecode,
+				errSyntax,
+				ErrorReply("(error) ERR STORE option in GEORADIUS is not compatible with WITHDIST, WITHHASH and WITHCOORD options"),
+			},
+		},
+		{
+			name: "geo commands on other values",
+			commands: []Command{
+				NewCommand(SET, "s", "v"),
+				NewCommand(GEOADD, "s", "13", "38", "Palermo"),
+				NewCommand(GEOPOS, "s", "Palermo"),
+				sicily,
+				NewCommand(GET, "Sicily"),
+			},
+			expected: []interface{}{statusOK, errWrongType, errWrongType, 2, errWrongType},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompactGeo(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(GEOADD, "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"))
+
+	_, got := kvdb.Execute(0, NewCommand(COMPACT))
+	want := []interface{}{
+		"GEOADD Sicily 13.36138933897018433 38.11555639549629859 Palermo",
+		"GEOADD Sicily 15.08726745843887329 37.50266842333162032 Catania",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("COMPACT returned %q, want %q", got, want)
+	}
+
+	// Replaying the output must give back the same scores.
+	replay := NewKeyValueDB(storage.NewInMemory("1"))
+	replay.Execute(0, NewCommand(GEOADD, "Sicily", "13.36138933897018433", "38.11555639549629859", "Palermo"))
+	_, before := kvdb.Execute(0, NewCommand(GEOHASH, "Sicily", "Palermo"))
+	_, after := replay.Execute(0, NewCommand(GEOHASH, "Sicily", "Palermo"))
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("GEOHASH after replay = %v, want %v", after, before)
+	}
+}
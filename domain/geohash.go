@@ -0,0 +1,335 @@
+package domain
+
+import (
+	"math"
+)
+
+// Geo members are stored in a sorted set scored by a 52-bit geohash: 26 bits
+// of longitude and 26 bits of latitude interleaved, longitude first. The
+// latitude range is limited to what the Web Mercator projection covers.
+const (
+	geoStepMax = 26
+
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoLongMin = -180.0
+	geoLongMax = 180.0
+
+	earthRadiusMeters = 6372797.560856
+	mercatorMax       = 20037726.37
+
+	geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+type geoHash struct {
+	bits uint64
+	step uint
+}
+
+type geoRange struct {
+	min, max float64
+}
+
+type geoArea struct {
+	hash      geoHash
+	longitude geoRange
+	latitude  geoRange
+}
+
+// interleave64 spreads the bits of x over the even positions and the bits
+// of y over the odd positions.
+func interleave64(x, y uint32) uint64 {
+	spread := func(v uint64) uint64 {
+		v = (v | v<<16) & 0x0000FFFF0000FFFF
+		v = (v | v<<8) & 0x00FF00FF00FF00FF
+		v = (v | v<<4) & 0x0F0F0F0F0F0F0F0F
+		v = (v | v<<2) & 0x3333333333333333
+		v = (v | v<<1) & 0x5555555555555555
+		return v
+	}
+	return spread(uint64(x)) | spread(uint64(y))<<1
+}
+
+// deinterleave64 is the inverse of interleave64.
+func deinterleave64(v uint64) (uint32, uint32) {
+	squash := func(v uint64) uint32 {
+		v &= 0x5555555555555555
+		v = (v | v>>1) & 0x3333333333333333
+		v = (v | v>>2) & 0x0F0F0F0F0F0F0F0F
+		v = (v | v>>4) & 0x00FF00FF00FF00FF
+		v = (v | v>>8) & 0x0000FFFF0000FFFF
+		v = (v | v>>16) & 0x00000000FFFFFFFF
+		return uint32(v)
+	}
+	return squash(v), squash(v >> 1)
+}
+
+func validGeoPoint(longitude, latitude float64) bool {
+	return longitude >= geoLongMin && longitude <= geoLongMax &&
+		latitude >= geoLatMin && latitude <= geoLatMax
+}
+
+func geohashEncode(longRange, latRange geoRange, longitude, latitude float64, step uint) geoHash {
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+	scale := float64(uint64(1) << step)
+	latBits := uint32(latOffset * scale)
+	longBits := uint32(longOffset * scale)
+	// The maximum value of a range maps one past the last cell.
+	if uint64(latBits) == uint64(1)<<step {
+		latBits--
+	}
+	if uint64(longBits) == uint64(1)<<step {
+		longBits--
+	}
+	return geoHash{bits: interleave64(latBits, longBits), step: step}
+}
+
+func geohashEncodeWGS84(longitude, latitude float64, step uint) geoHash {
+	return geohashEncode(geoRange{geoLongMin, geoLongMax}, geoRange{geoLatMin, geoLatMax}, longitude, latitude, step)
+}
+
+func geohashDecode(longRange, latRange geoRange, hash geoHash) geoArea {
+	latBits, longBits := deinterleave64(hash.bits)
+	scale := float64(uint64(1) << hash.step)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+	return geoArea{
+		hash: hash,
+		latitude: geoRange{
+			min: latRange.min + float64(latBits)/scale*latScale,
+			max: latRange.min + float64(latBits+1)/scale*latScale,
+		},
+		longitude: geoRange{
+			min: longRange.min + float64(longBits)/scale*longScale,
+			max: longRange.min + float64(longBits+1)/scale*longScale,
+		},
+	}
+}
+
+func geohashDecodeWGS84(hash geoHash) geoArea {
+	return geohashDecode(geoRange{geoLongMin, geoLongMax}, geoRange{geoLatMin, geoLatMax}, hash)
+}
+
+// geohashToPoint returns the centre of the cell a 52-bit score describes.
+func geohashToPoint(score float64) (float64, float64) {
+	area := geohashDecodeWGS84(geoHash{bits: uint64(score), step: geoStepMax})
+	longitude := (area.longitude.min + area.longitude.max) / 2
+	latitude := (area.latitude.min + area.latitude.max) / 2
+	return math.Max(geoLongMin, math.Min(geoLongMax, longitude)),
+		math.Max(geoLatMin, math.Min(geoLatMax, latitude))
+}
+
+// geohashString returns the standard 11 character geohash of a point, which
+// uses the full latitude range unlike the scores stored in the set.
+func geohashString(longitude, latitude float64) string {
+	hash := geohashEncode(geoRange{-180, 180}, geoRange{-90, 90}, longitude, latitude, geoStepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		// The 52-bit hash only fills ten characters; the last one is zero.
+		if i < 10 {
+			idx = int(hash.bits>>(52-uint(i+1)*5)) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func radToDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// geoDistance returns the haversine distance in meters between two points.
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lon1r := degToRad(lat1), degToRad(lon1)
+	lat2r, lon2r := degToRad(lat2), degToRad(lon2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+func geoLatDistance(lat1, lat2 float64) float64 {
+	return earthRadiusMeters * math.Abs(degToRad(lat2)-degToRad(lat1))
+}
+
+// geohashAlign52Bits scales a hash of any precision to a 52-bit score.
+func geohashAlign52Bits(hash geoHash) uint64 {
+	return hash.bits << (52 - hash.step*2)
+}
+
+// geohashEstimateSteps returns the geohash precision whose cells are about
+// the size of a search radius.
+func geohashEstimateSteps(rangeMeters, latitude float64) uint {
+	if rangeMeters == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	// Make sure the range is included in most of the base cases.
+	step -= 2
+
+	// Cells get narrower towards the poles, so use wider ones there.
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
+
+func (h geoHash) moveX(d int) geoHash {
+	x := h.bits & 0xaaaaaaaaaaaaaaaa
+	y := h.bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - h.step*2)
+	if d > 0 {
+		x += zz + 1
+	} else {
+		x |= zz
+		x -= zz + 1
+	}
+	x &= uint64(0xaaaaaaaaaaaaaaaa) >> (64 - h.step*2)
+	return geoHash{bits: x | y, step: h.step}
+}
+
+func (h geoHash) moveY(d int) geoHash {
+	x := h.bits & 0xaaaaaaaaaaaaaaaa
+	y := h.bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - h.step*2)
+	if d > 0 {
+		y += zz + 1
+	} else {
+		y |= zz
+		y -= zz + 1
+	}
+	y &= uint64(0x5555555555555555) >> (64 - h.step*2)
+	return geoHash{bits: x | y, step: h.step}
+}
+
+// geoShape is the area of a geo search: a circle around the centre, or a
+// box when isBox is set. Sizes are in meters.
+type geoShape struct {
+	longitude, latitude float64
+	radius              float64
+	width, height       float64
+	isBox               bool
+}
+
+// boundingBox returns the longitude and latitude limits of the shape.
+func (s geoShape) boundingBox() (minLon, minLat, maxLon, maxLat float64) {
+	width, height := s.radius*2, s.radius*2
+	if s.isBox {
+		width, height = s.width, s.height
+	}
+	latDelta := radToDeg(height / 2 / earthRadiusMeters)
+	longDeltaTop := radToDeg(width / 2 / earthRadiusMeters / math.Cos(degToRad(s.latitude+latDelta)))
+	longDeltaBottom := radToDeg(width / 2 / earthRadiusMeters / math.Cos(degToRad(s.latitude-latDelta)))
+
+	// The same width spans more degrees of longitude nearer the poles, so use
+	// the edge closest to the pole.
+	longDelta := longDeltaTop
+	if s.latitude < 0 {
+		longDelta = longDeltaBottom
+	}
+	return s.longitude - longDelta, s.latitude - latDelta, s.longitude + longDelta, s.latitude + latDelta
+}
+
+// contains reports whether the point lies within the shape and returns its
+// distance in meters from the centre.
+func (s geoShape) contains(longitude, latitude float64) (float64, bool) {
+	if !s.isBox {
+		distance := geoDistance(s.longitude, s.latitude, longitude, latitude)
+		return distance, distance <= s.radius
+	}
+	// The latitude distance is cheaper, so check it first.
+	if geoLatDistance(latitude, s.latitude) > s.height/2 {
+		return 0, false
+	}
+	if geoDistance(longitude, latitude, s.longitude, latitude) > s.width/2 {
+		return 0, false
+	}
+	return geoDistance(s.longitude, s.latitude, longitude, latitude), true
+}
+
+// searchAreas returns the geohash cells that cover the shape: the cell of
+// the centre and its eight neighbours, minus those that cannot match.
+func (s geoShape) searchAreas() []geoHash {
+	radius := s.radius
+	if s.isBox {
+		radius = math.Sqrt(s.width/2*s.width/2 + s.height/2*s.height/2)
+	}
+	minLon, minLat, maxLon, maxLat := s.boundingBox()
+	steps := geohashEstimateSteps(radius, s.latitude)
+
+	var hash geoHash
+	var area geoArea
+	var neighbors [8]geoHash
+	compute := func() {
+		hash = geohashEncodeWGS84(s.longitude, s.latitude, steps)
+		area = geohashDecodeWGS84(hash)
+		neighbors = [8]geoHash{
+			hash.moveY(1),            // north
+			hash.moveY(-1),           // south
+			hash.moveX(1),            // east
+			hash.moveX(-1),           // west
+			hash.moveX(1).moveY(1),   // north east
+			hash.moveX(-1).moveY(1),  // north west
+			hash.moveX(1).moveY(-1),  // south east
+			hash.moveX(-1).moveY(-1), // south west
+		}
+	}
+	compute()
+
+	// When the neighbours do not cover the bounding box, use larger cells.
+	north := geohashDecodeWGS84(neighbors[0])
+	south := geohashDecodeWGS84(neighbors[1])
+	east := geohashDecodeWGS84(neighbors[2])
+	west := geohashDecodeWGS84(neighbors[3])
+	if steps > 1 && (north.latitude.max < maxLat || south.latitude.min > minLat ||
+		east.longitude.max < maxLon || west.longitude.min > minLon) {
+		steps--
+		compute()
+	}
+
+	skip := make([]bool, 8)
+	if steps >= 2 {
+		if area.latitude.min < minLat {
+			skip[1], skip[6], skip[7] = true, true, true
+		}
+		if area.latitude.max > maxLat {
+			skip[0], skip[4], skip[5] = true, true, true
+		}
+		if area.longitude.min < minLon {
+			skip[3], skip[5], skip[7] = true, true, true
+		}
+		if area.longitude.max > maxLon {
+			skip[2], skip[4], skip[6] = true, true, true
+		}
+	}
+
+	areas := []geoHash{hash}
+	seen := map[uint64]bool{hash.bits: true}
+	for i, n := range neighbors {
+		// With very large cells neighbours wrap around and repeat.
+		if skip[i] || seen[n.bits] {
+			continue
+		}
+		seen[n.bits] = true
+		areas = append(areas, n)
+	}
+	return areas
+}
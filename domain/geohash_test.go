@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInterleaveRoundTrip(t *testing.T) {
+	for _, v := range [][2]uint32{{0, 0}, {1, 2}, {0x3ffffff, 0}, {12345, 0x2aaaaaa}} {
+		x, y := deinterleave64(interleave64(v[0], v[1]))
+		if x != v[0] || y != v[1] {
+			t.Errorf("deinterleave64(interleave64(%d, %d)) = %d, %d", v[0], v[1], x, y)
+		}
+	}
+}
+
+func TestGeohashPoint(t *testing.T) {
+	hash := geohashEncodeWGS84(13.361389, 38.115556, geoStepMax)
+	longitude, latitude := geohashToPoint(float64(geohashAlign52Bits(hash)))
+	if math.Abs(longitude-13.361389) > 1e-5 || math.Abs(latitude-38.115556) > 1e-5 {
+		t.Errorf("geohashToPoint() = %v, %v, want about 13.361389, 38.115556", longitude, latitude)
+	}
+	if got := geohashString(13.361389, 38.115556); got != "sqc8b49rny0" {
+		t.Errorf("geohashString() = %q, want sqc8b49rny0", got)
+	}
+}
+
+func TestGeoDistance(t *testing.T) {
+	got := geoDistance(13.361389, 38.115556, 15.087269, 37.502669)
+	if math.Abs(got-166274.15) > 1 {
+		t.Errorf("geoDistance(Palermo, Catania) = %v, want about 166274", got)
+	}
+}
+
+func TestGeoShapeSearchAreasCoverShape(t *testing.T) {
+	shapes := []geoShape{
+		{longitude: 15, latitude: 37, radius: 200000},
+		{longitude: -0.1, latitude: 51.5, radius: 10},
+		{longitude: 179.9, latitude: 0, width: 50000, height: 20000, isBox: true},
+		{longitude: 0, latitude: 84, radius: 1000000},
+	}
+	for _, shape := range shapes {
+		areas := shape.searchAreas()
+		minLon, minLat, maxLon, maxLat := shape.boundingBox()
+		// Every corner of the bounding box that is a valid point must fall in
+		// one of the areas.
+		for _, p := range [][2]float64{{minLon, minLat}, {minLon, maxLat}, {maxLon, minLat}, {maxLon, maxLat}} {
+			if !validGeoPoint(p[0], p[1]) {
+				continue
+			}
+			covered := false
+			for _, area := range areas {
+				if geohashEncodeWGS84(p[0], p[1], area.step).bits == area.bits {
+					covered = true
+				}
+			}
+			if !covered {
+				t.Errorf("searchAreas() for %+v does not cover %v", shape, p)
+			}
+		}
+	}
+}
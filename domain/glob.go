@@ -0,0 +1,85 @@
+package domain
+
+// globMatch reports whether s matches the glob pattern with the semantics of
+// Redis: `*` matches any run of bytes, `?` any single byte, `[abc]`, `[^abc]`
+// and `[a-z]` a byte in or out of a set, and a backslash makes the next byte
+// literal. An unterminated `[` set is closed by the end of the pattern.
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	// Where to resume after the last `*` when a later token fails.
+	starP, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			if p == len(pattern) {
+				return true
+			}
+			starP, starI = p, i
+			continue
+		}
+		if p < len(pattern) {
+			if ok, next := globToken(pattern, p, s[i]); ok {
+				p, i = next, i+1
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starI++
+		p, i = starP, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// globToken matches c against the token at pattern[p], which is not `*`,
+// and returns the index of the next token.
+func globToken(pattern string, p int, c byte) (bool, int) {
+	switch pattern[p] {
+	case '?':
+		return true, p + 1
+	case '\\':
+		if p+1 < len(pattern) {
+			return pattern[p+1] == c, p + 2
+		}
+	case '[':
+		return globSet(pattern, p+1, c)
+	}
+	return pattern[p] == c, p + 1
+}
+
+// globSet matches c against the set that starts at pattern[p], just after
+// its `[`.
+func globSet(pattern string, p int, c byte) (bool, int) {
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+	match := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			match = match || pattern[p+1] == c
+			p += 2
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			start, end := pattern[p], pattern[p+2]
+			if start > end {
+				start, end = end, start
+			}
+			match = match || (c >= start && c <= end)
+			p += 3
+		default:
+			match = match || pattern[p] == c
+			p++
+		}
+	}
+	if p < len(pattern) {
+		p++
+	}
+	return match != negate, p
+}
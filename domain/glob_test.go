@@ -0,0 +1,43 @@
+package domain
+
+import "testing"
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"*llo*", "hello world", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h[\]]llo`, "h]llo", true},
+		{`\`, `\`, true},
+		{"user:[0-9]*", "user:42", true},
+		{"user:[0-9]*", "user:x", false},
+		{"[]a", "a", false},
+		{"h[el", "he", true},
+		{"**a", "ba", true},
+	}
+	for _, test := range tests {
+		if got := globMatch(test.pattern, test.s); got != test.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", test.pattern, test.s, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package domain
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog values use the same string layout as Redis so they survive
+// GET and SET: a 16 byte header ("HYLL", the encoding, three unused bytes
+// and a cached cardinality) followed by the registers, either packed six
+// bits each (dense) or run-length encoded (sparse).
+const (
+	hllP             = 14
+	hllQ             = 64 - hllP
+	hllRegisters     = 1 << hllP
+	hllRegisterMask  = hllRegisters - 1
+	hllBits          = 6
+	hllRegisterMax   = 1<<hllBits - 1
+	hllHeaderSize    = 16
+	hllDenseSize     = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllSparseMaxSize = 3000
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllAlphaInf = 0.721347520444481703680
+
+	// Sparse opcodes.
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+)
+
+var (
+	errHLLInvalid = errors.New("(error) WRONGTYPE Key is not a valid HyperLogLog string value.")
+	errHLLCorrupt = errors.New("(error) INVALIDOBJ Corrupted HLL object detected")
+)
+
+// murmurHash64A is the hash function Redis uses for HyperLogLog elements.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ (uint64(len(key)) * m)
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+	switch len(key) {
+	case 7:
+		h ^= uint64(key[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(key[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(key[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(key[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(key[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(key[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(key[0])
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register an element maps to and the length of the
+// run of zeros in its hash, plus one.
+func hllPatLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := int(hash & hllRegisterMask)
+	hash >>= hllP
+	// Make sure the count terminates even if every remaining bit is zero.
+	hash |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// newHLL returns an empty HyperLogLog in the sparse encoding.
+func newHLL() []byte {
+	b := make([]byte, hllHeaderSize, hllHeaderSize+2)
+	copy(b, "HYLL")
+	b[4] = hllSparse
+	// A single XZERO opcode covering every register.
+	return append(b, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+}
+
+// isHLL reports whether b looks like a HyperLogLog value.
+func isHLL(b []byte) bool {
+	if len(b) < hllHeaderSize || string(b[:4]) != "HYLL" {
+		return false
+	}
+	switch b[4] {
+	case hllDense:
+		return len(b) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+func hllCachedCount(b []byte) (uint64, bool) {
+	if b[15]&0x80 != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(b[8:16]), true
+}
+
+func hllSetCachedCount(b []byte, count uint64) {
+	binary.LittleEndian.PutUint64(b[8:16], count)
+}
+
+func hllInvalidateCache(b []byte) {
+	b[15] |= 0x80
+}
+
+func denseRegister(regs []byte, index int) uint8 {
+	bit := index * hllBits
+	byteIdx, fb := bit/8, uint(bit&7)
+	b0 := uint(regs[byteIdx])
+	var b1 uint
+	if byteIdx+1 < len(regs) {
+		b1 = uint(regs[byteIdx+1])
+	}
+	return uint8((b0>>fb | b1<<(8-fb)) & hllRegisterMax)
+}
+
+func setDenseRegister(regs []byte, index int, val uint8) {
+	bit := index * hllBits
+	byteIdx, fb := bit/8, uint(bit&7)
+	v := uint(val)
+	regs[byteIdx] &^= byte(hllRegisterMax << fb)
+	regs[byteIdx] |= byte(v << fb)
+	if byteIdx+1 < len(regs) {
+		regs[byteIdx+1] &^= byte(hllRegisterMax >> (8 - fb))
+		regs[byteIdx+1] |= byte(v >> (8 - fb))
+	}
+}
+
+// hllRegistersOf decodes the registers of a HyperLogLog value, one byte per
+// register.
+func hllRegistersOf(b []byte) ([]uint8, error) {
+	regs := make([]uint8, hllRegisters)
+	if b[4] == hllDense {
+		payload := b[hllHeaderSize:]
+		for i := range regs {
+			regs[i] = denseRegister(payload, i)
+		}
+		return regs, nil
+	}
+
+	index := 0
+	payload := b[hllHeaderSize:]
+	for i := 0; i < len(payload); {
+		op := payload[i]
+		switch {
+		case op&0xc0 == 0x00: // ZERO
+			index += int(op&0x3f) + 1
+			i++
+		case op&0xc0 == 0x40: // XZERO
+			if i+1 >= len(payload) {
+				return nil, errHLLCorrupt
+			}
+			index += int(op&0x3f)<<8 | int(payload[i+1]) + 1
+			i += 2
+		default: // VAL
+			val := (op>>2)&0x1f + 1
+			run := int(op&0x3) + 1
+			if index+run > hllRegisters {
+				return nil, errHLLCorrupt
+			}
+			for j := 0; j < run; j++ {
+				regs[index+j] = val
+			}
+			index += run
+			i++
+		}
+		if index > hllRegisters {
+			return nil, errHLLCorrupt
+		}
+	}
+	if index != hllRegisters {
+		return nil, errHLLCorrupt
+	}
+	return regs, nil
+}
+
+// encodeSparse run-length encodes registers. It reports false when a
+// register is too large for the sparse encoding.
+func encodeSparse(regs []uint8) ([]byte, bool) {
+	var out []byte
+	for i := 0; i < len(regs); {
+		val := regs[i]
+		run := 1
+		for i+run < len(regs) && regs[i+run] == val {
+			run++
+		}
+		i += run
+
+		if val > hllSparseValMaxValue {
+			return nil, false
+		}
+		for run > 0 {
+			switch {
+			case val != 0:
+				n := run
+				if n > hllSparseValMaxLen {
+					n = hllSparseValMaxLen
+				}
+				out = append(out, 0x80|(val-1)<<2|byte(n-1))
+				run -= n
+			case run > hllSparseZeroMaxLen:
+				n := run
+				if n > hllSparseXZeroMaxLen {
+					n = hllSparseXZeroMaxLen
+				}
+				out = append(out, 0x40|byte((n-1)>>8), byte((n-1)&0xff))
+				run -= n
+			default:
+				out = append(out, byte(run-1))
+				run = 0
+			}
+		}
+	}
+	return out, true
+}
+
+// encodeHLL builds a HyperLogLog value from registers, using the sparse
+// encoding when allowed and small enough. The cached count is invalid.
+func encodeHLL(regs []uint8, allowSparse bool) []byte {
+	if allowSparse {
+		if payload, ok := encodeSparse(regs); ok && hllHeaderSize+len(payload) <= hllSparseMaxSize {
+			b := make([]byte, hllHeaderSize, hllHeaderSize+len(payload))
+			copy(b, "HYLL")
+			b[4] = hllSparse
+			hllInvalidateCache(b)
+			return append(b, payload...)
+		}
+	}
+
+	b := make([]byte, hllDenseSize)
+	copy(b, "HYLL")
+	b[4] = hllDense
+	hllInvalidateCache(b)
+	payload := b[hllHeaderSize:]
+	for i, val := range regs {
+		setDenseRegister(payload, i, val)
+	}
+	return b
+}
+
+// hllEstimate estimates the cardinality from the registers using the
+// estimator by Otmar Ertl that Redis uses.
+func hllEstimate(regs []uint8) uint64 {
+	var histogram [64]int
+	for _, val := range regs {
+		histogram[val]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
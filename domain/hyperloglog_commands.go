@@ -0,0 +1,133 @@
+package domain
+
+// lookupHLL returns the HyperLogLog stored at key, or nil when the key does
+// not exist. It returns an error reply when the key holds anything else.
+func (kvdb *KeyValueDB) lookupHLL(dbIndex int, key string) ([]byte, ErrorReply) {
+	b, ok := kvdb.lookupBytes(dbIndex, key)
+	if !ok {
+		return nil, errWrongType
+	}
+	if b != nil && !isHLL(b) {
+		return nil, ErrorReply(errHLLInvalid.Error())
+	}
+	return b, ""
+}
+
+func (kvdb *KeyValueDB) pfadd(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	b, errMsg := kvdb.lookupHLL(dbIndex, cmd.arg(0))
+	if errMsg != "" {
+		return errMsg
+	}
+
+	updated := false
+	if b == nil {
+		b = newHLL()
+		updated = true
+	}
+
+	if b[4] == hllDense {
+		// Dense registers are updated in place.
+		payload := b[hllHeaderSize:]
+		for _, element := range args[1:] {
+			index, count := hllPatLen([]byte(element))
+			if count > denseRegister(payload, index) {
+				setDenseRegister(payload, index, count)
+				updated = true
+			}
+		}
+	} else {
+		regs, err := hllRegistersOf(b)
+		if err != nil {
+			return ErrorReply(err.Error())
+		}
+		changed := false
+		for _, element := range args[1:] {
+			index, count := hllPatLen([]byte(element))
+			if count > regs[index] {
+				regs[index] = count
+				changed = true
+			}
+		}
+		if changed {
+			b = encodeHLL(regs, true)
+			updated = true
+		}
+	}
+
+	if !updated {
+		return 0
+	}
+	hllInvalidateCache(b)
+	kvdb.storage.Set(dbIndex, cmd.Argv[0], b)
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return 1
+}
+
+func (kvdb *KeyValueDB) pfcount(dbIndex int, cmd Command) interface{} {
+	keys := cmd.params()
+	if len(keys) == 1 {
+		b, errMsg := kvdb.lookupHLL(dbIndex, cmd.arg(0))
+		if errMsg != "" {
+			return errMsg
+		}
+		if b == nil {
+			return 0
+		}
+		if count, ok := hllCachedCount(b); ok {
+			return int(count)
+		}
+		regs, err := hllRegistersOf(b)
+		if err != nil {
+			return ErrorReply(err.Error())
+		}
+		count := hllEstimate(regs)
+		hllSetCachedCount(b, count)
+		kvdb.storage.Set(dbIndex, cmd.Argv[0], b)
+		return int(count)
+	}
+
+	regs, _, errMsg := kvdb.mergeHLLs(dbIndex, keys)
+	if errMsg != "" {
+		return errMsg
+	}
+	return int(hllEstimate(regs))
+}
+
+func (kvdb *KeyValueDB) pfmerge(dbIndex int, cmd Command) interface{} {
+	keys := cmd.params()
+	regs, anyDense, errMsg := kvdb.mergeHLLs(dbIndex, keys)
+	if errMsg != "" {
+		return errMsg
+	}
+	kvdb.storage.Set(dbIndex, cmd.Argv[0], encodeHLL(regs, !anyDense))
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return statusOK
+}
+
+// mergeHLLs returns the register-wise maximum of the HyperLogLogs at keys,
+// skipping missing keys, and whether any of them used the dense encoding.
+func (kvdb *KeyValueDB) mergeHLLs(dbIndex int, keys []string) ([]uint8, bool, ErrorReply) {
+	merged := make([]uint8, hllRegisters)
+	anyDense := false
+	for _, key := range keys {
+		b, errMsg := kvdb.lookupHLL(dbIndex, key)
+		if errMsg != "" {
+			return nil, false, errMsg
+		}
+		if b == nil {
+			continue
+		}
+		anyDense = anyDense || b[4] == hllDense
+		regs, err := hllRegistersOf(b)
+		if err != nil {
+			return nil, false, ErrorReply(err.Error())
+		}
+		for i, val := range regs {
+			if val > merged[i] {
+				merged[i] = val
+			}
+		}
+	}
+	return merged, anyDense, ""
+}
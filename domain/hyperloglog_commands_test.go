@@ -0,0 +1,128 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"math"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "PFADD and PFCOUNT",
+			commands: []Command{
+				NewCommand(PFADD, "hll", "a", "b", "c", "d", "e", "f", "g"),
+				NewCommand(PFADD, "hll", "a", "b"),
+				NewCommand(PFCOUNT, "hll"),
+				NewCommand(PFCOUNT, "missing"),
+			},
+			expected: []interface{}{1, 0, 7, 0},
+		},
+		{
+			name: "PFADD without elements creates the key once",
+			commands: []Command{
+				NewCommand(PFADD, "hll"),
+				NewCommand(PFADD, "hll"),
+				NewCommand(PFCOUNT, "hll"),
+			},
+			expected: []interface{}{1, 0, 0},
+		},
+		{
+			name: "PFCOUNT over several keys and PFMERGE",
+			commands: []Command{
+				NewCommand(PFADD, "h1", "foo", "bar", "zap", "a"),
+				NewCommand(PFADD, "h2", "a", "b", "c", "foo"),
+				NewCommand(PFCOUNT, "h1", "h2", "missing"),
+				NewCommand(PFMERGE, "h3", "h1", "h2"),
+				NewCommand(PFCOUNT, "h3"),
+				NewCommand(PFMERGE, "h4"),
+				NewCommand(PFCOUNT, "h4"),
+			},
+			expected: []interface{}{1, 1, 6, statusOK, 6, statusOK, 0},
+		},
+		{
+			name: "HyperLogLog commands on other values",
+			commands: []Command{
+				NewCommand(SET, "s", "not a hll"),
+				NewCommand(PFADD, "s", "a"),
+				NewCommand(PFCOUNT, "s"),
+				NewCommand(XADD, "x", "1-1", "a", "b"),
+				NewCommand(PFCOUNT, "x"),
+			},
+			expected: []interface{}{statusOK, ErrorReply(errHLLInvalid.Error()), ErrorReply(errHLLInvalid.Error()), "1-1", errWrongType},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHyperLogLogGetSetRoundTrip(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(PFADD, "hll", "a", "b", "c"))
+
+	_, raw := kvdb.Execute(0, NewCommand(GET, "hll"))
+	kvdb.Execute(0, NewCommand(SET, "copy", raw))
+	_, got := kvdb.Execute(0, NewCommand(PFCOUNT, "copy"))
+	if got != 3 {
+		t.Errorf("PFCOUNT after GET/SET = %v, want 3", got)
+	}
+	_, got = kvdb.Execute(0, NewCommand(PFADD, "copy", "d"))
+	if got != 1 {
+		t.Errorf("PFADD after GET/SET = %v, want 1", got)
+	}
+}
+
+func TestHyperLogLogErrorBound(t *testing.T) {
+	if testing.Short() {
+		t.Skip("adds millions of elements")
+	}
+
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	rnd := rand.New(rand.NewSource(1))
+	// The standard error with 16384 registers is 1.04/sqrt(16384) = 0.81%.
+	// Allow four standard errors.
+	const maxRelativeError = 4 * 0.0081
+	const batch = 1000
+
+	checkpoints := []int{1000, 10000, 100000, 1000000, 3000000}
+	added := 0
+	for _, checkpoint := range checkpoints {
+		for added < checkpoint {
+			args := make([]interface{}, 0, batch+1)
+			args = append(args, "hll")
+			for i := 0; i < batch; i++ {
+				args = append(args, strconv.FormatUint(rnd.Uint64(), 36))
+			}
+			kvdb.Execute(0, NewCommand(PFADD, args...))
+			added += batch
+		}
+
+		_, got := kvdb.Execute(0, NewCommand(PFCOUNT, "hll"))
+		estimate, ok := got.(int)
+		if !ok {
+			t.Fatalf("PFCOUNT returned %#v", got)
+		}
+		relErr := math.Abs(float64(estimate)-float64(added)) / float64(added)
+		if relErr > maxRelativeError {
+			t.Errorf("PFCOUNT after %d elements = %d, relative error %.4f exceeds %.4f", added, estimate, relErr, maxRelativeError)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHLLSparseRoundTrip(t *testing.T) {
+	regs := make([]uint8, hllRegisters)
+	regs[0] = 3
+	regs[1] = 3
+	regs[100] = 32
+	for i := 200; i < 210; i++ {
+		regs[i] = 1
+	}
+
+	b := encodeHLL(regs, true)
+	if b[4] != hllSparse {
+		t.Fatalf("encodeHLL() used encoding %d, want sparse", b[4])
+	}
+	got, err := hllRegistersOf(b)
+	if err != nil {
+		t.Fatalf("hllRegistersOf() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, regs) {
+		t.Errorf("sparse round trip changed the registers")
+	}
+}
+
+func TestHLLDenseRoundTrip(t *testing.T) {
+	regs := make([]uint8, hllRegisters)
+	for i := range regs {
+		regs[i] = uint8(i % (hllRegisterMax + 1))
+	}
+
+	// Register values above 32 cannot be sparse encoded.
+	b := encodeHLL(regs, true)
+	if b[4] != hllDense || len(b) != hllDenseSize {
+		t.Fatalf("encodeHLL() used encoding %d with %d bytes, want dense", b[4], len(b))
+	}
+	got, err := hllRegistersOf(b)
+	if err != nil {
+		t.Fatalf("hllRegistersOf() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, regs) {
+		t.Errorf("dense round trip changed the registers")
+	}
+}
+
+func TestHLLCorruptSparse(t *testing.T) {
+	b := newHLL()
+	b = b[:len(b)-1]
+	if _, err := hllRegistersOf(b); err != errHLLCorrupt {
+		t.Errorf("hllRegistersOf() error = %v, want %v", err, errHLLCorrupt)
+	}
+}
+
+func TestHLLEstimateEmpty(t *testing.T) {
+	if got := hllEstimate(make([]uint8, hllRegisters)); got != 0 {
+		t.Errorf("hllEstimate(empty) = %d, want 0", got)
+	}
+}
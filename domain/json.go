@@ -0,0 +1,495 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonDoc is a parsed JSON document. Its tree holds nil, bool, int64,
+// float64, string, *jsonArray and *jsonObject values. Integers and floats
+// are kept apart so that increments on integers stay exact.
+type jsonDoc struct {
+	root interface{}
+}
+
+type jsonArray struct {
+	items []interface{}
+}
+
+// jsonObject keeps its keys in insertion order, so documents are written
+// back the way they were stored.
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]interface{})}
+}
+
+func (o *jsonObject) get(key string) (interface{}, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+func (o *jsonObject) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *jsonObject) remove(key string) bool {
+	if _, ok := o.values[key]; !ok {
+		return false
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// parseJSON parses a complete JSON text into a tree.
+func parseJSON(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	v, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("trailing characters after JSON value")
+	}
+	return v, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("unexpected end of JSON input")
+		}
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := newJSONObject()
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj.set(keyTok.(string), v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return obj, nil
+		case '[':
+			arr := &jsonArray{items: []interface{}{}}
+			for dec.More() {
+				v, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr.items = append(arr.items, v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return arr, nil
+		}
+		return nil, fmt.Errorf("unexpected %q", rune(t))
+	case json.Number:
+		return parseJSONNumber(string(t))
+	default:
+		// string, bool or nil
+		return t, nil
+	}
+}
+
+func parseJSONNumber(s string) (interface{}, error) {
+	if !strings.ContainsAny(s, ".eE") {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %s", s)
+	}
+	return f, nil
+}
+
+// cloneJSON returns a deep copy of v, so one parsed value can be stored at
+// several paths without aliasing.
+func cloneJSON(v interface{}) interface{} {
+	switch val := v.(type) {
+	case *jsonArray:
+		arr := &jsonArray{items: make([]interface{}, len(val.items))}
+		for i, item := range val.items {
+			arr.items[i] = cloneJSON(item)
+		}
+		return arr
+	case *jsonObject:
+		obj := newJSONObject()
+		for _, k := range val.keys {
+			obj.set(k, cloneJSON(val.values[k]))
+		}
+		return obj
+	}
+	return v
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *jsonArray:
+		return "array"
+	}
+	return "object"
+}
+
+// jsonFormat controls the whitespace JSON.GET writes between tokens.
+type jsonFormat struct {
+	indent, newline, space string
+}
+
+func formatJSON(v interface{}, format jsonFormat) string {
+	var buf bytes.Buffer
+	writeJSON(&buf, v, format, 0)
+	return buf.String()
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}, format jsonFormat, depth int) {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(val))
+	case int64:
+		buf.WriteString(strconv.FormatInt(val, 10))
+	case float64:
+		buf.WriteString(formatJSONFloat(val))
+	case string:
+		writeJSONString(buf, val)
+	case *jsonArray:
+		if len(val.items) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteByte('[')
+		for i, item := range val.items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONIndent(buf, format, depth+1)
+			writeJSON(buf, item, format, depth+1)
+		}
+		writeJSONIndent(buf, format, depth)
+		buf.WriteByte(']')
+	case *jsonObject:
+		if len(val.keys) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteByte('{')
+		for i, k := range val.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONIndent(buf, format, depth+1)
+			writeJSONString(buf, k)
+			buf.WriteByte(':')
+			buf.WriteString(format.space)
+			writeJSON(buf, val.values[k], format, depth+1)
+		}
+		writeJSONIndent(buf, format, depth)
+		buf.WriteByte('}')
+	}
+}
+
+func writeJSONIndent(buf *bytes.Buffer, format jsonFormat, depth int) {
+	buf.WriteString(format.newline)
+	buf.WriteString(strings.Repeat(format.indent, depth))
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Encode always ends with a newline.
+	buf.Truncate(buf.Len() - 1)
+}
+
+// formatJSONFloat writes floats with a fraction or exponent, so they read
+// back as floats.
+func formatJSONFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// addJSONNumbers adds two numbers, keeping integers exact unless the sum
+// overflows.
+func addJSONNumbers(a, b interface{}) (interface{}, error) {
+	x, xInt := a.(int64)
+	y, yInt := b.(int64)
+	if xInt && yInt {
+		sum := x + y
+		if (sum > x) == (y > 0) {
+			return sum, nil
+		}
+	}
+	sum := jsonFloat(a) + jsonFloat(b)
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return nil, errors.New("result is not a number or infinity")
+	}
+	return sum, nil
+}
+
+func jsonFloat(v interface{}) float64 {
+	if n, ok := v.(int64); ok {
+		return float64(n)
+	}
+	return v.(float64)
+}
+
+// jsonPath is a parsed path. Paths starting with $ are JSONPath and address
+// every match; legacy paths, such as .a.b, address a single value.
+type jsonPath struct {
+	text     string
+	legacy   bool
+	segments []jsonPathSegment
+}
+
+// jsonPathSegment selects children of a node: every child, a member by key
+// or an element by index. A recursive segment applies to the node and all of
+// its descendants.
+type jsonPathSegment struct {
+	recursive bool
+	wildcard  bool
+	isIndex   bool
+	key       string
+	index     int
+}
+
+func parseJSONPath(text string) (jsonPath, error) {
+	path := jsonPath{text: text}
+	s := text
+	switch {
+	case strings.HasPrefix(s, "$"):
+		s = s[1:]
+	case s == ".":
+		path.legacy = true
+		return path, nil
+	default:
+		path.legacy = true
+		if !strings.HasPrefix(s, ".") && !strings.HasPrefix(s, "[") {
+			s = "." + s
+		}
+	}
+
+	for len(s) > 0 {
+		var seg jsonPathSegment
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			if strings.HasPrefix(s, ".") {
+				seg.recursive = true
+				s = s[1:]
+			}
+			if strings.HasPrefix(s, "[") {
+				if !seg.recursive {
+					return path, fmt.Errorf("invalid JSONPath %q", text)
+				}
+				break
+			}
+			if strings.HasPrefix(s, "*") {
+				seg.wildcard = true
+				s = s[1:]
+				path.segments = append(path.segments, seg)
+				continue
+			}
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return path, fmt.Errorf("invalid JSONPath %q", text)
+			}
+			seg.key = s[:end]
+			s = s[end:]
+			path.segments = append(path.segments, seg)
+			continue
+		case '[':
+		default:
+			return path, fmt.Errorf("invalid JSONPath %q", text)
+		}
+
+		// s starts with a bracket selector.
+		if len(s) > 2 && (s[1] == '\'' || s[1] == '"') {
+			closing := strings.Index(s[2:], string(s[1])+"]")
+			if closing < 0 {
+				return path, fmt.Errorf("invalid JSONPath %q", text)
+			}
+			seg.key = s[2 : closing+2]
+			s = s[closing+4:]
+		} else {
+			closing := strings.IndexByte(s, ']')
+			if closing < 0 {
+				return path, fmt.Errorf("invalid JSONPath %q", text)
+			}
+			inner := strings.TrimSpace(s[1:closing])
+			if inner == "*" {
+				seg.wildcard = true
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return path, fmt.Errorf("invalid JSONPath %q", text)
+				}
+				seg.isIndex = true
+				seg.index = index
+			}
+			s = s[closing+1:]
+		}
+		path.segments = append(path.segments, seg)
+	}
+	return path, nil
+}
+
+// jsonMatch is a node found by a path, with the container that holds it so
+// that it can be replaced or removed. The root has no parent.
+type jsonMatch struct {
+	parent interface{}
+	key    string
+	index  int
+	value  interface{}
+}
+
+func (doc *jsonDoc) find(segments []jsonPathSegment) []jsonMatch {
+	matches := []jsonMatch{{value: doc.root}}
+	for _, seg := range segments {
+		var next []jsonMatch
+		for _, m := range matches {
+			nodes := []jsonMatch{m}
+			if seg.recursive {
+				nodes = appendDescendants(nodes[:0], m)
+			}
+			for _, n := range nodes {
+				next = appendChildren(next, n.value, seg)
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+// appendDescendants appends m and every node below it in document order.
+func appendDescendants(out []jsonMatch, m jsonMatch) []jsonMatch {
+	out = append(out, m)
+	switch v := m.value.(type) {
+	case *jsonArray:
+		for i, item := range v.items {
+			out = appendDescendants(out, jsonMatch{parent: v, index: i, value: item})
+		}
+	case *jsonObject:
+		for _, k := range v.keys {
+			out = appendDescendants(out, jsonMatch{parent: v, key: k, value: v.values[k]})
+		}
+	}
+	return out
+}
+
+func appendChildren(out []jsonMatch, node interface{}, seg jsonPathSegment) []jsonMatch {
+	switch v := node.(type) {
+	case *jsonArray:
+		if seg.wildcard {
+			for i, item := range v.items {
+				out = append(out, jsonMatch{parent: v, index: i, value: item})
+			}
+		} else if seg.isIndex {
+			i := seg.index
+			if i < 0 {
+				i += len(v.items)
+			}
+			if i >= 0 && i < len(v.items) {
+				out = append(out, jsonMatch{parent: v, index: i, value: v.items[i]})
+			}
+		}
+	case *jsonObject:
+		if seg.wildcard {
+			for _, k := range v.keys {
+				out = append(out, jsonMatch{parent: v, key: k, value: v.values[k]})
+			}
+		} else if !seg.isIndex {
+			if item, ok := v.get(seg.key); ok {
+				out = append(out, jsonMatch{parent: v, key: seg.key, value: item})
+			}
+		}
+	}
+	return out
+}
+
+func (doc *jsonDoc) replace(m jsonMatch, v interface{}) {
+	switch p := m.parent.(type) {
+	case nil:
+		doc.root = v
+	case *jsonObject:
+		p.set(m.key, v)
+	case *jsonArray:
+		p.items[m.index] = v
+	}
+}
+
+// remove deletes the matches from their containers and returns how many
+// were removed.
+func (doc *jsonDoc) remove(matches []jsonMatch) int {
+	// Remove array elements from the back so earlier indexes stay valid.
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].index > matches[j].index
+	})
+	removed := 0
+	for _, m := range matches {
+		switch p := m.parent.(type) {
+		case *jsonObject:
+			if p.remove(m.key) {
+				removed++
+			}
+		case *jsonArray:
+			if m.index < len(p.items) && p.items[m.index] == m.value {
+				p.items = append(p.items[:m.index], p.items[m.index+1:]...)
+				removed++
+			}
+		}
+	}
+	return removed
+}
@@ -0,0 +1,339 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	errJSONNoKey      = ErrorReply("(error) ERR could not perform this operation on a key that doesn't exist")
+	errJSONNewAtRoot  = ErrorReply("(error) ERR new objects must be created at the root")
+	errJSONNotANumber = ErrorReply("(error) ERR result is not a number or infinity")
+)
+
+func errJSONPathMissing(path jsonPath) ErrorReply {
+	return ErrorReply(fmt.Sprintf("(error) ERR Path '%s' does not exist", path.text))
+}
+
+func errJSONPathType(expected string, v interface{}) ErrorReply {
+	return ErrorReply(fmt.Sprintf("(error) WRONGTYPE wrong type of path value - expected %s but found %s", expected, jsonTypeName(v)))
+}
+
+// lookupJSON returns the document stored at key, or nil when the key does
+// not exist. It reports false when the key holds another type.
+func (kvdb *KeyValueDB) lookupJSON(dbIndex int, key string) (*jsonDoc, bool) {
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	if v == nil {
+		return nil, true
+	}
+	doc, ok := v.(*jsonDoc)
+	return doc, ok
+}
+
+func parseJSONArg(arg string) (interface{}, ErrorReply) {
+	v, err := parseJSON(arg)
+	if err != nil {
+		return nil, ErrorReply(fmt.Sprintf("(error) ERR invalid JSON: %v", err))
+	}
+	return v, ""
+}
+
+func parseJSONPathArg(arg string) (jsonPath, ErrorReply) {
+	path, err := parseJSONPath(arg)
+	if err != nil {
+		return path, ErrorReply(fmt.Sprintf("(error) ERR %v", err))
+	}
+	return path, ""
+}
+
+func (kvdb *KeyValueDB) jsonSet(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	path, errMsg := parseJSONPathArg(args[1])
+	if errMsg != "" {
+		return errMsg
+	}
+	value, errMsg := parseJSONArg(args[2])
+	if errMsg != "" {
+		return errMsg
+	}
+	nx, xx := false, false
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return errSyntax
+		}
+	}
+
+	doc, ok := kvdb.lookupJSON(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if doc == nil {
+		if len(path.segments) > 0 {
+			return errJSONNewAtRoot
+		}
+		if xx {
+			return nil
+		}
+		kvdb.storage.Set(dbIndex, cmd.Argv[0], &jsonDoc{root: value})
+		kvdb.signalKey(dbIndex, cmd.arg(0))
+		return statusOK
+	}
+
+	matches := doc.find(path.segments)
+	if len(matches) > 0 {
+		if nx {
+			return nil
+		}
+		for _, m := range matches {
+			doc.replace(m, cloneJSON(value))
+		}
+		kvdb.signalKey(dbIndex, cmd.arg(0))
+		return statusOK
+	}
+
+	// A missing member is added when its parent object exists.
+	last := len(path.segments) - 1
+	if xx || path.segments[last].recursive || path.segments[last].wildcard || path.segments[last].isIndex {
+		return nil
+	}
+	added := false
+	for _, m := range doc.find(path.segments[:last]) {
+		if obj, ok := m.value.(*jsonObject); ok {
+			obj.set(path.segments[last].key, cloneJSON(value))
+			added = true
+		}
+	}
+	if !added {
+		if path.legacy {
+			return errJSONPathMissing(path)
+		}
+		return nil
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) jsonGet(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	var format jsonFormat
+	var paths []jsonPath
+	allLegacy := true
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if (opt == "INDENT" || opt == "NEWLINE" || opt == "SPACE") && i+1 < len(args) {
+			switch opt {
+			case "INDENT":
+				format.indent = args[i+1]
+			case "NEWLINE":
+				format.newline = args[i+1]
+			case "SPACE":
+				format.space = args[i+1]
+			}
+			i++
+			continue
+		}
+		path, errMsg := parseJSONPathArg(args[i])
+		if errMsg != "" {
+			return errMsg
+		}
+		allLegacy = allLegacy && path.legacy
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		paths = []jsonPath{{text: ".", legacy: true}}
+	}
+
+	doc, ok := kvdb.lookupJSON(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if doc == nil {
+		return nil
+	}
+
+	// Legacy paths return a single value, JSONPath returns every match.
+	// Once any path is JSONPath, all of them are treated that way.
+	result := func(path jsonPath) (interface{}, ErrorReply) {
+		matches := doc.find(path.segments)
+		if allLegacy {
+			if len(matches) == 0 {
+				return nil, errJSONPathMissing(path)
+			}
+			return matches[0].value, ""
+		}
+		arr := &jsonArray{items: make([]interface{}, 0, len(matches))}
+		for _, m := range matches {
+			arr.items = append(arr.items, m.value)
+		}
+		return arr, ""
+	}
+
+	if len(paths) == 1 {
+		v, errMsg := result(paths[0])
+		if errMsg != "" {
+			return errMsg
+		}
+		return formatJSON(v, format)
+	}
+	obj := newJSONObject()
+	for _, path := range paths {
+		v, errMsg := result(path)
+		if errMsg != "" {
+			return errMsg
+		}
+		obj.set(path.text, v)
+	}
+	return formatJSON(obj, format)
+}
+
+func (kvdb *KeyValueDB) jsonDel(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	path := jsonPath{text: ".", legacy: true}
+	if len(args) == 2 {
+		var errMsg ErrorReply
+		if path, errMsg = parseJSONPathArg(args[1]); errMsg != "" {
+			return errMsg
+		}
+	}
+
+	doc, ok := kvdb.lookupJSON(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if doc == nil {
+		return 0
+	}
+	if len(path.segments) == 0 {
+		kvdb.storage.Del(dbIndex, cmd.Argv[0])
+		kvdb.signalKey(dbIndex, cmd.arg(0))
+		return 1
+	}
+	removed := doc.remove(doc.find(path.segments))
+	if removed > 0 {
+		kvdb.signalKey(dbIndex, cmd.arg(0))
+	}
+	return removed
+}
+
+// jsonTargets returns the nodes a modifying command acts on: every match of
+// a JSONPath, or the first match of a legacy path.
+func (kvdb *KeyValueDB) jsonTargets(dbIndex int, key string, pathArg string) (*jsonDoc, jsonPath, []jsonMatch, ErrorReply) {
+	path, errMsg := parseJSONPathArg(pathArg)
+	if errMsg != "" {
+		return nil, path, nil, errMsg
+	}
+	doc, ok := kvdb.lookupJSON(dbIndex, key)
+	if !ok {
+		return nil, path, nil, errWrongType
+	}
+	if doc == nil {
+		return nil, path, nil, errJSONNoKey
+	}
+	matches := doc.find(path.segments)
+	if path.legacy {
+		if len(matches) == 0 {
+			return nil, path, nil, errJSONPathMissing(path)
+		}
+		matches = matches[:1]
+	}
+	return doc, path, matches, ""
+}
+
+func (kvdb *KeyValueDB) jsonArrAppend(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	values := make([]interface{}, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		v, errMsg := parseJSONArg(arg)
+		if errMsg != "" {
+			return errMsg
+		}
+		values = append(values, v)
+	}
+
+	_, path, matches, errMsg := kvdb.jsonTargets(dbIndex, cmd.arg(0), args[1])
+	if errMsg != "" {
+		return errMsg
+	}
+	if path.legacy {
+		if _, ok := matches[0].value.(*jsonArray); !ok {
+			return errJSONPathType("an array", matches[0].value)
+		}
+	}
+
+	reply := make([]interface{}, 0, len(matches))
+	for _, m := range matches {
+		arr, ok := m.value.(*jsonArray)
+		if !ok {
+			reply = append(reply, nil)
+			continue
+		}
+		for _, v := range values {
+			arr.items = append(arr.items, cloneJSON(v))
+		}
+		reply = append(reply, len(arr.items))
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	if path.legacy {
+		return reply[0]
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) jsonNumIncrBy(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	incr, err := parseJSON(args[2])
+	switch incr.(type) {
+	case int64, float64:
+	default:
+		err = fmt.Errorf("expected a number")
+	}
+	if err != nil {
+		return errNotFloat
+	}
+
+	doc, path, matches, errMsg := kvdb.jsonTargets(dbIndex, cmd.arg(0), args[1])
+	if errMsg != "" {
+		return errMsg
+	}
+	if path.legacy {
+		switch matches[0].value.(type) {
+		case int64, float64:
+		default:
+			return errJSONPathType("a number", matches[0].value)
+		}
+	}
+
+	// Compute every result before changing anything, so an overflow leaves
+	// the document as it was.
+	results := &jsonArray{items: make([]interface{}, len(matches))}
+	for i, m := range matches {
+		switch m.value.(type) {
+		case int64, float64:
+			sum, err := addJSONNumbers(m.value, incr)
+			if err != nil {
+				return errJSONNotANumber
+			}
+			results.items[i] = sum
+		}
+	}
+	for i, m := range matches {
+		if results.items[i] != nil {
+			doc.replace(m, results.items[i])
+		}
+	}
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	if path.legacy {
+		return formatJSON(results.items[0], jsonFormat{})
+	}
+	return formatJSON(results, jsonFormat{})
+}
+
+// compactJSON returns the command that recreates the document at key.
+func compactJSON(key string, doc *jsonDoc) []string {
+	return []string{fmt.Sprintf("JSON.SET %s $ %s", compactArg(key), compactArg(formatJSON(doc.root, jsonFormat{})))}
+}
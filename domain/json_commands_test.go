@@ -0,0 +1,173 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"testing"
+)
+
+func TestJSONCommands(t *testing.T) {
+	doc := `{"name":"shop","stock":{"apples":3,"pears":1.5},"tags":["a"],"items":[{"qty":1},{"qty":2}]}`
+
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "JSON.SET and JSON.GET",
+			commands: []Command{
+				NewCommand(JSON_SET, "doc", "$", doc),
+				NewCommand(JSON_GET, "doc"),
+				NewCommand(JSON_GET, "doc", "$.stock.apples"),
+				NewCommand(JSON_GET, "doc", ".stock.apples"),
+				NewCommand(JSON_GET, "doc", "$..qty"),
+				NewCommand(JSON_GET, "doc", "$.name", "$.tags"),
+				NewCommand(JSON_GET, "doc", ".missing"),
+				NewCommand(JSON_GET, "doc", "$.missing"),
+				NewCommand(JSON_GET, "nokey"),
+			},
+			expected: []interface{}{
+				statusOK,
+				doc,
+				"[3]",
+				"3",
+				"[1,2]",
+				`{"$.name":["shop"],"$.tags":[["a"]]}`,
+				ErrorReply("(error) ERR Path '.missing' does not exist"),
+				"[]",
+				nil,
+			},
+		},
+		{
+			name: "JSON.SET on sub-paths",
+			commands: []Command{
+				NewCommand(JSON_SET, "doc", "$", doc),
+				NewCommand(JSON_SET, "doc", "$.stock.plums", "7"),
+				NewCommand(JSON_SET, "doc", "$.items[*].qty", "0"),
+				NewCommand(JSON_SET, "doc", "$.name", `"market"`, "NX"),
+				NewCommand(JSON_SET, "doc", "$.open", "true", "XX"),
+				NewCommand(JSON_SET, "doc", "$.no.such", "1"),
+				NewCommand(JSON_GET, "doc", "$.stock", "$.items"),
+				NewCommand(JSON_SET, "new", "$.a", "1"),
+				NewCommand(JSON_SET, "new", "$", "{bad"),
+			},
+			expected: []interface{}{
+				statusOK, statusOK, statusOK, nil, nil, nil,
+				`{"$.stock":[{"apples":3,"pears":1.5,"plums":7}],"$.items":[[{"qty":0},{"qty":0}]]}`,
+				errJSONNewAtRoot,
+				ErrorReply("(error) ERR invalid JSON: invalid character 'b' looking for beginning of value"),
+			},
+		},
+		{
+			name: "JSON.DEL",
+			commands: []Command{
+				NewCommand(JSON_SET, "doc", "$", doc),
+				NewCommand(JSON_DEL, "doc", "$..qty"),
+				NewCommand(JSON_DEL, "doc", "$.stock.*"),
+				NewCommand(JSON_GET, "doc"),
+				NewCommand(JSON_DEL, "doc"),
+				NewCommand(JSON_GET, "doc"),
+				NewCommand(JSON_DEL, "doc"),
+			},
+			expected: []interface{}{
+				statusOK, 2, 2,
+				`{"name":"shop","stock":{},"tags":["a"],"items":[{},{}]}`,
+				1, nil, 0,
+			},
+		},
+		{
+			name: "JSON.ARRAPPEND",
+			commands: []Command{
+				NewCommand(JSON_SET, "doc", "$", doc),
+				NewCommand(JSON_ARRAPPEND, "doc", "$.tags", `"b"`, `{"c":1}`),
+				NewCommand(JSON_ARRAPPEND, "doc", "$..[0]", "null"),
+				NewCommand(JSON_ARRAPPEND, "doc", ".tags", "1"),
+				NewCommand(JSON_ARRAPPEND, "doc", ".stock", "1"),
+				NewCommand(JSON_ARRAPPEND, "doc", ".missing", "1"),
+				NewCommand(JSON_ARRAPPEND, "nokey", "$", "1"),
+				NewCommand(JSON_GET, "doc", "$.tags"),
+			},
+			expected: []interface{}{
+				statusOK,
+				[]interface{}{3},
+				[]interface{}{nil, nil},
+				4,
+				ErrorReply("(error) WRONGTYPE wrong type of path value - expected an array but found object"),
+				ErrorReply("(error) ERR Path '.missing' does not exist"),
+				errJSONNoKey,
+				`[["a","b",{"c":1},1]]`,
+			},
+		},
+		{
+			name: "JSON.NUMINCRBY",
+			commands: []Command{
+				NewCommand(JSON_SET, "doc", "$", doc),
+				NewCommand(JSON_NUMINCRBY, "doc", "$.stock.*", "2"),
+				NewCommand(JSON_NUMINCRBY, "doc", ".stock.apples", "0.5"),
+				NewCommand(JSON_NUMINCRBY, "doc", "$.items[*].qty", "10"),
+				NewCommand(JSON_NUMINCRBY, "doc", "$.*", "1"),
+				NewCommand(JSON_NUMINCRBY, "doc", ".name", "1"),
+				NewCommand(JSON_NUMINCRBY, "doc", "$.stock.apples", "x"),
+				NewCommand(JSON_GET, "doc", "$.stock", "$..qty"),
+			},
+			expected: []interface{}{
+				statusOK,
+				"[5,3.5]",
+				"5.5",
+				"[11,12]",
+				"[null,null,null,null]",
+				ErrorReply("(error) WRONGTYPE wrong type of path value - expected a number but found string"),
+				errNotFloat,
+				`{"$.stock":[{"apples":5.5,"pears":3.5}],"$..qty":[11,12]}`,
+			},
+		},
+		{
+			name: "JSON commands on other values",
+			commands: []Command{
+				NewCommand(SET, "s", `{"a":1}`),
+				NewCommand(JSON_GET, "s"),
+				NewCommand(JSON_SET, "s", "$.a", "2"),
+				NewCommand(JSON_SET, "doc", "$", doc),
+				NewCommand(GET, "doc"),
+			},
+			expected: []interface{}{statusOK, errWrongType, errWrongType, statusOK, errWrongType},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONSetDoesNotAlias(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(JSON_SET, "doc", "$", `{"a":{},"b":{}}`))
+	kvdb.Execute(0, NewCommand(JSON_SET, "doc", "$.*", `{"n":[]}`))
+	kvdb.Execute(0, NewCommand(JSON_ARRAPPEND, "doc", "$.a.n", "1"))
+
+	_, got := kvdb.Execute(0, NewCommand(JSON_GET, "doc"))
+	if want := `{"a":{"n":[1]},"b":{"n":[]}}`; got != want {
+		t.Errorf("JSON.GET = %v, want %v", got, want)
+	}
+}
+
+func TestCompactJSON(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(JSON_SET, "doc", "$", `{"a": [1, 2.5]}`))
+
+	_, got := kvdb.Execute(0, NewCommand(COMPACT))
+	want := []interface{}{`JSON.SET doc $ "{\"a\":[1,2.5]}"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("COMPACT returned %q, want %q", got, want)
+	}
+}
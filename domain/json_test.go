@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: `{"b":1,"a":[true,false,null],"c":{}}`, expected: `{"b":1,"a":[true,false,null],"c":{}}`},
+		{input: ` [ 1.5 , -2, 1e3, "x<y\n" ] `, expected: `[1.5,-2,1000.0,"x<y\n"]`},
+		{input: `3.0`, expected: `3.0`},
+		{input: `92233720368547758070`, expected: `9.223372036854776e+19`},
+	}
+	for _, test := range tests {
+		v, err := parseJSON(test.input)
+		if err != nil {
+			t.Errorf("parseJSON(%q) error = %v", test.input, err)
+			continue
+		}
+		if got := formatJSON(v, jsonFormat{}); got != test.expected {
+			t.Errorf("formatJSON(parseJSON(%q)) = %q, want %q", test.input, got, test.expected)
+		}
+	}
+}
+
+func TestParseJSONErrors(t *testing.T) {
+	for _, input := range []string{``, `{`, `[1,]`, `{"a" 1}`, `1 2`, `nope`} {
+		if _, err := parseJSON(input); err == nil {
+			t.Errorf("parseJSON(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestFormatJSONIndent(t *testing.T) {
+	v, _ := parseJSON(`{"a":[1,2],"b":{}}`)
+	got := formatJSON(v, jsonFormat{indent: "  ", newline: "\n", space: " "})
+	want := "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": {}\n}"
+	if got != want {
+		t.Errorf("formatJSON() = %q, want %q", got, want)
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		legacy   bool
+		segments []jsonPathSegment
+	}{
+		{path: "$", segments: nil},
+		{path: ".", legacy: true, segments: nil},
+		{path: "a.b", legacy: true, segments: []jsonPathSegment{{key: "a"}, {key: "b"}}},
+		{path: "$.a[0]['b c'][*]", segments: []jsonPathSegment{
+			{key: "a"}, {isIndex: true, index: 0}, {key: "b c"}, {wildcard: true},
+		}},
+		{path: `$..x.*["y"][-1]`, segments: []jsonPathSegment{
+			{recursive: true, key: "x"}, {wildcard: true}, {key: "y"}, {isIndex: true, index: -1},
+		}},
+		{path: "$..[1]", segments: []jsonPathSegment{{recursive: true, isIndex: true, index: 1}}},
+	}
+	for _, test := range tests {
+		got, err := parseJSONPath(test.path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q) error = %v", test.path, err)
+			continue
+		}
+		if got.legacy != test.legacy || !reflect.DeepEqual(got.segments, test.segments) {
+			t.Errorf("parseJSONPath(%q) = %+v, want legacy %v and %+v", test.path, got, test.legacy, test.segments)
+		}
+	}
+
+	for _, path := range []string{"$.", "$[x]", "$['a'", "$.a[1", "$x", ".[0]"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("parseJSONPath(%q) succeeded, want an error", path)
+		}
+	}
+}
+
+func TestJSONFind(t *testing.T) {
+	root, _ := parseJSON(`{"a":{"x":1},"b":[{"x":2},{"y":3}],"x":4}`)
+	doc := &jsonDoc{root: root}
+
+	tests := []struct {
+		path     string
+		expected []interface{}
+	}{
+		{path: "$..x", expected: []interface{}{int64(4), int64(1), int64(2)}},
+		{path: "$.b[-1].y", expected: []interface{}{int64(3)}},
+		{path: "$.b[*].x", expected: []interface{}{int64(2)}},
+		{path: "$.missing", expected: nil},
+	}
+	for _, test := range tests {
+		path, _ := parseJSONPath(test.path)
+		var got []interface{}
+		for _, m := range doc.find(path.segments) {
+			got = append(got, m.value)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("find(%q) = %v, want %v", test.path, got, test.expected)
+		}
+	}
+}
+
+func TestAddJSONNumbers(t *testing.T) {
+	tests := []struct {
+		a, b     interface{}
+		expected interface{}
+	}{
+		{a: int64(1), b: int64(2), expected: int64(3)},
+		{a: int64(1), b: 0.5, expected: 1.5},
+		{a: int64(9223372036854775807), b: int64(1), expected: 9223372036854775808.0},
+		{a: int64(-9223372036854775808), b: int64(-1), expected: -9223372036854775809.0},
+	}
+	for _, test := range tests {
+		got, err := addJSONNumbers(test.a, test.b)
+		if err != nil || got != test.expected {
+			t.Errorf("addJSONNumbers(%v, %v) = %v, %v, want %v", test.a, test.b, got, err, test.expected)
+		}
+	}
+	if _, err := addJSONNumbers(1.7e308, 1.7e308); err == nil {
+		t.Errorf("addJSONNumbers() overflowing to infinity succeeded")
+	}
+}
@@ -0,0 +1,162 @@
+package domain
+
+import "strings"
+
+const errSameObject = ErrorReply("(error) ERR source and destination objects are the same")
+
+// cloneValue returns a copy of v that shares no mutable state with it.
+func cloneValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case []byte:
+		return append([]byte(nil), val...)
+	case *stream:
+		return val.clone()
+	case *sortedSet:
+		return val.clone()
+	case *jsonDoc:
+		return &jsonDoc{root: cloneJSON(val.root)}
+	case *bloomFilter:
+		return val.clone()
+	case *cuckooFilter:
+		return val.clone()
+	case *timeSeries:
+		return val.clone()
+	}
+	return v
+}
+
+// del implements DEL and UNLINK, which are the same here since values are
+// freed by the garbage collector.
+func (kvdb *KeyValueDB) del(dbIndex int, cmd Command) interface{} {
+	deleted := 0
+	for _, key := range cmd.params() {
+		if kvdb.storage.Del(dbIndex, []byte(key)) == 1 {
+			kvdb.signalKey(dbIndex, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// exists implements EXISTS and TOUCH. A key named more than once is counted
+// every time.
+func (kvdb *KeyValueDB) exists(dbIndex int, cmd Command) interface{} {
+	found := 0
+	for _, key := range cmd.params() {
+		if kvdb.storage.Get(dbIndex, []byte(key)) != nil {
+			found++
+		}
+	}
+	return found
+}
+
+func (kvdb *KeyValueDB) keyType(dbIndex int, cmd Command) interface{} {
+	return valueType(kvdb.storage.Get(dbIndex, cmd.Argv[0]))
+}
+
+// rename implements RENAME and, with nx, RENAMENX, which leaves an existing
+// newkey alone.
+func (kvdb *KeyValueDB) rename(dbIndex int, cmd Command, nx bool) interface{} {
+	args := cmd.params()
+	key, newKey := args[0], args[1]
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	if v == nil {
+		return errNoSuchKey
+	}
+	if nx && kvdb.storage.Get(dbIndex, []byte(newKey)) != nil {
+		return 0
+	}
+	if key != newKey {
+		expireAt, _ := kvdb.storage.ExpireTime(dbIndex, []byte(key))
+		kvdb.storage.Del(dbIndex, []byte(key))
+		kvdb.storage.Set(dbIndex, []byte(newKey), v)
+		kvdb.storage.Expire(dbIndex, []byte(newKey), expireAt)
+		if ts, ok := v.(*timeSeries); ok {
+			kvdb.renameTSLinks(dbIndex, ts, key, newKey)
+		}
+		kvdb.signalKey(dbIndex, key)
+		kvdb.signalKey(dbIndex, newKey)
+	}
+	if nx {
+		return 1
+	}
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) copyKey(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	src, dest := args[0], args[1]
+	destDB, replace := dbIndex, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "DB":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			db, err := kvdb.storage.Select(args[i+1])
+			if err != nil {
+				return ErrorReply(err.Error())
+			}
+			destDB = db
+			i++
+		case "REPLACE":
+			replace = true
+		default:
+			return errSyntax
+		}
+	}
+	if src == dest && destDB == dbIndex {
+		return errSameObject
+	}
+
+	v := kvdb.storage.Get(dbIndex, []byte(src))
+	if v == nil {
+		return 0
+	}
+	if !replace && kvdb.storage.Get(destDB, []byte(dest)) != nil {
+		return 0
+	}
+	expireAt, _ := kvdb.storage.ExpireTime(dbIndex, []byte(src))
+	kvdb.storage.Set(destDB, []byte(dest), cloneValue(v))
+	kvdb.storage.Expire(destDB, []byte(dest), expireAt)
+	kvdb.touchKey(destDB, dest)
+	kvdb.signalKey(destDB, dest)
+	return 1
+}
+
+func (kvdb *KeyValueDB) move(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	key := args[0]
+	destDB, err := kvdb.storage.Select(args[1])
+	if err != nil {
+		return ErrorReply(err.Error())
+	}
+	if destDB == dbIndex {
+		return errSameObject
+	}
+
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	if v == nil || kvdb.storage.Get(destDB, []byte(key)) != nil {
+		return 0
+	}
+	if ts, ok := v.(*timeSeries); ok {
+		// Compaction rules only link keys of the same database.
+		kvdb.unlinkTS(dbIndex, ts, key)
+	}
+	expireAt, _ := kvdb.storage.ExpireTime(dbIndex, []byte(key))
+	kvdb.storage.Del(dbIndex, []byte(key))
+	kvdb.storage.Set(destDB, []byte(key), v)
+	kvdb.storage.Expire(destDB, []byte(key), expireAt)
+	kvdb.touchKey(destDB, key)
+	kvdb.signalKey(dbIndex, key)
+	kvdb.signalKey(destDB, key)
+	return 1
+}
+
+func (kvdb *KeyValueDB) randomKey(dbIndex int, cmd Command) interface{} {
+	key, ok := kvdb.storage.RandomKey(dbIndex)
+	if !ok {
+		return nil
+	}
+	return string(key)
+}
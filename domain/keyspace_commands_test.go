@@ -0,0 +1,260 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyspaceCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "DEL, UNLINK, EXISTS and TOUCH count keys",
+			commands: []Command{
+				NewCommand(SET, "a", "1"),
+				NewCommand(SET, "b", "2"),
+				NewCommand(SET, "c", "3"),
+				NewCommand(EXISTS, "a", "b", "a", "missing"),
+				NewCommand(TOUCH, "a", "missing"),
+				NewCommand(DEL, "a", "missing", "a"),
+				NewCommand(UNLINK, "b", "c"),
+				NewCommand(EXISTS, "a", "b", "c"),
+				NewCommand(DEL),
+			},
+			expected: []interface{}{
+				statusOK, statusOK, statusOK, 3, 1, 1, 2, 0,
+				ErrorReply("(error) ERR wrong number of arguments for 'del' command"),
+			},
+		},
+		{
+			name: "TYPE",
+			commands: []Command{
+				NewCommand(SET, "s", "v"),
+				NewCommand(XADD, "x", "1-1", "f", "v"),
+				NewCommand(GEOADD, "z", "1", "1", "m"),
+				NewCommand(JSON_SET, "j", "$", "1"),
+				NewCommand(BF_ADD, "bf", "a"),
+				NewCommand(CF_ADD, "cf", "a"),
+				NewCommand(TS_CREATE, "ts"),
+				NewCommand(SETBIT, "bits", "1", "1"),
+				NewCommand(TYPE, "s"),
+				NewCommand(TYPE, "x"),
+				NewCommand(TYPE, "z"),
+				NewCommand(TYPE, "j"),
+				NewCommand(TYPE, "bf"),
+				NewCommand(TYPE, "cf"),
+				NewCommand(TYPE, "ts"),
+				NewCommand(TYPE, "bits"),
+				NewCommand(TYPE, "missing"),
+			},
+			expected: []interface{}{
+				statusOK, "1-1", 1, statusOK, 1, 1, statusOK, 0,
+				"string", "stream", "zset", "ReJSON-RL", "MBbloom--", "MBbloomCF", "TSDB-TYPE", "string", "none",
+			},
+		},
+		{
+			name: "RENAME and RENAMENX",
+			commands: []Command{
+				NewCommand(SET, "a", "1"),
+				NewCommand(SET, "b", "2"),
+				NewCommand(RENAME, "missing", "x"),
+				NewCommand(RENAMENX, "a", "b"),
+				NewCommand(RENAME, "a", "b"),
+				NewCommand(GET, "a"),
+				NewCommand(GET, "b"),
+				NewCommand(RENAMENX, "b", "c"),
+				NewCommand(GET, "c"),
+				NewCommand(RENAME, "c", "c"),
+				NewCommand(RENAMENX, "c", "c"),
+				NewCommand(GET, "c"),
+			},
+			expected: []interface{}{
+				statusOK, statusOK, errNoSuchKey, 0, statusOK, nil, "1", 1, "1", statusOK, 0, "1",
+			},
+		},
+		{
+			name: "COPY",
+			commands: []Command{
+				NewCommand(SET, "a", "1"),
+				NewCommand(SET, "b", "2"),
+				NewCommand(COPY, "a", "b"),
+				NewCommand(COPY, "a", "b", "REPLACE"),
+				NewCommand(GET, "b"),
+				NewCommand(COPY, "a", "a"),
+				NewCommand(COPY, "a", "a", "DB", "1"),
+				NewCommand(COPY, "missing", "x"),
+				NewCommand(COPY, "a", "x", "DB", "7"),
+				NewCommand(COPY, "a", "x", "FORCE"),
+				NewCommand(SETBIT, "bits", "0", "1"),
+				NewCommand(COPY, "bits", "bits2"),
+				NewCommand(SETBIT, "bits2", "1", "1"),
+				NewCommand(BITCOUNT, "bits"),
+				NewCommand(BITCOUNT, "bits2"),
+			},
+			expected: []interface{}{
+				statusOK, statusOK, 0, 1, "1", errSameObject, 1, 0,
+				ErrorReply("(error) ERR DB index is out of range"), errSyntax,
+				0, 1, 0, 1, 2,
+			},
+		},
+		{
+			name: "MOVE",
+			commands: []Command{
+				NewCommand(SET, "a", "1"),
+				NewCommand(MOVE, "a", "0"),
+				NewCommand(MOVE, "a", "x"),
+				NewCommand(MOVE, "missing", "1"),
+				NewCommand(MOVE, "a", "1"),
+				NewCommand(EXISTS, "a"),
+				NewCommand(SELECT, "1"),
+				NewCommand(SET, "b", "x"),
+			},
+			expected: []interface{}{
+				statusOK, errSameObject, errNotInteger, 0, 1, 0, statusOK, statusOK,
+			},
+		},
+		{
+			name: "RANDOMKEY",
+			commands: []Command{
+				NewCommand(RANDOMKEY),
+				NewCommand(SET, "only", "1"),
+				NewCommand(RANDOMKEY),
+				NewCommand(RANDOMKEY, "x"),
+			},
+			expected: []interface{}{
+				nil, statusOK, "only",
+				ErrorReply("(error) ERR wrong number of arguments for 'randomkey' command"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMoveToOtherDatabase(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	kvdb.Execute(0, NewCommand(SET, "a", "1"))
+	kvdb.Execute(1, NewCommand(SET, "b", "2"))
+	kvdb.Execute(0, NewCommand(SET, "b", "3"))
+
+	if _, got := kvdb.Execute(0, NewCommand(MOVE, "a", "1")); got != 1 {
+		t.Errorf("MOVE a 1 = %v, expected 1", got)
+	}
+	if _, got := kvdb.Execute(0, NewCommand(MOVE, "b", "1")); got != 0 {
+		t.Errorf("MOVE onto an existing key = %v, expected 0", got)
+	}
+	if _, got := kvdb.Execute(1, NewCommand(GET, "a")); got != "1" {
+		t.Errorf("GET a in database 1 = %v, expected 1", got)
+	}
+	if _, got := kvdb.Execute(0, NewCommand(GET, "b")); got != "3" {
+		t.Errorf("GET b in database 0 = %v, expected 3", got)
+	}
+}
+
+func TestCopyIsIndependent(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	for _, cmd := range []Command{
+		NewCommand(XADD, "s", "1-1", "f", "v"),
+		NewCommand(XGROUP, "CREATE", "s", "g", "0"),
+		NewCommand(XREADGROUP, "GROUP", "g", "alice", "STREAMS", "s", ">"),
+		NewCommand(JSON_SET, "j", "$", `{"a":[1]}`),
+		NewCommand(TS_ADD, "ts", "1", "1"),
+		NewCommand(GEOADD, "z", "1", "1", "m"),
+	} {
+		kvdb.Execute(0, cmd)
+	}
+	for _, key := range []string{"s", "j", "ts", "z"} {
+		kvdb.Execute(0, NewCommand(COPY, key, key, "DB", "1"))
+	}
+
+	for _, cmd := range []Command{
+		NewCommand(XACK, "s", "g", "1-1"),
+		NewCommand(JSON_ARRAPPEND, "j", "$.a", "2"),
+		NewCommand(TS_ADD, "ts", "2", "2"),
+		NewCommand(GEOADD, "z", "2", "2", "n"),
+	} {
+		kvdb.Execute(1, cmd)
+	}
+
+	checks := []struct {
+		cmd      Command
+		expected interface{}
+	}{
+		{cmd: NewCommand(XPENDING, "s", "g"), expected: []interface{}{1, "1-1", "1-1", []interface{}{[]interface{}{"alice", "1"}}}},
+		{cmd: NewCommand(JSON_GET, "j"), expected: `{"a":[1]}`},
+		{cmd: NewCommand(TS_RANGE, "ts", "-", "+"), expected: []interface{}{[]interface{}{int64(1), "1"}}},
+		{cmd: NewCommand(GEOPOS, "z", "n"), expected: []interface{}{nil}},
+	}
+	for _, check := range checks {
+		if _, got := kvdb.Execute(0, check.cmd); !reflect.DeepEqual(got, check.expected) {
+			t.Errorf("command %v on the original returned %#v, expected %#v", check.cmd, got, check.expected)
+		}
+	}
+	if _, got := kvdb.Execute(1, NewCommand(XPENDING, "s", "g")); !reflect.DeepEqual(got, []interface{}{0, nil, nil, nil}) {
+		t.Errorf("XPENDING on the copy returned %#v", got)
+	}
+}
+
+func TestRenameKeepsCompactionRules(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	for _, cmd := range []Command{
+		NewCommand(TS_CREATE, "raw"),
+		NewCommand(TS_CREATE, "max"),
+		NewCommand(TS_CREATERULE, "raw", "max", "AGGREGATION", "max", "10"),
+		NewCommand(RENAME, "raw", "raw2"),
+		NewCommand(RENAME, "max", "max2"),
+		NewCommand(TS_ADD, "raw2", "1", "5"),
+		NewCommand(TS_ADD, "raw2", "11", "1"),
+	} {
+		kvdb.Execute(0, cmd)
+	}
+	_, got := kvdb.Execute(0, NewCommand(TS_RANGE, "max2", "-", "+"))
+	if want := []interface{}{[]interface{}{int64(0), "5"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TS.RANGE of the renamed destination = %v, expected %v", got, want)
+	}
+
+	// A new series under the old destination name is not fed by the rule.
+	kvdb.Execute(0, NewCommand(DEL, "max2"))
+	kvdb.Execute(0, NewCommand(TS_CREATE, "max2"))
+	kvdb.Execute(0, NewCommand(TS_ADD, "raw2", "21", "1"))
+	if _, got := kvdb.Execute(0, NewCommand(TS_RANGE, "max2", "-", "+")); !reflect.DeepEqual(got, []interface{}{}) {
+		t.Errorf("TS.RANGE of a replaced destination = %v, expected no samples", got)
+	}
+}
+
+func TestMoveDropsCompactionRules(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	for _, cmd := range []Command{
+		NewCommand(TS_CREATE, "raw"),
+		NewCommand(TS_CREATE, "max"),
+		NewCommand(TS_CREATERULE, "raw", "max", "AGGREGATION", "max", "10"),
+		NewCommand(MOVE, "max", "1"),
+		NewCommand(TS_CREATE, "max"),
+	} {
+		kvdb.Execute(0, cmd)
+	}
+	// The source no longer has a rule that a snapshot would link to the new
+	// series under the moved destination's name.
+	_, got := kvdb.Execute(0, NewCommand(COMPACT))
+	for _, line := range got.([]interface{}) {
+		if strings.HasPrefix(line.(string), "TS.CREATERULE") {
+			t.Errorf("COMPACT after moving the destination returned %q", line)
+		}
+	}
+}
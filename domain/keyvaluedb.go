@@ -2,114 +2,305 @@ package domain
 
 import (
 	"fmt"
+	"keyvaluedb/config"
 	"keyvaluedb/storage"
 	"strconv"
+	"strings"
+	"sync"
 )
 
+const (
+	errWrongType  = ErrorReply("(error) WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInteger = ErrorReply("(error) ERR value is not an integer or out of range")
+	errSyntax     = ErrorReply("(error) ERR syntax error")
+)
+
+// KeyValueDB is the engine every client runs its commands on. It is safe
+// for concurrent use; what belongs to a single client lives in its Session.
 type KeyValueDB struct {
-	storage             storage.Storage
-	isMultiBlockStarted bool
-	cmds                []Command
+	storage storage.Storage
+	// isExecuting is set while EXEC runs a transaction, which must not
+	// block.
+	isExecuting bool
+
+	// mu serialises commands across every session sharing the storage.
+	mu      *sync.Mutex
+	waiters *keyWaiters
+	stats   *serverStats
+	config  *config.Config
+	// shutdown asks the server to stop; SHUTDOWN fails when it is nil.
+	shutdown func()
+
+	// session runs the commands given to Execute. sessions holds those
+	// of connected clients, and watchers the sessions watching each key.
+	session       *Session
+	sessions      map[int64]*Session
+	lastSessionID int64
+	watchers      map[waitKey]map[*Session]struct{}
+	// pause is the CLIENT PAUSE in effect, if any.
+	pause *clientPause
+	acl   *accessList
+}
+
+func NewKeyValueDB(storage storage.Storage) *KeyValueDB {
+	return &KeyValueDB{
+		storage:  storage,
+		mu:       &sync.Mutex{},
+		waiters:  newKeyWaiters(),
+		stats:    newServerStats(),
+		config:   config.New(),
+		session:  newSession(0, Conn{}),
+		sessions: make(map[int64]*Session),
+		watchers: make(map[waitKey]map[*Session]struct{}),
+		acl:      newAccessList(),
+	}
+}
+
+// SetConfig replaces the default settings. It must be called before the
+// KeyValueDB is shared between connections.
+func (kvdb *KeyValueDB) SetConfig(cfg *config.Config) {
+	kvdb.config = cfg
+}
+
+// OnShutdown sets how SHUTDOWN stops the server. fn is called with the
+// database locked, so it must only start the shutdown and not wait for it.
+// It must be called before the KeyValueDB is shared between connections.
+func (kvdb *KeyValueDB) OnShutdown(fn func()) {
+	kvdb.shutdown = fn
+}
+
+// Config returns the settings CONFIG reads and changes.
+func (kvdb *KeyValueDB) Config() *config.Config {
+	return kvdb.config
 }
 
-func NewKeyValueDB(storage storage.Storage) KeyValueDB {
-	return KeyValueDB{storage: storage}
+// expireCycleLimit bounds the keys of each database one call to
+// DeleteExpired deletes, so that it holds the lock only briefly.
+const expireCycleLimit = 1000
+
+// DeleteExpired frees the keys that expired without being looked up since,
+// up to expireCycleLimit of them in each database, and returns how many it
+// deleted. A Server calls it periodically.
+func (kvdb *KeyValueDB) DeleteExpired() int {
+	kvdb.mu.Lock()
+	defer kvdb.mu.Unlock()
+	deleted := 0
+	for idx := 0; idx < kvdb.storage.DBCount(); idx++ {
+		deleted += kvdb.storage.DeleteExpired(idx, expireCycleLimit)
+	}
+	return deleted
 }
 
+// Execute runs cmd against database dbIndex outside of any client
+// session, and returns the database selected afterwards with the reply.
+// Transactions started by Execute are shared by all its callers.
 func (kvdb *KeyValueDB) Execute(dbIndex int, cmd Command) (int, interface{}) {
+	kvdb.mu.Lock()
+	defer kvdb.mu.Unlock()
+	return kvdb.execute(kvdb.session, dbIndex, cmd)
+}
+
+func (kvdb *KeyValueDB) execute(s *Session, dbIndex int, cmd Command) (int, interface{}) {
 	_, err := cmd.Validate()
 	if err != nil {
-		return dbIndex, err.Error()
+		return dbIndex, ErrorReply(err.Error())
 	}
 
-	if kvdb.isMultiBlockStarted && !cmd.isTerminatorCmd() {
-		kvdb.enqueue(cmd)
-		return dbIndex, "QUEUED"
+	spec, _ := lookupCommand(cmd.Name)
+	if denied := kvdb.checkAccess(s, dbIndex, cmd, spec); denied != "" {
+		return dbIndex, denied
 	}
 
-	switch cmd.Name {
-	case SELECT:
-		dbIndex, err = kvdb.storage.Select(cmd.Key)
-		if err != nil {
-			return dbIndex, err.Error()
-		}
-		return dbIndex, "OK"
-	case MULTI:
-		kvdb.isMultiBlockStarted = true
-		return dbIndex, "OK"
-	case DISCARD:
-		kvdb.isMultiBlockStarted = false
-		kvdb.cmds = nil
-		return dbIndex, "OK"
-	case EXEC:
-		kvdb.isMultiBlockStarted = false
-		return dbIndex, kvdb.executeCommands(dbIndex)
-	case COMPACT:
-		var outputs []interface{}
-		for keyVal := range kvdb.storage.GetAll(dbIndex) {
-			outputs = append(outputs, fmt.Sprintf("SET %s", keyVal))
-		}
-		return dbIndex, outputs
-	case SET:
-		kvdb.storage.Set(dbIndex, cmd.Key, cmd.Value)
-		return dbIndex, "OK"
-	case GET:
-		return dbIndex, kvdb.storage.Get(dbIndex, cmd.Key)
-	case DEL:
-		return dbIndex, kvdb.storage.Del(dbIndex, cmd.Key)
-	case INCR:
-		v := kvdb.storage.Get(dbIndex, cmd.Key)
-		if v == nil {
-			newResult := "1"
-			kvdb.storage.Set(dbIndex, cmd.Key, newResult)
-			return dbIndex, newResult
-		}
+	if s.inMulti && !cmd.runsInMulti() {
+		s.queue = append(s.queue, cmd)
+		return dbIndex, statusQueued
+	}
 
-		currentValue, err := strconv.Atoi(v.(string))
-		if err != nil {
-			return dbIndex, "(error) ERR value is not an integer or out of range"
-		}
+	kvdb.recordLookups(dbIndex, cmd, spec)
+	ctx := &CommandContext{DBIndex: dbIndex, kvdb: kvdb, session: s}
+	reply := spec.Handler(ctx, cmd)
+	kvdb.recordCommand(spec, reply)
+	if !isErrorReply(reply) {
+		kvdb.touchKeys(dbIndex, cmd, spec)
+	}
+	s.dbIndex = ctx.DBIndex
+	return ctx.DBIndex, reply
+}
 
-		incrementedValue := fmt.Sprintf("%v", currentValue+1)
-		kvdb.storage.Set(dbIndex, cmd.Key, incrementedValue)
-		return dbIndex, incrementedValue
-	case INCRBY:
-		v := kvdb.storage.Get(dbIndex, cmd.Key)
-		if v == nil {
-			newResult := cmd.Value
-			kvdb.storage.Set(dbIndex, cmd.Key, newResult)
-			return dbIndex, newResult
-		}
+func selectDB(ctx *CommandContext, cmd Command) interface{} {
+	dbIndex, err := ctx.kvdb.storage.Select(cmd.arg(0))
+	if err != nil {
+		return ErrorReply(err.Error())
+	}
+	ctx.DBIndex = dbIndex
+	return statusOK
+}
 
-		currentValue, err := strconv.Atoi(v.(string))
-		if err != nil {
-			return dbIndex, "(error) ERR value is not an integer or out of range"
-		}
+func multi(ctx *CommandContext, cmd Command) interface{} {
+	ctx.session.inMulti = true
+	return statusOK
+}
+
+func discard(ctx *CommandContext, cmd Command) interface{} {
+	ctx.session.inMulti = false
+	ctx.session.queue = nil
+	ctx.kvdb.unwatch(ctx.session)
+	return statusOK
+}
 
-		resultValue, err := strconv.Atoi(cmd.Value.(string))
-		if err != nil {
-			return dbIndex, "(error) ERR value is not an integer or out of range"
+func exec(ctx *CommandContext, cmd Command) interface{} {
+	s := ctx.session
+	s.inMulti = false
+	queue := s.queue
+	s.queue = nil
+	// A transaction whose watched keys changed is not run at all.
+	dirty := s.dirty
+	ctx.kvdb.unwatch(s)
+	if dirty {
+		return nil
+	}
+	var outputs []interface{}
+	ctx.DBIndex, outputs = ctx.kvdb.executeCommands(s, ctx.DBIndex, queue)
+	return outputs
+}
+
+func (kvdb *KeyValueDB) compact(dbIndex int, cmd Command) interface{} {
+	var outputs []interface{}
+	keys := kvdb.storage.Keys(dbIndex)
+	for _, key := range keys {
+		for _, line := range compactValue(string(key), kvdb.storage.Get(dbIndex, key)) {
+			outputs = append(outputs, line)
+		}
+		if at, ok := kvdb.storage.ExpireTime(dbIndex, key); ok {
+			outputs = append(outputs, fmt.Sprintf("GETEX %s PXAT %d", compactArg(string(key)), at.UnixMilli()))
+		}
+	}
+	for _, key := range keys {
+		for _, line := range compactLinks(string(key), kvdb.storage.Get(dbIndex, key)) {
+			outputs = append(outputs, line)
 		}
+	}
+	return outputs
+}
 
-		incrementedValue := fmt.Sprintf("%v", currentValue+resultValue)
+// executeCommands runs the commands of a transaction in order, so that a
+// SELECT among them applies to those after it.
+func (kvdb *KeyValueDB) executeCommands(s *Session, dbIndex int, cmds []Command) (int, []interface{}) {
+	var outputs []interface{}
+	kvdb.isExecuting = true
+	for _, cmd := range cmds {
+		var result interface{}
+		dbIndex, result = kvdb.execute(s, dbIndex, cmd)
+		outputs = append(outputs, result)
+	}
+	kvdb.isExecuting = false
+	return dbIndex, outputs
+}
 
-		kvdb.storage.Set(dbIndex, cmd.Key, incrementedValue)
-		return dbIndex, incrementedValue
+// isErrorReply reports whether a command failed with reply.
+func isErrorReply(reply interface{}) bool {
+	_, ok := reply.(ErrorReply)
+	return ok
+}
+
+// stringValue returns the contents of a string value. Strings are stored as
+// byte slices, or as int64 once INCR and its relatives have counted with
+// them.
+func stringValue(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case []byte:
+		return string(val), true
+	case int64:
+		return strconv.FormatInt(val, 10), true
 	}
+	return "", false
+}
 
-	return dbIndex, fmt.Errorf("(error) ERR unknown command '%s'", cmd.Key)
+// valueType returns the name Redis gives to the type of value v.
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "none"
+	case *stream:
+		return "stream"
+	case *sortedSet:
+		return "zset"
+	case *jsonDoc:
+		return "ReJSON-RL"
+	case *bloomFilter:
+		return "MBbloom--"
+	case *cuckooFilter:
+		return "MBbloomCF"
+	case *timeSeries:
+		return "TSDB-TYPE"
+	}
+	return "string"
 }
 
-func (kvdb *KeyValueDB) enqueue(cmd Command) {
-	kvdb.cmds = append(kvdb.cmds, cmd)
+// compactValue returns the commands that recreate key with value v.
+func compactValue(key string, v interface{}) []string {
+	switch val := v.(type) {
+	case *stream:
+		return compactStream(key, val)
+	case *sortedSet:
+		return compactSortedSet(key, val)
+	case *jsonDoc:
+		return compactJSON(key, val)
+	case *bloomFilter:
+		return compactFilter(BF_LOADCHUNK, key, val)
+	case *cuckooFilter:
+		return compactFilter(CF_LOADCHUNK, key, val)
+	case *timeSeries:
+		return compactTimeSeries(key, val)
+	case []byte:
+		return []string{fmt.Sprintf("SET %s %s", compactArg(key), compactArg(string(val)))}
+	}
+	return []string{fmt.Sprintf("SET %s %v", compactArg(key), compactArg(fmt.Sprintf("%v", v)))}
 }
 
-func (kvdb *KeyValueDB) executeCommands(dbIndex int) interface{} {
-	var outputs []interface{}
-	for _, cmd := range kvdb.cmds {
-		_, result := kvdb.Execute(dbIndex, cmd)
-		outputs = append(outputs, result)
+// compactLinks returns the commands that tie key to other keys. They are
+// written after every key, so the keys they name exist when replayed.
+func compactLinks(key string, v interface{}) []string {
+	if ts, ok := v.(*timeSeries); ok {
+		return compactTimeSeriesRules(key, ts)
 	}
-	kvdb.cmds = nil
-	return outputs
+	return nil
+}
+
+// compactArg quotes an argument when it would not survive being read back
+// as a single word, escaping the bytes an inline command cannot hold as
+// they are.
+func compactArg(arg string) string {
+	plain := arg != ""
+	for i := 0; i < len(arg) && plain; i++ {
+		c := arg[i]
+		plain = c > ' ' && c < 0x7f && c != '"' && c != '\'' && c != '\\'
+	}
+	if plain {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < ' ' || c >= 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
 }
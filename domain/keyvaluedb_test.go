@@ -17,14 +17,14 @@ func TestKeyValueDBExecute(t *testing.T) {
 			commands: []Command{
 				NewCommand(SET, "foo", "bar"),
 			},
-			expected: []interface{}{"OK"},
+			expected: []interface{}{statusOK},
 		},
 		{
 			name: "Set with invalid argument",
 			commands: []Command{
 				NewCommand(SET, "foo"),
 			},
-			expected: []interface{}{"(error) ERR wrong number of arguments for 'set' command"},
+			expected: []interface{}{ErrorReply("(error) ERR wrong number of arguments for 'set' command")},
 		},
 		{
 			name: "Get for nonexisting key",
@@ -39,14 +39,14 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(SET, "foo", "bar"),
 				NewCommand(GET, "foo"),
 			},
-			expected: []interface{}{"OK", "bar"},
+			expected: []interface{}{statusOK, "bar"},
 		},
 		{
 			name: "Get with invalid argument",
 			commands: []Command{
-				NewCommand(GET, ""),
+				NewCommand(GET),
 			},
-			expected: []interface{}{"(error) ERR wrong number of arguments for 'get' command"},
+			expected: []interface{}{ErrorReply("(error) ERR wrong number of arguments for 'get' command")},
 		},
 		{
 			name: "Delete for nonexisting key",
@@ -62,7 +62,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(DEL, "foo"),
 				NewCommand(GET, "foo"),
 			},
-			expected: []interface{}{"OK", 1, nil},
+			expected: []interface{}{statusOK, 1, nil},
 		},
 		{
 			name: "Increment for nonexisting key",
@@ -79,7 +79,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(INCR, "counter"),
 				NewCommand(GET, "counter"),
 			},
-			expected: []interface{}{"OK", "4", "4"},
+			expected: []interface{}{statusOK, "4", "4"},
 		},
 		{
 			name: "Increment non-integer value",
@@ -87,7 +87,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(SET, "counter", "non-integer"),
 				NewCommand(INCR, "counter"),
 			},
-			expected: []interface{}{"OK", "(error) ERR value is not an integer or out of range"},
+			expected: []interface{}{statusOK, ErrorReply("(error) ERR value is not an integer or out of range")},
 		},
 		{
 			name: "IncrementBy for nonexisting key",
@@ -103,7 +103,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(INCRBY, "counter", nil),
 				NewCommand(GET, "counter"),
 			},
-			expected: []interface{}{"(error) ERR wrong number of arguments for 'incrby' command", nil},
+			expected: []interface{}{ErrorReply("(error) ERR wrong number of arguments for 'incrby' command"), nil},
 		},
 		{
 			name: "IncrementBy",
@@ -112,7 +112,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(INCRBY, "counter", "10"),
 				NewCommand(GET, "counter"),
 			},
-			expected: []interface{}{"OK", "13", "13"},
+			expected: []interface{}{statusOK, "13", "13"},
 		},
 		{
 			name: "IncrementBy integer value for exiting non-integer value",
@@ -120,7 +120,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(SET, "counter", "non-integer"),
 				NewCommand(INCRBY, "counter", "10"),
 			},
-			expected: []interface{}{"OK", "(error) ERR value is not an integer or out of range"},
+			expected: []interface{}{statusOK, ErrorReply("(error) ERR value is not an integer or out of range")},
 		},
 		{
 			name: "IncrementBy non-integer value for exiting integer value",
@@ -128,7 +128,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(SET, "counter", "10"),
 				NewCommand(INCRBY, "counter", "non-integer"),
 			},
-			expected: []interface{}{"OK", "(error) ERR value is not an integer or out of range"},
+			expected: []interface{}{statusOK, ErrorReply("(error) ERR value is not an integer or out of range")},
 		},
 		{
 			name: "SetAndGetMultipleKeys",
@@ -138,7 +138,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(GET, "foo"),
 				NewCommand(GET, "baz"),
 			},
-			expected: []interface{}{"OK", "OK", "bar", "qux"},
+			expected: []interface{}{statusOK, statusOK, "bar", "qux"},
 		},
 		{
 			name: "MultiBlock",
@@ -148,7 +148,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(GET, "foo"),
 				NewCommand(EXEC),
 			},
-			expected: []interface{}{"OK", "QUEUED", "QUEUED", []interface{}{"OK", "bar"}},
+			expected: []interface{}{statusOK, statusQueued, statusQueued, []interface{}{statusOK, "bar"}},
 		},
 		{
 			name: "MultiBlock with error in one of the command in the transaction",
@@ -159,7 +159,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(INCR, "foo"),
 				NewCommand(EXEC),
 			},
-			expected: []interface{}{"OK", "QUEUED", "QUEUED", "QUEUED", []interface{}{"OK", "bar", "(error) ERR value is not an integer or out of range"}},
+			expected: []interface{}{statusOK, statusQueued, statusQueued, statusQueued, []interface{}{statusOK, "bar", ErrorReply("(error) ERR value is not an integer or out of range")}},
 		},
 		{
 			name: "DiscardMultiBlock",
@@ -169,7 +169,7 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(DISCARD),
 				NewCommand(GET, "foo"),
 			},
-			expected: []interface{}{"OK", "QUEUED", "OK", nil},
+			expected: []interface{}{statusOK, statusQueued, statusOK, nil},
 		},
 		{
 			name: "Compact",
@@ -178,42 +178,42 @@ func TestKeyValueDBExecute(t *testing.T) {
 				NewCommand(SET, "baz", "qux"),
 				NewCommand(COMPACT),
 			},
-			expected: []interface{}{"OK", "OK", []interface{}{"SET foo bar", "SET baz qux"}},
+			expected: []interface{}{statusOK, statusOK, []interface{}{"SET foo bar", "SET baz qux"}},
 		},
 		{
 			name: "Select with wrong number of arguments",
 			commands: []Command{
 				NewCommand(SELECT),
 			},
-			expected: []interface{}{"(error) ERR wrong number of arguments for 'select' command"},
+			expected: []interface{}{ErrorReply("(error) ERR wrong number of arguments for 'select' command")},
 		},
 		{
 			name: "Select with invalid database index",
 			commands: []Command{
 				NewCommand(SELECT, "invalid"),
 			},
-			expected: []interface{}{"(error) ERR value is not an integer or out of range"},
+			expected: []interface{}{ErrorReply("(error) ERR value is not an integer or out of range")},
 		},
 		{
 			name: "Select with out of range database index",
 			commands: []Command{
 				NewCommand(SELECT, "40"),
 			},
-			expected: []interface{}{"(error) ERR DB index is out of range"},
+			expected: []interface{}{ErrorReply("(error) ERR DB index is out of range")},
 		},
 		{
 			name: "Select valid database",
 			commands: []Command{
 				NewCommand(SELECT, "1"),
 			},
-			expected: []interface{}{"OK"},
+			expected: []interface{}{statusOK},
 		},
 		{
 			name: "Invalid command",
 			commands: []Command{
 				NewCommand("INVALID", "foo", "bar"),
 			},
-			expected: []interface{}{"(error) ERR unknown command `INVALID`, with args beginning with: `foo`, `bar`,"},
+			expected: []interface{}{ErrorReply("(error) ERR unknown command `INVALID`, with args beginning with: `foo`, `bar`,")},
 		},
 	}
 
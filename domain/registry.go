@@ -0,0 +1,163 @@
+package domain
+
+import (
+	"fmt"
+	"keyvaluedb/storage"
+	"strings"
+)
+
+// CommandFlags describe how a command behaves, for callers that route,
+// restrict or list commands.
+type CommandFlags uint
+
+const (
+	// FlagWrite marks commands that may change data.
+	FlagWrite CommandFlags = 1 << iota
+	// FlagReadOnly marks commands that only read data.
+	FlagReadOnly
+	// FlagAdmin marks commands meant for operators rather than applications.
+	FlagAdmin
+	// FlagBlocking marks commands that may wait for other clients.
+	FlagBlocking
+	// FlagNoScript marks commands that cannot run inside a script.
+	FlagNoScript
+)
+
+// flagNames lists the names COMMAND INFO gives the flags, in bit order.
+var flagNames = []string{"write", "readonly", "admin", "blocking", "noscript"}
+
+// names returns the names of the flags that are set.
+func (f CommandFlags) names() []string {
+	var names []string
+	for i, name := range flagNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// KeySpec locates the key arguments of a command the way Redis does.
+// Positions count the command name as 0, a negative Last counts back from
+// the final argument, and a zero First means the command takes no keys.
+type KeySpec struct {
+	First, Last, Step int
+}
+
+var (
+	noKeys   = KeySpec{}
+	firstKey = KeySpec{1, 1, 1}
+	twoKeys  = KeySpec{1, 2, 1}
+	allKeys  = KeySpec{1, -1, 1}
+	keyPairs = KeySpec{1, -1, 2}
+)
+
+// find returns the arguments at the positions the spec names.
+func (k KeySpec) find(args []string) []string {
+	if k.First <= 0 || k.Step <= 0 {
+		return nil
+	}
+	last := k.Last
+	if last < 0 {
+		last += len(args) + 1
+	}
+	var keys []string
+	for pos := k.First; pos <= last && pos <= len(args); pos += k.Step {
+		keys = append(keys, args[pos-1])
+	}
+	return keys
+}
+
+// CommandContext is what a handler sees of the client running a command.
+type CommandContext struct {
+	// DBIndex is the selected database. A handler that changes it, as
+	// SELECT does, switches the client to that database.
+	DBIndex int
+
+	kvdb    *KeyValueDB
+	session *Session
+}
+
+// Session returns the session of the client running the command.
+func (ctx *CommandContext) Session() *Session {
+	return ctx.session
+}
+
+// Storage returns the storage the command runs against.
+func (ctx *CommandContext) Storage() storage.Storage {
+	return ctx.kvdb.storage
+}
+
+// SignalKey tells clients blocked on key in the selected database that it
+// changed. Handlers call it after every write.
+func (ctx *CommandContext) SignalKey(key string) {
+	ctx.kvdb.signalKey(ctx.DBIndex, key)
+}
+
+// Handler runs a command whose arguments have been checked against its
+// spec and returns the reply. A reply is nil, an int or int64, a string or
+// []byte sent as a bulk string whatever it holds, an ErrorReply, a
+// StatusReply, or a []interface{} of replies.
+type Handler func(ctx *CommandContext, cmd Command) interface{}
+
+// ErrorReply is the reply of a command that failed. Its text is printed as
+// is, and starts with "(error) " and the error code, such as "(error) ERR
+// syntax error".
+type ErrorReply string
+
+func (e ErrorReply) Error() string {
+	return string(e)
+}
+
+// StatusReply is a short reply, such as OK, that RESP sends as a simple
+// string rather than as a bulk string.
+type StatusReply string
+
+const (
+	statusOK     StatusReply = "OK"
+	statusQueued StatusReply = "QUEUED"
+)
+
+// CommandSpec describes a command and how to run it.
+type CommandSpec struct {
+	Name string
+	// MinArgs and MaxArgs bound the number of arguments after the name. A
+	// negative MaxArgs means there is no upper bound.
+	MinArgs, MaxArgs int
+	Flags            CommandFlags
+	Keys             KeySpec
+	// FindKeys, when set, returns the keys of a command whose key positions
+	// depend on its other arguments, and takes precedence over Keys.
+	FindKeys func(args []string) []string
+	// Group and Summary document the command for COMMAND DOCS.
+	Group   string
+	Summary string
+	Handler Handler
+}
+
+// registry holds every known command by upper-case name.
+var registry = make(map[string]*CommandSpec)
+
+// RegisterCommand adds a command that every KeyValueDB will run. Commands
+// are looked up without locking, so they must be registered before any
+// client is served, typically from an init function.
+func RegisterCommand(spec CommandSpec) error {
+	spec.Name = strings.ToUpper(spec.Name)
+	if spec.Name == "" {
+		return fmt.Errorf("command has no name")
+	}
+	if spec.Handler == nil {
+		return fmt.Errorf("command %s has no handler", spec.Name)
+	}
+	if _, ok := registry[spec.Name]; ok {
+		return fmt.Errorf("command %s is already registered", spec.Name)
+	}
+	registry[spec.Name] = &spec
+	return nil
+}
+
+// lookupCommand returns the spec of the named command.
+func lookupCommand(name string) (*CommandSpec, bool) {
+	spec, ok := registry[strings.ToUpper(name)]
+	return spec, ok
+}
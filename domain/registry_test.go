@@ -0,0 +1,72 @@
+package domain_test
+
+import (
+	"keyvaluedb/domain"
+	"keyvaluedb/storage"
+	"reflect"
+	"testing"
+)
+
+// getOrDefault is registered the way a package outside domain would add a
+// command of its own.
+func getOrDefault(ctx *domain.CommandContext, cmd domain.Command) interface{} {
+	v := ctx.Storage().Get(ctx.DBIndex, cmd.Argv[0])
+	if v == nil {
+		return string(cmd.Argv[1])
+	}
+	return v
+}
+
+func TestRegisterCommand(t *testing.T) {
+	err := domain.RegisterCommand(domain.CommandSpec{
+		Name:    "getordefault",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Flags:   domain.FlagReadOnly,
+		Keys:    domain.KeySpec{First: 1, Last: 1, Step: 1},
+		Handler: getOrDefault,
+	})
+	if err != nil {
+		t.Fatalf("RegisterCommand() = %v", err)
+	}
+
+	kvdb := domain.NewKeyValueDB(storage.NewInMemory("1"))
+	tests := []struct {
+		cmd      domain.Command
+		expected interface{}
+	}{
+		{cmd: domain.NewCommand("GETORDEFAULT", "k", "fallback"), expected: "fallback"},
+		{cmd: domain.NewCommand(domain.SET, "k", "v"), expected: domain.StatusReply("OK")},
+		{cmd: domain.NewCommand("GETORDEFAULT", "k", "fallback"), expected: []byte("v")},
+		{cmd: domain.NewCommand("GETORDEFAULT", "k"), expected: domain.ErrorReply("(error) ERR wrong number of arguments for 'getordefault' command")},
+		{cmd: domain.NewCommand(domain.MULTI), expected: domain.StatusReply("OK")},
+		{cmd: domain.NewCommand("GETORDEFAULT", "other", "x"), expected: domain.StatusReply("QUEUED")},
+		{cmd: domain.NewCommand(domain.EXEC), expected: []interface{}{"x"}},
+	}
+	for _, test := range tests {
+		if _, got := kvdb.Execute(0, test.cmd); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("command %v returned %#v, expected %#v", test.cmd, got, test.expected)
+		}
+	}
+	if got := domain.NewCommand("GETORDEFAULT", "k", "fallback").Keys(); !reflect.DeepEqual(got, []string{"k"}) {
+		t.Errorf("Keys() = %v, expected [k]", got)
+	}
+}
+
+func TestRegisterCommandErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec domain.CommandSpec
+	}{
+		{name: "no name", spec: domain.CommandSpec{Handler: getOrDefault}},
+		{name: "no handler", spec: domain.CommandSpec{Name: "NOHANDLER"}},
+		{name: "built-in name", spec: domain.CommandSpec{Name: "set", Handler: getOrDefault}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := domain.RegisterCommand(test.spec); err == nil {
+				t.Errorf("RegisterCommand() accepted a command with %s", test.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package domain
+
+import (
+	"strconv"
+	"strings"
+)
+
+const scanDefaultCount = 10
+
+// scanOptions holds the arguments shared by SCAN and ZSCAN.
+type scanOptions struct {
+	cursor    uint64
+	match     string
+	count     int
+	valueType string
+}
+
+// parseScan parses `cursor [MATCH pattern] [COUNT count]`, and `[TYPE type]`
+// when withType is set.
+func parseScan(args []string, withType bool) (scanOptions, ErrorReply) {
+	opts := scanOptions{count: scanDefaultCount}
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return opts, ErrorReply("(error) ERR invalid cursor")
+	}
+	opts.cursor = cursor
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			opts.match = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if n < 1 {
+				return opts, errSyntax
+			}
+			opts.count = n
+		case "TYPE":
+			if !withType {
+				return opts, errSyntax
+			}
+			opts.valueType = strings.ToLower(args[i+1])
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, ""
+}
+
+// matches reports whether key passes the MATCH filter.
+func (opts scanOptions) matches(key string) bool {
+	return opts.match == "" || globMatch(opts.match, key)
+}
+
+func scanReply(cursor uint64, items []interface{}) interface{} {
+	return []interface{}{strconv.FormatUint(cursor, 10), items}
+}
+
+func (kvdb *KeyValueDB) keys(dbIndex int, cmd Command) interface{} {
+	reply := []interface{}{}
+	for _, k := range kvdb.storage.Keys(dbIndex) {
+		if key := string(k); globMatch(cmd.arg(0), key) {
+			reply = append(reply, key)
+		}
+	}
+	return reply
+}
+
+// scan walks the keyspace with the cursor of storage.Storage.Scan. MATCH and
+// TYPE filter the keys of the buckets visited, so a call may return fewer
+// keys than COUNT, or none, before the walk is complete.
+func (kvdb *KeyValueDB) scan(dbIndex int, cmd Command) interface{} {
+	opts, errMsg := parseScan(cmd.params(), true)
+	if errMsg != "" {
+		return errMsg
+	}
+	cursor, keys := kvdb.storage.Scan(dbIndex, opts.cursor, opts.count)
+	items := []interface{}{}
+	for _, k := range keys {
+		key := string(k)
+		if !opts.matches(key) {
+			continue
+		}
+		if opts.valueType != "" && strings.ToLower(valueType(kvdb.storage.Get(dbIndex, k))) != opts.valueType {
+			continue
+		}
+		items = append(items, key)
+	}
+	return scanReply(cursor, items)
+}
+
+func (kvdb *KeyValueDB) zscan(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	z, ok := kvdb.lookupSortedSet(dbIndex, args[0])
+	if !ok {
+		return errWrongType
+	}
+	opts, errMsg := parseScan(args[1:], false)
+	if errMsg != "" {
+		return errMsg
+	}
+	if z == nil {
+		return scanReply(0, []interface{}{})
+	}
+	cursor, members := z.scan(opts.cursor, opts.count)
+	items := []interface{}{}
+	for _, member := range members {
+		if !opts.matches(member) {
+			continue
+		}
+		score, _ := z.score(member)
+		items = append(items, member, strconv.FormatFloat(score, 'f', -1, 64))
+	}
+	return scanReply(cursor, items)
+}
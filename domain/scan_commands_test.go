@@ -0,0 +1,132 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestKeysCommand(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	for _, key := range []string{"user:1", "user:2", "user:10", "session:1", "h*llo"} {
+		kvdb.Execute(0, NewCommand(SET, key, "v"))
+	}
+
+	tests := []struct {
+		pattern  string
+		expected []interface{}
+	}{
+		{pattern: "*", expected: []interface{}{"user:1", "user:2", "user:10", "session:1", "h*llo"}},
+		{pattern: "user:?", expected: []interface{}{"user:1", "user:2"}},
+		{pattern: "*:1*", expected: []interface{}{"user:1", "user:10", "session:1"}},
+		{pattern: `h\*llo`, expected: []interface{}{"h*llo"}},
+		{pattern: "nothing", expected: []interface{}{}},
+	}
+	for _, test := range tests {
+		if _, got := kvdb.Execute(0, NewCommand(KEYS, test.pattern)); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("KEYS %s = %v, expected %v", test.pattern, got, test.expected)
+		}
+	}
+}
+
+// scanAll runs a scan command until its cursor is back to 0 and returns
+// every item it replied with, sorted.
+func scanAll(t *testing.T, kvdb *KeyValueDB, name string, args ...interface{}) []string {
+	t.Helper()
+	var items []string
+	cursor := "0"
+	for {
+		cmdArgs := append([]interface{}{cursor}, args...)
+		if name == ZSCAN {
+			cmdArgs = append([]interface{}{args[0], cursor}, args[1:]...)
+		}
+		_, res := kvdb.Execute(0, NewCommand(name, cmdArgs...))
+		reply, ok := res.([]interface{})
+		if !ok {
+			t.Fatalf("%s returned %v", name, res)
+		}
+		for _, item := range reply[1].([]interface{}) {
+			items = append(items, item.(string))
+		}
+		if cursor = reply[0].(string); cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(items)
+	return items
+}
+
+func TestScanCommand(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	var want []string
+	for i := 0; i < 100; i++ {
+		key := "key:" + strconv.Itoa(i)
+		kvdb.Execute(0, NewCommand(SET, key, "v"))
+		want = append(want, key)
+	}
+	kvdb.Execute(0, NewCommand(XADD, "events", "*", "a", "1"))
+	sort.Strings(want)
+
+	if got := scanAll(t, kvdb, SCAN, "COUNT", "7", "MATCH", "key:*"); !reflect.DeepEqual(got, want) {
+		t.Errorf("SCAN MATCH key:* returned %d keys, want %d", len(got), len(want))
+	}
+	if got := scanAll(t, kvdb, SCAN, "MATCH", "key:9?"); len(got) != 10 {
+		t.Errorf("SCAN MATCH key:9? = %v", got)
+	}
+	if got := scanAll(t, kvdb, SCAN, "TYPE", "stream"); !reflect.DeepEqual(got, []string{"events"}) {
+		t.Errorf("SCAN TYPE stream = %v", got)
+	}
+
+	errors := []struct {
+		args     []interface{}
+		expected interface{}
+	}{
+		{args: []interface{}{"x"}, expected: ErrorReply("(error) ERR invalid cursor")},
+		{args: []interface{}{"0", "COUNT", "0"}, expected: errSyntax},
+		{args: []interface{}{"0", "COUNT", "x"}, expected: errNotInteger},
+		{args: []interface{}{"0", "MATCH"}, expected: errSyntax},
+		{args: []interface{}{"0", "LIMIT", "1"}, expected: errSyntax},
+	}
+	for _, test := range errors {
+		if _, got := kvdb.Execute(0, NewCommand(SCAN, test.args...)); got != test.expected {
+			t.Errorf("SCAN %v = %v, expected %v", test.args, got, test.expected)
+		}
+	}
+}
+
+func TestScanEmptyDatabase(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	_, got := kvdb.Execute(0, NewCommand(SCAN, "0"))
+	if want := []interface{}{"0", []interface{}{}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SCAN 0 = %v, expected %v", got, want)
+	}
+}
+
+func TestZScanCommand(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(GEOADD, "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"))
+	for i := 0; i < 50; i++ {
+		kvdb.Execute(0, NewCommand(GEOADD, "places", "0", "0", "p"+strconv.Itoa(i)))
+	}
+	kvdb.Execute(0, NewCommand(SET, "s", "v"))
+
+	got := scanAll(t, kvdb, ZSCAN, "Sicily")
+	want := []string{"3479099956230698", "3479447370796909", "Catania", "Palermo"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ZSCAN Sicily = %v, expected %v", got, want)
+	}
+	if got := scanAll(t, kvdb, ZSCAN, "places", "MATCH", "p1*", "COUNT", "3"); len(got) != 22 {
+		t.Errorf("ZSCAN places MATCH p1* returned %d items, expected 22", len(got))
+	}
+	if got := scanAll(t, kvdb, ZSCAN, "missing"); len(got) != 0 {
+		t.Errorf("ZSCAN of a missing key = %v", got)
+	}
+	if _, got := kvdb.Execute(0, NewCommand(ZSCAN, "s", "0")); got != errWrongType {
+		t.Errorf("ZSCAN of a string = %v, expected %v", got, errWrongType)
+	}
+	if _, got := kvdb.Execute(0, NewCommand(ZSCAN, "Sicily", "0", "TYPE", "zset")); got != errSyntax {
+		t.Errorf("ZSCAN with TYPE = %v, expected %v", got, errSyntax)
+	}
+}
@@ -0,0 +1,256 @@
+package domain
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// sortedCommands returns every registered command in name order.
+func sortedCommands() []*CommandSpec {
+	specs := make([]*CommandSpec, 0, len(registry))
+	for _, spec := range registry {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// namedCommands returns the commands with the given names, or every command
+// when there are none. Unknown names give nil.
+func namedCommands(names []string) []*CommandSpec {
+	if len(names) == 0 {
+		return sortedCommands()
+	}
+	specs := make([]*CommandSpec, len(names))
+	for i, name := range names {
+		specs[i], _ = lookupCommand(name)
+	}
+	return specs
+}
+
+// arity returns the argument count in the form Redis reports it: the count
+// including the name, negated when it is only a minimum.
+func (spec *CommandSpec) arity() int {
+	if spec.MinArgs == spec.MaxArgs {
+		return spec.MinArgs + 1
+	}
+	return -(spec.MinArgs + 1)
+}
+
+// info returns the COMMAND INFO reply for the command.
+func (spec *CommandSpec) info() []interface{} {
+	flags := []interface{}{}
+	for _, name := range spec.Flags.names() {
+		flags = append(flags, name)
+	}
+	if spec.FindKeys != nil {
+		flags = append(flags, "movablekeys")
+	}
+	return []interface{}{
+		strings.ToLower(spec.Name), spec.arity(), flags,
+		spec.Keys.First, spec.Keys.Last, spec.Keys.Step,
+	}
+}
+
+func command(ctx *CommandContext, cmd Command) interface{} {
+	args := cmd.params()
+	if len(args) == 0 {
+		reply := []interface{}{}
+		for _, spec := range sortedCommands() {
+			reply = append(reply, spec.info())
+		}
+		return reply
+	}
+
+	switch sub := strings.ToUpper(args[0]); {
+	case sub == "COUNT" && len(args) == 1:
+		return len(registry)
+	case sub == "INFO":
+		reply := []interface{}{}
+		for _, spec := range namedCommands(args[1:]) {
+			if spec == nil {
+				reply = append(reply, nil)
+				continue
+			}
+			reply = append(reply, spec.info())
+		}
+		return reply
+	case sub == "DOCS":
+		reply := []interface{}{}
+		for _, spec := range namedCommands(args[1:]) {
+			if spec == nil {
+				continue
+			}
+			reply = append(reply, strings.ToLower(spec.Name), []interface{}{
+				"summary", spec.Summary,
+				"group", spec.Group,
+			})
+		}
+		return reply
+	case sub == "GETKEYS" && len(args) > 1:
+		return commandGetKeys(Command{Name: strings.ToUpper(args[1]), Argv: cmd.Argv[2:]})
+	case sub == "COUNT" || sub == "GETKEYS":
+		return ErrorReply(fmt.Sprintf("(error) ERR wrong number of arguments for 'command|%s' command", strings.ToLower(sub)))
+	}
+	return ErrorReply(fmt.Sprintf("(error) ERR unknown subcommand '%s'. Try COMMAND HELP.", args[0]))
+}
+
+// commandGetKeys returns the keys of the command line target.
+func commandGetKeys(target Command) interface{} {
+	if _, ok := lookupCommand(target.Name); !ok {
+		return ErrorReply("(error) ERR Invalid command specified")
+	}
+	if _, err := target.Validate(); err != nil {
+		return ErrorReply("(error) ERR Invalid number of arguments specified for command")
+	}
+	keys := target.Keys()
+	if len(keys) == 0 {
+		return ErrorReply("(error) ERR The command has no key arguments")
+	}
+	reply := make([]interface{}, len(keys))
+	for i, key := range keys {
+		reply[i] = key
+	}
+	return reply
+}
+
+// shutdown saves a snapshot, when asked to or when save points are set,
+// and then has the server stop. The snapshot is written before the server
+// is told to stop, so that a failed save leaves it running.
+func shutdown(ctx *CommandContext, cmd Command) interface{} {
+	kvdb := ctx.kvdb
+	if kvdb.shutdown == nil {
+		return ErrorReply("(error) ERR SHUTDOWN is not supported by this server")
+	}
+	save := kvdb.config.Values().Save != ""
+	switch strings.ToUpper(cmd.arg(0)) {
+	case "":
+	case "SAVE":
+		save = true
+	case "NOSAVE":
+		save = false
+	default:
+		return errSyntax
+	}
+	if save {
+		if err := kvdb.save(); err != nil {
+			return ErrorReply(fmt.Sprintf("(error) ERR Errors trying to SHUTDOWN: %v", err))
+		}
+	}
+	kvdb.shutdown()
+	return statusOK
+}
+
+// infoSections lists the INFO sections in the order they are reported.
+var infoSections = []struct {
+	name  string
+	write func(kvdb *KeyValueDB, b *strings.Builder)
+}{
+	{"server", (*KeyValueDB).infoServer},
+	{"clients", (*KeyValueDB).infoClients},
+	{"memory", (*KeyValueDB).infoMemory},
+	{"persistence", (*KeyValueDB).infoPersistence},
+	{"stats", (*KeyValueDB).infoStats},
+	{"keyspace", (*KeyValueDB).infoKeyspace},
+}
+
+// info reports the named sections, or all of them when none are named or
+// one of them is "all", "default" or "everything". Unknown sections are
+// left out.
+func (kvdb *KeyValueDB) info(dbIndex int, cmd Command) interface{} {
+	wanted := map[string]bool{}
+	all := len(cmd.Argv) == 0
+	for _, arg := range cmd.params() {
+		section := strings.ToLower(arg)
+		if section == "all" || section == "default" || section == "everything" {
+			all = true
+		}
+		wanted[section] = true
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s%s\r\n", strings.ToUpper(section.name[:1]), section.name[1:])
+		section.write(kvdb, &b)
+	}
+	return b.String()
+}
+
+func infoField(b *strings.Builder, name string, value interface{}) {
+	fmt.Fprintf(b, "%s:%v\r\n", name, value)
+}
+
+func (kvdb *KeyValueDB) infoServer(b *strings.Builder) {
+	uptime := timeNow().Sub(kvdb.stats.startTime)
+	infoField(b, "kvdb_version", Version)
+	infoField(b, "go_version", runtime.Version())
+	infoField(b, "os", runtime.GOOS+" "+runtime.GOARCH)
+	infoField(b, "process_id", os.Getpid())
+	infoField(b, "tcp_port", kvdb.config.Values().Port)
+	infoField(b, "uptime_in_seconds", int64(uptime.Seconds()))
+	infoField(b, "uptime_in_days", int64(uptime.Hours()/24))
+	infoField(b, "config_file", kvdb.config.File())
+}
+
+func (kvdb *KeyValueDB) infoClients(b *strings.Builder) {
+	infoField(b, "connected_clients", len(kvdb.sessions))
+	infoField(b, "blocked_clients", kvdb.stats.blockedClients)
+}
+
+func (kvdb *KeyValueDB) infoMemory(b *strings.Builder) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	infoField(b, "used_memory", mem.HeapAlloc)
+	infoField(b, "used_memory_human", humanBytes(mem.HeapAlloc))
+	infoField(b, "used_memory_sys", mem.Sys)
+	infoField(b, "used_memory_sys_human", humanBytes(mem.Sys))
+}
+
+func (kvdb *KeyValueDB) infoPersistence(b *strings.Builder) {
+	infoField(b, "loading", 0)
+	infoField(b, "rdb_changes_since_last_save", kvdb.stats.changes)
+	infoField(b, "rdb_bgsave_in_progress", 0)
+	infoField(b, "rdb_last_save_time", kvdb.stats.lastSave.Unix())
+	infoField(b, "aof_enabled", 0)
+}
+
+func (kvdb *KeyValueDB) infoStats(b *strings.Builder) {
+	s := kvdb.stats
+	s.rollOps(timeNow())
+	infoField(b, "total_connections_received", s.totalConnections)
+	infoField(b, "total_commands_processed", s.totalCommands)
+	infoField(b, "instantaneous_ops_per_sec", s.opsLastSecond)
+	infoField(b, "keyspace_hits", s.keyspaceHits)
+	infoField(b, "keyspace_misses", s.keyspaceMisses)
+}
+
+// infoKeyspace reports every database, empty ones included.
+func (kvdb *KeyValueDB) infoKeyspace(b *strings.Builder) {
+	for idx := 0; idx < kvdb.storage.DBCount(); idx++ {
+		infoField(b, fmt.Sprintf("db%d", idx), fmt.Sprintf("keys=%d,expires=%d", kvdb.storage.Size(idx), kvdb.storage.Expires(idx)))
+	}
+}
+
+// humanBytes formats a byte count the way INFO does, as in "1.50M".
+func humanBytes(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", v, units[i])
+}
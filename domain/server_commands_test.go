@@ -0,0 +1,223 @@
+package domain
+
+import (
+	"keyvaluedb/config"
+	"keyvaluedb/storage"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      Command
+		expected interface{}
+	}{
+		{
+			name: "INFO",
+			cmd:  NewCommand(COMMAND, "INFO", "get", "MSET", "xread", "nope", "georadius"),
+			expected: []interface{}{
+				[]interface{}{"get", 2, []interface{}{"readonly"}, 1, 1, 1},
+				[]interface{}{"mset", -3, []interface{}{"write"}, 1, -1, 2},
+				[]interface{}{"xread", -4, []interface{}{"readonly", "blocking", "movablekeys"}, 0, 0, 0},
+				nil,
+				[]interface{}{"georadius", -6, []interface{}{"write", "movablekeys"}, 1, 1, 1},
+			},
+		},
+		{
+			name: "DOCS",
+			cmd:  NewCommand(COMMAND, "DOCS", "get", "nope"),
+			expected: []interface{}{
+				"get", []interface{}{"summary", "Returns the string value of a key.", "group", "string"},
+			},
+		},
+		{
+			name:     "GETKEYS",
+			cmd:      NewCommand(COMMAND, "GETKEYS", "mset", "a", "1", "b", "2"),
+			expected: []interface{}{"a", "b"},
+		},
+		{
+			name:     "GETKEYS of movable keys",
+			cmd:      NewCommand(COMMAND, "GETKEYS", "XREAD", "COUNT", "1", "STREAMS", "s1", "s2", "0", "0"),
+			expected: []interface{}{"s1", "s2"},
+		},
+		{
+			name:     "GETKEYS of an unknown command",
+			cmd:      NewCommand(COMMAND, "GETKEYS", "nope", "a"),
+			expected: ErrorReply("(error) ERR Invalid command specified"),
+		},
+		{
+			name:     "GETKEYS with the wrong number of arguments",
+			cmd:      NewCommand(COMMAND, "GETKEYS", "get", "a", "b"),
+			expected: ErrorReply("(error) ERR Invalid number of arguments specified for command"),
+		},
+		{
+			name:     "GETKEYS of a command without keys",
+			cmd:      NewCommand(COMMAND, "GETKEYS", "dbsize"),
+			expected: ErrorReply("(error) ERR The command has no key arguments"),
+		},
+		{
+			name:     "GETKEYS without a command",
+			cmd:      NewCommand(COMMAND, "GETKEYS"),
+			expected: ErrorReply("(error) ERR wrong number of arguments for 'command|getkeys' command"),
+		},
+		{
+			name:     "Unknown subcommand",
+			cmd:      NewCommand(COMMAND, "FOO"),
+			expected: ErrorReply("(error) ERR unknown subcommand 'FOO'. Try COMMAND HELP."),
+		},
+	}
+
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, got := kvdb.Execute(0, test.cmd); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("command %v returned %#v, expected %#v", test.cmd, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestCommandListsEveryCommand(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	_, count := kvdb.Execute(0, NewCommand(COMMAND, "COUNT"))
+	_, all := kvdb.Execute(0, NewCommand(COMMAND))
+	if n := len(all.([]interface{})); n != count {
+		t.Errorf("COMMAND returned %d commands, COMMAND COUNT %v", n, count)
+	}
+
+	for _, spec := range builtinCommands {
+		if spec.Group == "" || spec.Summary == "" {
+			t.Errorf("command %s is not documented", spec.Name)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	kvdb.NewSession(Conn{})
+	kvdb.EndSession(kvdb.NewSession(Conn{}))
+	kvdb.Execute(0, NewCommand(SET, "a", "1"))
+	kvdb.Execute(0, NewCommand(SET, "b", "2", "EXAT", "4102444800"))
+	kvdb.Execute(0, NewCommand(INCR, "a", "extra"))
+	kvdb.Execute(0, NewCommand(MGET, "a", "b", "c"))
+	kvdb.Execute(0, NewCommand(GET, "a"))
+	kvdb.Execute(1, NewCommand(INCR, "b"))
+	clock = clock.Add(90061 * time.Second)
+
+	_, got := kvdb.Execute(0, NewCommand(INFO))
+	for _, line := range []string{
+		"# Server\r\n",
+		"uptime_in_seconds:90061\r\n",
+		"uptime_in_days:1\r\n",
+		"\r\n\r\n# Clients\r\nconnected_clients:1\r\nblocked_clients:0\r\n",
+		"# Memory\r\nused_memory:",
+		"rdb_changes_since_last_save:3\r\n",
+		"total_connections_received:2\r\n",
+		// The wrong number of arguments to INCR is not a processed command.
+		"total_commands_processed:5\r\n",
+		"keyspace_hits:3\r\nkeyspace_misses:1\r\n",
+		"# Keyspace\r\ndb0:keys=2,expires=1\r\ndb1:keys=1,expires=0\r\n",
+	} {
+		if !strings.Contains(got.(string), line) {
+			t.Errorf("INFO does not contain %q:\n%s", line, got)
+		}
+	}
+
+	_, got = kvdb.Execute(0, NewCommand(INFO, "KEYSPACE", "clients"))
+	expected := "# Clients\r\nconnected_clients:1\r\nblocked_clients:0\r\n\r\n" +
+		"# Keyspace\r\ndb0:keys=2,expires=1\r\ndb1:keys=1,expires=0\r\n"
+	if got != expected {
+		t.Errorf("INFO keyspace clients = %q, expected %q", got, expected)
+	}
+	if _, got = kvdb.Execute(0, NewCommand(INFO, "nope")); got != "" {
+		t.Errorf("INFO of an unknown section = %q, expected nothing", got)
+	}
+}
+
+func TestInfoOpsPerSec(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	for i := 0; i < 3; i++ {
+		kvdb.Execute(0, NewCommand(GET, "a"))
+	}
+	clock = clock.Add(time.Second)
+	if _, got := kvdb.Execute(0, NewCommand(INFO, "stats")); !strings.Contains(got.(string), "instantaneous_ops_per_sec:3\r\n") {
+		t.Errorf("INFO stats one second later = %q, expected 3 ops/sec", got)
+	}
+	clock = clock.Add(2 * time.Second)
+	if _, got := kvdb.Execute(0, NewCommand(INFO, "stats")); !strings.Contains(got.(string), "instantaneous_ops_per_sec:0\r\n") {
+		t.Errorf("INFO stats after an idle second = %q, expected 0 ops/sec", got)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "dump.kvdb")
+	tests := []struct {
+		name         string
+		save         string
+		cmd          Command
+		expected     interface{}
+		wantStop     bool
+		wantSnapshot bool
+	}{
+		{name: "with save points", save: "3600 1", cmd: NewCommand(SHUTDOWN), expected: statusOK, wantStop: true, wantSnapshot: true},
+		{name: "without save points", save: "", cmd: NewCommand(SHUTDOWN), expected: statusOK, wantStop: true},
+		{name: "SAVE", save: "", cmd: NewCommand(SHUTDOWN, "save"), expected: statusOK, wantStop: true, wantSnapshot: true},
+		{name: "NOSAVE", save: "3600 1", cmd: NewCommand(SHUTDOWN, "NOSAVE"), expected: statusOK, wantStop: true},
+		{name: "unknown option", cmd: NewCommand(SHUTDOWN, "NOW"), expected: errSyntax},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Remove(snapshot)
+			cfg := config.New()
+			cfg.Set("dir", dir)
+			cfg.Set("save", test.save)
+			kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+			kvdb.SetConfig(cfg)
+			stopped := false
+			kvdb.OnShutdown(func() { stopped = true })
+			kvdb.Execute(0, NewCommand(SET, "k", "v"))
+
+			if _, got := kvdb.Execute(0, test.cmd); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("command %v returned %#v, expected %#v", test.cmd, got, test.expected)
+			}
+			if stopped != test.wantStop {
+				t.Errorf("command %v stopped the server: %v, expected %v", test.cmd, stopped, test.wantStop)
+			}
+			if _, err := os.Stat(snapshot); (err == nil) != test.wantSnapshot {
+				t.Errorf("command %v saved a snapshot: %v, expected %v", test.cmd, err == nil, test.wantSnapshot)
+			}
+		})
+	}
+}
+
+func TestShutdownFailures(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	expected := ErrorReply("(error) ERR SHUTDOWN is not supported by this server")
+	if _, got := kvdb.Execute(0, NewCommand(SHUTDOWN)); got != expected {
+		t.Errorf("SHUTDOWN without a server returned %#v, expected %#v", got, expected)
+	}
+
+	cfg := config.New()
+	cfg.Set("dir", filepath.Join(t.TempDir(), "missing"))
+	kvdb.SetConfig(cfg)
+	stopped := false
+	kvdb.OnShutdown(func() { stopped = true })
+	_, got := kvdb.Execute(0, NewCommand(SHUTDOWN, "SAVE"))
+	if !strings.HasPrefix(string(got.(ErrorReply)), "(error) ERR Errors trying to SHUTDOWN: ") || stopped {
+		t.Errorf("SHUTDOWN SAVE into a missing directory returned %#v and stopped: %v", got, stopped)
+	}
+}
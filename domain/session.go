@@ -0,0 +1,212 @@
+package domain
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conn describes the connection of a client, for CLIENT LIST and CLIENT
+// KILL.
+type Conn struct {
+	RemoteAddr string
+	LocalAddr  string
+	// User is the user the connection logged in as on its own, as with a
+	// TLS client certificate, or "" when it starts as the default user.
+	User string
+	// Close disconnects the client. It is called with the KeyValueDB
+	// locked, so it must not wait for the client's commands to finish.
+	Close func()
+}
+
+// Session is the state of one client: what its commands change that other
+// clients must not see. The KeyValueDB they share only holds the data.
+// Sessions are created by NewSession and, like the data, guarded by the
+// KeyValueDB lock.
+type Session struct {
+	id      int64
+	dbIndex int
+	conn    Conn
+	// created is when the client connected, and lastActive and lastCmd
+	// when it last ran a command and which.
+	created    time.Time
+	lastActive time.Time
+	lastCmd    string
+	// queryBuf and outputBuf are the sizes of the connection's buffers,
+	// which its own goroutine stores atomically.
+	queryBuf  int64
+	outputBuf int64
+	noEvict   bool
+	// name is the name the client gave its connection, user the user it
+	// runs commands as once authenticated, and protocol the RESP version
+	// of its replies.
+	name          string
+	user          string
+	authenticated bool
+	protocol      int
+
+	// inMulti is set from MULTI until EXEC or DISCARD, while queue
+	// collects the commands to run.
+	inMulti bool
+	queue   []Command
+	// watched are the keys WATCH is watching, and dirty is set once one
+	// of them changes so that EXEC aborts.
+	watched map[waitKey]struct{}
+	dirty   bool
+
+	// channels and patterns are the Pub/Sub subscriptions of the client.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// done is closed by Cancel, which cancelOnce lets run without the
+	// KeyValueDB lock.
+	done       chan struct{}
+	cancelOnce sync.Once
+}
+
+func newSession(id int64, conn Conn) *Session {
+	now := timeNow()
+	return &Session{
+		id:         id,
+		conn:       conn,
+		created:    now,
+		lastActive: now,
+		user:       "default",
+		protocol:   2,
+		watched:    make(map[waitKey]struct{}),
+		channels:   make(map[string]struct{}),
+		patterns:   make(map[string]struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// ID identifies the session for as long as the KeyValueDB exists.
+func (s *Session) ID() int64 {
+	return s.id
+}
+
+// DBIndex returns the selected database. Only the goroutine running the
+// session's commands may call it.
+func (s *Session) DBIndex() int {
+	return s.dbIndex
+}
+
+// SetBuffers records how many bytes the connection holds in its query and
+// output buffers. It may be called without the KeyValueDB lock.
+func (s *Session) SetBuffers(query, output int) {
+	atomic.StoreInt64(&s.queryBuf, int64(query))
+	atomic.StoreInt64(&s.outputBuf, int64(output))
+}
+
+// Cancel wakes the command of the session that is blocked, as XREAD BLOCK
+// or CLIENT PAUSE block, and makes every later one return at once, for a
+// client being disconnected. It may be called without the KeyValueDB lock
+// and more than once.
+func (s *Session) Cancel() {
+	s.cancelOnce.Do(func() { close(s.done) })
+}
+
+// NewSession starts the session of a client newly connected on conn.
+func (kvdb *KeyValueDB) NewSession(conn Conn) *Session {
+	kvdb.mu.Lock()
+	defer kvdb.mu.Unlock()
+	kvdb.lastSessionID++
+	s := newSession(kvdb.lastSessionID, conn)
+	s.authenticated = kvdb.authenticates()
+	if u, ok := kvdb.acl.users[conn.User]; ok && conn.User != "" && u.enabled {
+		s.user, s.authenticated = u.name, true
+	}
+	kvdb.sessions[s.id] = s
+	kvdb.stats.totalConnections++
+	return s
+}
+
+// EndSession forgets the session of a client that disconnected.
+func (kvdb *KeyValueDB) EndSession(s *Session) {
+	s.Cancel()
+	kvdb.mu.Lock()
+	defer kvdb.mu.Unlock()
+	kvdb.unwatch(s)
+	delete(kvdb.sessions, s.id)
+}
+
+// Run runs cmd for the client of session, once CLIENT PAUSE lets it. A
+// command held back when the session is cancelled does not run, and the
+// reply is nil.
+func (kvdb *KeyValueDB) Run(s *Session, cmd Command) interface{} {
+	kvdb.mu.Lock()
+	defer kvdb.mu.Unlock()
+	if !kvdb.awaitUnpause(s, cmd) {
+		return nil
+	}
+	s.lastActive = timeNow()
+	s.lastCmd = strings.ToLower(cmd.Name)
+	_, reply := kvdb.execute(s, s.dbIndex, cmd)
+	return reply
+}
+
+func watch(ctx *CommandContext, cmd Command) interface{} {
+	s := ctx.session
+	if s.inMulti {
+		return ErrorReply("(error) ERR WATCH inside MULTI is not allowed")
+	}
+	for _, key := range cmd.params() {
+		wk := waitKey{ctx.DBIndex, key}
+		s.watched[wk] = struct{}{}
+		watchers, ok := ctx.kvdb.watchers[wk]
+		if !ok {
+			watchers = make(map[*Session]struct{})
+			ctx.kvdb.watchers[wk] = watchers
+		}
+		watchers[s] = struct{}{}
+	}
+	return statusOK
+}
+
+func unwatch(ctx *CommandContext, cmd Command) interface{} {
+	ctx.kvdb.unwatch(ctx.session)
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) unwatch(s *Session) {
+	for wk := range s.watched {
+		watchers := kvdb.watchers[wk]
+		delete(watchers, s)
+		if len(watchers) == 0 {
+			delete(kvdb.watchers, wk)
+		}
+	}
+	s.watched = make(map[waitKey]struct{})
+	s.dirty = false
+}
+
+// touchKeys makes the transactions watching the keys of a write command
+// fail. A write without key arguments, such as FLUSHALL, may change any
+// key, so it fails every watching transaction.
+func (kvdb *KeyValueDB) touchKeys(dbIndex int, cmd Command, spec *CommandSpec) {
+	if spec.Flags&FlagWrite == 0 || len(kvdb.watchers) == 0 {
+		return
+	}
+	keys := cmd.Keys()
+	if len(keys) == 0 {
+		for _, watchers := range kvdb.watchers {
+			for s := range watchers {
+				s.dirty = true
+			}
+		}
+		return
+	}
+	for _, key := range keys {
+		kvdb.touchKey(dbIndex, key)
+	}
+}
+
+// touchKey makes the transactions watching key in the database dbIndex
+// fail. Commands that write to another database, such as MOVE, call it for
+// the key they write there.
+func (kvdb *KeyValueDB) touchKey(dbIndex int, key string) {
+	for s := range kvdb.watchers[waitKey{dbIndex, key}] {
+		s.dirty = true
+	}
+}
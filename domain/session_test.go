@@ -0,0 +1,179 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSessions(t *testing.T) {
+	type step struct {
+		client   int
+		cmd      Command
+		expected interface{}
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "Transactions are per session",
+			steps: []step{
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(SET, "a", "1"), statusQueued},
+				{1, NewCommand(SET, "a", "2"), statusOK},
+				{1, NewCommand(EXEC), []interface{}(nil)},
+				{1, NewCommand(GET, "a"), "2"},
+				{0, NewCommand(EXEC), []interface{}{statusOK}},
+				{1, NewCommand(GET, "a"), "1"},
+			},
+		},
+		{
+			name: "Selected databases are per session",
+			steps: []step{
+				{0, NewCommand(SELECT, "1"), statusOK},
+				{0, NewCommand(SET, "a", "1"), statusOK},
+				{1, NewCommand(GET, "a"), nil},
+				{0, NewCommand(GET, "a"), "1"},
+			},
+		},
+		{
+			name: "SELECT inside a transaction",
+			steps: []step{
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(SELECT, "1"), statusQueued},
+				{0, NewCommand(SET, "a", "1"), statusQueued},
+				{0, NewCommand(EXEC), []interface{}{statusOK, statusOK}},
+				{0, NewCommand(GET, "a"), "1"},
+				{1, NewCommand(GET, "a"), nil},
+			},
+		},
+		{
+			name: "WATCH aborts a transaction when another session writes",
+			steps: []step{
+				{0, NewCommand(WATCH, "a", "b"), statusOK},
+				{1, NewCommand(SET, "b", "1"), statusOK},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(SET, "a", "1"), statusQueued},
+				{0, NewCommand(EXEC), nil},
+				{0, NewCommand(GET, "a"), nil},
+			},
+		},
+		{
+			name: "WATCH aborts a transaction after a flush",
+			steps: []step{
+				{0, NewCommand(WATCH, "a"), statusOK},
+				{1, NewCommand(FLUSHALL), statusOK},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(EXEC), nil},
+			},
+		},
+		{
+			name: "WATCH ignores reads, other keys and other databases",
+			steps: []step{
+				{0, NewCommand(WATCH, "a"), statusOK},
+				{1, NewCommand(GET, "a"), nil},
+				{1, NewCommand(SET, "b", "1"), statusOK},
+				{1, NewCommand(SELECT, "1"), statusOK},
+				{1, NewCommand(SET, "a", "1"), statusOK},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(SET, "a", "2"), statusQueued},
+				{0, NewCommand(EXEC), []interface{}{statusOK}},
+			},
+		},
+		{
+			name: "EXEC forgets the watched keys",
+			steps: []step{
+				{0, NewCommand(WATCH, "a"), statusOK},
+				{1, NewCommand(SET, "a", "1"), statusOK},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(EXEC), nil},
+				{1, NewCommand(SET, "a", "2"), statusOK},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(EXEC), []interface{}(nil)},
+			},
+		},
+		{
+			name: "UNWATCH",
+			steps: []step{
+				{0, NewCommand(WATCH, "a"), statusOK},
+				{0, NewCommand(UNWATCH), statusOK},
+				{1, NewCommand(SET, "a", "1"), statusOK},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(INCR, "a"), statusQueued},
+				{0, NewCommand(EXEC), []interface{}{"2"}},
+			},
+		},
+		{
+			name: "WATCH sees a write that replies with a value reading like an error",
+			steps: []step{
+				{1, NewCommand(SET, "a", "(error) x"), statusOK},
+				{0, NewCommand(WATCH, "a", "b"), statusOK},
+				{1, NewCommand(GETSET, "a", "1"), "(error) x"},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(EXEC), nil},
+				{1, NewCommand(SET, "b", "(error) y"), statusOK},
+				{0, NewCommand(WATCH, "b"), statusOK},
+				{1, NewCommand(SET, "b", "2", "GET"), "(error) y"},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(EXEC), nil},
+			},
+		},
+		{
+			name: "WATCH sees MOVE and COPY into the watched database",
+			steps: []step{
+				{0, NewCommand(SELECT, "1"), statusOK},
+				{0, NewCommand(WATCH, "a"), statusOK},
+				{1, NewCommand(SET, "a", "1"), statusOK},
+				{1, NewCommand(MOVE, "a", "1"), 1},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(GET, "a"), statusQueued},
+				{0, NewCommand(EXEC), nil},
+				{0, NewCommand(WATCH, "b"), statusOK},
+				{1, NewCommand(SET, "c", "2"), statusOK},
+				{1, NewCommand(COPY, "c", "b", "DB", "1"), 1},
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(GET, "b"), statusQueued},
+				{0, NewCommand(EXEC), nil},
+			},
+		},
+		{
+			name: "WATCH inside MULTI",
+			steps: []step{
+				{0, NewCommand(MULTI), statusOK},
+				{0, NewCommand(WATCH, "a"), ErrorReply("(error) ERR WATCH inside MULTI is not allowed")},
+				{0, NewCommand(DISCARD), statusOK},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+			sessions := []*Session{kvdb.NewSession(Conn{}), kvdb.NewSession(Conn{})}
+			for _, step := range test.steps {
+				got := kvdb.Run(sessions[step.client], step.cmd)
+				if !reflect.DeepEqual(got, step.expected) {
+					t.Errorf("client %d: command %v returned %#v, expected %#v", step.client, step.cmd, got, step.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	first, second := kvdb.NewSession(Conn{}), kvdb.NewSession(Conn{})
+	if first.ID() == second.ID() {
+		t.Errorf("sessions share the ID %d", first.ID())
+	}
+	kvdb.Run(first, NewCommand(WATCH, "a"))
+	kvdb.EndSession(first)
+	if len(kvdb.watchers) != 0 {
+		t.Errorf("an ended session still watches %v", kvdb.watchers)
+	}
+	_, info := kvdb.Execute(0, NewCommand(INFO, "clients"))
+	if !strings.Contains(info.(string), "connected_clients:1\r\n") {
+		t.Errorf("INFO clients does not count one client:\n%s", info)
+	}
+}
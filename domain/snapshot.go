@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Save writes a snapshot of every database to the file named by the dir and
+// dbfilename settings. The snapshot holds the COMPACT commands of each
+// database after a SELECT of it, so replaying it restores the data.
+func (kvdb *KeyValueDB) Save() error {
+	kvdb.mu.Lock()
+	defer kvdb.mu.Unlock()
+	return kvdb.save()
+}
+
+func (kvdb *KeyValueDB) save() error {
+	path := kvdb.config.Values().SnapshotPath()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".kvdb-snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for idx := 0; idx < kvdb.storage.DBCount(); idx++ {
+		lines := kvdb.compact(idx, Command{}).([]interface{})
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(writer, "%s %d\n", SELECT, idx)
+		for _, line := range lines {
+			fmt.Fprintln(writer, line)
+		}
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// The old snapshot is replaced only once the new one is complete.
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	kvdb.stats.changes = 0
+	kvdb.stats.lastSave = timeNow()
+	return nil
+}
@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"keyvaluedb/config"
+	"keyvaluedb/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.New()
+	if err := cfg.Set("dir", dir); err != nil {
+		t.Fatal(err)
+	}
+	kvdb := NewKeyValueDB(storage.NewInMemory("3"))
+	kvdb.SetConfig(cfg)
+	kvdb.Execute(0, NewCommand(SET, "a", "1"))
+	kvdb.Execute(2, NewCommand(SET, "b", "two words"))
+	kvdb.Execute(2, NewCommand(INCR, "n"))
+
+	if err := kvdb.Save(); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "dump.kvdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "SELECT 0\nSET a 1\nSELECT 2\nSET b \"two words\"\nSET n 1\n"
+	if string(data) != expected {
+		t.Errorf("Save() wrote %q, expected %q", data, expected)
+	}
+	_, info := kvdb.Execute(0, NewCommand(INFO, "persistence"))
+	if !strings.Contains(info.(string), "rdb_changes_since_last_save:0\r\n") {
+		t.Errorf("INFO persistence after Save():\n%s", info)
+	}
+
+	if err := cfg.Set("dir", filepath.Join(dir, "missing")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvdb.Save(); err == nil {
+		t.Errorf("Save() into a missing directory succeeded")
+	}
+}
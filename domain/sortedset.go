@@ -0,0 +1,171 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"math/rand"
+)
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+// sortedSet keeps members ordered by score, and by member for equal scores,
+// in a skiplist, with a hash table for constant time score lookups.
+type sortedSet struct {
+	scores *storage.Dict
+	head   *skiplistNode
+	level  int
+}
+
+type skiplistNode struct {
+	member string
+	score  float64
+	next   []*skiplistNode
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{
+		scores: storage.NewDict(),
+		head:   &skiplistNode{next: make([]*skiplistNode, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func (z *sortedSet) clone() *sortedSet {
+	c := newSortedSet()
+	z.each(func(member string, score float64) bool {
+		c.insert(member, score)
+		return true
+	})
+	return c
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether the node sorts before (score, member).
+func (n *skiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func (z *sortedSet) length() int {
+	return z.scores.Len()
+}
+
+func (z *sortedSet) score(member string) (float64, bool) {
+	score, ok := z.scores.Get(member)
+	if !ok {
+		return 0, false
+	}
+	return score.(float64), true
+}
+
+// add sets the score of member. It reports whether the member was added and
+// whether an existing member changed its score.
+func (z *sortedSet) add(member string, score float64) (added, changed bool) {
+	if old, ok := z.score(member); ok {
+		if old == score {
+			return false, false
+		}
+		z.unlink(member, old)
+		z.insert(member, score)
+		return false, true
+	}
+	z.insert(member, score)
+	return true, false
+}
+
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.score(member)
+	if !ok {
+		return false
+	}
+	z.unlink(member, score)
+	return true
+}
+
+func (z *sortedSet) insert(member string, score float64) {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].before(score, member) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			update[i] = z.head
+		}
+		z.level = level
+	}
+	node := &skiplistNode{member: member, score: score, next: make([]*skiplistNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	z.scores.Set(member, score)
+}
+
+func (z *sortedSet) unlink(member string, score float64) {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].before(score, member) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+	x = x.next[0]
+	if x == nil || x.member != member {
+		return
+	}
+	for i := 0; i < z.level; i++ {
+		if update[i].next[i] == x {
+			update[i].next[i] = x.next[i]
+		}
+	}
+	for z.level > 1 && z.head.next[z.level-1] == nil {
+		z.level--
+	}
+	z.scores.Del(member)
+}
+
+// rangeByScore calls fn for every member with min <= score < max in order,
+// until fn returns false.
+func (z *sortedSet) rangeByScore(min, max float64, fn func(member string, score float64) bool) {
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].score < min {
+			x = x.next[i]
+		}
+	}
+	for x = x.next[0]; x != nil && x.score < max; x = x.next[0] {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+// each calls fn for every member in order until fn returns false.
+func (z *sortedSet) each(fn func(member string, score float64) bool) {
+	for x := z.head.next[0]; x != nil; x = x.next[0] {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+// scan returns members from the buckets of the member table starting at
+// cursor, with the cursor to continue from, as storage.Dict.Scan does.
+func (z *sortedSet) scan(cursor uint64, count int) (uint64, []string) {
+	return z.scores.Scan(cursor, count)
+}
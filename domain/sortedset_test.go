@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortedSetOrder(t *testing.T) {
+	z := newSortedSet()
+	z.add("c", 3)
+	z.add("a", 1)
+	z.add("b", 1)
+	z.add("d", 2)
+	if added, changed := z.add("d", 4); added || !changed {
+		t.Errorf("add(existing) = %v, %v, want false, true", added, changed)
+	}
+
+	var got []string
+	z.each(func(member string, score float64) bool {
+		got = append(got, member)
+		return true
+	})
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("each() visited %v, want %v", got, want)
+	}
+}
+
+func TestSortedSetRangeByScore(t *testing.T) {
+	z := newSortedSet()
+	for i := 0; i < 100; i++ {
+		z.add(string(rune('A'+i%26))+string(rune('a'+i/26)), float64(i))
+	}
+	z.remove("Ba")
+
+	var got []float64
+	z.rangeByScore(0, 5, func(member string, score float64) bool {
+		got = append(got, score)
+		return true
+	})
+	if want := []float64{0, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rangeByScore(0, 5) visited %v, want %v", got, want)
+	}
+	if z.length() != 99 {
+		t.Errorf("length() = %d, want 99", z.length())
+	}
+}
@@ -0,0 +1,95 @@
+package domain
+
+import (
+	"time"
+)
+
+// Version is the server version INFO reports.
+const Version = "0.1.0"
+
+// serverStats counts what the server did since it started. It is guarded
+// by the KeyValueDB mutex.
+type serverStats struct {
+	startTime time.Time
+
+	totalConnections int64
+	blockedClients   int
+
+	totalCommands  int64
+	keyspaceHits   int64
+	keyspaceMisses int64
+	// changes counts the write commands that did not fail since lastSave,
+	// the time of the last snapshot or else of the start.
+	changes  int64
+	lastSave time.Time
+
+	// opsSecond is the Unix second opsInSecond counts the commands of, and
+	// opsLastSecond holds the count of the second before it.
+	opsSecond     int64
+	opsInSecond   int64
+	opsLastSecond int64
+}
+
+func newServerStats() *serverStats {
+	now := timeNow()
+	return &serverStats{startTime: now, lastSave: now, opsSecond: now.Unix()}
+}
+
+// ResetStats zeroes the command counters and marks the data as saved, as
+// after loading a snapshot.
+func (kvdb *KeyValueDB) ResetStats() {
+	kvdb.mu.Lock()
+	defer kvdb.mu.Unlock()
+	s := kvdb.stats
+	s.totalCommands, s.keyspaceHits, s.keyspaceMisses = 0, 0, 0
+	s.changes, s.lastSave = 0, timeNow()
+	s.opsInSecond, s.opsLastSecond = 0, 0
+}
+
+// rollOps moves the per-second command count on to the current second.
+func (s *serverStats) rollOps(now time.Time) {
+	sec := now.Unix()
+	if sec == s.opsSecond {
+		return
+	}
+	if sec == s.opsSecond+1 {
+		s.opsLastSecond = s.opsInSecond
+	} else {
+		s.opsLastSecond = 0
+	}
+	s.opsSecond = sec
+	s.opsInSecond = 0
+}
+
+// recordLookups counts the keys a read-only command is about to read as
+// keyspace hits or misses.
+func (kvdb *KeyValueDB) recordLookups(dbIndex int, cmd Command, spec *CommandSpec) {
+	if spec.Flags&FlagReadOnly == 0 {
+		return
+	}
+	for _, key := range cmd.Keys() {
+		if kvdb.storage.Get(dbIndex, []byte(key)) != nil {
+			kvdb.stats.keyspaceHits++
+		} else {
+			kvdb.stats.keyspaceMisses++
+		}
+	}
+}
+
+// recordCommand counts a command that has run, and the change it made when
+// it is a write command that succeeded. Counting after the command, as
+// Redis does, keeps INFO out of its own report.
+func (kvdb *KeyValueDB) recordCommand(spec *CommandSpec, reply interface{}) {
+	s := kvdb.stats
+	s.totalCommands++
+	s.rollOps(timeNow())
+	s.opsInSecond++
+
+	if spec.Flags&FlagWrite == 0 {
+		return
+	}
+	if isErrorReply(reply) {
+		return
+	}
+	s.changes++
+}
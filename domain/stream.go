@@ -0,0 +1,396 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamID identifies a stream entry. Entries are ordered by the millisecond
+// part first and the sequence number second.
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+var (
+	minStreamID = streamID{0, 0}
+	maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+)
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms < other.ms:
+		return -1
+	case id.ms > other.ms:
+		return 1
+	case id.seq < other.seq:
+		return -1
+	case id.seq > other.seq:
+		return 1
+	}
+	return 0
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.compare(other) < 0
+}
+
+// next returns the smallest ID greater than id.
+func (id streamID) next() (streamID, bool) {
+	if id.seq == math.MaxUint64 {
+		if id.ms == math.MaxUint64 {
+			return id, false
+		}
+		return streamID{id.ms + 1, 0}, true
+	}
+	return streamID{id.ms, id.seq + 1}, true
+}
+
+// prev returns the largest ID smaller than id.
+func (id streamID) prev() (streamID, bool) {
+	if id.seq == 0 {
+		if id.ms == 0 {
+			return id, false
+		}
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return streamID{id.ms, id.seq - 1}, true
+}
+
+// parseStreamID parses an ID of the form "ms-seq" or "ms". When the sequence
+// part is missing, missingSeq is used instead.
+func parseStreamID(s string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart := s, ""
+	if idx := strings.IndexByte(s, '-'); idx >= 0 {
+		msPart, seqPart = s[:idx], s[idx+1:]
+	}
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	if seqPart == "" && !strings.Contains(s, "-") {
+		return streamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, errInvalidStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseRangeID parses a range boundary accepted by XRANGE and friends: the
+// special IDs "-" and "+", incomplete IDs and exclusive IDs prefixed by "(".
+func parseRangeID(s string, missingSeq uint64) (streamID, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	switch s {
+	case "-":
+		if exclusive {
+			return streamID{}, false, errInvalidStreamID
+		}
+		return minStreamID, true, nil
+	case "+":
+		if exclusive {
+			return streamID{}, false, errInvalidStreamID
+		}
+		return maxStreamID, true, nil
+	}
+	id, err := parseStreamID(s, missingSeq)
+	if err != nil {
+		return streamID{}, false, err
+	}
+	return id, !exclusive, nil
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// reply formats the entry the way stream commands return it: the ID followed
+// by the flat list of fields and values.
+func (e streamEntry) reply() interface{} {
+	fields := make([]interface{}, 0, len(e.fields))
+	for _, f := range e.fields {
+		fields = append(fields, f)
+	}
+	return []interface{}{e.id.String(), fields}
+}
+
+// stream is an append-only log of entries ordered by ID, together with the
+// consumer groups reading from it.
+type stream struct {
+	entries      []streamEntry
+	lastID       streamID
+	entriesAdded uint64
+	groups       map[string]*consumerGroup
+}
+
+type consumerGroup struct {
+	name      string
+	lastID    streamID
+	pel       map[streamID]*pendingEntry
+	consumers map[string]*streamConsumer
+}
+
+type streamConsumer struct {
+	name     string
+	seenTime time.Time
+	pending  map[streamID]*pendingEntry
+}
+
+// pendingEntry records an entry delivered to a consumer but not yet
+// acknowledged.
+type pendingEntry struct {
+	id            streamID
+	consumer      *streamConsumer
+	deliveryTime  time.Time
+	deliveryCount uint64
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*consumerGroup)}
+}
+
+// clone returns a deep copy of the stream, including its consumer groups
+// and their pending entries. Entry fields are never modified, so they are
+// shared.
+func (s *stream) clone() *stream {
+	c := &stream{
+		entries:      append([]streamEntry(nil), s.entries...),
+		lastID:       s.lastID,
+		entriesAdded: s.entriesAdded,
+		groups:       make(map[string]*consumerGroup, len(s.groups)),
+	}
+	for name, g := range s.groups {
+		cg := &consumerGroup{
+			name:      g.name,
+			lastID:    g.lastID,
+			pel:       make(map[streamID]*pendingEntry, len(g.pel)),
+			consumers: make(map[string]*streamConsumer, len(g.consumers)),
+		}
+		for cname, consumer := range g.consumers {
+			cg.consumers[cname] = &streamConsumer{
+				name:     consumer.name,
+				seenTime: consumer.seenTime,
+				pending:  make(map[streamID]*pendingEntry, len(consumer.pending)),
+			}
+		}
+		for id, pe := range g.pel {
+			owner := cg.consumers[pe.consumer.name]
+			cpe := &pendingEntry{id: pe.id, consumer: owner, deliveryTime: pe.deliveryTime, deliveryCount: pe.deliveryCount}
+			cg.pel[id] = cpe
+			owner.pending[id] = cpe
+		}
+		c.groups[name] = cg
+	}
+	return c
+}
+
+// add appends an entry. The caller must make sure id is greater than lastID.
+func (s *stream) add(id streamID, fields []string) {
+	s.entries = append(s.entries, streamEntry{id: id, fields: fields})
+	s.lastID = id
+	s.entriesAdded++
+}
+
+func (s *stream) length() int {
+	return len(s.entries)
+}
+
+// search returns the position of the first entry whose ID is not less than id.
+func (s *stream) search(id streamID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].id.less(id)
+	})
+}
+
+func (s *stream) get(id streamID) (streamEntry, bool) {
+	idx := s.search(id)
+	if idx < len(s.entries) && s.entries[idx].id == id {
+		return s.entries[idx], true
+	}
+	return streamEntry{}, false
+}
+
+// between returns up to count entries with IDs in [start, end], newest first
+// when rev is set. A count of zero or less means no limit.
+func (s *stream) between(start, end streamID, count int, rev bool) []streamEntry {
+	if end.less(start) {
+		return nil
+	}
+	lo := s.search(start)
+	hi := s.search(end)
+	if hi < len(s.entries) && s.entries[hi].id == end {
+		hi++
+	}
+	if lo >= hi {
+		return nil
+	}
+	selected := s.entries[lo:hi]
+	if count <= 0 || count > len(selected) {
+		count = len(selected)
+	}
+	result := make([]streamEntry, 0, count)
+	if rev {
+		for i := len(selected) - 1; i >= 0 && len(result) < count; i-- {
+			result = append(result, selected[i])
+		}
+		return result
+	}
+	return append(result, selected[:count]...)
+}
+
+// after returns up to count entries with IDs strictly greater than id.
+func (s *stream) after(id streamID, count int) []streamEntry {
+	start, ok := id.next()
+	if !ok {
+		return nil
+	}
+	return s.between(start, maxStreamID, count, false)
+}
+
+func (s *stream) remove(id streamID) bool {
+	idx := s.search(id)
+	if idx >= len(s.entries) || s.entries[idx].id != id {
+		return false
+	}
+	copy(s.entries[idx:], s.entries[idx+1:])
+	s.entries[len(s.entries)-1] = streamEntry{}
+	s.entries = s.entries[:len(s.entries)-1]
+	return true
+}
+
+// trimMaxLen evicts the oldest entries until at most maxLen remain and
+// returns how many were removed.
+func (s *stream) trimMaxLen(maxLen int) int {
+	if len(s.entries) <= maxLen {
+		return 0
+	}
+	return s.dropFront(len(s.entries) - maxLen)
+}
+
+// trimMinID evicts every entry whose ID is less than minID.
+func (s *stream) trimMinID(minID streamID) int {
+	return s.dropFront(s.search(minID))
+}
+
+func (s *stream) dropFront(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	// Copy the survivors so the evicted entries can be garbage collected.
+	remaining := make([]streamEntry, len(s.entries)-n)
+	copy(remaining, s.entries[n:])
+	s.entries = remaining
+	return n
+}
+
+func (s *stream) createGroup(name string, lastID streamID) bool {
+	if _, ok := s.groups[name]; ok {
+		return false
+	}
+	s.groups[name] = &consumerGroup{
+		name:      name,
+		lastID:    lastID,
+		pel:       make(map[streamID]*pendingEntry),
+		consumers: make(map[string]*streamConsumer),
+	}
+	return true
+}
+
+// groupNames returns the consumer group names in a stable order.
+func (s *stream) groupNames() []string {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// consumer returns the named consumer, creating it when it does not exist.
+// The boolean reports whether it was created.
+func (g *consumerGroup) consumer(name string, now time.Time) (*streamConsumer, bool) {
+	if c, ok := g.consumers[name]; ok {
+		return c, false
+	}
+	c := &streamConsumer{
+		name:     name,
+		seenTime: now,
+		pending:  make(map[streamID]*pendingEntry),
+	}
+	g.consumers[name] = c
+	return c, true
+}
+
+// deleteConsumer removes a consumer and its pending entries, returning how
+// many entries were pending.
+func (g *consumerGroup) deleteConsumer(name string) int {
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0
+	}
+	for id := range c.pending {
+		delete(g.pel, id)
+	}
+	delete(g.consumers, name)
+	return len(c.pending)
+}
+
+// deliver records that entry id was handed to consumer c.
+func (g *consumerGroup) deliver(id streamID, c *streamConsumer, now time.Time) {
+	if pe, ok := g.pel[id]; ok {
+		delete(pe.consumer.pending, id)
+		pe.consumer = c
+		pe.deliveryTime = now
+		pe.deliveryCount++
+		c.pending[id] = pe
+		return
+	}
+	pe := &pendingEntry{id: id, consumer: c, deliveryTime: now, deliveryCount: 1}
+	g.pel[id] = pe
+	c.pending[id] = pe
+}
+
+func (g *consumerGroup) ack(id streamID) bool {
+	pe, ok := g.pel[id]
+	if !ok {
+		return false
+	}
+	delete(pe.consumer.pending, id)
+	delete(g.pel, id)
+	return true
+}
+
+// consumerNames returns the consumer names in a stable order.
+func (g *consumerGroup) consumerNames() []string {
+	names := make([]string, 0, len(g.consumers))
+	for name := range g.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedPending returns the pending entries ordered by ID.
+func sortedPending(pel map[streamID]*pendingEntry) []*pendingEntry {
+	entries := make([]*pendingEntry, 0, len(pel))
+	for _, pe := range pel {
+		entries = append(entries, pe)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].id.less(entries[j].id)
+	})
+	return entries
+}
@@ -0,0 +1,926 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidStreamID = errors.New("(error) ERR Invalid stream ID specified as stream command argument")
+
+const (
+	errXAddIDTooSmall = ErrorReply("(error) ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errXAddIDZero     = ErrorReply("(error) ERR The ID specified in XADD must be greater than 0-0")
+	errNoSuchKey      = ErrorReply("(error) ERR no such key")
+)
+
+// streamTrim describes a MAXLEN or MINID trimming strategy.
+type streamTrim struct {
+	byMinID bool
+	maxLen  int
+	minID   streamID
+}
+
+func (t streamTrim) apply(s *stream) int {
+	if t.byMinID {
+		return s.trimMinID(t.minID)
+	}
+	return s.trimMaxLen(t.maxLen)
+}
+
+// parseStreamTrim parses "MAXLEN|MINID [=|~] threshold" at the start of args
+// and returns the strategy and the number of arguments consumed.
+func parseStreamTrim(args []string) (streamTrim, int, ErrorReply) {
+	var trim streamTrim
+	trim.byMinID = strings.ToUpper(args[0]) == "MINID"
+	n := 1
+	if n < len(args) && (args[n] == "=" || args[n] == "~") {
+		// Trimming is always exact, which satisfies the approximate form too.
+		n++
+	}
+	if n >= len(args) {
+		return trim, 0, errSyntax
+	}
+	if trim.byMinID {
+		id, err := parseStreamID(args[n], 0)
+		if err != nil {
+			return trim, 0, ErrorReply(err.Error())
+		}
+		trim.minID = id
+	} else {
+		maxLen, err := strconv.Atoi(args[n])
+		if err != nil {
+			return trim, 0, errNotInteger
+		}
+		if maxLen < 0 {
+			return trim, 0, ErrorReply("(error) ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = maxLen
+	}
+	return trim, n + 1, ""
+}
+
+// lookupStream returns the stream stored at key, or nil when the key does
+// not exist. It reports false when the key holds another type.
+func (kvdb *KeyValueDB) lookupStream(dbIndex int, key string) (*stream, bool) {
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	if v == nil {
+		return nil, true
+	}
+	s, ok := v.(*stream)
+	return s, ok
+}
+
+func entriesReply(entries []streamEntry) []interface{} {
+	reply := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		reply = append(reply, e.reply())
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) xadd(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	key := args[0]
+
+	noMkStream := false
+	var trim *streamTrim
+	i := 1
+options:
+	for i < len(args) {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			i++
+		case "MAXLEN", "MINID":
+			t, n, errMsg := parseStreamTrim(args[i:])
+			if errMsg != "" {
+				return errMsg
+			}
+			trim = &t
+			i += n
+		default:
+			break options
+		}
+	}
+
+	if i+1 >= len(args) || (len(args)-i-1)%2 != 0 {
+		return ErrorReply("(error) ERR wrong number of arguments for 'xadd' command")
+	}
+	fields := args[i+1:]
+
+	s, ok := kvdb.lookupStream(dbIndex, key)
+	if !ok {
+		return errWrongType
+	}
+	if s == nil && noMkStream {
+		return nil
+	}
+	lastID := minStreamID
+	if s != nil {
+		lastID = s.lastID
+	}
+
+	id, errMsg := xaddID(args[i], lastID)
+	if errMsg != "" {
+		return errMsg
+	}
+
+	if s == nil {
+		s = newStream()
+		kvdb.storage.Set(dbIndex, []byte(key), s)
+	}
+	s.add(id, append([]string(nil), fields...))
+	if trim != nil {
+		trim.apply(s)
+	}
+	kvdb.signalKey(dbIndex, key)
+	return id.String()
+}
+
+// xaddID resolves the ID argument of XADD, which may be "*", "ms-*" or an
+// explicit ID, against the last ID of the stream.
+func xaddID(arg string, lastID streamID) (streamID, ErrorReply) {
+	if arg == "*" {
+		ms := uint64(timeNow().UnixNano() / int64(time.Millisecond))
+		if ms > lastID.ms {
+			return streamID{ms, 0}, ""
+		}
+		id, ok := lastID.next()
+		if !ok {
+			return streamID{}, ErrorReply("(error) ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, ""
+	}
+
+	if strings.HasSuffix(arg, "-*") {
+		ms, err := strconv.ParseUint(strings.TrimSuffix(arg, "-*"), 10, 64)
+		if err != nil {
+			return streamID{}, ErrorReply(errInvalidStreamID.Error())
+		}
+		switch {
+		case ms < lastID.ms:
+			return streamID{}, errXAddIDTooSmall
+		case ms == lastID.ms:
+			if lastID.seq == math.MaxUint64 {
+				return streamID{}, errXAddIDTooSmall
+			}
+			return streamID{ms, lastID.seq + 1}, ""
+		case ms == 0:
+			return streamID{0, 1}, ""
+		}
+		return streamID{ms, 0}, ""
+	}
+
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return streamID{}, ErrorReply(err.Error())
+	}
+	if id == minStreamID {
+		return streamID{}, errXAddIDZero
+	}
+	if !lastID.less(id) {
+		return streamID{}, errXAddIDTooSmall
+	}
+	return id, ""
+}
+
+func (kvdb *KeyValueDB) xlen(dbIndex int, cmd Command) interface{} {
+	s, ok := kvdb.lookupStream(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if s == nil {
+		return 0
+	}
+	return s.length()
+}
+
+func (kvdb *KeyValueDB) xdel(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	ids := make([]streamID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return ErrorReply(err.Error())
+		}
+		ids = append(ids, id)
+	}
+
+	s, ok := kvdb.lookupStream(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if s == nil {
+		return 0
+	}
+	deleted := 0
+	for _, id := range ids {
+		if s.remove(id) {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (kvdb *KeyValueDB) xtrim(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	switch strings.ToUpper(args[1]) {
+	case "MAXLEN", "MINID":
+	default:
+		return errSyntax
+	}
+	trim, n, errMsg := parseStreamTrim(args[1:])
+	if errMsg != "" {
+		return errMsg
+	}
+	if 1+n != len(args) {
+		return errSyntax
+	}
+
+	s, ok := kvdb.lookupStream(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if s == nil {
+		return 0
+	}
+	return trim.apply(s)
+}
+
+func (kvdb *KeyValueDB) xsetid(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	id, err := parseStreamID(args[1], 0)
+	if err != nil {
+		return ErrorReply(err.Error())
+	}
+
+	s, ok := kvdb.lookupStream(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if s == nil {
+		return errNoSuchKey
+	}
+	if n := s.length(); n > 0 && id.less(s.entries[n-1].id) {
+		return ErrorReply("(error) ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	s.lastID = id
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) xrange(dbIndex int, cmd Command, rev bool) interface{} {
+	args := cmd.params()
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, inclusive, err := parseRangeID(startArg, 0)
+	if err != nil {
+		return ErrorReply(err.Error())
+	}
+	if !inclusive {
+		var ok bool
+		if start, ok = start.next(); !ok {
+			return []interface{}{}
+		}
+	}
+	end, inclusive, err := parseRangeID(endArg, math.MaxUint64)
+	if err != nil {
+		return ErrorReply(err.Error())
+	}
+	if !inclusive {
+		var ok bool
+		if end, ok = end.prev(); !ok {
+			return []interface{}{}
+		}
+	}
+
+	count := -1
+	switch len(args) {
+	case 3:
+	case 5:
+		if strings.ToUpper(args[3]) != "COUNT" {
+			return errSyntax
+		}
+		n, err := strconv.Atoi(args[4])
+		if err != nil {
+			return errNotInteger
+		}
+		if n <= 0 {
+			return []interface{}{}
+		}
+		count = n
+	default:
+		return errSyntax
+	}
+
+	s, ok := kvdb.lookupStream(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if s == nil {
+		return []interface{}{}
+	}
+	return entriesReply(s.between(start, end, count, rev))
+}
+
+// streamReadArgs holds the options shared by XREAD and XREADGROUP.
+type streamReadArgs struct {
+	count   int
+	block   bool
+	timeout int64
+	noAck   bool
+	group   string
+	member  string
+	keys    []string
+	ids     []string
+}
+
+// parseStreamRead parses the arguments of XREAD, or of XREADGROUP when
+// withGroup is set.
+func parseStreamRead(name string, args []string, withGroup bool) (streamReadArgs, ErrorReply) {
+	var ra streamReadArgs
+	for i := 0; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return ra, errNotInteger
+			}
+			if n < 0 {
+				n = 0
+			}
+			ra.count = n
+			i++
+		case opt == "BLOCK" && i+1 < len(args):
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return ra, ErrorReply("(error) ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return ra, ErrorReply("(error) ERR timeout is negative")
+			}
+			ra.block = true
+			ra.timeout = ms
+			i++
+		case opt == "GROUP" && withGroup && i+2 < len(args):
+			ra.group = args[i+1]
+			ra.member = args[i+2]
+			i += 2
+		case opt == "NOACK" && withGroup:
+			ra.noAck = true
+		case opt == "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return ra, ErrorReply(fmt.Sprintf("(error) ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", strings.ToLower(name)))
+			}
+			ra.keys = rest[:len(rest)/2]
+			ra.ids = rest[len(rest)/2:]
+			if withGroup && ra.group == "" {
+				return ra, ErrorReply("(error) ERR Missing GROUP option for XREADGROUP")
+			}
+			return ra, ""
+		default:
+			return ra, errSyntax
+		}
+	}
+	return ra, errSyntax
+}
+
+// streamReadKeys returns a function that finds the stream keys of XREAD,
+// or of XREADGROUP when withGroup is set.
+func streamReadKeys(withGroup bool) func(args []string) []string {
+	return func(args []string) []string {
+		ra, _ := parseStreamRead(XREAD, args, withGroup)
+		return ra.keys
+	}
+}
+
+func (kvdb *KeyValueDB) xread(session *Session, dbIndex int, cmd Command) interface{} {
+	ra, errMsg := parseStreamRead(XREAD, cmd.params(), false)
+	if errMsg != "" {
+		return errMsg
+	}
+
+	ids := make([]streamID, len(ra.keys))
+	for i, key := range ra.keys {
+		s, ok := kvdb.lookupStream(dbIndex, key)
+		if !ok {
+			return errWrongType
+		}
+		if ra.ids[i] == "$" {
+			if s != nil {
+				ids[i] = s.lastID
+			}
+			continue
+		}
+		id, err := parseStreamID(ra.ids[i], 0)
+		if err != nil {
+			return ErrorReply(err.Error())
+		}
+		ids[i] = id
+	}
+
+	deadline := blockDeadline(ra.timeout)
+	for {
+		var result []interface{}
+		for i, key := range ra.keys {
+			s, ok := kvdb.lookupStream(dbIndex, key)
+			if !ok {
+				return errWrongType
+			}
+			if s == nil {
+				continue
+			}
+			if entries := s.after(ids[i], ra.count); len(entries) > 0 {
+				result = append(result, []interface{}{key, entriesReply(entries)})
+			}
+		}
+		if len(result) > 0 {
+			return result
+		}
+		if !ra.block || kvdb.isExecuting || !kvdb.blockOn(session, dbIndex, ra.keys, deadline) {
+			return nil
+		}
+	}
+}
+
+// lookupGroup returns the stream and consumer group named by key and group,
+// or an error reply when either does not exist.
+func (kvdb *KeyValueDB) lookupGroup(dbIndex int, key, group string) (*stream, *consumerGroup, ErrorReply) {
+	s, ok := kvdb.lookupStream(dbIndex, key)
+	if !ok {
+		return nil, nil, errWrongType
+	}
+	if s != nil {
+		if g, ok := s.groups[group]; ok {
+			return s, g, ""
+		}
+	}
+	return nil, nil, ErrorReply(fmt.Sprintf("(error) NOGROUP No such key '%s' or consumer group '%s'", key, group))
+}
+
+func (kvdb *KeyValueDB) xgroup(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	sub := strings.ToUpper(args[0])
+
+	wrongArgs := ErrorReply(fmt.Sprintf("(error) ERR wrong number of arguments for 'xgroup|%s' command", strings.ToLower(sub)))
+	switch sub {
+	case "CREATE":
+		if len(args) < 4 || len(args) > 5 {
+			return wrongArgs
+		}
+	case "SETID", "CREATECONSUMER", "DELCONSUMER":
+		if len(args) != 4 {
+			return wrongArgs
+		}
+	case "DESTROY":
+		if len(args) != 3 {
+			return wrongArgs
+		}
+	default:
+		return ErrorReply(fmt.Sprintf("(error) ERR unknown subcommand '%s'. Try XGROUP HELP.", args[0]))
+	}
+
+	key, group := args[1], args[2]
+	s, ok := kvdb.lookupStream(dbIndex, key)
+	if !ok {
+		return errWrongType
+	}
+
+	if sub == "CREATE" {
+		if len(args) == 5 {
+			if strings.ToUpper(args[4]) != "MKSTREAM" {
+				return errSyntax
+			}
+			if s == nil {
+				s = newStream()
+				kvdb.storage.Set(dbIndex, []byte(key), s)
+			}
+		}
+		if s == nil {
+			return ErrorReply("(error) ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		}
+		id, errMsg := groupStartID(s, args[3])
+		if errMsg != "" {
+			return errMsg
+		}
+		if !s.createGroup(group, id) {
+			return ErrorReply("(error) BUSYGROUP Consumer Group name already exists")
+		}
+		return statusOK
+	}
+
+	if s == nil {
+		return ErrorReply("(error) ERR The XGROUP subcommand requires the key to exist.")
+	}
+	g, ok := s.groups[group]
+	if !ok {
+		if sub == "DESTROY" {
+			return 0
+		}
+		return ErrorReply(fmt.Sprintf("(error) NOGROUP No such consumer group '%s' for key name '%s'", group, key))
+	}
+
+	switch sub {
+	case "SETID":
+		id, errMsg := groupStartID(s, args[3])
+		if errMsg != "" {
+			return errMsg
+		}
+		g.lastID = id
+		return statusOK
+	case "DESTROY":
+		delete(s.groups, group)
+		// Wake consumers blocked on the group so they notice it is gone.
+		kvdb.signalKey(dbIndex, key)
+		return 1
+	case "CREATECONSUMER":
+		if _, created := g.consumer(args[3], timeNow()); created {
+			return 1
+		}
+		return 0
+	}
+	return g.deleteConsumer(args[3])
+}
+
+// groupStartID resolves the ID a consumer group starts reading after, where
+// "$" means the last ID of the stream.
+func groupStartID(s *stream, arg string) (streamID, ErrorReply) {
+	if arg == "$" {
+		return s.lastID, ""
+	}
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return streamID{}, ErrorReply(err.Error())
+	}
+	return id, ""
+}
+
+func (kvdb *KeyValueDB) xreadgroup(session *Session, dbIndex int, cmd Command) interface{} {
+	ra, errMsg := parseStreamRead(XREADGROUP, cmd.params(), true)
+	if errMsg != "" {
+		return errMsg
+	}
+
+	// ">" asks for new entries; any other ID reads the consumer's history.
+	ids := make([]streamID, len(ra.keys))
+	onlyNew := true
+	for i, arg := range ra.ids {
+		if arg == ">" {
+			continue
+		}
+		onlyNew = false
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return ErrorReply(err.Error())
+		}
+		ids[i] = id
+	}
+
+	deadline := blockDeadline(ra.timeout)
+	for {
+		result := make([]interface{}, 0, len(ra.keys))
+		now := timeNow()
+		for i, key := range ra.keys {
+			s, g, errMsg := kvdb.lookupGroup(dbIndex, key, ra.group)
+			if errMsg != "" {
+				if errMsg == errWrongType {
+					return errMsg
+				}
+				return ErrorReply(fmt.Sprintf("(error) NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, ra.group))
+			}
+			c, _ := g.consumer(ra.member, now)
+			c.seenTime = now
+
+			if ra.ids[i] != ">" {
+				result = append(result, []interface{}{key, consumerHistory(s, c, ids[i], ra.count)})
+				continue
+			}
+
+			entries := s.after(g.lastID, ra.count)
+			for _, e := range entries {
+				g.lastID = e.id
+				if !ra.noAck {
+					g.deliver(e.id, c, now)
+				}
+			}
+			if len(entries) > 0 {
+				result = append(result, []interface{}{key, entriesReply(entries)})
+			}
+		}
+		if len(result) > 0 {
+			return result
+		}
+		if !onlyNew || !ra.block || kvdb.isExecuting || !kvdb.blockOn(session, dbIndex, ra.keys, deadline) {
+			return nil
+		}
+	}
+}
+
+// consumerHistory returns the entries pending for consumer c with IDs
+// greater than after. Entries deleted from the stream are reported with a
+// nil body.
+func consumerHistory(s *stream, c *streamConsumer, after streamID, count int) []interface{} {
+	reply := make([]interface{}, 0)
+	for _, pe := range sortedPending(c.pending) {
+		if !after.less(pe.id) {
+			continue
+		}
+		if count > 0 && len(reply) >= count {
+			break
+		}
+		if e, ok := s.get(pe.id); ok {
+			reply = append(reply, e.reply())
+		} else {
+			reply = append(reply, []interface{}{pe.id.String(), nil})
+		}
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) xack(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	ids := make([]streamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return ErrorReply(err.Error())
+		}
+		ids = append(ids, id)
+	}
+
+	_, g, errMsg := kvdb.lookupGroup(dbIndex, cmd.arg(0), args[1])
+	if errMsg == errWrongType {
+		return errMsg
+	}
+	if g == nil {
+		return 0
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	return acked
+}
+
+func (kvdb *KeyValueDB) xpending(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	_, g, errMsg := kvdb.lookupGroup(dbIndex, cmd.arg(0), args[1])
+	if errMsg != "" {
+		return errMsg
+	}
+
+	if len(args) == 2 {
+		return pendingSummary(g)
+	}
+
+	rest := args[2:]
+	var minIdle int64
+	if strings.ToUpper(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			return errSyntax
+		}
+		n, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil {
+			return errNotInteger
+		}
+		minIdle = n
+		rest = rest[2:]
+	}
+	if len(rest) < 3 || len(rest) > 4 {
+		return errSyntax
+	}
+
+	start, inclusive, err := parseRangeID(rest[0], 0)
+	if err != nil {
+		return ErrorReply(err.Error())
+	}
+	if !inclusive {
+		var ok bool
+		if start, ok = start.next(); !ok {
+			return []interface{}{}
+		}
+	}
+	end, inclusive, err := parseRangeID(rest[1], math.MaxUint64)
+	if err != nil {
+		return ErrorReply(err.Error())
+	}
+	if !inclusive {
+		var ok bool
+		if end, ok = end.prev(); !ok {
+			return []interface{}{}
+		}
+	}
+	count, err := strconv.Atoi(rest[2])
+	if err != nil {
+		return errNotInteger
+	}
+
+	pel := g.pel
+	if len(rest) == 4 {
+		c, ok := g.consumers[rest[3]]
+		if !ok {
+			return []interface{}{}
+		}
+		pel = c.pending
+	}
+
+	now := timeNow()
+	reply := make([]interface{}, 0)
+	for _, pe := range sortedPending(pel) {
+		if len(reply) >= count {
+			break
+		}
+		if pe.id.less(start) || end.less(pe.id) {
+			continue
+		}
+		idle := now.Sub(pe.deliveryTime).Milliseconds()
+		if idle < minIdle {
+			continue
+		}
+		reply = append(reply, []interface{}{pe.id.String(), pe.consumer.name, idle, int(pe.deliveryCount)})
+	}
+	return reply
+}
+
+// pendingSummary builds the reply of the short form of XPENDING.
+func pendingSummary(g *consumerGroup) interface{} {
+	if len(g.pel) == 0 {
+		return []interface{}{0, nil, nil, nil}
+	}
+	pending := sortedPending(g.pel)
+	consumers := make([]interface{}, 0)
+	for _, name := range g.consumerNames() {
+		if n := len(g.consumers[name].pending); n > 0 {
+			consumers = append(consumers, []interface{}{name, strconv.Itoa(n)})
+		}
+	}
+	return []interface{}{
+		len(pending),
+		pending[0].id.String(),
+		pending[len(pending)-1].id.String(),
+		consumers,
+	}
+}
+
+func (kvdb *KeyValueDB) xclaim(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	group, member := args[1], args[2]
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return ErrorReply("(error) ERR Invalid min-idle-time argument for XCLAIM")
+	}
+
+	var ids []streamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return ErrorReply(errInvalidStreamID.Error())
+	}
+
+	now := timeNow()
+	deliveryTime := now
+	var retryCount *uint64
+	var lastID *streamID
+	force, justID := false, false
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		hasValue := i+1 < len(args)
+		switch {
+		case opt == "FORCE":
+			force = true
+		case opt == "JUSTID":
+			justID = true
+		case opt == "IDLE" && hasValue:
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return ErrorReply("(error) ERR Invalid IDLE option argument for XCLAIM")
+			}
+			deliveryTime = now.Add(-time.Duration(ms) * time.Millisecond)
+			i++
+		case opt == "TIME" && hasValue:
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return ErrorReply("(error) ERR Invalid TIME option argument for XCLAIM")
+			}
+			deliveryTime = time.Unix(0, ms*int64(time.Millisecond))
+			i++
+		case opt == "RETRYCOUNT" && hasValue:
+			n, err := strconv.ParseUint(args[i+1], 10, 64)
+			if err != nil {
+				return ErrorReply("(error) ERR Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+			retryCount = &n
+			i++
+		case opt == "LASTID" && hasValue:
+			id, err := parseStreamID(args[i+1], 0)
+			if err != nil {
+				return ErrorReply(err.Error())
+			}
+			lastID = &id
+			i++
+		default:
+			return ErrorReply(fmt.Sprintf("(error) ERR Unrecognized XCLAIM option '%s'", args[i]))
+		}
+	}
+
+	s, g, errMsg := kvdb.lookupGroup(dbIndex, cmd.arg(0), group)
+	if errMsg != "" {
+		return errMsg
+	}
+	if lastID != nil && g.lastID.less(*lastID) {
+		g.lastID = *lastID
+	}
+
+	c, _ := g.consumer(member, now)
+	c.seenTime = now
+	reply := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		entry, exists := s.get(id)
+		pe, pending := g.pel[id]
+		if !exists {
+			// The entry was deleted from the stream, so nobody can process it.
+			if pending {
+				g.ack(id)
+			}
+			continue
+		}
+		if !pending {
+			if !force {
+				continue
+			}
+			pe = &pendingEntry{id: id, consumer: c}
+			g.pel[id] = pe
+			c.pending[id] = pe
+		} else if minIdle > 0 && now.Sub(pe.deliveryTime).Milliseconds() < minIdle {
+			continue
+		}
+
+		delete(pe.consumer.pending, id)
+		pe.consumer = c
+		c.pending[id] = pe
+		pe.deliveryTime = deliveryTime
+		if !justID {
+			pe.deliveryCount++
+		}
+		if retryCount != nil {
+			pe.deliveryCount = *retryCount
+		}
+
+		if justID {
+			reply = append(reply, id.String())
+		} else {
+			reply = append(reply, entry.reply())
+		}
+	}
+	return reply
+}
+
+// compactStream returns the commands that recreate the stream s at key,
+// including its consumer groups and their pending entries.
+func compactStream(key string, s *stream) []string {
+	var lines []string
+	k := compactArg(key)
+	if s.length() == 0 {
+		// An empty stream can only be created by adding an entry and
+		// trimming it away.
+		lines = append(lines, fmt.Sprintf("XADD %s MAXLEN 0 0-1 x y", k))
+	}
+	for _, e := range s.entries {
+		line := fmt.Sprintf("XADD %s %s", k, e.id)
+		for _, f := range e.fields {
+			line += " " + compactArg(f)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fmt.Sprintf("XSETID %s %s", k, s.lastID))
+
+	for _, name := range s.groupNames() {
+		g := s.groups[name]
+		gname := compactArg(name)
+		lines = append(lines, fmt.Sprintf("XGROUP CREATE %s %s %s", k, gname, g.lastID))
+		for _, cname := range g.consumerNames() {
+			lines = append(lines, fmt.Sprintf("XGROUP CREATECONSUMER %s %s %s", k, gname, compactArg(cname)))
+		}
+		for _, pe := range sortedPending(g.pel) {
+			lines = append(lines, fmt.Sprintf("XCLAIM %s %s %s 0 %s TIME %d RETRYCOUNT %d FORCE JUSTID",
+				k, gname, compactArg(pe.consumer.name), pe.id,
+				pe.deliveryTime.UnixNano()/int64(time.Millisecond), pe.deliveryCount))
+		}
+	}
+	return lines
+}
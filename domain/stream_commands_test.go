@@ -0,0 +1,308 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStreamCommands(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	entry := func(id string, fields ...interface{}) interface{} {
+		return []interface{}{id, fields}
+	}
+
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "XADD generates IDs from the clock",
+			commands: []Command{
+				NewCommand(XADD, "s", "*", "a", "1"),
+				NewCommand(XADD, "s", "*", "b", "2"),
+				NewCommand(XLEN, "s"),
+			},
+			expected: []interface{}{"1700000000000-0", "1700000000000-1", 2},
+		},
+		{
+			name: "XADD rejects IDs that do not grow",
+			commands: []Command{
+				NewCommand(XADD, "s", "5-1", "a", "1"),
+				NewCommand(XADD, "s", "5-1", "a", "1"),
+				NewCommand(XADD, "s", "5-*", "a", "1"),
+				NewCommand(XADD, "t", "0-0", "a", "1"),
+			},
+			expected: []interface{}{"5-1", errXAddIDTooSmall, "5-2", errXAddIDZero},
+		},
+		{
+			name: "XADD with odd number of fields",
+			commands: []Command{
+				NewCommand(XADD, "s", "*", "a", "1", "b"),
+			},
+			expected: []interface{}{ErrorReply("(error) ERR wrong number of arguments for 'xadd' command")},
+		},
+		{
+			name: "XADD with NOMKSTREAM on missing key",
+			commands: []Command{
+				NewCommand(XADD, "s", "NOMKSTREAM", "*", "a", "1"),
+				NewCommand(XLEN, "s"),
+			},
+			expected: []interface{}{nil, 0},
+		},
+		{
+			name: "XADD with MAXLEN trims the oldest entries",
+			commands: []Command{
+				NewCommand(XADD, "s", "1-1", "a", "1"),
+				NewCommand(XADD, "s", "1-2", "a", "2"),
+				NewCommand(XADD, "s", "MAXLEN", "~", "2", "1-3", "a", "3"),
+				NewCommand(XRANGE, "s", "-", "+"),
+			},
+			expected: []interface{}{"1-1", "1-2", "1-3", []interface{}{entry("1-2", "a", "2"), entry("1-3", "a", "3")}},
+		},
+		{
+			name: "XRANGE and XREVRANGE with bounds and COUNT",
+			commands: []Command{
+				NewCommand(XADD, "s", "1-1", "a", "1"),
+				NewCommand(XADD, "s", "2-1", "a", "2"),
+				NewCommand(XADD, "s", "3-1", "a", "3"),
+				NewCommand(XRANGE, "s", "(1-1", "3", "COUNT", "1"),
+				NewCommand(XREVRANGE, "s", "+", "-", "COUNT", "2"),
+				NewCommand(XRANGE, "missing", "-", "+"),
+			},
+			expected: []interface{}{
+				"1-1", "2-1", "3-1",
+				[]interface{}{entry("2-1", "a", "2")},
+				[]interface{}{entry("3-1", "a", "3"), entry("2-1", "a", "2")},
+				[]interface{}{},
+			},
+		},
+		{
+			name: "XDEL and XTRIM",
+			commands: []Command{
+				NewCommand(XADD, "s", "1-1", "a", "1"),
+				NewCommand(XADD, "s", "2-1", "a", "2"),
+				NewCommand(XADD, "s", "3-1", "a", "3"),
+				NewCommand(XDEL, "s", "2-1", "9-9"),
+				NewCommand(XTRIM, "s", "MINID", "3"),
+				NewCommand(XRANGE, "s", "-", "+"),
+			},
+			expected: []interface{}{"1-1", "2-1", "3-1", 1, 1, []interface{}{entry("3-1", "a", "3")}},
+		},
+		{
+			name: "Stream commands against a string",
+			commands: []Command{
+				NewCommand(SET, "foo", "bar"),
+				NewCommand(XADD, "foo", "*", "a", "1"),
+				NewCommand(XLEN, "foo"),
+			},
+			expected: []interface{}{statusOK, errWrongType, errWrongType},
+		},
+		{
+			name: "GET against a stream",
+			commands: []Command{
+				NewCommand(XADD, "s", "1-1", "a", "1"),
+				NewCommand(GET, "s"),
+				NewCommand(INCR, "s"),
+			},
+			expected: []interface{}{"1-1", errWrongType, errWrongType},
+		},
+		{
+			name: "XREAD without BLOCK",
+			commands: []Command{
+				NewCommand(XADD, "s1", "1-1", "a", "1"),
+				NewCommand(XADD, "s2", "2-1", "b", "2"),
+				NewCommand(XREAD, "COUNT", "10", "STREAMS", "s1", "s2", "0", "2-1"),
+				NewCommand(XREAD, "STREAMS", "s1", "$"),
+				NewCommand(XREAD, "COUNT", "1", "STREAMS", "s1"),
+			},
+			expected: []interface{}{
+				"1-1", "2-1",
+				[]interface{}{[]interface{}{"s1", []interface{}{entry("1-1", "a", "1")}}},
+				nil,
+				ErrorReply("(error) ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."),
+			},
+		},
+		{
+			name: "XREAD with BLOCK times out",
+			commands: []Command{
+				NewCommand(XREAD, "BLOCK", "1", "STREAMS", "s", "$"),
+			},
+			expected: []interface{}{nil},
+		},
+		{
+			name: "XGROUP CREATE",
+			commands: []Command{
+				NewCommand(XGROUP, "CREATE", "s", "g", "$"),
+				NewCommand(XGROUP, "CREATE", "s", "g", "$", "MKSTREAM"),
+				NewCommand(XGROUP, "CREATE", "s", "g", "$"),
+				NewCommand(XGROUP, "CREATECONSUMER", "s", "g", "alice"),
+				NewCommand(XGROUP, "CREATECONSUMER", "s", "g", "alice"),
+				NewCommand(XGROUP, "DESTROY", "s", "g"),
+				NewCommand(XGROUP, "DESTROY", "s", "g"),
+				NewCommand(XGROUP, "FOO", "s", "g"),
+			},
+			expected: []interface{}{
+				ErrorReply("(error) ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."),
+				statusOK,
+				ErrorReply("(error) BUSYGROUP Consumer Group name already exists"),
+				1, 0, 1, 0,
+				ErrorReply("(error) ERR unknown subcommand 'FOO'. Try XGROUP HELP."),
+			},
+		},
+		{
+			name: "XREADGROUP delivers each entry once and tracks it as pending",
+			commands: []Command{
+				NewCommand(XADD, "s", "1-1", "a", "1"),
+				NewCommand(XADD, "s", "2-1", "a", "2"),
+				NewCommand(XGROUP, "CREATE", "s", "g", "0"),
+				NewCommand(XREADGROUP, "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">"),
+				NewCommand(XREADGROUP, "GROUP", "g", "bob", "STREAMS", "s", ">"),
+				NewCommand(XREADGROUP, "GROUP", "g", "bob", "STREAMS", "s", ">"),
+				NewCommand(XREADGROUP, "GROUP", "g", "alice", "STREAMS", "s", "0"),
+				NewCommand(XPENDING, "s", "g"),
+				NewCommand(XACK, "s", "g", "1-1", "1-1"),
+				NewCommand(XPENDING, "s", "g", "-", "+", "10"),
+			},
+			expected: []interface{}{
+				"1-1", "2-1", statusOK,
+				[]interface{}{[]interface{}{"s", []interface{}{entry("1-1", "a", "1")}}},
+				[]interface{}{[]interface{}{"s", []interface{}{entry("2-1", "a", "2")}}},
+				nil,
+				[]interface{}{[]interface{}{"s", []interface{}{entry("1-1", "a", "1")}}},
+				[]interface{}{2, "1-1", "2-1", []interface{}{
+					[]interface{}{"alice", "1"},
+					[]interface{}{"bob", "1"},
+				}},
+				1,
+				[]interface{}{[]interface{}{"2-1", "bob", int64(0), 1}},
+			},
+		},
+		{
+			name: "XREADGROUP with NOACK and unknown group",
+			commands: []Command{
+				NewCommand(XADD, "s", "1-1", "a", "1"),
+				NewCommand(XGROUP, "CREATE", "s", "g", "0"),
+				NewCommand(XREADGROUP, "GROUP", "g", "alice", "NOACK", "STREAMS", "s", ">"),
+				NewCommand(XPENDING, "s", "g"),
+				NewCommand(XREADGROUP, "GROUP", "nope", "alice", "STREAMS", "s", ">"),
+			},
+			expected: []interface{}{
+				"1-1", statusOK,
+				[]interface{}{[]interface{}{"s", []interface{}{entry("1-1", "a", "1")}}},
+				[]interface{}{0, nil, nil, nil},
+				ErrorReply("(error) NOGROUP No such key 's' or consumer group 'nope' in XREADGROUP with GROUP option"),
+			},
+		},
+		{
+			name: "XCLAIM moves pending entries to another consumer",
+			commands: []Command{
+				NewCommand(XADD, "s", "1-1", "a", "1"),
+				NewCommand(XADD, "s", "2-1", "a", "2"),
+				NewCommand(XGROUP, "CREATE", "s", "g", "0"),
+				NewCommand(XREADGROUP, "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">"),
+				NewCommand(XCLAIM, "s", "g", "bob", "3600000", "1-1"),
+				NewCommand(XCLAIM, "s", "g", "bob", "0", "1-1"),
+				NewCommand(XCLAIM, "s", "g", "bob", "0", "2-1", "JUSTID"),
+				NewCommand(XCLAIM, "s", "g", "bob", "0", "2-1", "FORCE", "JUSTID"),
+				NewCommand(XPENDING, "s", "g", "-", "+", "10", "bob"),
+			},
+			expected: []interface{}{
+				"1-1", "2-1", statusOK,
+				[]interface{}{[]interface{}{"s", []interface{}{entry("1-1", "a", "1")}}},
+				[]interface{}{},
+				[]interface{}{entry("1-1", "a", "1")},
+				[]interface{}{},
+				[]interface{}{"2-1"},
+				[]interface{}{
+					[]interface{}{"1-1", "bob", int64(0), 2},
+					[]interface{}{"2-1", "bob", int64(0), 0},
+				},
+			},
+		},
+		{
+			name: "MULTI does not block",
+			commands: []Command{
+				NewCommand(MULTI),
+				NewCommand(XREAD, "BLOCK", "0", "STREAMS", "s", "$"),
+				NewCommand(EXEC),
+			},
+			expected: []interface{}{statusOK, statusQueued, []interface{}{nil}},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamBlockingRead(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+	reader := kvdb.NewSession(Conn{})
+
+	done := make(chan interface{})
+	go func() {
+		result := kvdb.Run(reader, NewCommand(XREAD, "BLOCK", "0", "STREAMS", "s", "$"))
+		done <- result
+	}()
+
+	// Keep adding until the reader has blocked and picked up an entry.
+	timeout := time.After(5 * time.Second)
+	for i := 1; ; i++ {
+		select {
+		case result := <-done:
+			items, ok := result.([]interface{})
+			if !ok || len(items) != 1 {
+				t.Fatalf("XREAD BLOCK returned %#v", result)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+			kvdb.Execute(0, NewCommand(XADD, "s", "*", "n", i))
+		case <-timeout:
+			t.Fatal("XREAD BLOCK never returned")
+		}
+	}
+}
+
+func TestCompactStream(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	for _, cmd := range []Command{
+		NewCommand(XADD, "s", "1-1", "field", "hello world"),
+		NewCommand(XADD, "s", "2-1", "a", "1"),
+		NewCommand(XGROUP, "CREATE", "s", "g", "0"),
+		NewCommand(XREADGROUP, "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">"),
+	} {
+		kvdb.Execute(0, cmd)
+	}
+
+	_, got := kvdb.Execute(0, NewCommand(COMPACT))
+	lines, ok := got.([]interface{})
+	if !ok || len(lines) != 6 {
+		t.Fatalf("COMPACT returned %#v", got)
+	}
+
+	want := []interface{}{
+		`XADD s 1-1 field "hello world"`,
+		"XADD s 2-1 a 1",
+		"XSETID s 2-1",
+		"XGROUP CREATE s g 1-1",
+		"XGROUP CREATECONSUMER s g alice",
+	}
+	if !reflect.DeepEqual(lines[:5], want) {
+		t.Errorf("COMPACT returned %q, want prefix %q", lines, want)
+	}
+}
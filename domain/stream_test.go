@@ -0,0 +1,152 @@
+package domain
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseStreamID(t *testing.T) {
+	tests := []struct {
+		name       string
+		s          string
+		missingSeq uint64
+		want       streamID
+		wantErr    bool
+	}{
+		{
+			name: "Complete ID",
+			s:    "1526919030474-55",
+			want: streamID{1526919030474, 55},
+		},
+		{
+			name:       "Incomplete ID uses the missing sequence",
+			s:          "1526919030474",
+			missingSeq: math.MaxUint64,
+			want:       streamID{1526919030474, math.MaxUint64},
+		},
+		{
+			name:    "Missing sequence after dash",
+			s:       "10-",
+			wantErr: true,
+		},
+		{
+			name:    "Not a number",
+			s:       "abc-1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStreamID(tt.s, tt.missingSeq)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStreamID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseStreamID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamBetween(t *testing.T) {
+	s := newStream()
+	for seq := uint64(1); seq <= 5; seq++ {
+		s.add(streamID{1, seq}, []string{"n", "v"})
+	}
+
+	ids := func(entries []streamEntry) []streamID {
+		var out []streamID
+		for _, e := range entries {
+			out = append(out, e.id)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name       string
+		start, end streamID
+		count      int
+		rev        bool
+		want       []streamID
+	}{
+		{
+			name:  "Whole stream",
+			start: minStreamID,
+			end:   maxStreamID,
+			want:  []streamID{{1, 1}, {1, 2}, {1, 3}, {1, 4}, {1, 5}},
+		},
+		{
+			name:  "Inclusive bounds with count",
+			start: streamID{1, 2},
+			end:   streamID{1, 4},
+			count: 2,
+			want:  []streamID{{1, 2}, {1, 3}},
+		},
+		{
+			name:  "Reverse with count",
+			start: streamID{1, 2},
+			end:   streamID{1, 4},
+			count: 2,
+			rev:   true,
+			want:  []streamID{{1, 4}, {1, 3}},
+		},
+		{
+			name:  "Empty range",
+			start: streamID{2, 0},
+			end:   maxStreamID,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(s.between(tt.start, tt.end, tt.count, tt.rev))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("between() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	s := newStream()
+	for seq := uint64(1); seq <= 5; seq++ {
+		s.add(streamID{1, seq}, []string{"n", "v"})
+	}
+
+	if got := s.trimMaxLen(3); got != 2 {
+		t.Errorf("trimMaxLen(3) = %d, want 2", got)
+	}
+	if got := s.trimMinID(streamID{1, 5}); got != 2 {
+		t.Errorf("trimMinID(1-5) = %d, want 2", got)
+	}
+	if s.length() != 1 || s.entries[0].id != (streamID{1, 5}) {
+		t.Errorf("stream after trimming = %v, want only 1-5", s.entries)
+	}
+	if s.lastID != (streamID{1, 5}) {
+		t.Errorf("lastID = %v, want 1-5", s.lastID)
+	}
+}
+
+func TestConsumerGroupDeliverAndAck(t *testing.T) {
+	s := newStream()
+	s.createGroup("g", minStreamID)
+	g := s.groups["g"]
+	alice, _ := g.consumer("alice", timeNow())
+	bob, _ := g.consumer("bob", timeNow())
+
+	g.deliver(streamID{1, 1}, alice, timeNow())
+	g.deliver(streamID{1, 1}, bob, timeNow())
+
+	if len(alice.pending) != 0 || len(bob.pending) != 1 {
+		t.Fatalf("redelivery did not move the entry: alice=%d bob=%d", len(alice.pending), len(bob.pending))
+	}
+	if got := g.pel[streamID{1, 1}].deliveryCount; got != 2 {
+		t.Errorf("deliveryCount = %d, want 2", got)
+	}
+	if !g.ack(streamID{1, 1}) || g.ack(streamID{1, 1}) {
+		t.Errorf("ack should succeed once")
+	}
+	if len(g.pel) != 0 || len(bob.pending) != 0 {
+		t.Errorf("ack left pending entries behind")
+	}
+}
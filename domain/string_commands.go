@@ -0,0 +1,470 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxStringLength  = 512 * 1024 * 1024
+	errStringTooLong = ErrorReply("(error) ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	errOverflow      = ErrorReply("(error) ERR increment or decrement would overflow")
+)
+
+// lookupString returns the string stored at key. It reports false when the
+// key holds another type; a missing key gives ("", true) with exists false.
+func (kvdb *KeyValueDB) lookupString(dbIndex int, key string) (str string, exists, ok bool) {
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	if v == nil {
+		return "", false, true
+	}
+	str, ok = stringValue(v)
+	return str, true, ok
+}
+
+// replaceValue stores v at key as a new value, dropping any expiry the key
+// had, as SET does.
+func (kvdb *KeyValueDB) replaceValue(dbIndex int, key string, v interface{}) {
+	kvdb.storage.Set(dbIndex, []byte(key), v)
+	kvdb.storage.Expire(dbIndex, []byte(key), time.Time{})
+	kvdb.signalKey(dbIndex, key)
+}
+
+// parseExpiry converts the argument of EX, PX, EXAT or PXAT into the time
+// the key expires.
+func parseExpiry(opt, arg, cmdName string) (time.Time, ErrorReply) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return time.Time{}, errNotInteger
+	}
+	invalid := ErrorReply(fmt.Sprintf("(error) ERR invalid expire time in '%s' command", cmdName))
+	if n <= 0 {
+		return time.Time{}, invalid
+	}
+	ms := n
+	if opt == "EX" || opt == "EXAT" {
+		if n > math.MaxInt64/1000 {
+			return time.Time{}, invalid
+		}
+		ms = n * 1000
+	}
+	if opt == "EX" || opt == "PX" {
+		now := timeNow().UnixMilli()
+		if ms > math.MaxInt64-now {
+			return time.Time{}, invalid
+		}
+		ms += now
+	}
+	return time.UnixMilli(ms), ""
+}
+
+func (kvdb *KeyValueDB) set(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	key, value := args[0], cmd.argBytes(1)
+	var nx, xx, get, keepTTL bool
+	var expireAt time.Time
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if !expireAt.IsZero() || i+1 >= len(args) {
+				return errSyntax
+			}
+			at, errMsg := parseExpiry(opt, args[i+1], "set")
+			if errMsg != "" {
+				return errMsg
+			}
+			expireAt = at
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	if (nx && xx) || (keepTTL && !expireAt.IsZero()) {
+		return errSyntax
+	}
+
+	old, exists, ok := kvdb.lookupString(dbIndex, key)
+	var reply interface{} = statusOK
+	if get {
+		if !ok {
+			return errWrongType
+		}
+		reply = nil
+		if exists {
+			reply = old
+		}
+	}
+	if (nx && exists) || (xx && !exists) {
+		if get {
+			return reply
+		}
+		return nil
+	}
+
+	if keepTTL {
+		kvdb.storage.Set(dbIndex, []byte(key), value)
+		kvdb.signalKey(dbIndex, key)
+	} else {
+		kvdb.replaceValue(dbIndex, key, value)
+		if !expireAt.IsZero() {
+			kvdb.storage.Expire(dbIndex, []byte(key), expireAt)
+		}
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) get(dbIndex int, cmd Command) interface{} {
+	v := kvdb.storage.Get(dbIndex, cmd.Argv[0])
+	if v == nil {
+		return nil
+	}
+	str, ok := stringValue(v)
+	if !ok {
+		return errWrongType
+	}
+	return str
+}
+
+// lookupInt returns the integer stored at key, or 0 when the key is
+// missing.
+func (kvdb *KeyValueDB) lookupInt(dbIndex int, key string) (int64, ErrorReply) {
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	switch n := v.(type) {
+	case nil:
+		return 0, ""
+	case int64:
+		return n, ""
+	}
+	str, ok := stringValue(v)
+	if !ok {
+		return 0, errWrongType
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	return n, ""
+}
+
+// incrBy adds delta to the integer at key. The result is stored as an
+// int64, so a counter is only parsed the first time it is incremented.
+func (kvdb *KeyValueDB) incrBy(dbIndex int, key string, delta int64) interface{} {
+	n, errMsg := kvdb.lookupInt(dbIndex, key)
+	if errMsg != "" {
+		return errMsg
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return errOverflow
+	}
+	n += delta
+	kvdb.storage.Set(dbIndex, []byte(key), n)
+	kvdb.signalKey(dbIndex, key)
+	return strconv.FormatInt(n, 10)
+}
+
+func (kvdb *KeyValueDB) incr(dbIndex int, cmd Command) interface{} {
+	return kvdb.incrBy(dbIndex, cmd.arg(0), 1)
+}
+
+func (kvdb *KeyValueDB) decr(dbIndex int, cmd Command) interface{} {
+	return kvdb.incrBy(dbIndex, cmd.arg(0), -1)
+}
+
+func (kvdb *KeyValueDB) incrby(dbIndex int, cmd Command) interface{} {
+	delta, err := strconv.ParseInt(cmd.arg(1), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	return kvdb.incrBy(dbIndex, cmd.arg(0), delta)
+}
+
+func (kvdb *KeyValueDB) decrby(dbIndex int, cmd Command) interface{} {
+	delta, err := strconv.ParseInt(cmd.arg(1), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	if delta == math.MinInt64 {
+		return ErrorReply("(error) ERR decrement would overflow")
+	}
+	return kvdb.incrBy(dbIndex, cmd.arg(0), -delta)
+}
+
+func (kvdb *KeyValueDB) incrbyfloat(dbIndex int, cmd Command) interface{} {
+	key := cmd.arg(0)
+	incr, ok := parseDecimalFloat(cmd.arg(1))
+	if !ok {
+		return errNotFloat
+	}
+	var n float64
+	switch v := kvdb.storage.Get(dbIndex, []byte(key)).(type) {
+	case nil:
+	case int64:
+		n = float64(v)
+	default:
+		str, ok := stringValue(v)
+		if !ok {
+			return errWrongType
+		}
+		if n, ok = parseDecimalFloat(str); !ok {
+			return errNotFloat
+		}
+	}
+
+	n += incr
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return ErrorReply("(error) ERR increment would produce NaN or Infinity")
+	}
+	// Like Redis, the result is written out in full rather than with an
+	// exponent, and with no trailing zeros.
+	str := strconv.FormatFloat(n, 'f', -1, 64)
+	kvdb.storage.Set(dbIndex, []byte(key), []byte(str))
+	kvdb.signalKey(dbIndex, key)
+	return str
+}
+
+// parseDecimalFloat parses a finite float written in decimal, with an
+// optional sign, fraction and exponent, as in "-1.5e3". strconv.ParseFloat
+// alone would also accept hexadecimal floats, underscores between digits,
+// infinities and NaN.
+func parseDecimalFloat(s string) (float64, bool) {
+	i := 0
+	skipDigits := func() int {
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i - start
+	}
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digits := skipDigits()
+	if i < len(s) && s[i] == '.' {
+		i++
+		digits += skipDigits()
+	}
+	if digits == 0 {
+		return 0, false
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if skipDigits() == 0 {
+			return 0, false
+		}
+	}
+	if i != len(s) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+func (kvdb *KeyValueDB) setnx(dbIndex int, cmd Command) interface{} {
+	if kvdb.storage.Get(dbIndex, cmd.Argv[0]) != nil {
+		return 0
+	}
+	kvdb.replaceValue(dbIndex, cmd.arg(0), cmd.argBytes(1))
+	return 1
+}
+
+func (kvdb *KeyValueDB) getset(dbIndex int, cmd Command) interface{} {
+	old, exists, ok := kvdb.lookupString(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	kvdb.replaceValue(dbIndex, cmd.arg(0), cmd.argBytes(1))
+	if !exists {
+		return nil
+	}
+	return old
+}
+
+func (kvdb *KeyValueDB) getdel(dbIndex int, cmd Command) interface{} {
+	str, exists, ok := kvdb.lookupString(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if !exists {
+		return nil
+	}
+	kvdb.storage.Del(dbIndex, cmd.Argv[0])
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return str
+}
+
+func (kvdb *KeyValueDB) getex(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	var expireAt time.Time
+	persist := false
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "PERSIST":
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if !expireAt.IsZero() || i+1 >= len(args) {
+				return errSyntax
+			}
+			at, errMsg := parseExpiry(opt, args[i+1], "getex")
+			if errMsg != "" {
+				return errMsg
+			}
+			expireAt = at
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	if persist && !expireAt.IsZero() {
+		return errSyntax
+	}
+
+	str, exists, ok := kvdb.lookupString(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if !exists {
+		return nil
+	}
+	if persist || !expireAt.IsZero() {
+		kvdb.storage.Expire(dbIndex, cmd.Argv[0], expireAt)
+	}
+	return str
+}
+
+func (kvdb *KeyValueDB) mget(dbIndex int, cmd Command) interface{} {
+	keys := cmd.params()
+	reply := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if str, ok := stringValue(kvdb.storage.Get(dbIndex, []byte(key))); ok {
+			reply[i] = str
+		}
+	}
+	return reply
+}
+
+// mset implements MSET and, with nx, MSETNX, which sets nothing if any of
+// the keys exists. Commands run under the database lock, so either sets
+// every key before another command runs.
+func (kvdb *KeyValueDB) mset(dbIndex int, cmd Command, nx bool) interface{} {
+	args := cmd.params()
+	if len(args)%2 != 0 {
+		return ErrorReply(fmt.Sprintf("(error) ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Name)))
+	}
+	if nx {
+		for i := 0; i < len(args); i += 2 {
+			if kvdb.storage.Get(dbIndex, []byte(args[i])) != nil {
+				return 0
+			}
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		kvdb.replaceValue(dbIndex, args[i], cmd.argBytes(i+1))
+	}
+	if nx {
+		return 1
+	}
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) appendString(dbIndex int, cmd Command) interface{} {
+	b, ok := kvdb.lookupBytes(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	suffix := cmd.params()[1]
+	if len(b)+len(suffix) > maxStringLength {
+		return errStringTooLong
+	}
+	b = append(b, suffix...)
+	kvdb.storage.Set(dbIndex, cmd.Argv[0], b)
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return len(b)
+}
+
+func (kvdb *KeyValueDB) strlen(dbIndex int, cmd Command) interface{} {
+	b, ok := kvdb.lookupBytes(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	return len(b)
+}
+
+func (kvdb *KeyValueDB) getrange(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	start, err1 := strconv.Atoi(args[1])
+	end, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return errNotInteger
+	}
+	b, ok := kvdb.lookupBytes(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+
+	n := len(b)
+	if start < 0 && end < 0 && start > end {
+		return ""
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if n == 0 || start > end {
+		return ""
+	}
+	return string(b[start : end+1])
+}
+
+func (kvdb *KeyValueDB) setrange(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	offset, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errNotInteger
+	}
+	if offset < 0 {
+		return ErrorReply("(error) ERR offset is out of range")
+	}
+	value := args[2]
+
+	b, ok := kvdb.lookupBytes(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if value == "" {
+		// Nothing to write, so a missing key is not created.
+		return len(b)
+	}
+	// Compared this way round so that a huge offset cannot overflow.
+	if offset > maxStringLength-len(value) {
+		return errStringTooLong
+	}
+	b, _ = kvdb.mutableBytes(dbIndex, cmd.arg(0), uint64(offset+len(value)))
+	copy(b[offset:], value)
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return len(b)
+}
@@ -0,0 +1,266 @@
+package domain
+
+import (
+	"keyvaluedb/storage"
+	"reflect"
+	"testing"
+)
+
+func TestStringCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "SET NX, XX and GET",
+			commands: []Command{
+				NewCommand(SET, "k", "1", "XX"),
+				NewCommand(SET, "k", "1", "NX"),
+				NewCommand(SET, "k", "2", "NX"),
+				NewCommand(SET, "k", "3", "XX", "GET"),
+				NewCommand(SET, "new", "1", "GET"),
+				NewCommand(SET, "k", "4", "NX", "GET"),
+				NewCommand(GET, "k"),
+				NewCommand(SET, "k", "1", "NX", "XX"),
+				NewCommand(SET, "k", "1", "EX", "10", "KEEPTTL"),
+				NewCommand(SET, "k", "1", "EX", "0"),
+				NewCommand(SET, "k", "1", "PX", "x"),
+				NewCommand(SET, "k", "1", "EX"),
+				NewCommand(SET, "k", "1", "BOGUS"),
+				NewCommand(XADD, "s", "1-1", "f", "v"),
+				NewCommand(SET, "s", "1", "GET"),
+				NewCommand(SET, "s", "1"),
+				NewCommand(GET, "s"),
+			},
+			expected: []interface{}{
+				nil, statusOK, nil, "1", nil, "3", "3",
+				errSyntax, errSyntax,
+				ErrorReply("(error) ERR invalid expire time in 'set' command"),
+				errNotInteger, errSyntax, errSyntax,
+				"1-1", errWrongType, statusOK, "1",
+			},
+		},
+		{
+			name: "SETNX, GETSET and GETDEL",
+			commands: []Command{
+				NewCommand(SETNX, "k", "1"),
+				NewCommand(SETNX, "k", "2"),
+				NewCommand(GETSET, "k", "3"),
+				NewCommand(GETSET, "other", "1"),
+				NewCommand(GETDEL, "k"),
+				NewCommand(GETDEL, "k"),
+				NewCommand(GEOADD, "z", "1", "1", "m"),
+				NewCommand(GETSET, "z", "1"),
+				NewCommand(GETDEL, "z"),
+			},
+			expected: []interface{}{1, 0, "1", nil, "3", nil, 1, errWrongType, errWrongType},
+		},
+		{
+			name: "GETEX",
+			commands: []Command{
+				NewCommand(GETEX, "missing", "EX", "10"),
+				NewCommand(SET, "k", "v"),
+				NewCommand(GETEX, "k", "EX", "100"),
+				NewCommand(GETEX, "k", "PERSIST"),
+				NewCommand(GETEX, "k", "PERSIST", "EX", "1"),
+				NewCommand(GETEX, "k", "PX", "-1"),
+				NewCommand(GETEX, "k", "PXAT", "1"),
+				NewCommand(GET, "k"),
+			},
+			expected: []interface{}{
+				nil, statusOK, "v", "v", errSyntax,
+				ErrorReply("(error) ERR invalid expire time in 'getex' command"),
+				"v", nil,
+			},
+		},
+		{
+			name: "MSET, MSETNX and MGET",
+			commands: []Command{
+				NewCommand(MSET, "a", "1", "b", "2"),
+				NewCommand(MSET, "a", "1", "b"),
+				NewCommand(MSETNX, "b", "x", "c", "3"),
+				NewCommand(MSETNX, "c", "3", "d", "4"),
+				NewCommand(XADD, "s", "1-1", "f", "v"),
+				NewCommand(MGET, "a", "b", "c", "d", "missing", "s"),
+			},
+			expected: []interface{}{
+				statusOK,
+				ErrorReply("(error) ERR wrong number of arguments for 'mset' command"),
+				0, 1, "1-1",
+				[]interface{}{"1", "2", "3", "4", nil, nil},
+			},
+		},
+		{
+			name: "APPEND and STRLEN",
+			commands: []Command{
+				NewCommand(STRLEN, "k"),
+				NewCommand(APPEND, "k", "Hello"),
+				NewCommand(APPEND, "k", " World"),
+				NewCommand(STRLEN, "k"),
+				NewCommand(GET, "k"),
+				NewCommand(SETBIT, "bits", "7", "1"),
+				NewCommand(APPEND, "bits", "A"),
+				NewCommand(GET, "bits"),
+				NewCommand(XADD, "s", "1-1", "f", "v"),
+				NewCommand(STRLEN, "s"),
+			},
+			expected: []interface{}{0, 5, 11, 11, "Hello World", 0, 2, "\x01A", "1-1", errWrongType},
+		},
+		{
+			name: "GETRANGE",
+			commands: []Command{
+				NewCommand(SET, "k", "This is a string"),
+				NewCommand(GETRANGE, "k", "0", "3"),
+				NewCommand(GETRANGE, "k", "-3", "-1"),
+				NewCommand(GETRANGE, "k", "0", "-1"),
+				NewCommand(GETRANGE, "k", "10", "100"),
+				NewCommand(GETRANGE, "k", "5", "3"),
+				NewCommand(GETRANGE, "k", "-1", "-5"),
+				NewCommand(GETRANGE, "k", "-100", "3"),
+				NewCommand(GETRANGE, "missing", "0", "-1"),
+				NewCommand(GETRANGE, "k", "x", "1"),
+			},
+			expected: []interface{}{statusOK, "This", "ing", "This is a string", "string", "", "", "This", "", errNotInteger},
+		},
+		{
+			name: "SETRANGE",
+			commands: []Command{
+				NewCommand(SET, "k", "Hello World"),
+				NewCommand(SETRANGE, "k", "6", "Redis"),
+				NewCommand(GET, "k"),
+				NewCommand(SETRANGE, "pad", "3", "x"),
+				NewCommand(GET, "pad"),
+				NewCommand(SETRANGE, "missing", "5", ""),
+				NewCommand(EXISTS, "missing"),
+				NewCommand(SETRANGE, "k", "-1", "x"),
+				NewCommand(SETRANGE, "k", "536870911", "xx"),
+				NewCommand(SETRANGE, "k", "9223372036854775807", "z"),
+			},
+			expected: []interface{}{
+				statusOK, 11, "Hello Redis", 4, "\x00\x00\x00x", 0, 0,
+				ErrorReply("(error) ERR offset is out of range"), errStringTooLong, errStringTooLong,
+			},
+		},
+		{
+			name: "INCRBY, DECR and DECRBY",
+			commands: []Command{
+				NewCommand(DECR, "n"),
+				NewCommand(DECRBY, "n", "10"),
+				NewCommand(INCRBY, "n", "-5"),
+				NewCommand(INCRBY, "missing", "abc"),
+				NewCommand(EXISTS, "missing"),
+				NewCommand(DECRBY, "n", "1.5"),
+				NewCommand(GET, "n"),
+				NewCommand(APPEND, "n", "0"),
+				NewCommand(INCR, "n"),
+			},
+			expected: []interface{}{"-1", "-11", "-16", errNotInteger, 0, errNotInteger, "-16", 4, "-159"},
+		},
+		{
+			name: "Overflow",
+			commands: []Command{
+				NewCommand(SET, "max", "9223372036854775807"),
+				NewCommand(INCR, "max"),
+				NewCommand(SET, "min", "-9223372036854775808"),
+				NewCommand(DECR, "min"),
+				NewCommand(INCRBY, "min", "9223372036854775807"),
+				NewCommand(INCRBY, "n", "9223372036854775808"),
+				NewCommand(DECRBY, "n", "-9223372036854775808"),
+				NewCommand(GET, "max"),
+			},
+			expected: []interface{}{
+				statusOK, errOverflow, statusOK, errOverflow, "-1", errNotInteger,
+				ErrorReply("(error) ERR decrement would overflow"), "9223372036854775807",
+			},
+		},
+		{
+			name: "INCRBYFLOAT",
+			commands: []Command{
+				NewCommand(SET, "f", "10.50"),
+				NewCommand(INCRBYFLOAT, "f", "0.1"),
+				NewCommand(INCRBYFLOAT, "f", "-5"),
+				NewCommand(SET, "e", "5.0e3"),
+				NewCommand(INCRBYFLOAT, "e", "2.0e2"),
+				NewCommand(INCRBYFLOAT, "new", "3e-5"),
+				NewCommand(INCR, "i"),
+				NewCommand(INCRBYFLOAT, "i", "1.5"),
+				NewCommand(INCRBYFLOAT, "f", "x"),
+				NewCommand(SET, "s", "abc"),
+				NewCommand(INCRBYFLOAT, "s", "1"),
+				NewCommand(INCRBYFLOAT, "f", "inf"),
+				NewCommand(INCR, "f"),
+				NewCommand(INCRBYFLOAT, "f", "0x1p4"),
+				NewCommand(INCRBYFLOAT, "f", "1_0"),
+				NewCommand(INCRBYFLOAT, "f", "-Infinity"),
+				NewCommand(INCRBYFLOAT, "f", "nan"),
+				NewCommand(INCRBYFLOAT, "f", " 1"),
+				NewCommand(INCRBYFLOAT, "f", "1e"),
+				NewCommand(INCRBYFLOAT, "f", "."),
+				NewCommand(INCRBYFLOAT, "f", "1e400"),
+				NewCommand(INCRBYFLOAT, "f", "+.4"),
+				NewCommand(INCRBYFLOAT, "f", "1."),
+				NewCommand(SET, "h", "0x10"),
+				NewCommand(INCRBYFLOAT, "h", "1"),
+				NewCommand(SET, "big", "1e308"),
+				NewCommand(INCRBYFLOAT, "big", "1e308"),
+			},
+			expected: []interface{}{
+				statusOK, "10.6", "5.6", statusOK, "5200", "0.00003", "1", "2.5",
+				errNotFloat, statusOK, errNotFloat, errNotFloat, errNotInteger,
+				errNotFloat, errNotFloat, errNotFloat, errNotFloat, errNotFloat, errNotFloat, errNotFloat, errNotFloat,
+				"6", "7", statusOK, errNotFloat,
+				statusOK, ErrorReply("(error) ERR increment would produce NaN or Infinity"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetClearsExpiry(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(SET, "k", "v", "PXAT", "99999999999999"))
+	kvdb.Execute(0, NewCommand(SET, "keep", "v", "PXAT", "99999999999999"))
+	kvdb.Execute(0, NewCommand(SET, "keep", "w", "KEEPTTL"))
+	kvdb.Execute(0, NewCommand(SET, "k", "w"))
+
+	_, got := kvdb.Execute(0, NewCommand(COMPACT))
+	want := []interface{}{"SET k w", "SET keep w", "GETEX keep PXAT 99999999999999"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("COMPACT = %#v, expected %#v", got, want)
+	}
+}
+
+func TestCountersAreStoredAsIntegers(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.Execute(0, NewCommand(SET, "n", "41"))
+	kvdb.Execute(0, NewCommand(INCR, "n"))
+	if v := kvdb.storage.Get(0, []byte("n")); v != int64(42) {
+		t.Fatalf("INCR stored %#v, expected int64(42)", v)
+	}
+	for _, check := range []struct {
+		cmd      Command
+		expected interface{}
+	}{
+		{cmd: NewCommand(GET, "n"), expected: "42"},
+		{cmd: NewCommand(STRLEN, "n"), expected: 2},
+		{cmd: NewCommand(TYPE, "n"), expected: "string"},
+		{cmd: NewCommand(COMPACT), expected: []interface{}{"SET n 42"}},
+	} {
+		if _, got := kvdb.Execute(0, check.cmd); !reflect.DeepEqual(got, check.expected) {
+			t.Errorf("command %v returned %#v, expected %#v", check.cmd, got, check.expected)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package domain
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	tsDefaultChunkSize = 4096
+
+	tsPolicyBlock = "BLOCK"
+	tsPolicyFirst = "FIRST"
+	tsPolicyLast  = "LAST"
+	tsPolicyMin   = "MIN"
+	tsPolicyMax   = "MAX"
+	tsPolicySum   = "SUM"
+)
+
+var (
+	errTSDuplicate    = errors.New("ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode")
+	errTSOldTimestamp = errors.New("ERR TSDB: Timestamp is older than retention")
+)
+
+type tsSample struct {
+	timestamp int64
+	value     float64
+}
+
+// bitWriter appends bits most significant first.
+type bitWriter struct {
+	buf   []byte
+	nbits uint64
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.nbits%8 == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if bit {
+		w.buf[w.nbits/8] |= 0x80 >> (w.nbits % 8)
+	}
+	w.nbits++
+}
+
+// writeBits appends the n low bits of v.
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.writeBit(v&(1<<uint(i)) != 0)
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos uint64
+}
+
+func (r *bitReader) readBit() bool {
+	bit := r.buf[r.pos/8]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+	return bit
+}
+
+func (r *bitReader) readBits(n uint) uint64 {
+	var v uint64
+	for i := uint(0); i < n; i++ {
+		v <<= 1
+		if r.readBit() {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// Delta-of-delta ranges and the prefixes that select them. Each range is
+// stored in its width as a two's complement number.
+var tsDodBuckets = []struct {
+	prefix, prefixBits uint64
+	width              uint
+}{
+	{prefix: 0x2, prefixBits: 2, width: 7},
+	{prefix: 0x6, prefixBits: 3, width: 9},
+	{prefix: 0xe, prefixBits: 4, width: 12},
+	{prefix: 0xf, prefixBits: 4, width: 64},
+}
+
+// tsChunk holds samples in increasing timestamp order, compressed as in
+// Facebook's Gorilla: timestamps as delta-of-delta, values as the XOR with
+// the previous value with its leading and trailing zeros elided.
+type tsChunk struct {
+	w     bitWriter
+	count int
+
+	firstTimestamp int64
+	lastTimestamp  int64
+	lastDelta      int64
+	lastValue      uint64
+	leading        uint8
+	trailing       uint8
+}
+
+func (c *tsChunk) size() int {
+	return len(c.w.buf)
+}
+
+func (c *tsChunk) append(s tsSample) {
+	value := math.Float64bits(s.value)
+	if c.count == 0 {
+		c.w.writeBits(uint64(s.timestamp), 64)
+		c.w.writeBits(value, 64)
+		c.firstTimestamp = s.timestamp
+		c.lastTimestamp = s.timestamp
+		c.lastValue = value
+		c.leading = 0xff
+		c.count++
+		return
+	}
+
+	delta := s.timestamp - c.lastTimestamp
+	c.writeDod(delta - c.lastDelta)
+	c.lastDelta = delta
+	c.lastTimestamp = s.timestamp
+
+	xor := value ^ c.lastValue
+	c.lastValue = value
+	c.count++
+	if xor == 0 {
+		c.w.writeBit(false)
+		return
+	}
+	c.w.writeBit(true)
+	leading := uint8(bits.LeadingZeros64(xor))
+	trailing := uint8(bits.TrailingZeros64(xor))
+	if c.leading != 0xff && leading >= c.leading && trailing >= c.trailing {
+		// The meaningful bits fit in the previous window.
+		c.w.writeBit(false)
+		c.w.writeBits(xor>>c.trailing, uint(64-c.leading-c.trailing))
+		return
+	}
+	c.w.writeBit(true)
+	c.w.writeBits(uint64(leading), 6)
+	c.w.writeBits(uint64(64-leading-trailing-1), 6)
+	c.w.writeBits(xor>>trailing, uint(64-leading-trailing))
+	c.leading, c.trailing = leading, trailing
+}
+
+func (c *tsChunk) writeDod(dod int64) {
+	if dod == 0 {
+		c.w.writeBit(false)
+		return
+	}
+	for _, b := range tsDodBuckets {
+		limit := int64(1) << (b.width - 1)
+		if b.width == 64 || (dod >= -limit && dod < limit) {
+			c.w.writeBits(b.prefix, uint(b.prefixBits))
+			c.w.writeBits(uint64(dod), b.width)
+			return
+		}
+	}
+}
+
+func (c *tsChunk) samples() []tsSample {
+	samples := make([]tsSample, 0, c.count)
+	if c.count == 0 {
+		return samples
+	}
+	r := bitReader{buf: c.w.buf}
+	timestamp := int64(r.readBits(64))
+	value := r.readBits(64)
+	samples = append(samples, tsSample{timestamp, math.Float64frombits(value)})
+
+	var delta int64
+	var leading, trailing uint
+	for i := 1; i < c.count; i++ {
+		delta += readDod(&r)
+		timestamp += delta
+
+		if r.readBit() {
+			if r.readBit() {
+				leading = uint(r.readBits(6))
+				trailing = 64 - leading - uint(r.readBits(6)) - 1
+			}
+			value ^= r.readBits(64-leading-trailing) << trailing
+		}
+		samples = append(samples, tsSample{timestamp, math.Float64frombits(value)})
+	}
+	return samples
+}
+
+func readDod(r *bitReader) int64 {
+	if !r.readBit() {
+		return 0
+	}
+	for _, b := range tsDodBuckets {
+		// Every prefix but the last ends with a zero bit.
+		if b.width == 64 || !r.readBit() {
+			v := r.readBits(b.width)
+			if b.width < 64 && v&(1<<(b.width-1)) != 0 {
+				v |= ^uint64(0) << b.width
+			}
+			return int64(v)
+		}
+	}
+	return 0
+}
+
+func encodeChunk(samples []tsSample) *tsChunk {
+	c := &tsChunk{}
+	for _, s := range samples {
+		c.append(s)
+	}
+	return c
+}
+
+// timeSeries is a list of chunks in timestamp order. Samples are appended
+// to the last chunk; older timestamps are inserted by re-encoding the chunk
+// that holds them.
+type timeSeries struct {
+	chunks          []*tsChunk
+	retention       int64
+	chunkSize       int
+	duplicatePolicy string
+	labels          [][2]string
+	rules           []*tsRule
+	// srcKey is set on the destination of a compaction rule.
+	srcKey string
+}
+
+func newTimeSeries() *timeSeries {
+	return &timeSeries{chunkSize: tsDefaultChunkSize, duplicatePolicy: tsPolicyBlock}
+}
+
+// clone returns a copy of the series without its compaction rules, which
+// name other keys.
+func (ts *timeSeries) clone() *timeSeries {
+	c := &timeSeries{
+		chunks:          make([]*tsChunk, len(ts.chunks)),
+		retention:       ts.retention,
+		chunkSize:       ts.chunkSize,
+		duplicatePolicy: ts.duplicatePolicy,
+		labels:          append([][2]string(nil), ts.labels...),
+	}
+	for i, chunk := range ts.chunks {
+		cc := *chunk
+		cc.w.buf = append([]byte(nil), chunk.w.buf...)
+		c.chunks[i] = &cc
+	}
+	return c
+}
+
+func (ts *timeSeries) length() int {
+	n := 0
+	for _, c := range ts.chunks {
+		n += c.count
+	}
+	return n
+}
+
+func (ts *timeSeries) last() (tsSample, bool) {
+	if len(ts.chunks) == 0 {
+		return tsSample{}, false
+	}
+	c := ts.chunks[len(ts.chunks)-1]
+	return tsSample{c.lastTimestamp, math.Float64frombits(c.lastValue)}, true
+}
+
+func (ts *timeSeries) label(name string) (string, bool) {
+	for _, l := range ts.labels {
+		if l[0] == name {
+			return l[1], true
+		}
+	}
+	return "", false
+}
+
+// add stores a sample. A sample at an existing timestamp is resolved with
+// policy. It returns the value stored at the timestamp.
+func (ts *timeSeries) add(s tsSample, policy string) (float64, error) {
+	last, ok := ts.last()
+	if ok && ts.retention > 0 && s.timestamp < last.timestamp-ts.retention {
+		return 0, errTSOldTimestamp
+	}
+	if !ok || s.timestamp > last.timestamp {
+		c := ts.chunks
+		if len(c) == 0 || c[len(c)-1].size() >= ts.chunkSize {
+			ts.chunks = append(ts.chunks, &tsChunk{})
+		}
+		ts.chunks[len(ts.chunks)-1].append(s)
+		ts.trim()
+		return s.value, nil
+	}
+
+	// Find the last chunk that starts at or before the timestamp.
+	i := sort.Search(len(ts.chunks), func(i int) bool {
+		return ts.chunks[i].firstTimestamp > s.timestamp
+	}) - 1
+	if i < 0 {
+		i = 0
+	}
+	samples := ts.chunks[i].samples()
+	j := sort.Search(len(samples), func(j int) bool {
+		return samples[j].timestamp >= s.timestamp
+	})
+	if j < len(samples) && samples[j].timestamp == s.timestamp {
+		value, err := resolveDuplicate(policy, samples[j].value, s.value)
+		if err != nil {
+			return 0, err
+		}
+		samples[j].value = value
+		s.value = value
+	} else {
+		samples = append(samples, tsSample{})
+		copy(samples[j+1:], samples[j:])
+		samples[j] = s
+	}
+	ts.chunks[i] = encodeChunk(samples)
+	return s.value, nil
+}
+
+func resolveDuplicate(policy string, old, new float64) (float64, error) {
+	switch policy {
+	case tsPolicyFirst:
+		return old, nil
+	case tsPolicyLast:
+		return new, nil
+	case tsPolicyMin:
+		return math.Min(old, new), nil
+	case tsPolicyMax:
+		return math.Max(old, new), nil
+	case tsPolicySum:
+		return old + new, nil
+	}
+	return 0, errTSDuplicate
+}
+
+// trim drops the samples that fell out of the retention window.
+func (ts *timeSeries) trim() {
+	last, ok := ts.last()
+	if !ok || ts.retention <= 0 {
+		return
+	}
+	oldest := last.timestamp - ts.retention
+	drop := 0
+	for drop < len(ts.chunks)-1 && ts.chunks[drop].lastTimestamp < oldest {
+		drop++
+	}
+	ts.chunks = ts.chunks[drop:]
+	if first := ts.chunks[0]; first.firstTimestamp < oldest {
+		samples := first.samples()
+		k := sort.Search(len(samples), func(k int) bool {
+			return samples[k].timestamp >= oldest
+		})
+		ts.chunks[0] = encodeChunk(samples[k:])
+	}
+}
+
+// rangeSamples returns the samples with from <= timestamp <= to.
+func (ts *timeSeries) rangeSamples(from, to int64) []tsSample {
+	var out []tsSample
+	for _, c := range ts.chunks {
+		if c.count == 0 || c.lastTimestamp < from || c.firstTimestamp > to {
+			continue
+		}
+		for _, s := range c.samples() {
+			if s.timestamp >= from && s.timestamp <= to {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// tsAggregator folds samples into a single value.
+type tsAggregator struct {
+	kind     string
+	count    int
+	sum      float64
+	min, max float64
+}
+
+func validAggregation(kind string) bool {
+	switch kind {
+	case "avg", "min", "max", "sum", "count":
+		return true
+	}
+	return false
+}
+
+func (a *tsAggregator) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+	a.count++
+}
+
+func (a *tsAggregator) value() float64 {
+	switch a.kind {
+	case "avg":
+		return a.sum / float64(a.count)
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	case "sum":
+		return a.sum
+	}
+	return float64(a.count)
+}
+
+// bucketStart returns the start of the bucket of the given duration that
+// holds timestamp.
+func bucketStart(timestamp, duration int64) int64 {
+	start := timestamp - timestamp%duration
+	if timestamp < 0 && timestamp%duration != 0 {
+		start -= duration
+	}
+	return start
+}
+
+// aggregate groups samples into buckets of duration and reduces each bucket
+// with kind. Buckets are labelled with their start time.
+func aggregate(samples []tsSample, kind string, duration int64) []tsSample {
+	var out []tsSample
+	var agg tsAggregator
+	var start int64
+	for _, s := range samples {
+		b := bucketStart(s.timestamp, duration)
+		if agg.count > 0 && b != start {
+			out = append(out, tsSample{start, agg.value()})
+			agg = tsAggregator{}
+		}
+		agg.kind = kind
+		start = b
+		agg.add(s.value)
+	}
+	if agg.count > 0 {
+		out = append(out, tsSample{start, agg.value()})
+	}
+	return out
+}
+
+// tsRule downsamples every sample added to a series into destKey. The
+// bucket being filled is kept open until a sample for a later bucket
+// arrives.
+type tsRule struct {
+	destKey     string
+	aggregation string
+	duration    int64
+	open        bool
+	start       int64
+	agg         tsAggregator
+}
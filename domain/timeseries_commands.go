@@ -0,0 +1,583 @@
+package domain
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	errTSNoKey       = ErrorReply("(error) ERR TSDB: the key does not exist")
+	errTSKeyExists   = ErrorReply("(error) ERR TSDB: key already exists")
+	errTSTimestamp   = ErrorReply("(error) ERR TSDB: invalid timestamp")
+	errTSValue       = ErrorReply("(error) ERR TSDB: invalid value")
+	errTSAggregation = ErrorReply("(error) ERR TSDB: Unknown aggregation type")
+	errTSBucket      = ErrorReply("(error) ERR TSDB: bucketDuration must be greater than zero")
+)
+
+// tsOptions holds the options TS.CREATE and TS.ADD accept for a new
+// series.
+type tsOptions struct {
+	retention       int64
+	chunkSize       int
+	duplicatePolicy string
+	onDuplicate     string
+	labels          [][2]string
+}
+
+// parseTSOptions parses series options. ON_DUPLICATE is only accepted when
+// allowOnDuplicate is set.
+func parseTSOptions(args []string, allowOnDuplicate bool) (tsOptions, ErrorReply) {
+	opts := tsOptions{chunkSize: tsDefaultChunkSize, duplicatePolicy: tsPolicyBlock}
+	for i := 0; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "LABELS" {
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return opts, errSyntax
+			}
+			for j := 0; j < len(rest); j += 2 {
+				opts.labels = append(opts.labels, [2]string{rest[j], rest[j+1]})
+			}
+			break
+		}
+		if i+1 >= len(args) {
+			return opts, errSyntax
+		}
+		arg := args[i+1]
+		i++
+		switch {
+		case opt == "RETENTION":
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || n < 0 {
+				return opts, ErrorReply("(error) ERR TSDB: Couldn't parse RETENTION")
+			}
+			opts.retention = n
+		case opt == "CHUNK_SIZE":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 48 || n > 1048576 || n%8 != 0 {
+				return opts, ErrorReply("(error) ERR TSDB: CHUNK_SIZE value must be a multiple of 8 in the range [48 .. 1048576]")
+			}
+			opts.chunkSize = n
+		case opt == "DUPLICATE_POLICY" || (opt == "ON_DUPLICATE" && allowOnDuplicate):
+			policy := strings.ToUpper(arg)
+			switch policy {
+			case tsPolicyBlock, tsPolicyFirst, tsPolicyLast, tsPolicyMin, tsPolicyMax, tsPolicySum:
+			default:
+				return opts, ErrorReply("(error) ERR TSDB: Unknown DUPLICATE_POLICY")
+			}
+			if opt == "ON_DUPLICATE" {
+				opts.onDuplicate = policy
+			} else {
+				opts.duplicatePolicy = policy
+			}
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, ""
+}
+
+func (opts tsOptions) newSeries() *timeSeries {
+	ts := newTimeSeries()
+	ts.retention = opts.retention
+	ts.chunkSize = opts.chunkSize
+	ts.duplicatePolicy = opts.duplicatePolicy
+	ts.labels = opts.labels
+	return ts
+}
+
+func parseTSTimestamp(arg string) (int64, bool) {
+	if arg == "*" {
+		return timeNow().UnixNano() / int64(1e6), true
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	return n, err == nil && n >= 0
+}
+
+func parseTSValue(arg string) (float64, bool) {
+	v, err := strconv.ParseFloat(arg, 64)
+	return v, err == nil && !math.IsNaN(v)
+}
+
+// parseTSRangeBound parses a range bound, where - and + stand for the
+// smallest and largest timestamps.
+func parseTSRangeBound(arg string) (int64, bool) {
+	switch arg {
+	case "-":
+		return 0, true
+	case "+":
+		return math.MaxInt64, true
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	return n, err == nil && n >= 0
+}
+
+func formatTSValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func tsSampleReply(s tsSample) interface{} {
+	return []interface{}{s.timestamp, formatTSValue(s.value)}
+}
+
+func tsLabelsReply(labels [][2]string) []interface{} {
+	reply := make([]interface{}, 0, len(labels))
+	for _, l := range labels {
+		reply = append(reply, []interface{}{l[0], l[1]})
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) lookupTimeSeries(dbIndex int, key string) (*timeSeries, bool) {
+	v := kvdb.storage.Get(dbIndex, []byte(key))
+	if v == nil {
+		return nil, true
+	}
+	ts, ok := v.(*timeSeries)
+	return ts, ok
+}
+
+func (kvdb *KeyValueDB) tsCreate(dbIndex int, cmd Command) interface{} {
+	opts, errMsg := parseTSOptions(cmd.params()[1:], false)
+	if errMsg != "" {
+		return errMsg
+	}
+	if kvdb.storage.Get(dbIndex, cmd.Argv[0]) != nil {
+		return errTSKeyExists
+	}
+	kvdb.storage.Set(dbIndex, cmd.Argv[0], opts.newSeries())
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return statusOK
+}
+
+func (kvdb *KeyValueDB) tsAdd(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	timestamp, ok := parseTSTimestamp(args[1])
+	if !ok {
+		return errTSTimestamp
+	}
+	value, ok := parseTSValue(args[2])
+	if !ok {
+		return errTSValue
+	}
+	opts, errMsg := parseTSOptions(args[3:], true)
+	if errMsg != "" {
+		return errMsg
+	}
+
+	ts, ok := kvdb.lookupTimeSeries(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if ts == nil {
+		ts = opts.newSeries()
+		kvdb.storage.Set(dbIndex, cmd.Argv[0], ts)
+	}
+	policy := ts.duplicatePolicy
+	if opts.onDuplicate != "" {
+		policy = opts.onDuplicate
+	}
+
+	last, hadSamples := ts.last()
+	appended := !hadSamples || timestamp > last.timestamp
+	if _, err := ts.add(tsSample{timestamp, value}, policy); err != nil {
+		return ErrorReply("(error) " + err.Error())
+	}
+	kvdb.applyTSRules(dbIndex, cmd.arg(0), ts, tsSample{timestamp, value}, appended)
+	kvdb.signalKey(dbIndex, cmd.arg(0))
+	return timestamp
+}
+
+// applyTSRules feeds a sample just stored in src to its compaction rules.
+// A sample that starts a later bucket closes the open one and writes its
+// aggregate to the destination. appended reports whether the sample was
+// added after every other one; otherwise the bucket it falls in is
+// recomputed from src.
+func (kvdb *KeyValueDB) applyTSRules(dbIndex int, srcKey string, src *timeSeries, s tsSample, appended bool) {
+	rules := src.rules[:0]
+	for _, rule := range src.rules {
+		dest, ok := kvdb.lookupTimeSeries(dbIndex, rule.destKey)
+		if !ok || dest == nil || dest.srcKey != srcKey {
+			// The destination is gone or was replaced, so is the rule.
+			continue
+		}
+		rules = append(rules, rule)
+
+		start := bucketStart(s.timestamp, rule.duration)
+		switch {
+		case rule.open && start < rule.start:
+			if agg, ok := src.bucketAggregate(rule, start); ok {
+				dest.add(tsSample{start, agg.value()}, tsPolicyLast)
+				kvdb.signalKey(dbIndex, rule.destKey)
+			}
+		case rule.open && start == rule.start && appended:
+			rule.agg.add(s.value)
+		default:
+			if rule.open && start > rule.start {
+				dest.add(tsSample{rule.start, rule.agg.value()}, tsPolicyLast)
+				kvdb.signalKey(dbIndex, rule.destKey)
+			}
+			rule.open = true
+			rule.start = start
+			rule.agg, _ = src.bucketAggregate(rule, start)
+		}
+	}
+	src.rules = rules
+}
+
+// bucketAggregate aggregates the samples of the rule's bucket starting at
+// start. It reports false when the bucket is empty.
+func (ts *timeSeries) bucketAggregate(rule *tsRule, start int64) (tsAggregator, bool) {
+	agg := tsAggregator{kind: rule.aggregation}
+	for _, s := range ts.rangeSamples(start, start+rule.duration-1) {
+		agg.add(s.value)
+	}
+	return agg, agg.count > 0
+}
+
+func (kvdb *KeyValueDB) tsGet(dbIndex int, cmd Command) interface{} {
+	ts, ok := kvdb.lookupTimeSeries(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if ts == nil {
+		return errTSNoKey
+	}
+	last, ok := ts.last()
+	if !ok {
+		return []interface{}{}
+	}
+	return tsSampleReply(last)
+}
+
+// tsRangeOptions holds the arguments of TS.RANGE, TS.REVRANGE and
+// TS.MRANGE.
+type tsRangeOptions struct {
+	from, to    int64
+	count       int
+	aggregation string
+	duration    int64
+	withLabels  bool
+	filters     []tsFilter
+}
+
+func parseTSRange(args []string, multi bool) (tsRangeOptions, ErrorReply) {
+	var opts tsRangeOptions
+	var ok bool
+	if opts.from, ok = parseTSRangeBound(args[0]); !ok {
+		return opts, ErrorReply("(error) ERR TSDB: invalid fromTimestamp")
+	}
+	if opts.to, ok = parseTSRangeBound(args[1]); !ok {
+		return opts, ErrorReply("(error) ERR TSDB: invalid toTimestamp")
+	}
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 {
+				return opts, ErrorReply("(error) ERR TSDB: Invalid COUNT")
+			}
+			opts.count = n
+			i++
+		case opt == "AGGREGATION" && i+2 < len(args):
+			opts.aggregation = strings.ToLower(args[i+1])
+			if !validAggregation(opts.aggregation) {
+				return opts, errTSAggregation
+			}
+			n, err := strconv.ParseInt(args[i+2], 10, 64)
+			if err != nil || n <= 0 {
+				return opts, errTSBucket
+			}
+			opts.duration = n
+			i += 2
+		case opt == "WITHLABELS" && multi:
+			opts.withLabels = true
+		case opt == "FILTER" && multi:
+			for _, arg := range args[i+1:] {
+				f, ok := parseTSFilter(arg)
+				if !ok {
+					return opts, ErrorReply("(error) ERR TSDB: failed parsing labels")
+				}
+				opts.filters = append(opts.filters, f)
+			}
+			i = len(args)
+		default:
+			return opts, errSyntax
+		}
+	}
+	if multi && len(opts.filters) == 0 {
+		return opts, ErrorReply("(error) ERR TSDB: missing FILTER argument")
+	}
+	return opts, ""
+}
+
+// samples returns the range of ts described by opts, newest first when
+// reverse is set.
+func (opts tsRangeOptions) samples(ts *timeSeries, reverse bool) []interface{} {
+	samples := ts.rangeSamples(opts.from, opts.to)
+	if opts.aggregation != "" {
+		samples = aggregate(samples, opts.aggregation, opts.duration)
+	}
+	if reverse {
+		for i, j := 0, len(samples)-1; i < j; i, j = i+1, j-1 {
+			samples[i], samples[j] = samples[j], samples[i]
+		}
+	}
+	if opts.count > 0 && len(samples) > opts.count {
+		samples = samples[:opts.count]
+	}
+	reply := make([]interface{}, 0, len(samples))
+	for _, s := range samples {
+		reply = append(reply, tsSampleReply(s))
+	}
+	return reply
+}
+
+// tsRange implements TS.RANGE and TS.REVRANGE.
+func (kvdb *KeyValueDB) tsRange(dbIndex int, cmd Command, reverse bool) interface{} {
+	opts, errMsg := parseTSRange(cmd.params()[1:], false)
+	if errMsg != "" {
+		return errMsg
+	}
+	ts, ok := kvdb.lookupTimeSeries(dbIndex, cmd.arg(0))
+	if !ok {
+		return errWrongType
+	}
+	if ts == nil {
+		return errTSNoKey
+	}
+	return opts.samples(ts, reverse)
+}
+
+// tsFilter matches series whose label is one of values, or is none of them
+// when negated. A missing label compares as the empty string, so "l=" and
+// "l!=" select series without or with the label.
+type tsFilter struct {
+	label   string
+	negated bool
+	values  []string
+}
+
+func parseTSFilter(arg string) (tsFilter, bool) {
+	var f tsFilter
+	i := strings.Index(arg, "=")
+	if i <= 0 {
+		return f, false
+	}
+	f.label = arg[:i]
+	if strings.HasSuffix(f.label, "!") {
+		f.negated = true
+		f.label = f.label[:len(f.label)-1]
+	}
+	if f.label == "" {
+		return f, false
+	}
+	value := arg[i+1:]
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		f.values = strings.Split(value[1:len(value)-1], ",")
+	} else {
+		f.values = []string{value}
+	}
+	return f, true
+}
+
+func (f tsFilter) matches(ts *timeSeries) bool {
+	value, _ := ts.label(f.label)
+	for _, v := range f.values {
+		if v == value {
+			return !f.negated
+		}
+	}
+	return f.negated
+}
+
+func (kvdb *KeyValueDB) tsMRange(dbIndex int, cmd Command) interface{} {
+	opts, errMsg := parseTSRange(cmd.params(), true)
+	if errMsg != "" {
+		return errMsg
+	}
+
+	keys := kvdb.storage.Keys(dbIndex)
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	reply := []interface{}{}
+	for _, key := range keys {
+		ts, ok := kvdb.storage.Get(dbIndex, key).(*timeSeries)
+		if !ok {
+			continue
+		}
+		matched := true
+		for _, f := range opts.filters {
+			if !f.matches(ts) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		labels := []interface{}{}
+		if opts.withLabels {
+			labels = tsLabelsReply(ts.labels)
+		}
+		reply = append(reply, []interface{}{string(key), labels, opts.samples(ts, false)})
+	}
+	return reply
+}
+
+func (kvdb *KeyValueDB) tsCreateRule(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	srcKey, destKey := args[0], args[1]
+	if strings.ToUpper(args[2]) != "AGGREGATION" {
+		return errSyntax
+	}
+	aggregation := strings.ToLower(args[3])
+	if !validAggregation(aggregation) {
+		return errTSAggregation
+	}
+	duration, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil || duration <= 0 {
+		return errTSBucket
+	}
+	if srcKey == destKey {
+		return ErrorReply("(error) ERR TSDB: the source key and destination key should be different")
+	}
+
+	src, ok1 := kvdb.lookupTimeSeries(dbIndex, srcKey)
+	dest, ok2 := kvdb.lookupTimeSeries(dbIndex, destKey)
+	if !ok1 || !ok2 {
+		return errWrongType
+	}
+	if src == nil || dest == nil {
+		return errTSNoKey
+	}
+	if kvdb.hasTSSource(dbIndex, dest, destKey) {
+		return ErrorReply("(error) ERR TSDB: the destination key already has a src rule")
+	}
+	// Rules do not chain, which also keeps them free of cycles.
+	if len(dest.rules) > 0 || kvdb.hasTSSource(dbIndex, src, srcKey) {
+		return ErrorReply("(error) ERR TSDB: a compaction rule cannot read from or write to another compaction")
+	}
+
+	rule := &tsRule{destKey: destKey, aggregation: aggregation, duration: duration}
+	// Open the bucket of the newest sample, so it is not lost when the next
+	// bucket starts.
+	if last, ok := src.last(); ok {
+		rule.start = bucketStart(last.timestamp, duration)
+		rule.agg, rule.open = src.bucketAggregate(rule, rule.start)
+	}
+	src.rules = append(src.rules, rule)
+	dest.srcKey = srcKey
+	return statusOK
+}
+
+// hasTSSource reports whether ts, stored at key, is the destination of a
+// rule that still exists.
+func (kvdb *KeyValueDB) hasTSSource(dbIndex int, ts *timeSeries, key string) bool {
+	if ts.srcKey == "" {
+		return false
+	}
+	src, ok := kvdb.storage.Get(dbIndex, []byte(ts.srcKey)).(*timeSeries)
+	if ok {
+		for _, rule := range src.rules {
+			if rule.destKey == key {
+				return true
+			}
+		}
+	}
+	ts.srcKey = ""
+	return false
+}
+
+// renameTSLinks points the compaction rules that involve ts, which was
+// renamed from oldKey to newKey, at its new name.
+func (kvdb *KeyValueDB) renameTSLinks(dbIndex int, ts *timeSeries, oldKey, newKey string) {
+	for _, rule := range ts.rules {
+		if dest, ok := kvdb.storage.Get(dbIndex, []byte(rule.destKey)).(*timeSeries); ok && dest.srcKey == oldKey {
+			dest.srcKey = newKey
+		}
+	}
+	if src, ok := kvdb.storage.Get(dbIndex, []byte(ts.srcKey)).(*timeSeries); ok {
+		for _, rule := range src.rules {
+			if rule.destKey == oldKey {
+				rule.destKey = newKey
+			}
+		}
+	}
+}
+
+// unlinkTS removes the compaction rules that involve ts, stored at key,
+// from it and from the series at the other end, when ts leaves the
+// database.
+func (kvdb *KeyValueDB) unlinkTS(dbIndex int, ts *timeSeries, key string) {
+	for _, rule := range ts.rules {
+		if dest, ok := kvdb.storage.Get(dbIndex, []byte(rule.destKey)).(*timeSeries); ok && dest.srcKey == key {
+			dest.srcKey = ""
+		}
+	}
+	if src, ok := kvdb.storage.Get(dbIndex, []byte(ts.srcKey)).(*timeSeries); ok {
+		for i, rule := range src.rules {
+			if rule.destKey == key {
+				src.rules = append(src.rules[:i], src.rules[i+1:]...)
+				break
+			}
+		}
+	}
+	ts.rules = nil
+	ts.srcKey = ""
+}
+
+func (kvdb *KeyValueDB) tsDeleteRule(dbIndex int, cmd Command) interface{} {
+	args := cmd.params()
+	srcKey, destKey := args[0], args[1]
+	src, ok1 := kvdb.lookupTimeSeries(dbIndex, srcKey)
+	dest, ok2 := kvdb.lookupTimeSeries(dbIndex, destKey)
+	if !ok1 || !ok2 {
+		return errWrongType
+	}
+	if src == nil || dest == nil {
+		return errTSNoKey
+	}
+	for i, rule := range src.rules {
+		if rule.destKey == destKey {
+			src.rules = append(src.rules[:i], src.rules[i+1:]...)
+			dest.srcKey = ""
+			return statusOK
+		}
+	}
+	return ErrorReply("(error) ERR TSDB: compaction rule does not exist")
+}
+
+// compactTimeSeries returns the commands that recreate the series at key,
+// without its compaction rules.
+func compactTimeSeries(key string, ts *timeSeries) []string {
+	create := fmt.Sprintf("TS.CREATE %s RETENTION %d CHUNK_SIZE %d DUPLICATE_POLICY %s",
+		compactArg(key), ts.retention, ts.chunkSize, ts.duplicatePolicy)
+	if len(ts.labels) > 0 {
+		create += " LABELS"
+		for _, l := range ts.labels {
+			create += " " + compactArg(l[0]) + " " + compactArg(l[1])
+		}
+	}
+	lines := []string{create}
+	for _, c := range ts.chunks {
+		for _, s := range c.samples() {
+			lines = append(lines, fmt.Sprintf("TS.ADD %s %d %s", compactArg(key), s.timestamp, formatTSValue(s.value)))
+		}
+	}
+	return lines
+}
+
+// compactTimeSeriesRules returns the commands that recreate the compaction
+// rules of the series at key.
+func compactTimeSeriesRules(key string, ts *timeSeries) []string {
+	var lines []string
+	for _, rule := range ts.rules {
+		lines = append(lines, fmt.Sprintf("TS.CREATERULE %s %s AGGREGATION %s %d",
+			compactArg(key), compactArg(rule.destKey), rule.aggregation, rule.duration))
+	}
+	return lines
+}
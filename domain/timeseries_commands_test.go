@@ -0,0 +1,237 @@
+package domain
+
+import (
+	"fmt"
+	"keyvaluedb/storage"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeSeriesCommands(t *testing.T) {
+	clock := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	sample := func(ts int64, v string) interface{} {
+		return []interface{}{ts, v}
+	}
+
+	tests := []struct {
+		name     string
+		commands []Command
+		expected []interface{}
+	}{
+		{
+			name: "TS.ADD, TS.GET and TS.RANGE",
+			commands: []Command{
+				NewCommand(TS_ADD, "temp", "1000", "20.5"),
+				NewCommand(TS_ADD, "temp", "2000", "21"),
+				NewCommand(TS_ADD, "temp", "*", "22"),
+				NewCommand(TS_GET, "temp"),
+				NewCommand(TS_RANGE, "temp", "-", "+"),
+				NewCommand(TS_RANGE, "temp", "1500", "+", "COUNT", "1"),
+				NewCommand(TS_REVRANGE, "temp", "-", "2000"),
+				NewCommand(TS_RANGE, "missing", "-", "+"),
+			},
+			expected: []interface{}{
+				int64(1000), int64(2000), int64(1700000000000),
+				sample(1700000000000, "22"),
+				[]interface{}{sample(1000, "20.5"), sample(2000, "21"), sample(1700000000000, "22")},
+				[]interface{}{sample(2000, "21")},
+				[]interface{}{sample(2000, "21"), sample(1000, "20.5")},
+				errTSNoKey,
+			},
+		},
+		{
+			name: "TS.RANGE with AGGREGATION",
+			commands: []Command{
+				NewCommand(TS_ADD, "s", "0", "1"),
+				NewCommand(TS_ADD, "s", "5", "3"),
+				NewCommand(TS_ADD, "s", "12", "10"),
+				NewCommand(TS_RANGE, "s", "-", "+", "AGGREGATION", "avg", "10"),
+				NewCommand(TS_REVRANGE, "s", "-", "+", "AGGREGATION", "COUNT", "10", "COUNT", "1"),
+				NewCommand(TS_RANGE, "s", "-", "+", "AGGREGATION", "median", "10"),
+				NewCommand(TS_RANGE, "s", "-", "+", "AGGREGATION", "sum", "0"),
+			},
+			expected: []interface{}{
+				int64(0), int64(5), int64(12),
+				[]interface{}{sample(0, "2"), sample(10, "10")},
+				[]interface{}{sample(10, "1")},
+				errTSAggregation,
+				errTSBucket,
+			},
+		},
+		{
+			name: "TS.CREATE options and duplicates",
+			commands: []Command{
+				NewCommand(TS_CREATE, "s", "RETENTION", "100", "DUPLICATE_POLICY", "max", "LABELS", "room", "kitchen"),
+				NewCommand(TS_CREATE, "s"),
+				NewCommand(TS_ADD, "s", "1000", "5"),
+				NewCommand(TS_ADD, "s", "1000", "3"),
+				NewCommand(TS_ADD, "s", "1000", "1", "ON_DUPLICATE", "LAST"),
+				NewCommand(TS_ADD, "s", "800", "1"),
+				NewCommand(TS_RANGE, "s", "-", "+"),
+				NewCommand(TS_ADD, "b", "1", "1"),
+				NewCommand(TS_ADD, "b", "1", "2"),
+				NewCommand(TS_ADD, "b", "x", "2"),
+				NewCommand(TS_ADD, "b", "2", "nan"),
+				NewCommand(TS_CREATE, "c", "CHUNK_SIZE", "7"),
+			},
+			expected: []interface{}{
+				statusOK,
+				errTSKeyExists,
+				int64(1000), int64(1000), int64(1000),
+				ErrorReply("(error) " + errTSOldTimestamp.Error()),
+				[]interface{}{sample(1000, "1")},
+				int64(1),
+				ErrorReply("(error) " + errTSDuplicate.Error()),
+				errTSTimestamp,
+				errTSValue,
+				ErrorReply("(error) ERR TSDB: CHUNK_SIZE value must be a multiple of 8 in the range [48 .. 1048576]"),
+			},
+		},
+		{
+			name: "TS.MRANGE with label filters",
+			commands: []Command{
+				NewCommand(TS_CREATE, "t1", "LABELS", "type", "temp", "room", "kitchen"),
+				NewCommand(TS_CREATE, "t2", "LABELS", "type", "temp", "room", "hall"),
+				NewCommand(TS_CREATE, "h1", "LABELS", "type", "humidity"),
+				NewCommand(TS_ADD, "t1", "10", "1"),
+				NewCommand(TS_ADD, "t2", "10", "2"),
+				NewCommand(TS_ADD, "h1", "10", "3"),
+				NewCommand(TS_MRANGE, "-", "+", "FILTER", "type=temp"),
+				NewCommand(TS_MRANGE, "-", "+", "WITHLABELS", "FILTER", "type=temp", "room!=kitchen"),
+				NewCommand(TS_MRANGE, "-", "+", "FILTER", "room=", "type=(humidity,pressure)"),
+				NewCommand(TS_MRANGE, "-", "+", "FILTER", "room!="),
+				NewCommand(TS_MRANGE, "-", "+", "COUNT", "1"),
+			},
+			expected: []interface{}{
+				statusOK, statusOK, statusOK, int64(10), int64(10), int64(10),
+				[]interface{}{
+					[]interface{}{"t1", []interface{}{}, []interface{}{sample(10, "1")}},
+					[]interface{}{"t2", []interface{}{}, []interface{}{sample(10, "2")}},
+				},
+				[]interface{}{
+					[]interface{}{"t2", []interface{}{[]interface{}{"type", "temp"}, []interface{}{"room", "hall"}}, []interface{}{sample(10, "2")}},
+				},
+				[]interface{}{
+					[]interface{}{"h1", []interface{}{}, []interface{}{sample(10, "3")}},
+				},
+				[]interface{}{
+					[]interface{}{"t1", []interface{}{}, []interface{}{sample(10, "1")}},
+					[]interface{}{"t2", []interface{}{}, []interface{}{sample(10, "2")}},
+				},
+				ErrorReply("(error) ERR TSDB: missing FILTER argument"),
+			},
+		},
+		{
+			name: "TS.CREATERULE downsamples into the destination",
+			commands: []Command{
+				NewCommand(TS_CREATE, "raw"),
+				NewCommand(TS_CREATE, "avg"),
+				NewCommand(TS_CREATERULE, "raw", "avg", "AGGREGATION", "avg", "10"),
+				NewCommand(TS_CREATERULE, "raw", "avg", "AGGREGATION", "max", "10"),
+				NewCommand(TS_CREATERULE, "avg", "raw", "AGGREGATION", "max", "10"),
+				NewCommand(TS_ADD, "raw", "1", "1"),
+				NewCommand(TS_ADD, "raw", "5", "3"),
+				NewCommand(TS_RANGE, "avg", "-", "+"),
+				NewCommand(TS_ADD, "raw", "12", "7"),
+				NewCommand(TS_ADD, "raw", "25", "9"),
+				NewCommand(TS_RANGE, "avg", "-", "+"),
+				NewCommand(TS_ADD, "raw", "3", "8"),
+				NewCommand(TS_RANGE, "avg", "-", "+"),
+				NewCommand(TS_DELETERULE, "raw", "avg"),
+				NewCommand(TS_DELETERULE, "raw", "avg"),
+				NewCommand(TS_CREATERULE, "raw", "raw", "AGGREGATION", "max", "10"),
+			},
+			expected: []interface{}{
+				statusOK, statusOK, statusOK,
+				ErrorReply("(error) ERR TSDB: the destination key already has a src rule"),
+				ErrorReply("(error) ERR TSDB: a compaction rule cannot read from or write to another compaction"),
+				int64(1), int64(5),
+				[]interface{}{},
+				int64(12), int64(25),
+				[]interface{}{sample(0, "2"), sample(10, "7")},
+				int64(3),
+				[]interface{}{sample(0, "4"), sample(10, "7")},
+				statusOK,
+				ErrorReply("(error) ERR TSDB: compaction rule does not exist"),
+				ErrorReply("(error) ERR TSDB: the source key and destination key should be different"),
+			},
+		},
+		{
+			name: "time series commands on other values",
+			commands: []Command{
+				NewCommand(SET, "s", "v"),
+				NewCommand(TS_ADD, "s", "1", "1"),
+				NewCommand(TS_RANGE, "s", "-", "+"),
+				NewCommand(TS_ADD, "ts", "1", "1"),
+				NewCommand(GET, "ts"),
+			},
+			expected: []interface{}{statusOK, errWrongType, errWrongType, int64(1), errWrongType},
+		},
+	}
+
+	for _, test := range tests {
+		kvdb := NewKeyValueDB(storage.NewInMemory("2"))
+		t.Run(test.name, func(t *testing.T) {
+			for idx, cmd := range test.commands {
+				_, got := kvdb.Execute(0, cmd)
+				want := test.expected[idx]
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("command %v returned %#v, expected %#v", cmd, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompactTimeSeries(t *testing.T) {
+	kvdb := NewKeyValueDB(storage.NewInMemory("1"))
+	for _, cmd := range []Command{
+		NewCommand(TS_CREATE, "raw", "RETENTION", "1000", "LABELS", "room", "the hall"),
+		NewCommand(TS_CREATE, "max"),
+		NewCommand(TS_CREATERULE, "raw", "max", "AGGREGATION", "max", "10"),
+		NewCommand(TS_ADD, "raw", "1", "4"),
+		NewCommand(TS_ADD, "raw", "11", "2.5"),
+	} {
+		kvdb.Execute(0, cmd)
+	}
+
+	_, lines := kvdb.Execute(0, NewCommand(COMPACT))
+	// The rule comes after both series, whatever order the keys are in.
+	all := lines.([]interface{})
+	if last := all[len(all)-1]; last != "TS.CREATERULE raw max AGGREGATION max 10" {
+		t.Errorf("COMPACT ended with %q", last)
+	}
+
+	restored := NewKeyValueDB(storage.NewInMemory("1"))
+	for _, line := range all {
+		words := strings.Split(line.(string), " ")
+		if words[0] == TS_CREATE && words[1] == "raw" {
+			// The quoted label is not split by words.
+			if line != `TS.CREATE raw RETENTION 1000 CHUNK_SIZE 4096 DUPLICATE_POLICY BLOCK LABELS room "the hall"` {
+				t.Fatalf("COMPACT returned %q", line)
+			}
+			words = []string{TS_CREATE, "raw", "RETENTION", "1000", "LABELS", "room", "the hall"}
+		}
+		args := make([]interface{}, 0, len(words)-1)
+		for _, w := range words[1:] {
+			args = append(args, w)
+		}
+		if _, res := restored.Execute(0, NewCommand(words[0], args...)); strings.HasPrefix(fmt.Sprint(res), "(error)") {
+			t.Fatalf("replaying %q returned %v", line, res)
+		}
+	}
+
+	// The open bucket of the rule continues where it left off.
+	restored.Execute(0, NewCommand(TS_ADD, "raw", "15", "3"))
+	restored.Execute(0, NewCommand(TS_ADD, "raw", "21", "1"))
+	_, got := restored.Execute(0, NewCommand(TS_RANGE, "max", "-", "+"))
+	want := []interface{}{[]interface{}{int64(0), "4"}, []interface{}{int64(10), "3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TS.RANGE after restoring = %v, want %v", got, want)
+	}
+}
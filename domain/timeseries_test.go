@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestTSChunkRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var samples []tsSample
+	timestamp := int64(1700000000000)
+	value := 20.0
+	for i := 0; i < 2000; i++ {
+		// Mostly regular intervals with some jitter and large gaps, to use
+		// every delta-of-delta range.
+		switch i % 100 {
+		case 50:
+			timestamp += 1 << 40
+		case 51:
+			timestamp += 3000
+		default:
+			timestamp += 1000 + rnd.Int63n(3) - 1
+		}
+		switch i % 7 {
+		case 0:
+			value = rnd.NormFloat64() * 1e6
+		case 1:
+			value += 0.5
+		}
+		samples = append(samples, tsSample{timestamp, value})
+	}
+	samples = append(samples, tsSample{timestamp + 1, math.Inf(1)}, tsSample{timestamp + 2, 0})
+
+	c := encodeChunk(samples)
+	if got := c.samples(); !reflect.DeepEqual(got, samples) {
+		t.Fatalf("samples() did not return what was appended")
+	}
+	if raw := len(samples) * 16; c.size() >= raw {
+		t.Errorf("chunk uses %d bytes for %d raw bytes", c.size(), raw)
+	}
+}
+
+func TestTSChunkCompressesRegularSamples(t *testing.T) {
+	c := &tsChunk{}
+	for i := 0; i < 1000; i++ {
+		c.append(tsSample{int64(i) * 1000, 42})
+	}
+	// Two bits per sample after the second: one for the timestamp, one for
+	// the value.
+	if c.size() > 16+4+1000*2/8 {
+		t.Errorf("chunk uses %d bytes for regular samples", c.size())
+	}
+}
+
+func TestTimeSeriesAdd(t *testing.T) {
+	ts := newTimeSeries()
+	ts.chunkSize = 48
+	for i := int64(0); i < 100; i += 2 {
+		ts.add(tsSample{i, float64(i)}, tsPolicyBlock)
+	}
+	if len(ts.chunks) < 2 {
+		t.Fatalf("got %d chunks, want the series to be split", len(ts.chunks))
+	}
+	// Fill the gaps out of order.
+	for i := int64(99); i > 0; i -= 2 {
+		if _, err := ts.add(tsSample{i, float64(i)}, tsPolicyBlock); err != nil {
+			t.Fatalf("add(%d) error = %v", i, err)
+		}
+	}
+	got := ts.rangeSamples(0, math.MaxInt64)
+	if len(got) != 100 {
+		t.Fatalf("got %d samples, want 100", len(got))
+	}
+	for i, s := range got {
+		if s.timestamp != int64(i) || s.value != float64(i) {
+			t.Fatalf("sample %d = %v", i, s)
+		}
+	}
+
+	if _, err := ts.add(tsSample{10, 1}, tsPolicyBlock); err != errTSDuplicate {
+		t.Errorf("add() of a duplicate error = %v, want %v", err, errTSDuplicate)
+	}
+	if v, _ := ts.add(tsSample{10, 5}, tsPolicySum); v != 15 {
+		t.Errorf("add() with SUM stored %v, want 15", v)
+	}
+}
+
+func TestTimeSeriesRetention(t *testing.T) {
+	ts := newTimeSeries()
+	ts.chunkSize = 48
+	ts.retention = 50
+	for i := int64(0); i < 200; i++ {
+		ts.add(tsSample{i, 1}, tsPolicyBlock)
+	}
+	got := ts.rangeSamples(0, math.MaxInt64)
+	if len(got) != 51 || got[0].timestamp != 149 {
+		t.Errorf("got %d samples from %d, want 51 from 149", len(got), got[0].timestamp)
+	}
+	if _, err := ts.add(tsSample{100, 1}, tsPolicyLast); err != errTSOldTimestamp {
+		t.Errorf("add() before the retention window error = %v, want %v", err, errTSOldTimestamp)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	samples := []tsSample{{0, 1}, {5, 3}, {10, 10}, {25, 2}, {29, 4}}
+	tests := []struct {
+		kind     string
+		expected []tsSample
+	}{
+		{kind: "avg", expected: []tsSample{{0, 2}, {10, 10}, {20, 3}}},
+		{kind: "min", expected: []tsSample{{0, 1}, {10, 10}, {20, 2}}},
+		{kind: "max", expected: []tsSample{{0, 3}, {10, 10}, {20, 4}}},
+		{kind: "sum", expected: []tsSample{{0, 4}, {10, 10}, {20, 6}}},
+		{kind: "count", expected: []tsSample{{0, 2}, {10, 1}, {20, 2}}},
+	}
+	for _, test := range tests {
+		if got := aggregate(samples, test.kind, 10); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("aggregate(%s) = %v, want %v", test.kind, got, test.expected)
+		}
+	}
+}
@@ -1,164 +1,220 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"keyvaluedb/config"
 	"keyvaluedb/domain"
+	"keyvaluedb/server"
 	"keyvaluedb/storage"
 	"log"
 	"net"
 	"os"
 	"os/signal"
-	"strings"
+	"strconv"
 	"syscall"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
-
-	// Handle interrupt signal
-	handleInterruptSignal()
-
-	err := godotenv.Load()
-	if err != nil {
+	if err := run(os.Args[1:]); err != nil {
 		log.Fatal(err.Error())
 	}
+}
 
-	dbCntStr := os.Getenv("DB_COUNT")
+// run starts the server configured by the command-line arguments and
+// serves clients until it is shut down.
+func run(args []string) error {
+	opts, err := parseFlags(args)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	values := cfg.Values()
+	if values.Port == 0 && values.TLSPort == 0 {
+		return errors.New("port and tls-port are both 0, so there is nothing to listen on")
+	}
+	addr := net.JoinHostPort(values.Bind, strconv.Itoa(values.Port))
+	tlsAddr := net.JoinHostPort(values.Bind, strconv.Itoa(values.TLSPort))
+	var tlsConfig *tls.Config
+	if values.TLSPort != 0 {
+		if tlsConfig, err = server.NewTLSConfig(cfg, nil); err != nil {
+			return err
+		}
+	}
+	if opts.testConfig {
+		for _, a := range []string{addr, tlsAddr} {
+			if _, err := net.ResolveTCPAddr("tcp", a); err != nil {
+				return err
+			}
+		}
+		fmt.Println("Configuration is valid")
+		return nil
+	}
 
-	storage := storage.NewInMemory(dbCntStr)
+	storage := storage.NewInMemory(strconv.Itoa(values.Databases))
 	kvdb := domain.NewKeyValueDB(storage)
-
-	// Start TCP server
-	listener, err := startTcpServer(fmt.Sprintf(":%s", os.Getenv("APP_PORT")))
-	if err != nil {
-		log.Fatalf("Failed to start TCP server: %v\n", err)
+	kvdb.SetConfig(cfg)
+	if values.ACLFile != "" {
+		if err := kvdb.LoadACL(); err != nil {
+			return err
+		}
+	}
+	if err := server.LoadSnapshot(kvdb, values.SnapshotPath()); err != nil {
+		return err
 	}
-	defer listener.Close()
 
-	for {
-		conn, err := listener.Accept()
+	// Start the TCP and TLS listeners that have a port
+	var listeners []net.Listener
+	if values.Port != 0 {
+		listener, err := net.Listen("tcp", addr)
 		if err != nil {
-			fmt.Printf("Failed to accept connection: %v\n", err)
-			continue
+			return fmt.Errorf("failed to start TCP server: %v", err)
 		}
-		// Handle connection in a separate goroutine
-		go handleConnection(conn, kvdb)
+		fmt.Println("TCP server started. Listening on port", addr)
+		listeners = append(listeners, listener)
+	}
+	if values.TLSPort != 0 {
+		listener, err := net.Listen("tcp", tlsAddr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("failed to start TLS server: %v", err)
+		}
+		fmt.Println("TLS server started. Listening on port", tlsAddr)
+		listeners = append(listeners, tls.NewListener(listener, tlsConfig))
+	}
+	srv := server.New(listeners[0], kvdb, server.Options{})
+	for _, listener := range listeners[1:] {
+		srv.AddListener(listener)
 	}
-}
-
-func handleInterruptSignal() {
-	// Create an interrupt channel to listen for the interrupt signal
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
-
-	go func() {
-		<-interrupt
-		fmt.Println("Interrupt signal received. Gracefully stopping...")
-
-		os.Exit(0)
-	}()
-}
 
-func startTcpServer(port string) (net.Listener, error) {
-	listener, err := net.Listen("tcp", port)
-	if err != nil {
-		return nil, err
+	// Handle interrupt signal
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterruptSignal(cancel)
+
+	err = srv.Serve(ctx)
+	// SHUTDOWN has saved already if it was asked to; a signal saves when
+	// save points are set.
+	if ctx.Err() != nil && cfg.Values().Save != "" {
+		if saveErr := kvdb.Save(); saveErr != nil && err == nil {
+			err = saveErr
+		}
 	}
+	return err
+}
 
-	fmt.Println("TCP server started. Listening on port", port)
-	return listener, nil
+// options are the command-line flags.
+type options struct {
+	configFile string
+	testConfig bool
+	// settings holds the settings given as flags, by setting name.
+	settings map[string]string
 }
 
-func handleConnection(conn net.Conn, kvdb domain.KeyValueDB) {
-	defer conn.Close()
-
-	reader := bufio.NewReader(conn)
-	writer := bufio.NewWriter(conn)
-	dbIndex := 0
-	for {
-		if dbIndex > 0 {
-			fmt.Fprintf(writer, "[%d]$", dbIndex)
-		} else {
-			fmt.Fprintf(writer, "$")
-		}
-		writer.Flush()
+// settingFlags are the flags that set a config setting of the same name.
+var settingFlags = []struct {
+	name  string
+	usage string
+}{
+	{"port", "TCP port to listen on (default 9736)"},
+	{"bind", "address to listen on (default all interfaces)"},
+	{"databases", "number of databases (default 16)"},
+	{"tls-port", "TLS port to listen on (default none)"},
+}
 
-		// Read client input
-		command, err := readCommand(reader)
-		if err != nil {
-			printResult(writer, err)
-			break
+func parseFlags(args []string) (options, error) {
+	opts := options{settings: make(map[string]string)}
+	fs := flag.NewFlagSet("kvdb", flag.ContinueOnError)
+	for _, f := range settingFlags {
+		fs.String(f.name, "", f.usage)
+	}
+	fs.StringVar(&opts.configFile, "config", "", "config file to load")
+	fs.BoolVar(&opts.testConfig, "test-config", false, "check the configuration and exit")
+	if err := fs.Parse(args); err != nil {
+		return options{}, err
+	}
+	if fs.NArg() > 0 {
+		return options{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	// Only the flags that were given override the other sources.
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settingFlags {
+			if f.Name == s.name {
+				opts.settings[f.Name] = f.Value.String()
+			}
 		}
+	})
+	return opts, nil
+}
 
-		var result interface{}
-		dbIndex, result = kvdb.Execute(dbIndex, command)
-		printResult(writer, result)
-	}
+// envSettings are the environment variables that set a config setting.
+var envSettings = []struct {
+	env     string
+	setting string
+}{
+	{"APP_PORT", "port"},
+	{"APP_BIND", "bind"},
+	{"DB_COUNT", "databases"},
 }
 
-func readCommand(reader *bufio.Reader) (domain.Command, error) {
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		return domain.Command{}, err
-	}
-	// Trim any leading/trailing whitespace and newline characters
-	line = strings.TrimSpace(line)
-
-	// Split the line into words
-	words := strings.Split(line, " ")
-
-	// Separate the number of words within the command including double quotes
-	args := make([]interface{}, 0, 10)
-	count := 0
-	inQuotes := false
-	isQuoteCompletes := true
-	word := ""
-	for _, tempWord := range words {
-		if strings.HasPrefix(tempWord, `"`) {
-			inQuotes = true
-			isQuoteCompletes = false
+// loadConfig builds the settings from, in increasing precedence, their
+// defaults, the config file, the environment and the flags. The
+// environment may be given in a .env file, which is optional.
+func loadConfig(opts options) (*config.Config, error) {
+	cfg := config.New()
+	if opts.configFile != "" {
+		if err := cfg.Load(opts.configFile); err != nil {
+			return nil, err
 		}
+	}
 
-		if inQuotes {
-			word = fmt.Sprintf("%s %s", word, tempWord)
-
-			if strings.HasSuffix(tempWord, `"`) {
-				args = append(args, strings.ReplaceAll(strings.TrimSpace(word), `"`, ""))
-				word = ""
-				inQuotes = false
-				count++
-				isQuoteCompletes = true
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range envSettings {
+		if value := os.Getenv(e.env); value != "" {
+			if err := cfg.Set(e.setting, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", e.env, err)
 			}
-		} else {
-			args = append(args, strings.ReplaceAll(tempWord, `"`, ""))
-			count++
 		}
 	}
 
-	if count < 1 {
-		return domain.Command{}, fmt.Errorf("invalid command")
-	}
-
-	if !isQuoteCompletes {
-		return domain.Command{}, fmt.Errorf("(error) ERR Protocol error: unbalanced quotes in request")
+	for _, f := range settingFlags {
+		if value, ok := opts.settings[f.name]; ok {
+			if err := cfg.Set(f.name, value); err != nil {
+				return nil, fmt.Errorf("--%v", err)
+			}
+		}
 	}
+	return cfg, nil
+}
 
-	cmd := strings.ToUpper(strings.TrimSpace(args[0].(string)))
+// handleInterruptSignal calls stop on SIGINT or SIGTERM. A second signal
+// exits at once.
+func handleInterruptSignal(stop func()) {
+	// Create an interrupt channel to listen for the interrupt signal
+	interrupt := make(chan os.Signal, 2)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
-	command := domain.NewCommand(cmd, args[1:]...)
-	return command, nil
-}
+	go func() {
+		<-interrupt
+		fmt.Println("Interrupt signal received. Gracefully stopping...")
+		stop()
 
-func printResult(writer *bufio.Writer, result interface{}) {
-	switch res := result.(type) {
-	case []interface{}:
-		for i, item := range res {
-			fmt.Fprintf(writer, "%d) %v\n", i+1, item)
-		}
-	default:
-		fmt.Fprintf(writer, "%v\n", result)
-	}
-	writer.Flush()
+		<-interrupt
+		os.Exit(1)
+	}()
 }
@@ -1,82 +1,75 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
-	"net"
-	"strings"
+	"keyvaluedb/config"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
-func TestHandleConnection(t *testing.T) {
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvdb.conf")
+	content := "port 7000\nbind 127.0.0.1\ndatabases 4\ntimeout 30\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APP_PORT", "7001")
+	t.Setenv("APP_BIND", "")
+	t.Setenv("DB_COUNT", "8")
+
 	tests := []struct {
-		name           string
-		input          string
-		expectedOutput string
+		name    string
+		args    []string
+		want    config.Values
+		wantErr bool
 	}{
 		{
-			name:           "SET command",
-			input:          "SET key value\n",
-			expectedOutput: "OK",
-		},
-		{
-			name:           "SET command with quotes",
-			input:          "SET \"key\" \"value\"\n",
-			expectedOutput: "OK",
-		},
-		{
-			name:           "GET command",
-			input:          "GET key\n",
-			expectedOutput: "value",
-		},
-		{
-			name:           "DEL command",
-			input:          "DEL key\n",
-			expectedOutput: "1",
+			name: "environment over file",
+			args: []string{"--config", path},
+			want: config.Values{Port: 7001, Bind: "127.0.0.1", Databases: 8, Timeout: 30},
 		},
 		{
-			name:           "Unknown command",
-			input:          "UNKNOWN command\n",
-			expectedOutput: "(error) ERR unknown command `UNKNOWN`, with args beginning with: `command`,",
+			name: "flags over environment",
+			args: []string{"--config", path, "--port", "7002", "-databases=2", "--bind", "::1"},
+			want: config.Values{Port: 7002, Bind: "::1", Databases: 2, Timeout: 30},
 		},
 		{
-			name:           "SET command with unbalanced quotes",
-			input:          "SET \"key\" \"value\n",
-			expectedOutput: "(error) ERR Protocol error: unbalanced quotes in request",
+			name: "no config file",
+			args: []string{"--bind", "0.0.0.0"},
+			want: config.Values{Port: 7001, Bind: "0.0.0.0", Databases: 8},
 		},
+		{name: "invalid flag value", args: []string{"--port", "x"}, wantErr: true},
+		{name: "unknown flag", args: []string{"--maxclients", "1"}, wantErr: true},
+		{name: "stray argument", args: []string{"kvdb.conf"}, wantErr: true},
+		{name: "missing config file", args: []string{"--config", path + ".missing"}, wantErr: true},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseFlags(tt.args)
+			var cfg *config.Config
+			if err == nil {
+				cfg, err = loadConfig(opts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loading %v: error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			v := cfg.Values()
+			got := config.Values{Port: v.Port, Bind: v.Bind, Databases: v.Databases, Timeout: v.Timeout}
+			if got != tt.want {
+				t.Errorf("loading %v = %+v, want %+v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
 
-	// Start the server in a separate goroutine
-	go func() {
-		main()
-	}()
-
-	for _, testCase := range tests {
-		// Connect to the server
-		conn, err := net.Dial("tcp", "localhost:9736")
-		if err != nil {
-			t.Fatalf("Failed to connect to server: %v", err)
-		}
-		defer conn.Close()
-
-		// Send the test message to the server
-		_, err = fmt.Fprintf(conn, testCase.input)
-		if err != nil {
-			t.Fatalf("Failed to send message to server: %v", err)
-		}
-
-		// Read the response from the server
-		response, err := bufio.NewReader(conn).ReadString('\n')
-		if err != nil {
-			t.Fatalf("Failed to read response from server: %v", err)
-		}
-
-		response = strings.Trim(response, "$")
-		response = strings.Trim(response, "\n")
-
-		// Compare the received response with the expected response
-		if response != testCase.expectedOutput {
-			t.Errorf("Expected response: %s, but got: %s", testCase.expectedOutput, response)
-		}
+func TestRunTestConfig(t *testing.T) {
+	if err := run([]string{"--test-config", "--port", "7003"}); err != nil {
+		t.Errorf("run(--test-config) = %v", err)
+	}
+	if err := run([]string{"--test-config", "--databases", "0"}); err == nil {
+		t.Errorf("run(--test-config) accepted 0 databases")
 	}
 }
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"keyvaluedb/domain"
+	"net"
+	"strings"
+)
+
+func (s *Server) handleConnection(conn net.Conn) {
+	defer s.closeConnection(conn)
+	user := ""
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if !s.awaitCommand(conn) {
+			return
+		}
+		var err error
+		if user, err = s.handshake(tlsConn); err != nil {
+			return
+		}
+	}
+	session := s.kvdb.NewSession(domain.Conn{
+		RemoteAddr: conn.RemoteAddr().String(),
+		LocalAddr:  conn.LocalAddr().String(),
+		User:       user,
+		Close:      func() { s.kill(conn) },
+	})
+	defer s.kvdb.EndSession(session)
+	s.startSession(conn, session)
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for s.awaitCommand(conn) {
+		// Read client input
+		command, resp, err := readCommand(reader)
+		if err != nil {
+			if _, ok := err.(protocolError); ok {
+				if resp {
+					writeRESP(writer, err)
+					writer.Flush()
+				} else {
+					printResult(writer, err)
+				}
+			}
+			break
+		}
+		if command.Name == "" && !resp {
+			printPrompt(writer, session.DBIndex())
+			continue
+		}
+
+		session.SetBuffers(reader.Buffered(), 0)
+		result := s.kvdb.Run(session, command)
+		if resp {
+			writeRESP(writer, result)
+			session.SetBuffers(reader.Buffered(), writer.Buffered())
+			writer.Flush()
+			continue
+		}
+		printResult(writer, result)
+		printPrompt(writer, session.DBIndex())
+	}
+}
+
+// printPrompt shows that the server is ready for the next inline command.
+// It follows a reply rather than preceding each read, so clients speaking
+// RESP never receive it.
+func printPrompt(writer *bufio.Writer, dbIndex int) {
+	if dbIndex > 0 {
+		fmt.Fprintf(writer, "[%d]$", dbIndex)
+	} else {
+		fmt.Fprintf(writer, "$")
+	}
+	writer.Flush()
+}
+
+func printResult(writer *bufio.Writer, result interface{}) {
+	writeResult(writer, result, 0)
+	writer.Flush()
+}
+
+// writeResult prints nested lists with each level indented under the number
+// of the item that contains it.
+func writeResult(writer *bufio.Writer, result interface{}, indent int) {
+	switch res := result.(type) {
+	case []interface{}:
+		if len(res) == 0 {
+			fmt.Fprintf(writer, "(empty array)\n")
+			return
+		}
+		for i, item := range res {
+			if i > 0 {
+				fmt.Fprint(writer, strings.Repeat(" ", indent))
+			}
+			prefix := fmt.Sprintf("%d) ", i+1)
+			fmt.Fprint(writer, prefix)
+			writeResult(writer, item, indent+len(prefix))
+		}
+	case []byte:
+		fmt.Fprintf(writer, "%s\n", res)
+	default:
+		fmt.Fprintf(writer, "%v\n", result)
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"keyvaluedb/domain"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxBulkLen bounds a single argument, as Redis's proto-max-bulk-len.
+	maxBulkLen = 512 * 1024 * 1024
+	// maxMultiBulkLen bounds the number of arguments of one command.
+	maxMultiBulkLen = 1024 * 1024 * 1024
+	// maxInlineLen bounds an inline command and the lines that start RESP
+	// arrays and bulk strings, as Redis's PROTO_INLINE_MAX_SIZE.
+	maxInlineLen = 64 * 1024
+)
+
+// protocolError is a malformed request. The connection is closed after the
+// error is reported, since the rest of the stream cannot be trusted.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "(error) ERR Protocol error: " + string(e)
+}
+
+// readCommand reads the next command from the client. Clients either send
+// a RESP array of bulk strings, whose length prefixes let arguments hold
+// any bytes, or an inline command typed as a line of words. It reports
+// whether the command arrived as RESP so the reply can be sent the same way.
+// An empty inline line is returned as a command without a name, so the
+// prompt can be shown again.
+func readCommand(reader *bufio.Reader) (domain.Command, bool, error) {
+	for {
+		first, err := reader.Peek(1)
+		if err != nil {
+			return domain.Command{}, false, err
+		}
+
+		var argv [][]byte
+		resp := first[0] == '*'
+		if resp {
+			argv, err = readMultiBulk(reader)
+		} else {
+			argv, err = readInline(reader)
+		}
+		if err != nil {
+			return domain.Command{}, resp, err
+		}
+		// Empty arrays are skipped, as Redis does.
+		if len(argv) == 0 {
+			if resp {
+				continue
+			}
+			return domain.Command{}, false, nil
+		}
+		return domain.Command{Name: strings.ToUpper(string(argv[0])), Argv: argv[1:]}, resp, nil
+	}
+}
+
+// readLine reads a line ended by "\n" or "\r\n" and returns it without the
+// line ending. A line longer than maxInlineLen fails with tooLong once that
+// much has arrived, so a client cannot make the server buffer an endless
+// line.
+func readLine(reader *bufio.Reader, tooLong protocolError) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if len(line) > maxInlineLen+len("\r\n") {
+			return nil, tooLong
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	if len(line) > maxInlineLen {
+		return nil, tooLong
+	}
+	return line, nil
+}
+
+func readMultiBulk(reader *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(reader, protocolError("too big mbulk count string"))
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxMultiBulkLen {
+		return nil, protocolError("invalid multibulk length")
+	}
+
+	var argv [][]byte
+	for i := 0; i < n; i++ {
+		line, err := readLine(reader, protocolError("too big bulk count string"))
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			got := ""
+			if len(line) > 0 {
+				got = string(line[:1])
+			}
+			return nil, protocolError(fmt.Sprintf("expected '$', got '%s'", got))
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, protocolError("invalid bulk length")
+		}
+
+		// The buffer grows as data arrives, so a length prefix alone
+		// cannot make the server allocate a large argument.
+		var arg bytes.Buffer
+		if _, err := io.CopyN(&arg, reader, int64(size)); err != nil {
+			return nil, err
+		}
+		end, err := readLine(reader, protocolError("bulk string is longer than its length"))
+		if err != nil {
+			return nil, err
+		}
+		if len(end) != 0 {
+			return nil, protocolError("bulk string is longer than its length")
+		}
+		argv = append(argv, arg.Bytes())
+	}
+	return argv, nil
+}
+
+func readInline(reader *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(reader, protocolError("too big inline request"))
+	if err != nil {
+		return nil, err
+	}
+	return splitArgs(line)
+}
+
+// splitArgs splits an inline command into words the way Redis does. Words
+// in double quotes may contain spaces and the escapes \n, \r, \t, \b, \a,
+// \\, \" and \xHH; words in single quotes may contain spaces and \'.
+func splitArgs(line []byte) ([][]byte, error) {
+	var argv [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return argv, nil
+		}
+
+		var word []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			switch {
+			case inDouble:
+				if i == len(line) {
+					return nil, protocolError("unbalanced quotes in request")
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					word = append(word, byte(b))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						word = append(word, '\n')
+					case 'r':
+						word = append(word, '\r')
+					case 't':
+						word = append(word, '\t')
+					case 'b':
+						word = append(word, '\b')
+					case 'a':
+						word = append(word, '\a')
+					default:
+						word = append(word, line[i])
+					}
+				case c == '"':
+					// A closing quote must end the word.
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, protocolError("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					word = append(word, c)
+				}
+				i++
+			case inSingle:
+				if i == len(line) {
+					return nil, protocolError("unbalanced quotes in request")
+				}
+				c := line[i]
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					word = append(word, '\'')
+					i++
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, protocolError("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					word = append(word, c)
+				}
+				i++
+			default:
+				if i == len(line) || isSpace(line[i]) {
+					done = true
+					break
+				}
+				switch line[i] {
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					word = append(word, line[i])
+				}
+				i++
+			}
+		}
+		if word == nil {
+			word = []byte{}
+		}
+		argv = append(argv, word)
+	}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// writeRESP writes a reply in RESP for clients that sent their command that
+// way. Errors, such as a domain.ErrorReply, are error replies and a
+// domain.StatusReply is a status reply; every string is a bulk string,
+// whatever it holds.
+func writeRESP(writer *bufio.Writer, result interface{}) {
+	switch res := result.(type) {
+	case nil:
+		writer.WriteString("$-1\r\n")
+	case error:
+		writeRESPError(writer, res.Error())
+	case domain.StatusReply:
+		fmt.Fprintf(writer, "+%s\r\n", res)
+	case string:
+		fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(res), res)
+	case []byte:
+		fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(res), res)
+	case int, int64:
+		fmt.Fprintf(writer, ":%d\r\n", res)
+	case []interface{}:
+		fmt.Fprintf(writer, "*%d\r\n", len(res))
+		for _, item := range res {
+			writeRESP(writer, item)
+		}
+	default:
+		s := fmt.Sprintf("%v", res)
+		fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(s), s)
+	}
+}
+
+func writeRESPError(writer *bufio.Writer, msg string) {
+	msg = strings.TrimPrefix(msg, "(error) ")
+	// An error reply is a single line.
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	fmt.Fprintf(writer, "-%s\r\n", msg)
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"keyvaluedb/domain"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "words", line: "SET  key\tvalue ", want: []string{"SET", "key", "value"}},
+		{name: "double quotes", line: `SET "my key" "a b"`, want: []string{"SET", "my key", "a b"}},
+		{name: "escapes", line: `SET k "line\nbreak \"q\" \\ \x00\xff"`, want: []string{"SET", "k", "line\nbreak \"q\" \\ \x00\xff"}},
+		{name: "single quotes", line: `SET k 'it\'s "raw" \n'`, want: []string{"SET", "k", `it's "raw" \n`}},
+		{name: "empty quoted word", line: `SET k ""`, want: []string{"SET", "k", ""}},
+		{name: "quote inside a word", line: `SET k a"b c"`, want: []string{"SET", "k", "ab c"}},
+		{name: "text after a closing quote", line: `SET k "a"b`, wantErr: true},
+		{name: "unbalanced double quote", line: `SET "key" "value`, wantErr: true},
+		{name: "unbalanced single quote", line: `SET 'key`, wantErr: true},
+		{name: "empty line", line: "   "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argv, err := splitArgs([]byte(tt.line))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, arg := range argv {
+				got = append(got, string(arg))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     domain.Command
+		wantRESP bool
+		wantErr  string
+	}{
+		{
+			name:  "inline",
+			input: "set key \"a\\r\\nb\"\r\n",
+			want:  domain.NewCommand(domain.SET, "key", "a\r\nb"),
+		},
+		{
+			name:  "empty inline line",
+			input: " \r\nget key\n",
+			want:  domain.Command{},
+		},
+		{
+			name:     "RESP",
+			input:    "*3\r\n$3\r\nSET\r\n$3\r\nk\x00y\r\n$6\r\n\"a\r\nb\"\r\n",
+			want:     domain.NewCommand(domain.SET, "k\x00y", "\"a\r\nb\""),
+			wantRESP: true,
+		},
+		{
+			name:     "RESP after an empty array",
+			input:    "*0\r\n*2\r\n$3\r\nget\r\n$3\r\nkey\r\n",
+			want:     domain.NewCommand(domain.GET, "key"),
+			wantRESP: true,
+		},
+		{
+			name:     "RESP with an empty argument",
+			input:    "*2\r\n$3\r\nget\r\n$0\r\n\r\n",
+			want:     domain.NewCommand(domain.GET, ""),
+			wantRESP: true,
+		},
+		{
+			name:  "inline of the longest size",
+			input: "get " + strings.Repeat("k", maxInlineLen-4) + "\r\n",
+			want:  domain.NewCommand(domain.GET, strings.Repeat("k", maxInlineLen-4)),
+		},
+		{
+			name:    "inline too long",
+			input:   "get " + strings.Repeat("k", maxInlineLen),
+			wantErr: "(error) ERR Protocol error: too big inline request",
+		},
+		{
+			name:    "RESP with a count too long",
+			input:   "*" + strings.Repeat("1", maxInlineLen) + "\r\n",
+			wantErr: "(error) ERR Protocol error: too big mbulk count string",
+		},
+		{
+			name:    "RESP with a bulk string too long",
+			input:   "*1\r\n$1\r\nx" + strings.Repeat("y", 2*maxInlineLen),
+			wantErr: "(error) ERR Protocol error: bulk string is longer than its length",
+		},
+		{
+			name:    "RESP with a bad count",
+			input:   "*x\r\n",
+			wantErr: "(error) ERR Protocol error: invalid multibulk length",
+		},
+		{
+			name:    "RESP without a bulk string",
+			input:   "*1\r\n:1\r\n",
+			wantErr: "(error) ERR Protocol error: expected '$', got ':'",
+		},
+		{
+			name:    "RESP with a bad length",
+			input:   "*1\r\n$-2\r\n",
+			wantErr: "(error) ERR Protocol error: invalid bulk length",
+		},
+		{
+			name:    "RESP with a short length",
+			input:   "*1\r\n$2\r\nabc\r\n",
+			wantErr: "(error) ERR Protocol error: bulk string is longer than its length",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, resp, err := readCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("readCommand() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readCommand() error = %v", err)
+			}
+			if resp != tt.wantRESP {
+				t.Errorf("readCommand() RESP = %v, want %v", resp, tt.wantRESP)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCommand() = %s %q, want %s %q", got.Name, got.Argv, tt.want.Name, tt.want.Argv)
+			}
+		})
+	}
+}
+
+func TestWriteRESP(t *testing.T) {
+	tests := []struct {
+		result interface{}
+		want   string
+	}{
+		{result: domain.StatusReply("OK"), want: "+OK\r\n"},
+		{result: "OK", want: "$2\r\nOK\r\n"},
+		{result: "a\r\nb", want: "$4\r\na\r\nb\r\n"},
+		{result: []byte{0, 1}, want: "$2\r\n\x00\x01\r\n"},
+		{result: nil, want: "$-1\r\n"},
+		{result: 3, want: ":3\r\n"},
+		{result: int64(-1), want: ":-1\r\n"},
+		{result: domain.ErrorReply("(error) ERR syntax error"), want: "-ERR syntax error\r\n"},
+		{result: "(error) x", want: "$9\r\n(error) x\r\n"},
+		{result: []interface{}{"x", 1, nil, []interface{}{}}, want: "*4\r\n$1\r\nx\r\n:1\r\n$-1\r\n*0\r\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		writer := bufio.NewWriter(&buf)
+		writeRESP(writer, tt.result)
+		writer.Flush()
+		if got := buf.String(); got != tt.want {
+			t.Errorf("writeRESP(%#v) = %q, want %q", tt.result, got, tt.want)
+		}
+	}
+}
+
+// COMPACT quotes arguments the way Command.String does.
+func TestCompactOutputReadsBack(t *testing.T) {
+	values := []string{"plain", "two words", "", "quote\"and\\slash", "nl\r\n\x00\xff", `{"a":1}`, "it's"}
+	for _, v := range values {
+		line := domain.NewCommand(domain.SET, "k", v).String()
+		argv, err := splitArgs([]byte(line))
+		if err != nil {
+			t.Fatalf("splitArgs(%q) error = %v", line, err)
+		}
+		if len(argv) != 3 || string(argv[2]) != v {
+			t.Errorf("%q read back as %q", line, argv)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Package server serves a KeyValueDB to clients over a network listener.
+// A process may run any number of servers, each on its own listener.
+package server
+
+import (
+	"context"
+	"errors"
+	"keyvaluedb/domain"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long a shutdown started by SHUTDOWN or by
+// the end of the Serve context waits for running commands.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// expirePeriod is how often the server frees keys that expired without
+// being looked up, ten times a second as Redis does by default.
+const expirePeriod = 100 * time.Millisecond
+
+// Options tune a Server. The zero value is ready to use.
+type Options struct {
+	// ShutdownTimeout bounds a shutdown the server starts on its own. It
+	// defaults to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// ErrorLog receives errors accepting connections. It defaults to a
+	// logger writing to standard error.
+	ErrorLog *log.Logger
+}
+
+// Server serves the clients of one KeyValueDB. The timeout and
+// tcp-keepalive settings of the KeyValueDB's config apply to its
+// connections.
+type Server struct {
+	// listeners holds the listener given to New first, then those given to
+	// AddListener.
+	listeners []net.Listener
+	kvdb      *domain.KeyValueDB
+	options   Options
+
+	// mu guards the fields below. A connection checks closing and arms its
+	// read deadline under mu, so a shutdown never misses a connection
+	// about to wait for its next command. conns holds the state of each
+	// connection being served, which CLIENT KILL marks in the same way.
+	mu      sync.Mutex
+	conns   map[net.Conn]*connState
+	closing bool
+	// handlers counts the connections being served.
+	handlers sync.WaitGroup
+
+	// done is closed once a shutdown has finished, with its result in err.
+	done chan struct{}
+	err  error
+}
+
+// connState is what a Server tracks of a connection.
+type connState struct {
+	// session is the session of the connection once it has one, so that a
+	// shutdown can wake its blocked command.
+	session *domain.Session
+	// killed is set once CLIENT KILL has closed the connection.
+	killed bool
+}
+
+// New creates a server that will accept connections on listener. It makes
+// SHUTDOWN stop the server, so each KeyValueDB should be served by a
+// single Server.
+func New(listener net.Listener, kvdb *domain.KeyValueDB, options Options) *Server {
+	if options.ShutdownTimeout == 0 {
+		options.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if options.ErrorLog == nil {
+		options.ErrorLog = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	s := &Server{
+		listeners: []net.Listener{listener},
+		kvdb:      kvdb,
+		options:   options,
+		conns:     make(map[net.Conn]*connState),
+		done:      make(chan struct{}),
+	}
+	// SHUTDOWN saves on its own, since it knows whether the client asked
+	// for a snapshot, so the server only has to stop.
+	s.kvdb.OnShutdown(func() { go s.shutdownWithTimeout() })
+	return s
+}
+
+func (s *Server) shutdownWithTimeout() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.ShutdownTimeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// AddListener makes the server accept connections on another listener as
+// well, such as a TLS one. It must be called before Serve.
+func (s *Server) AddListener(listener net.Listener) {
+	s.listeners = append(s.listeners, listener)
+}
+
+// Addr returns the address of the listener given to New.
+func (s *Server) Addr() net.Addr {
+	return s.listeners[0].Addr()
+}
+
+// Serve accepts connections until the server is shut down, by Shutdown,
+// by SHUTDOWN or by the end of ctx. It returns once the shutdown has
+// finished, with its result.
+func (s *Server) Serve(ctx context.Context) error {
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.shutdownWithTimeout()
+		case <-s.done:
+		}
+	}()
+
+	go s.deleteExpired()
+
+	for _, listener := range s.listeners[1:] {
+		go s.accept(listener)
+	}
+	return s.accept(s.listeners[0])
+}
+
+// deleteExpired frees expired keys every expirePeriod until the server has
+// shut down.
+func (s *Server) deleteExpired() {
+	ticker := time.NewTicker(expirePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.kvdb.DeleteExpired()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// accept serves the connections of one listener until it is closed.
+func (s *Server) accept(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosing() {
+				<-s.done
+				return s.err
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.options.ErrorLog.Printf("Failed to accept connection: %v", err)
+			continue
+		}
+		if tcp, ok := netConn(conn).(*net.TCPConn); ok {
+			if period := s.kvdb.Config().Values().TCPKeepAlive; period > 0 {
+				tcp.SetKeepAlive(true)
+				tcp.SetKeepAlivePeriod(time.Duration(period) * time.Second)
+			}
+		}
+
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = &connState{}
+		s.handlers.Add(1)
+		s.mu.Unlock()
+
+		// Handle connection in a separate goroutine, with a session of its
+		// own on the shared KeyValueDB.
+		go s.handleConnection(conn)
+	}
+}
+
+// netConn returns the network connection under a wrapping one, such as a
+// TLS connection.
+func netConn(conn net.Conn) net.Conn {
+	if wrapped, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		return wrapped.NetConn()
+	}
+	return conn
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// awaitCommand arms the read deadline of a connection before it reads its
+// next command. It reports false when the server is shutting down or the
+// connection was killed, and the connection should be closed instead.
+func (s *Server) awaitCommand(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing || s.conns[conn].killed {
+		return false
+	}
+	// Close the connection once it has been idle for the timeout.
+	deadline := time.Time{}
+	if timeout := s.kvdb.Config().Values().Timeout; timeout > 0 {
+		deadline = time.Now().Add(time.Duration(timeout) * time.Second)
+	}
+	conn.SetReadDeadline(deadline)
+	return true
+}
+
+// kill closes a connection for CLIENT KILL. An idle connection stops
+// waiting for its next command at once, and a busy one, which may be the
+// client asking, once it has sent its reply.
+func (s *Server) kill(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.conns[conn]; ok {
+		state.killed = true
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+// startSession records the session of a connection.
+func (s *Server) startSession(conn net.Conn, session *domain.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn].session = session
+}
+
+func (s *Server) closeConnection(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+	s.handlers.Done()
+}
+
+// Shutdown closes the listener and lets the commands that are running
+// finish. Connections waiting for a command are closed at once, commands
+// blocked waiting for data return nil, and connections still busy when
+// ctx is done are closed then. Shutdown does not save a
+// snapshot; the caller decides whether to call KeyValueDB.Save afterwards.
+// Calling Shutdown again waits for the first call to finish.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		select {
+		case <-s.done:
+			return s.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.closing = true
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	// Interrupt the reads of idle connections and wake the blocked
+	// commands, such as XREAD BLOCK, which would otherwise wait for ever;
+	// busy ones see closing before they read again.
+	for conn, state := range s.conns {
+		conn.SetReadDeadline(time.Now())
+		if state.session != nil {
+			state.session.Cancel()
+		}
+	}
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+	}
+
+	s.err = err
+	close(s.done)
+	return err
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"keyvaluedb/config"
+	"keyvaluedb/domain"
+	"keyvaluedb/storage"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer serves a KeyValueDB on a free port, keeping its snapshot in
+// dir after loading the one already there.
+func startServer(t *testing.T, dir string) (*Server, *domain.KeyValueDB, <-chan error) {
+	t.Helper()
+	cfg := config.New()
+	if err := cfg.Set("dir", dir); err != nil {
+		t.Fatal(err)
+	}
+	kvdb := domain.NewKeyValueDB(storage.NewInMemory("16"))
+	kvdb.SetConfig(cfg)
+	if err := LoadSnapshot(kvdb, cfg.Values().SnapshotPath()); err != nil {
+		t.Fatalf("LoadSnapshot() = %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(listener, kvdb, Options{})
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(context.Background()) }()
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	return srv, kvdb, served
+}
+
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, srv *Server) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// do sends an inline command and returns the first line of the reply.
+func (c *testClient) do(t *testing.T, line string) string {
+	t.Helper()
+	fmt.Fprintf(c.conn, "%s\n", line)
+	reply, err := c.reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+	return strings.TrimLeft(strings.TrimSuffix(reply, "\n"), "$")
+}
+
+// expectClosed checks that the server closed the connection.
+func (c *testClient) expectClosed(t *testing.T) {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := c.reader.ReadByte(); err != nil {
+			if err != io.EOF {
+				t.Errorf("reading from a closed connection: %v", err)
+			}
+			return
+		}
+	}
+}
+
+func waitServed(t *testing.T, served <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-served:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() did not return after the shutdown")
+		return nil
+	}
+}
+
+func TestHandleConnection(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		expectedOutput string
+	}{
+		{
+			name:           "SET command",
+			input:          "SET key value\n",
+			expectedOutput: "OK",
+		},
+		{
+			name:           "SET command with quotes",
+			input:          "SET \"key\" \"value\"\n",
+			expectedOutput: "OK",
+		},
+		{
+			name:           "GET command",
+			input:          "GET key\n",
+			expectedOutput: "value",
+		},
+		{
+			name:           "DEL command",
+			input:          "DEL key\n",
+			expectedOutput: "1",
+		},
+		{
+			name:           "Unknown command",
+			input:          "UNKNOWN command\n",
+			expectedOutput: "(error) ERR unknown command `UNKNOWN`, with args beginning with: `command`,",
+		},
+		{
+			name:           "SET command with unbalanced quotes",
+			input:          "SET \"key\" \"value\n",
+			expectedOutput: "(error) ERR Protocol error: unbalanced quotes in request",
+		},
+	}
+
+	srv, _, _ := startServer(t, t.TempDir())
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := dial(t, srv).do(t, strings.TrimSuffix(testCase.input, "\n")); got != testCase.expectedOutput {
+				t.Errorf("Expected response: %s, but got: %s", testCase.expectedOutput, got)
+			}
+		})
+	}
+}
+
+// Servers on different listeners are independent of each other.
+func TestServersAreIsolated(t *testing.T) {
+	first, _, _ := startServer(t, t.TempDir())
+	second, _, _ := startServer(t, t.TempDir())
+	dial(t, first).do(t, "SET k first")
+	if got := dial(t, second).do(t, "GET k"); got != "<nil>" {
+		t.Errorf("GET on the second server returned %q", got)
+	}
+}
+
+// Connections to one server share its data but not their transactions.
+func TestConnectionsHaveTheirOwnSessions(t *testing.T) {
+	srv, _, _ := startServer(t, t.TempDir())
+	first, second := dial(t, srv), dial(t, srv)
+	for _, step := range []struct {
+		client   *testClient
+		line     string
+		expected string
+	}{
+		{first, "MULTI", "OK"},
+		{first, "SET k first", "QUEUED"},
+		{second, "SET k second", "OK"},
+		{second, "EXEC", "(empty array)"},
+		{first, "EXEC", "1) OK"},
+		{second, "GET k", "first"},
+	} {
+		if got := step.client.do(t, step.line); got != step.expected {
+			t.Errorf("%s returned %q, expected %q", step.line, got, step.expected)
+		}
+	}
+}
+
+// A client typing commands is shown the prompt after each reply and after an
+// empty line, but nothing is written before it sends its first command; one
+// speaking RESP never is.
+func TestPrompt(t *testing.T) {
+	srv, _, _ := startServer(t, t.TempDir())
+
+	inline := dial(t, srv)
+	inline.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if got, err := inline.reader.ReadByte(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("a new client read %q, %v before sending anything", got, err)
+	}
+	inline.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, step := range []struct {
+		line     string
+		expected string
+	}{
+		{"SET a v", "OK\n$"},
+		{"SELECT 1", "OK\n[1]$"},
+		{"", "[1]$"},
+		{"GET k", "<nil>\n[1]$"},
+	} {
+		fmt.Fprintf(inline.conn, "%s\n", step.line)
+		if got, err := inline.reader.ReadString('$'); err != nil || got != step.expected {
+			t.Errorf("after %q the client read %q, %v, expected %q", step.line, got, err, step.expected)
+		}
+	}
+
+	resp := dial(t, srv)
+	resp.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(resp.conn, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n")
+	if got, err := resp.reader.ReadString('\n'); err != nil || got != "$-1\r\n" {
+		t.Errorf("a RESP client read %q, %v", got, err)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	srv, _, _ := startServer(t, t.TempDir())
+	admin, victim := dial(t, srv), dial(t, srv)
+	victim.do(t, "CLIENT SETNAME victim")
+	if got := admin.do(t, "CLIENT KILL ADDR "+victim.conn.LocalAddr().String()); got != "1" {
+		t.Errorf("CLIENT KILL ADDR returned %q", got)
+	}
+	victim.expectClosed(t)
+
+	// A client killing itself still receives the reply.
+	if got := admin.do(t, "CLIENT KILL "+admin.conn.LocalAddr().String()); got != "OK" {
+		t.Errorf("CLIENT KILL of itself returned %q", got)
+	}
+	admin.expectClosed(t)
+}
+
+func TestClientKillBlockedClient(t *testing.T) {
+	srv, _, _ := startServer(t, t.TempDir())
+	admin, blocked := dial(t, srv), dial(t, srv)
+	id := blocked.do(t, "CLIENT ID")
+	fmt.Fprintf(blocked.conn, "XREAD BLOCK 0 STREAMS s $\n")
+	time.Sleep(50 * time.Millisecond)
+
+	if got := admin.do(t, "CLIENT KILL ID "+id); got != "1" {
+		t.Errorf("CLIENT KILL ID returned %q", got)
+	}
+	blocked.expectClosed(t)
+	if got := admin.do(t, "CLIENT LIST ID "+id); got != "" {
+		t.Errorf("CLIENT LIST still shows the killed client: %q", got)
+	}
+}
+
+func TestServeStopsWithContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(listener, domain.NewKeyValueDB(storage.NewInMemory("1")), Options{ShutdownTimeout: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ctx) }()
+	client := dial(t, srv)
+	client.do(t, "SET k v")
+
+	cancel()
+	client.expectClosed(t)
+	if err := waitServed(t, served); err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+}
+
+func TestServerShutdownCommand(t *testing.T) {
+	dir := t.TempDir()
+	srv, _, served := startServer(t, dir)
+	client := dial(t, srv)
+	idle := dial(t, srv)
+	if got := client.do(t, `SET greeting "hello world"`); got != "OK" {
+		t.Fatalf("SET returned %q", got)
+	}
+	if got := client.do(t, "SHUTDOWN"); got != "OK" {
+		t.Fatalf("SHUTDOWN returned %q", got)
+	}
+	client.expectClosed(t)
+	idle.expectClosed(t)
+	if err := waitServed(t, served); err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+	if _, err := net.Dial("tcp", srv.Addr().String()); err == nil {
+		t.Errorf("the server still accepts connections")
+	}
+
+	// A new server loads the snapshot.
+	srv, _, _ = startServer(t, dir)
+	if got := dial(t, srv).do(t, "GET greeting"); got != "hello world" {
+		t.Errorf("GET after a restart returned %q", got)
+	}
+}
+
+func TestServerShutdownNoSave(t *testing.T) {
+	dir := t.TempDir()
+	srv, _, served := startServer(t, dir)
+	client := dial(t, srv)
+	client.do(t, "SET k v")
+	if got := client.do(t, "SHUTDOWN NOSAVE"); got != "OK" {
+		t.Fatalf("SHUTDOWN NOSAVE returned %q", got)
+	}
+	waitServed(t, served)
+	if _, err := os.Stat(filepath.Join(dir, "dump.kvdb")); !os.IsNotExist(err) {
+		t.Errorf("SHUTDOWN NOSAVE wrote a snapshot")
+	}
+}
+
+func TestServerShutdownWakesBlockedClients(t *testing.T) {
+	srv, _, served := startServer(t, t.TempDir())
+	blocked := dial(t, srv)
+	fmt.Fprintf(blocked.conn, "XREAD BLOCK 0 STREAMS s $\n")
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Errorf("Shutdown() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown() waits for the blocked client")
+	}
+	blocked.expectClosed(t)
+	if err := waitServed(t, served); err != nil {
+		t.Errorf("Serve() = %v", err)
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	dir := t.TempDir()
+	srv, kvdb, served := startServer(t, dir)
+	busy := dial(t, srv)
+	busy.do(t, "SETRANGE k 1048575 v")
+	// The client reads none of the replies, so the server is still
+	// writing them when the deadline passes and has to close it.
+	fmt.Fprint(busy.conn, strings.Repeat("GET k\n", 64))
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	busy.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.Copy(io.Discard, busy.conn); err != nil && os.IsTimeout(err) {
+		t.Errorf("the busy connection was not closed")
+	}
+	if err := waitServed(t, served); err != context.DeadlineExceeded {
+		t.Errorf("Serve() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// Shutdown leaves saving to the caller.
+	if _, err := os.Stat(filepath.Join(dir, "dump.kvdb")); !os.IsNotExist(err) {
+		t.Errorf("Shutdown() saved a snapshot")
+	}
+	if err := kvdb.Save(); err != nil {
+		t.Errorf("Save() after Shutdown() = %v", err)
+	}
+}
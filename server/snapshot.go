@@ -0,0 +1,41 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"keyvaluedb/domain"
+	"os"
+)
+
+// LoadSnapshot replays the commands of a snapshot written by
+// KeyValueDB.Save. A missing file is not an error.
+func LoadSnapshot(kvdb *domain.KeyValueDB, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	dbIndex := 0
+	for {
+		command, _, err := readCommand(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("loading %s: %v", path, err)
+		}
+		var result interface{}
+		dbIndex, result = kvdb.Execute(dbIndex, command)
+		if r, ok := result.(domain.ErrorReply); ok {
+			return fmt.Errorf("loading %s: %v: %s", path, command, r)
+		}
+	}
+	kvdb.ResetStats()
+	return nil
+}
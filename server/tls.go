@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"keyvaluedb/config"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewTLSConfig returns the TLS settings of a listener for the tls-port. They
+// follow the tls-* settings of cfg: each handshake uses the certificate,
+// key and CA files named by them, read again whenever a setting or the
+// modification time of a file changes, so certificates can be replaced
+// without a restart. NewTLSConfig fails when the files cannot be loaded
+// now; a later reload that fails is logged to errorLog, or to standard
+// error when it is nil, and the previous files stay in use.
+func NewTLSConfig(cfg *config.Config, errorLog *log.Logger) (*tls.Config, error) {
+	if errorLog == nil {
+		errorLog = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	r := &certReloader{cfg: cfg, errorLog: errorLog}
+	if err := r.reload(cfg.Values()); err != nil {
+		return nil, err
+	}
+	return &tls.Config{GetConfigForClient: r.configForClient}, nil
+}
+
+// tlsFiles identifies a version of the files a certReloader loaded.
+type tlsFiles struct {
+	cert, key, ca          string
+	certMod, keyMod, caMod time.Time
+}
+
+func statTLSFiles(v config.Values) tlsFiles {
+	files := tlsFiles{cert: v.TLSCertFile, key: v.TLSKeyFile, ca: v.TLSCACertFile}
+	files.certMod = modTime(files.cert)
+	files.keyMod = modTime(files.key)
+	files.caMod = modTime(files.ca)
+	return files
+}
+
+// modTime returns the modification time of a file, or the zero time when
+// there is none.
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+type certReloader struct {
+	cfg      *config.Config
+	errorLog *log.Logger
+
+	mu    sync.Mutex
+	files tlsFiles
+	cert  tls.Certificate
+	// pool holds the CAs client certificates must be signed by. It is
+	// empty rather than nil without a CA file, so that no client
+	// certificate is accepted instead of any the system trusts.
+	pool *x509.CertPool
+	// failed are the files of the last reload that failed, so that the
+	// failure is logged once.
+	failed tlsFiles
+}
+
+func (r *certReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v := r.cfg.Values()
+	if err := r.reload(v); err != nil {
+		r.errorLog.Printf("Failed to reload the TLS files, keeping the previous ones: %v", err)
+	}
+	clientAuth := tls.RequireAndVerifyClientCert
+	switch v.TLSAuthClients {
+	case "no":
+		clientAuth = tls.NoClientCert
+	case "optional":
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{r.cert},
+		ClientCAs:    r.pool,
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// reload loads the files named by v unless they are those loaded already or
+// those that failed to load last time.
+func (r *certReloader) reload(v config.Values) error {
+	files := statTLSFiles(v)
+	if files == r.files || files == r.failed {
+		return nil
+	}
+	cert, pool, err := loadTLSFiles(v)
+	if err != nil {
+		r.failed = files
+		return err
+	}
+	r.files, r.cert, r.pool = files, cert, pool
+	return nil
+}
+
+func loadTLSFiles(v config.Values) (tls.Certificate, *x509.CertPool, error) {
+	if v.TLSCertFile == "" || v.TLSKeyFile == "" {
+		return tls.Certificate{}, nil, errors.New("tls-cert-file and tls-key-file are needed for the tls-port")
+	}
+	cert, err := tls.LoadX509KeyPair(v.TLSCertFile, v.TLSKeyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pool := x509.NewCertPool()
+	if v.TLSCACertFile == "" {
+		if v.TLSAuthClients != "no" {
+			return tls.Certificate{}, nil, errors.New("tls-ca-cert-file is needed to verify client certificates")
+		}
+		return cert, pool, nil
+	}
+	pem, err := os.ReadFile(v.TLSCACertFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return tls.Certificate{}, nil, fmt.Errorf("%s: no certificates found", v.TLSCACertFile)
+	}
+	return cert, pool, nil
+}
+
+// handshake completes the TLS handshake of a connection and returns the
+// user its verified client certificate logs it in as, if any.
+func (s *Server) handshake(conn *tls.Conn) (string, error) {
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+	if s.kvdb.Config().Values().TLSAuthClientsUser != "cn" {
+		return "", nil
+	}
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return "", nil
+	}
+	return state.PeerCertificates[0].Subject.CommonName, nil
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"keyvaluedb/config"
+	"keyvaluedb/domain"
+	"keyvaluedb/storage"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates signed by a CA made up for a test.
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// certPath is the PEM file of the CA certificate.
+	certPath string
+	serial   int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	ca := &testCA{dir: t.TempDir()}
+	ca.cert, ca.key = ca.create(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "kvdb test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	})
+	ca.certPath = filepath.Join(ca.dir, "ca.pem")
+	writePEM(t, ca.certPath, "CERTIFICATE", ca.cert.Raw)
+	return ca
+}
+
+// create signs the template with the CA, or with its own key when the CA
+// is being created.
+func (ca *testCA) create(t *testing.T, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	template.SerialNumber = big.NewInt(ca.serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := template, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// issue writes a certificate for cn and its key to name.pem and name.key.
+func (ca *testCA) issue(t *testing.T, name, cn string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+	cert, key := ca.create(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+	})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(ca.dir, name+".pem"), filepath.Join(ca.dir, name+".key")
+	writePEM(t, certPath, "CERTIFICATE", cert.Raw)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	return certPath, keyPath
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLSServer serves a KeyValueDB on a plain and a TLS listener, with the
+// server certificate of ca and the given settings.
+func startTLSServer(t *testing.T, ca *testCA, settings ...string) (*domain.KeyValueDB, *Server, net.Addr) {
+	t.Helper()
+	certPath, keyPath := ca.issue(t, "server", "kvdb-1", x509.ExtKeyUsageServerAuth)
+	cfg := config.New()
+	settings = append([]string{
+		"tls-cert-file", certPath, "tls-key-file", keyPath, "tls-ca-cert-file", ca.certPath,
+	}, settings...)
+	for i := 0; i < len(settings); i += 2 {
+		if err := cfg.Set(settings[i], settings[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kvdb := domain.NewKeyValueDB(storage.NewInMemory("1"))
+	kvdb.SetConfig(cfg)
+	tlsConfig, err := NewTLSConfig(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewTLSConfig() = %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(listener, kvdb, Options{})
+	srv.AddListener(tls.NewListener(tlsListener, tlsConfig))
+	go srv.Serve(context.Background())
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	return kvdb, srv, tlsListener.Addr()
+}
+
+// dialTLS connects to addr trusting ca, with the client certificate in the
+// given files unless they are empty.
+func dialTLS(t *testing.T, addr net.Addr, ca *testCA, certPath, keyPath string) *testClient {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tlsConfig := &tls.Config{RootCAs: roots}
+	if certPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	conn, err := tls.Dial("tcp", addr.String(), tlsConfig)
+	if err != nil {
+		t.Fatalf("Failed to connect to the TLS server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	kvdb, srv, tlsAddr := startTLSServer(t, ca, "tls-auth-clients-user", "CN")
+	kvdb.Execute(0, domain.NewCommand(domain.ACL, "SETUSER", "alice", "on", "+@all", "~*"))
+	kvdb.Execute(0, domain.NewCommand(domain.ACL, "SETUSER", "default", "resetpass", ">secret"))
+	aliceCert, aliceKey := ca.issue(t, "alice", "alice", x509.ExtKeyUsageClientAuth)
+	bobCert, bobKey := ca.issue(t, "bob", "bob", x509.ExtKeyUsageClientAuth)
+
+	for _, test := range []struct {
+		name     string
+		client   func() *testClient
+		line     string
+		expected string
+	}{
+		{
+			name:     "The CN names an ACL user",
+			client:   func() *testClient { return dialTLS(t, tlsAddr, ca, aliceCert, aliceKey) },
+			line:     "ACL WHOAMI",
+			expected: "alice",
+		},
+		{
+			name:     "The CN names no user",
+			client:   func() *testClient { return dialTLS(t, tlsAddr, ca, bobCert, bobKey) },
+			line:     "GET k",
+			expected: "(error) NOAUTH Authentication required.",
+		},
+		{
+			name:     "Plain connections log in with a password",
+			client:   func() *testClient { return dial(t, srv) },
+			line:     "AUTH secret",
+			expected: "OK",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.client().do(t, test.line); got != test.expected {
+				t.Errorf("%s returned %q, expected %q", test.line, got, test.expected)
+			}
+		})
+	}
+
+	t.Run("A client without a certificate is refused", func(t *testing.T) {
+		c := dialTLS(t, tlsAddr, ca, "", "")
+		c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		c.conn.Write([]byte("GET k\n"))
+		if reply, err := c.reader.ReadString('\n'); err == nil {
+			t.Errorf("a client without a certificate received %q", reply)
+		}
+	})
+
+	t.Run("A certificate from another CA is refused", func(t *testing.T) {
+		otherCert, otherKey := newTestCA(t).issue(t, "alice", "alice", x509.ExtKeyUsageClientAuth)
+		c := dialTLS(t, tlsAddr, ca, otherCert, otherKey)
+		c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		c.conn.Write([]byte("ACL WHOAMI\n"))
+		if reply, err := c.reader.ReadString('\n'); err == nil {
+			t.Errorf("a client with an untrusted certificate received %q", reply)
+		}
+	})
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	ca := newTestCA(t)
+	kvdb, _, tlsAddr := startTLSServer(t, ca, "tls-auth-clients", "no")
+	serverCN := func() string {
+		c := dialTLS(t, tlsAddr, ca, "", "")
+		c.do(t, "GET k")
+		return c.conn.(*tls.Conn).ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	if got := serverCN(); got != "kvdb-1" {
+		t.Fatalf("the server presented %q", got)
+	}
+
+	// The files are replaced in place.
+	certPath, keyPath := ca.issue(t, "server", "kvdb-2", x509.ExtKeyUsageServerAuth)
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certPath, keyPath} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := serverCN(); got != "kvdb-2" {
+		t.Errorf("after replacing the files the server presented %q", got)
+	}
+
+	// The settings name other files.
+	certPath, keyPath = ca.issue(t, "server-3", "kvdb-3", x509.ExtKeyUsageServerAuth)
+	_, reply := kvdb.Execute(0, domain.NewCommand(domain.CONFIG, "SET", "tls-cert-file", certPath, "tls-key-file", keyPath))
+	if reply != domain.StatusReply("OK") {
+		t.Fatalf("CONFIG SET returned %v", reply)
+	}
+	if got := serverCN(); got != "kvdb-3" {
+		t.Errorf("after CONFIG SET the server presented %q", got)
+	}
+
+	// A broken file leaves the last good certificate in use.
+	if err := os.WriteFile(certPath, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certPath, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := serverCN(); got != "kvdb-3" {
+		t.Errorf("after breaking the files the server presented %q", got)
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	ca := newTestCA(t)
+	certPath, keyPath := ca.issue(t, "server", "kvdb", x509.ExtKeyUsageServerAuth)
+	for _, test := range []struct {
+		name     string
+		settings []string
+		err      string
+	}{
+		{
+			name:     "No certificate",
+			settings: []string{"tls-key-file", keyPath},
+			err:      "tls-cert-file and tls-key-file are needed",
+		},
+		{
+			name:     "Missing key",
+			settings: []string{"tls-cert-file", certPath, "tls-key-file", filepath.Join(ca.dir, "nope.key")},
+			err:      "nope.key",
+		},
+		{
+			name:     "No CA to verify clients with",
+			settings: []string{"tls-cert-file", certPath, "tls-key-file", keyPath},
+			err:      "tls-ca-cert-file is needed to verify client certificates",
+		},
+		{
+			name:     "A CA file without certificates",
+			settings: []string{"tls-cert-file", certPath, "tls-key-file", keyPath, "tls-ca-cert-file", keyPath},
+			err:      "no certificates found",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.New()
+			for i := 0; i < len(test.settings); i += 2 {
+				if err := cfg.Set(test.settings[i], test.settings[i+1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := NewTLSConfig(cfg, nil); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("NewTLSConfig() = %v, expected an error containing %q", err, test.err)
+			}
+		})
+	}
+}
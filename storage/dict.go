@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"container/heap"
+	"math/bits"
+	"math/rand"
+	"sort"
+)
+
+const dictMinSize = 4
+
+// Dict is a chained hash table with a power-of-two number of buckets. It is
+// used instead of a Go map so that it can be walked with a stateless cursor:
+// Scan returns every key present for the whole walk at least once, even if
+// the table grows or shrinks between calls.
+type Dict struct {
+	buckets [][]*dictEntry
+	// entries holds every entry densely, so that a random one can be
+	// picked in constant time.
+	entries []*dictEntry
+	// seq numbers entries in insertion order, which Keys returns.
+	seq uint64
+	// expiring holds the entries with an expiry, the next to expire first.
+	expiring expiryHeap
+}
+
+type dictEntry struct {
+	key   string
+	value interface{}
+	seq   uint64
+	// pos is the index of the entry in Dict.entries.
+	pos int
+	// expireAt is when the entry expires in Unix milliseconds, or 0, and
+	// heapPos the index of the entry in Dict.expiring when it is not 0.
+	expireAt int64
+	heapPos  int
+}
+
+// expiryHeap orders entries by when they expire.
+type expiryHeap []*dictEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expireAt < h[j].expireAt }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapPos = i
+	h[j].heapPos = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*dictEntry)
+	e.heapPos = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+func NewDict() *Dict {
+	return &Dict{buckets: make([][]*dictEntry, dictMinSize)}
+}
+
+// dictHash is 64-bit FNV-1a.
+func dictHash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (d *Dict) bucket(key string) int {
+	return int(dictHash(key) & uint64(len(d.buckets)-1))
+}
+
+func (d *Dict) Len() int {
+	return len(d.entries)
+}
+
+// Volatile counts the keys that have an expiry.
+func (d *Dict) Volatile() int {
+	return len(d.expiring)
+}
+
+func (d *Dict) find(key string) *dictEntry {
+	for _, e := range d.buckets[d.bucket(key)] {
+		if e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+func (d *Dict) Get(key string) (interface{}, bool) {
+	if e := d.find(key); e != nil {
+		return e.value, true
+	}
+	return nil, false
+}
+
+// Set stores value under key and reports whether the key is new. An
+// existing key keeps its place in the insertion order and its expiry.
+func (d *Dict) Set(key string, value interface{}) bool {
+	if e := d.find(key); e != nil {
+		e.value = value
+		return false
+	}
+	b := d.bucket(key)
+	d.seq++
+	e := &dictEntry{key: key, value: value, seq: d.seq, pos: len(d.entries)}
+	d.buckets[b] = append(d.buckets[b], e)
+	d.entries = append(d.entries, e)
+	if len(d.entries) > len(d.buckets) {
+		d.resize(len(d.buckets) * 2)
+	}
+	return true
+}
+
+// SetExpire sets when key expires, in Unix milliseconds. Zero removes the
+// expiry. It reports false when the key does not exist.
+func (d *Dict) SetExpire(key string, at int64) bool {
+	e := d.find(key)
+	if e == nil {
+		return false
+	}
+	switch {
+	case e.expireAt == 0 && at != 0:
+		e.expireAt = at
+		heap.Push(&d.expiring, e)
+	case e.expireAt != 0 && at == 0:
+		heap.Remove(&d.expiring, e.heapPos)
+		e.expireAt = 0
+	case at != 0:
+		e.expireAt = at
+		heap.Fix(&d.expiring, e.heapPos)
+	}
+	return true
+}
+
+// DelExpired removes up to limit keys that expire at or before now, the
+// earliest first, and returns how many it removed. A limit of 0 removes
+// them all.
+func (d *Dict) DelExpired(now int64, limit int) int {
+	n := 0
+	for len(d.expiring) > 0 && d.expiring[0].expireAt <= now && (limit == 0 || n < limit) {
+		d.Del(d.expiring[0].key)
+		n++
+	}
+	return n
+}
+
+// ExpireAt returns when key expires in Unix milliseconds, or 0 when it does
+// not exist or has no expiry.
+func (d *Dict) ExpireAt(key string) int64 {
+	if e := d.find(key); e != nil {
+		return e.expireAt
+	}
+	return 0
+}
+
+// Del removes key and reports whether it was present.
+func (d *Dict) Del(key string) bool {
+	b := d.bucket(key)
+	for i, e := range d.buckets[b] {
+		if e.key != key {
+			continue
+		}
+		entries := d.buckets[b]
+		entries[i] = entries[len(entries)-1]
+		entries[len(entries)-1] = nil
+		d.buckets[b] = entries[:len(entries)-1]
+		if e.expireAt != 0 {
+			heap.Remove(&d.expiring, e.heapPos)
+		}
+
+		last := d.entries[len(d.entries)-1]
+		d.entries[e.pos] = last
+		last.pos = e.pos
+		d.entries[len(d.entries)-1] = nil
+		d.entries = d.entries[:len(d.entries)-1]
+
+		if len(d.buckets) > dictMinSize && len(d.entries) < len(d.buckets)/8 {
+			d.resize(len(d.buckets) / 2)
+		}
+		return true
+	}
+	return false
+}
+
+func (d *Dict) resize(size int) {
+	old := d.buckets
+	d.buckets = make([][]*dictEntry, size)
+	for _, entries := range old {
+		for _, e := range entries {
+			b := d.bucket(e.key)
+			d.buckets[b] = append(d.buckets[b], e)
+		}
+	}
+}
+
+// Keys returns every key in insertion order.
+func (d *Dict) Keys() []string {
+	entries := append([]*dictEntry(nil), d.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.key
+	}
+	return keys
+}
+
+// RandomKey returns a key picked uniformly at random, or false when the
+// table is empty.
+func (d *Dict) RandomKey() (string, bool) {
+	if len(d.entries) == 0 {
+		return "", false
+	}
+	return d.entries[rand.Intn(len(d.entries))].key, true
+}
+
+// Scan returns the keys of the buckets starting at cursor, visiting buckets
+// until at least count keys are found, and the cursor to continue from. A
+// returned cursor of 0 means the walk is complete.
+//
+// The cursor is incremented with its bits reversed, so the high bits of the
+// bucket index advance first. When the table doubles, every bucket splits
+// into two whose indexes share their low bits with it, and both come after
+// the cursor if the original bucket did; when it halves, pairs merge into a
+// bucket that may be visited again, which only repeats keys.
+func (d *Dict) Scan(cursor uint64, count int) (uint64, []string) {
+	var keys []string
+	if len(d.entries) == 0 {
+		return 0, keys
+	}
+	mask := uint64(len(d.buckets) - 1)
+	// Bound the buckets visited when they are mostly empty.
+	for visits := count * 10; ; visits-- {
+		for _, e := range d.buckets[cursor&mask] {
+			keys = append(keys, e.key)
+		}
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 || len(keys) >= count || visits <= 0 {
+			return cursor, keys
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestDictSetGetDel(t *testing.T) {
+	d := NewDict()
+	for i := 0; i < 1000; i++ {
+		if !d.Set(strconv.Itoa(i), i) {
+			t.Fatalf("Set(%d) reported an existing key", i)
+		}
+	}
+	if d.Set("5", "five") {
+		t.Errorf("Set() of an existing key reported a new key")
+	}
+	if v, ok := d.Get("5"); !ok || v != "five" {
+		t.Errorf("Get(5) = %v, %v", v, ok)
+	}
+	for i := 0; i < 1000; i += 2 {
+		if !d.Del(strconv.Itoa(i)) {
+			t.Fatalf("Del(%d) = false", i)
+		}
+	}
+	if d.Del("0") {
+		t.Errorf("Del() of a missing key = true")
+	}
+	if d.Len() != 500 {
+		t.Errorf("Len() = %d, want 500", d.Len())
+	}
+	if _, ok := d.Get("2"); ok {
+		t.Errorf("Get() found a deleted key")
+	}
+}
+
+func TestDictShrinks(t *testing.T) {
+	d := NewDict()
+	for i := 0; i < 1000; i++ {
+		d.Set(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 1000; i++ {
+		d.Del(strconv.Itoa(i))
+	}
+	if len(d.buckets) != dictMinSize {
+		t.Errorf("empty dict has %d buckets, want %d", len(d.buckets), dictMinSize)
+	}
+}
+
+func TestDictKeysInInsertionOrder(t *testing.T) {
+	d := NewDict()
+	want := []string{"foo", "baz", "a", "z", "m"}
+	for _, k := range want {
+		d.Set(k, k)
+	}
+	d.Set("foo", "again")
+	d.Set("gone", 1)
+	d.Del("gone")
+	if got := d.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v, want %v", got, want)
+	}
+}
+
+func TestDictScan(t *testing.T) {
+	tests := []struct {
+		name string
+		// change runs between calls to Scan.
+		change func(d *Dict, call int)
+	}{
+		{
+			name:   "unchanged",
+			change: func(d *Dict, call int) {},
+		},
+		{
+			name: "growing",
+			change: func(d *Dict, call int) {
+				for i := 0; i < 50; i++ {
+					d.Set("new"+strconv.Itoa(call*50+i), nil)
+				}
+			},
+		},
+		{
+			name: "shrinking",
+			change: func(d *Dict, call int) {
+				for i := 0; i < 50; i++ {
+					d.Del("tmp" + strconv.Itoa(call*50+i))
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := NewDict()
+			for i := 0; i < 100; i++ {
+				d.Set(strconv.Itoa(i), nil)
+			}
+			for i := 0; i < 2000; i++ {
+				d.Set("tmp"+strconv.Itoa(i), nil)
+			}
+
+			seen := make(map[string]bool)
+			var cursor uint64
+			for call := 0; ; call++ {
+				var keys []string
+				cursor, keys = d.Scan(cursor, 10)
+				for _, k := range keys {
+					seen[k] = true
+				}
+				if cursor == 0 {
+					break
+				}
+				test.change(d, call)
+			}
+			for i := 0; i < 100; i++ {
+				if !seen[strconv.Itoa(i)] {
+					t.Errorf("Scan() never returned %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestDictScanEmpty(t *testing.T) {
+	if cursor, keys := NewDict().Scan(0, 10); cursor != 0 || len(keys) != 0 {
+		t.Errorf("Scan() of an empty dict = %d, %v", cursor, keys)
+	}
+}
+
+func TestDictRandomKey(t *testing.T) {
+	d := NewDict()
+	if _, ok := d.RandomKey(); ok {
+		t.Errorf("RandomKey() of an empty dict reported a key")
+	}
+	for i := 0; i < 10; i++ {
+		d.Set(strconv.Itoa(i), nil)
+	}
+	for i := 0; i < 10; i += 2 {
+		d.Del(strconv.Itoa(i))
+	}
+	seen := make(map[string]int)
+	for i := 0; i < 5000; i++ {
+		key, _ := d.RandomKey()
+		seen[key]++
+	}
+	if len(seen) != 5 {
+		t.Fatalf("RandomKey() returned %d distinct keys, want 5", len(seen))
+	}
+	for key, n := range seen {
+		if _, ok := d.Get(key); !ok {
+			t.Errorf("RandomKey() returned deleted key %s", key)
+		}
+		if n < 800 || n > 1200 {
+			t.Errorf("RandomKey() returned %s %d times out of 5000", key, n)
+		}
+	}
+}
+
+func TestDictDelExpired(t *testing.T) {
+	d := NewDict()
+	for i := 0; i < 100; i++ {
+		d.Set(strconv.Itoa(i), i)
+		// Keys 0 to 49 expire at 1000 to 1049, the others never.
+		if i < 50 {
+			d.SetExpire(strconv.Itoa(i), int64(1049-i))
+		}
+	}
+	d.SetExpire("10", 2000)
+	d.SetExpire("11", 0)
+	d.Del("12")
+
+	if n := d.DelExpired(1009, 5); n != 5 {
+		t.Errorf("DelExpired(1009, 5) = %d, want 5", n)
+	}
+	// The earliest go first: keys 49 to 45.
+	if _, ok := d.Get("45"); ok {
+		t.Errorf("DelExpired() left key 45")
+	}
+	if _, ok := d.Get("44"); !ok {
+		t.Errorf("DelExpired() removed key 44 past its limit")
+	}
+	if n := d.DelExpired(1049, 0); n != 42 {
+		t.Errorf("DelExpired(1049, 0) = %d, want 42", n)
+	}
+	if d.Len() != 52 || d.Volatile() != 1 {
+		t.Errorf("Len() = %d and Volatile() = %d, want 52 and 1", d.Len(), d.Volatile())
+	}
+	if _, ok := d.Get("10"); !ok {
+		t.Errorf("DelExpired() removed a key whose expiry was postponed")
+	}
+	if n := d.DelExpired(2000, 0); n != 1 || d.Volatile() != 0 {
+		t.Errorf("DelExpired(2000, 0) = %d, leaving Volatile() = %d", n, d.Volatile())
+	}
+}
@@ -3,11 +3,15 @@ package storage
 import (
 	"fmt"
 	"strconv"
+	"time"
 )
 
+// timeNow is the clock keys expire by.
+var timeNow = time.Now
+
 type inMemory struct {
 	dbCount int
-	storage map[int]map[string]interface{}
+	storage map[int]*Dict
 }
 
 func NewInMemory(dbCntStr string) Storage {
@@ -16,9 +20,9 @@ func NewInMemory(dbCntStr string) Storage {
 		dbCnt = 16
 	}
 
-	stg := make(map[int]map[string]interface{})
+	stg := make(map[int]*Dict)
 	for idx := 0; idx < dbCnt; idx++ {
-		stg[idx] = make(map[string]interface{})
+		stg[idx] = NewDict()
 	}
 
 	return &inMemory{
@@ -38,38 +42,163 @@ func (in inMemory) Select(dbIndexStr string) (int, error) {
 	return dbIndex, nil
 }
 
-func (in inMemory) Set(dbIndex int, key string, value interface{}) {
-	stg := in.storage[dbIndex]
-	stg[key] = value
+func (in inMemory) Set(dbIndex int, key []byte, value interface{}) {
+	in.storage[dbIndex].Set(string(key), value)
 }
 
-func (in inMemory) Get(dbIndex int, key string) interface{} {
+// Get returns the value of key, or nil when it does not exist. An expired
+// key is deleted when it is next looked up.
+func (in inMemory) Get(dbIndex int, key []byte) interface{} {
 	stg := in.storage[dbIndex]
-	v, ok := stg[key]
-	if !ok {
+	if expireIfNeeded(stg, string(key)) {
 		return nil
 	}
+	v, _ := stg.Get(string(key))
 	return v
 }
 
-func (in inMemory) Del(dbIndex int, key string) interface{} {
+// expireIfNeeded deletes key if it has expired and reports whether it did.
+func expireIfNeeded(stg *Dict, key string) bool {
+	at := stg.ExpireAt(key)
+	if at == 0 || at > timeNow().UnixMilli() {
+		return false
+	}
+	stg.Del(key)
+	return true
+}
+
+// liveKeys returns the keys of keys that have not expired, dropping the
+// others.
+func liveKeys(stg *Dict, keys []string) [][]byte {
+	live := make([][]byte, 0, len(keys))
+	for _, k := range keys {
+		if !expireIfNeeded(stg, k) {
+			live = append(live, []byte(k))
+		}
+	}
+	return live
+}
+
+// Expire sets when key expires. A zero time removes the expiry. It reports
+// false when the key does not exist.
+func (in inMemory) Expire(dbIndex int, key []byte, at time.Time) bool {
 	stg := in.storage[dbIndex]
-	_, ok := stg[key]
-	if !ok {
+	if expireIfNeeded(stg, string(key)) {
+		return false
+	}
+	var ms int64
+	if !at.IsZero() {
+		ms = at.UnixMilli()
+	}
+	return stg.SetExpire(string(key), ms)
+}
+
+// ExpireTime returns when key expires, or false when it does not exist or
+// has no expiry.
+func (in inMemory) ExpireTime(dbIndex int, key []byte) (time.Time, bool) {
+	stg := in.storage[dbIndex]
+	if expireIfNeeded(stg, string(key)) {
+		return time.Time{}, false
+	}
+	at := stg.ExpireAt(string(key))
+	if at == 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(at), true
+}
+
+func (in inMemory) Del(dbIndex int, key []byte) interface{} {
+	if !in.storage[dbIndex].Del(string(key)) {
 		return 0
 	}
-	delete(stg, key)
 	return 1
 }
 
+// GetAll returns every key and value of a database, formatted as
+// "key value", in insertion order, with string values written out as text.
+// The channel is filled before it is returned, so a reader may stop early.
 func (in inMemory) GetAll(dbIndex int) <-chan string {
-	strChan := make(chan string)
-	go func() {
-		for k, v := range in.storage[dbIndex] {
-			strChan <- fmt.Sprintf("%s %v", k, v)
+	stg := in.storage[dbIndex]
+	keys := liveKeys(stg, stg.Keys())
+	strChan := make(chan string, len(keys))
+	for _, k := range keys {
+		v, _ := stg.Get(string(k))
+		if b, ok := v.([]byte); ok {
+			v = string(b)
 		}
-		close(strChan)
-	}()
-
+		strChan <- fmt.Sprintf("%s %v", k, v)
+	}
+	close(strChan)
 	return strChan
 }
+
+func (in inMemory) Keys(dbIndex int) [][]byte {
+	stg := in.storage[dbIndex]
+	return liveKeys(stg, stg.Keys())
+}
+
+func (in inMemory) Scan(dbIndex int, cursor uint64, count int) (uint64, [][]byte) {
+	stg := in.storage[dbIndex]
+	cursor, keys := stg.Scan(cursor, count)
+	return cursor, liveKeys(stg, keys)
+}
+
+func (in inMemory) RandomKey(dbIndex int) ([]byte, bool) {
+	stg := in.storage[dbIndex]
+	for {
+		key, ok := stg.RandomKey()
+		if !ok {
+			return nil, false
+		}
+		if !expireIfNeeded(stg, key) {
+			return []byte(key), true
+		}
+	}
+}
+
+// Size counts the keys of a database. The keys that expired are deleted
+// first, so they are not counted.
+func (in inMemory) Size(dbIndex int) int {
+	stg := in.storage[dbIndex]
+	stg.DelExpired(timeNow().UnixMilli(), 0)
+	return stg.Len()
+}
+
+// Expires counts the keys of a database that have an expiry and have not
+// expired.
+func (in inMemory) Expires(dbIndex int) int {
+	stg := in.storage[dbIndex]
+	stg.DelExpired(timeNow().UnixMilli(), 0)
+	return stg.Volatile()
+}
+
+// DeleteExpired deletes up to limit keys of a database that expired, the
+// earliest first, and returns how many it deleted. Expired keys are deleted
+// when they are looked up as well, so this frees those that are not.
+func (in inMemory) DeleteExpired(dbIndex int, limit int) int {
+	return in.storage[dbIndex].DelExpired(timeNow().UnixMilli(), limit)
+}
+
+// DBCount returns the number of databases.
+func (in inMemory) DBCount() int {
+	return in.dbCount
+}
+
+// Flush empties a database by replacing its table. The old table is freed
+// by the garbage collector, which runs concurrently, so this takes the same
+// constant time however many keys there were.
+func (in inMemory) Flush(dbIndex int) {
+	in.storage[dbIndex] = NewDict()
+}
+
+func (in inMemory) FlushAll() {
+	for idx := range in.storage {
+		in.Flush(idx)
+	}
+}
+
+// Swap exchanges the contents of two databases. Connections address
+// databases by index, so they see the swap at once.
+func (in inMemory) Swap(dbIndex1, dbIndex2 int) {
+	in.storage[dbIndex1], in.storage[dbIndex2] = in.storage[dbIndex2], in.storage[dbIndex1]
+}
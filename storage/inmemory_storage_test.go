@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNewInMemory(t *testing.T) {
@@ -115,20 +116,20 @@ func TestInMemorySetGet(t *testing.T) {
 			setArgs: args{
 				dbIndex: 0,
 				key:     "key1",
-				value:   "value1",
+				value:   []byte("value1"),
 			},
 			key:  "key1",
-			want: "value1",
+			want: []byte("value1"),
 		},
 		{
 			name: "Update the value of an existing key",
 			setArgs: args{
 				dbIndex: 0,
 				key:     "key1",
-				value:   "value2",
+				value:   []byte("value2"),
 			},
 			key:  "key1",
-			want: "value2",
+			want: []byte("value2"),
 		},
 		{
 			name: "Get a value for nonexisting key",
@@ -140,19 +141,19 @@ func TestInMemorySetGet(t *testing.T) {
 			setArgs: args{
 				dbIndex: 0,
 				key:     "key1",
-				value:   "value2",
+				value:   []byte("value2"),
 			},
 			key:  "key1",
-			want: "value2",
+			want: []byte("value2"),
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			in := NewInMemory(tt.dbCntStr)
 
-			in.Set(tt.setArgs.dbIndex, tt.setArgs.key, tt.setArgs.value)
+			in.Set(tt.setArgs.dbIndex, []byte(tt.setArgs.key), tt.setArgs.value)
 
-			got := in.Get(tt.setArgs.dbIndex, tt.key)
+			got := in.Get(tt.setArgs.dbIndex, []byte(tt.key))
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("inMemory.Set() = %v, want %v", got, tt.want)
 			}
@@ -183,7 +184,7 @@ func TestInMemorySetDel(t *testing.T) {
 			setArgs: args{
 				dbIndex: 0,
 				key:     "key1",
-				value:   "value2",
+				value:   []byte("value2"),
 			},
 			key:  "key1",
 			want: 1,
@@ -193,15 +194,15 @@ func TestInMemorySetDel(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			in := NewInMemory(tt.dbCntStr)
 
-			in.Set(tt.setArgs.dbIndex, tt.setArgs.key, tt.setArgs.value)
+			in.Set(tt.setArgs.dbIndex, []byte(tt.setArgs.key), tt.setArgs.value)
 
-			got := in.Del(tt.setArgs.dbIndex, tt.key)
+			got := in.Del(tt.setArgs.dbIndex, []byte(tt.key))
 
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("inMemory.Set() = %v, want %v", got, tt.want)
 			}
 
-			if in.Get(tt.setArgs.dbIndex, tt.key) != nil {
+			if in.Get(tt.setArgs.dbIndex, []byte(tt.key)) != nil {
 				t.Errorf("Del(%d, %s) did not delete the key properly", tt.setArgs.dbIndex, tt.key)
 			}
 		})
@@ -227,15 +228,15 @@ func TestInMemoryGetAll(t *testing.T) {
 			fields: []fields{
 				{
 					key:   "key1",
-					value: "value1",
+					value: []byte("value1"),
 				},
 				{
 					key:   "key2",
-					value: "value2",
+					value: []byte("value2"),
 				},
 				{
 					key:   "key3",
-					value: "value3",
+					value: []byte("value3"),
 				},
 			},
 			wantAll: []string{"key1 value1", "key2 value2", "key3 value3"},
@@ -246,7 +247,7 @@ func TestInMemoryGetAll(t *testing.T) {
 			in := NewInMemory(tt.dbCntStr)
 
 			for _, f := range tt.fields {
-				in.Set(tt.dbIndex, f.key, f.value)
+				in.Set(tt.dbIndex, []byte(f.key), f.value)
 			}
 
 			allChan := in.GetAll(tt.dbIndex)
@@ -266,3 +267,112 @@ func TestInMemoryGetAll(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryFlushAndSwap(t *testing.T) {
+	in := NewInMemory("3")
+	in.Set(0, []byte("a"), []byte("0"))
+	in.Set(1, []byte("b"), []byte("1"))
+	in.Set(1, []byte("c"), []byte("1"))
+	in.Set(2, []byte("d"), []byte("2"))
+
+	in.Swap(0, 1)
+	if in.Size(0) != 2 || in.Size(1) != 1 || !reflect.DeepEqual(in.Get(0, []byte("b")), []byte("1")) || !reflect.DeepEqual(in.Get(1, []byte("a")), []byte("0")) {
+		t.Errorf("Swap(0, 1) did not exchange the databases")
+	}
+
+	in.Flush(0)
+	if in.Size(0) != 0 || in.Size(1) != 1 {
+		t.Errorf("Flush(0) left sizes %d and %d, want 0 and 1", in.Size(0), in.Size(1))
+	}
+
+	in.FlushAll()
+	for idx := 0; idx < 3; idx++ {
+		if in.Size(idx) != 0 {
+			t.Errorf("FlushAll() left %d keys in database %d", in.Size(idx), idx)
+		}
+	}
+}
+
+func TestInMemoryExpire(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	in := NewInMemory("1")
+	if in.Expire(0, []byte("missing"), now.Add(time.Second)) {
+		t.Errorf("Expire() of a missing key = true")
+	}
+	in.Set(0, []byte("a"), []byte("1"))
+	in.Set(0, []byte("b"), []byte("2"))
+	in.Set(0, []byte("c"), []byte("3"))
+	in.Expire(0, []byte("a"), now.Add(time.Second))
+	in.Expire(0, []byte("b"), now.Add(2*time.Second))
+	in.Expire(0, []byte("c"), now.Add(time.Second))
+	in.Expire(0, []byte("c"), time.Time{})
+	if got := in.Expires(0); got != 2 {
+		t.Errorf("Expires() = %d, want 2", got)
+	}
+
+	if at, ok := in.ExpireTime(0, []byte("a")); !ok || !at.Equal(now.Add(time.Second)) {
+		t.Errorf("ExpireTime(a) = %v, %v", at, ok)
+	}
+	if _, ok := in.ExpireTime(0, []byte("c")); ok {
+		t.Errorf("ExpireTime() after removing the expiry reported one")
+	}
+	// Overwriting a value keeps its expiry.
+	in.Set(0, []byte("a"), []byte("one"))
+
+	now = now.Add(time.Second)
+	if in.Size(0) != 2 || in.Expires(0) != 1 {
+		t.Errorf("Size() = %d and Expires() = %d once a key expired, want 2 and 1", in.Size(0), in.Expires(0))
+	}
+	if got := in.Get(0, []byte("a")); got != nil {
+		t.Errorf("Get() of an expired key = %v", got)
+	}
+	if got := in.Keys(0); !reflect.DeepEqual(got, [][]byte{[]byte("b"), []byte("c")}) {
+		t.Errorf("Keys() = %q, want [b c]", got)
+	}
+
+	now = now.Add(time.Second)
+	if _, keys := in.Scan(0, 0, 10); !reflect.DeepEqual(keys, [][]byte{[]byte("c")}) {
+		t.Errorf("Scan() = %q, want [c]", keys)
+	}
+	if key, _ := in.RandomKey(0); string(key) != "c" {
+		t.Errorf("RandomKey() = %q, want c", key)
+	}
+	if in.Size(0) != 1 {
+		t.Errorf("Size() = %d after the expired keys were looked up, want 1", in.Size(0))
+	}
+	if got := in.Expires(0); got != 0 {
+		t.Errorf("Expires() = %d after the expired keys were deleted, want 0", got)
+	}
+}
+
+func TestInMemoryDeleteExpired(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	in := NewInMemory("2")
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprint(i))
+		in.Set(0, key, []byte("v"))
+		in.Expire(0, key, now.Add(time.Duration(i+1)*time.Second))
+	}
+	in.Set(1, []byte("k"), []byte("v"))
+	in.Expire(1, []byte("k"), now.Add(time.Second))
+
+	now = now.Add(5 * time.Second)
+	if n := in.DeleteExpired(0, 3); n != 3 {
+		t.Errorf("DeleteExpired(0, 3) = %d, want 3", n)
+	}
+	if n := in.DeleteExpired(0, 0); n != 2 {
+		t.Errorf("DeleteExpired(0, 0) = %d, want 2", n)
+	}
+	if got := in.Keys(0); len(got) != 5 {
+		t.Errorf("Keys() = %q after deleting the expired keys", got)
+	}
+	if got := in.(*inMemory).storage[1].Len(); got != 1 {
+		t.Errorf("DeleteExpired(0) left %d keys in database 1, want 1", got)
+	}
+}
@@ -1,9 +1,26 @@
 package storage
 
+import "time"
+
+// Storage holds the keys of numbered databases. Keys are byte strings, and
+// a string value is stored as a []byte of its contents; the other data
+// types are stored as the structures the caller keeps them in.
 type Storage interface {
 	Select(dbIndexStr string) (int, error)
-	Set(dbIndex int, key string, value interface{})
-	Get(dbIndex int, key string) interface{}
-	Del(dbIndex int, key string) interface{}
+	Set(dbIndex int, key []byte, value interface{})
+	Get(dbIndex int, key []byte) interface{}
+	Del(dbIndex int, key []byte) interface{}
 	GetAll(dbIndex int) <-chan string
+	Keys(dbIndex int) [][]byte
+	Scan(dbIndex int, cursor uint64, count int) (uint64, [][]byte)
+	RandomKey(dbIndex int) ([]byte, bool)
+	Size(dbIndex int) int
+	Expires(dbIndex int) int
+	DeleteExpired(dbIndex int, limit int) int
+	DBCount() int
+	Flush(dbIndex int)
+	FlushAll()
+	Swap(dbIndex1, dbIndex2 int)
+	Expire(dbIndex int, key []byte, at time.Time) bool
+	ExpireTime(dbIndex int, key []byte) (time.Time, bool)
 }